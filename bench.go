@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"code.google.com/p/go.crypto/ssh"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Benchmarks the connection to every host in the comma separated list
+// concurrently and prints the percentiles per host. Useful to find slow
+// network paths before running a plan against a large number of machines.
+func runBench(args []string, config *ssh.ClientConfig, overrides hostOverrides) {
+	benchFlags := flag.NewFlagSet("bench", flag.ExitOnError)
+	rounds := benchFlags.Int("rounds", 10, "Number of measurements per host")
+	size := benchFlags.Int("size", 1<<20, "Payload size in bytes for the transfer measurements")
+	benchFlags.Parse(args)
+
+	hosts := benchFlags.Arg(0)
+	if hosts == "" {
+		fmt.Fprintf(os.Stderr, "Missing hosts to benchmark\n")
+		os.Exit(1)
+	}
+
+	hostnames, err := henchman.ResolveHosts(strings.Split(hosts, ","))
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	machines := henchman.Machines(hostnames, config)
+	if err := henchman.ApplyHostSettings(machines); err != nil {
+		log.Fatalf("Couldn't apply the host settings: %s", err)
+	}
+	for _, override := range overrides {
+		if err := override.Apply(machines); err != nil {
+			log.Fatalf("Couldn't apply host override for '%s': %s", override.Pattern, err)
+		}
+	}
+	results := make([]*henchman.BenchResult, len(machines))
+	wg := new(sync.WaitGroup)
+	for i, machine := range machines {
+		wg.Add(1)
+		go func(i int, machine *henchman.Machine) {
+			defer wg.Done()
+			results[i] = machine.Bench(*rounds, *size)
+		}(i, machine)
+	}
+	wg.Wait()
+	henchman.PrintBenchReport(results)
+}
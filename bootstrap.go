@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"code.google.com/p/go.crypto/ssh"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Prepares fresh machines for henchman. Logs in with a password and creates
+// the automation user with the public key authorized and passwordless sudo,
+// so that plans can be run with key based authentication afterwards. The
+// hosts' keys are checked against the known hosts, or their host_key host
+// var, before any password is sent. The other host vars apply as they do
+// for plans, except that we always log in as the login user.
+func runBootstrap(args []string, username string, keyfile string, credentials henchman.CredentialProvider, knownHosts *henchman.KnownHosts, timeouts henchman.Timeouts) {
+	bootstrapFlags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	loginUser := bootstrapFlags.String("login-user", "root", "User to log in with using a password")
+	publicKeyfile := bootstrapFlags.String("public-keyfile", keyfile+".pub", "Public key to authorize for the user")
+	bootstrapFlags.Parse(args)
+
+	hosts := bootstrapFlags.Arg(0)
+	if hosts == "" {
+		fmt.Fprintf(os.Stderr, "Missing hosts to bootstrap\n")
+		os.Exit(1)
+	}
+
+	publicKey, err := ioutil.ReadFile(*publicKeyfile)
+	if err != nil {
+		log.Fatalf("Couldn't read the public key: %s", err)
+	}
+	script, err := henchman.BootstrapScript(username, publicKey)
+	if err != nil {
+		log.Fatalf("Couldn't prepare the bootstrap: %s", err)
+	}
+	password, err := credentials.Credential(henchman.SSHPassword)
+	if err != nil {
+		log.Fatalf("Couldn't get password: %s", err)
+	}
+	sshAuth, _ := henchman.PasswordAuth(password)
+	config := &ssh.ClientConfig{
+		User:            *loginUser,
+		Auth:            []ssh.AuthMethod{sshAuth},
+		HostKeyCallback: knownHosts.Callback(),
+	}
+
+	hostnames, err := henchman.ResolveHosts(strings.Split(hosts, ","))
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	machines := henchman.Machines(hostnames, config)
+	for _, machine := range machines {
+		machine.Timeouts = timeouts
+	}
+	if err := henchman.ApplyHostSettings(machines); err != nil {
+		log.Fatalf("Couldn't apply the host settings: %s", err)
+	}
+	for _, machine := range machines {
+		login := *machine.SSHConfig
+		login.User, login.Auth = config.User, config.Auth
+		machine.SSHConfig = &login
+	}
+	henchman.PinHostKeys(machines, nil)
+
+	var mutex sync.Mutex
+	failed := false
+	wg := new(sync.WaitGroup)
+	for _, _machine := range machines {
+		machine := _machine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer machine.Close()
+			out, err := machine.Bootstrap(script, password)
+			if err != nil {
+				log.Printf("Bootstrap failed on %s: %s\n%s", machine.Hostname, err, out)
+				mutex.Lock()
+				failed = true
+				mutex.Unlock()
+				return
+			}
+			log.Printf("Bootstrapped %s for %s\n", machine.Hostname, username)
+		}()
+	}
+	wg.Wait()
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Runs the connection broker in the foreground. Later invocations with
+// -broker run their actions over the connections it keeps open.
+func runBroker(args []string, config *ssh.ClientConfig, socket string) {
+	brokerFlags := flag.NewFlagSet("broker", flag.ExitOnError)
+	idle := brokerFlags.Duration("idle", 10*time.Minute, "Close connections that are idle for this long")
+	brokerFlags.Parse(args)
+
+	broker := henchman.NewBroker(config, *idle)
+	if err := broker.ListenAndServe(socket); err != nil {
+		log.Fatalf("Broker failed: %s", err)
+	}
+}
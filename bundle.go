@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Bundles the plan with everything it refers to, for running it with
+// -bundle where the original sources can't be reached.
+func runBundle(args []string, modulesPath string) {
+	bundleFlags := flag.NewFlagSet("bundle", flag.ExitOnError)
+	output := bundleFlags.String("o", "", "Path of the bundle. Defaults to the plan's name with a .tgz extension")
+	bundleFlags.Parse(args)
+
+	planFile := bundleFlags.Arg(0)
+	if planFile == "" {
+		fmt.Fprintf(os.Stderr, "Missing the plan to bundle\n")
+		os.Exit(1)
+	}
+	if *output == "" {
+		*output = planFile[:len(planFile)-len(filepath.Ext(planFile))] + ".tgz"
+	}
+	planBuf, err := ioutil.ReadFile(planFile)
+	if err != nil {
+		log.Fatalf("Error reading plan - %s\n", planFile)
+	}
+	plan, err := henchman.NewPlanFromYAML(planBuf, nil)
+	if err != nil {
+		log.Fatalf("Couldn't read the plan: %s", err)
+	}
+	plan.Dir = filepath.Dir(planFile)
+	if err := plan.ResolveFiles(); err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := plan.ResolveModules(modulesPath); err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Couldn't create the bundle: %s", err)
+	}
+	if err := henchman.WriteBundle(f, plan, planFile, modulesPath); err != nil {
+		f.Close()
+		os.Remove(*output)
+		log.Fatalf("Couldn't bundle the plan: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Couldn't write the bundle: %s", err)
+	}
+	fmt.Printf("Bundled %s into %s\n", planFile, *output)
+}
+
+// Extracts the bundle into a temporary directory. Returns the plan, the
+// bundled modules and the directory to remove once done.
+func extractBundle(path string) (string, string, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Couldn't open the bundle: %s", err)
+	}
+	defer f.Close()
+	dir, err := ioutil.TempDir("", "henchman-bundle")
+	if err != nil {
+		log.Fatalf("Couldn't extract the bundle: %s", err)
+	}
+	planFile, err := henchman.ExtractBundle(f, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		log.Fatalf("Couldn't extract the bundle: %s", err)
+	}
+	return planFile, filepath.Join(dir, "modules"), dir
+}
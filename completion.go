@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+var commands = []string{"bench", "bootstrap", "broker", "bundle", "completion", "init", "module", "plugin", "replay", "run", "template", "vars"}
+
+const bashCompletion = `_henchman() {
+    local cur prev words
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    words=$(printf "%s\n" "${COMP_WORDS[@]}" | grep -E '\.ya?ml$')
+    case "$prev" in
+        doc)
+            COMPREPLY=( $(compgen -W "$(henchman __complete modules 2>/dev/null)" -- "$cur") )
+            return ;;
+        module)
+            COMPREPLY=( $(compgen -W "list doc" -- "$cur") )
+            return ;;
+        template)
+            COMPREPLY=( $(compgen -W "render" -- "$cur") )
+            return ;;
+        plugin)
+            COMPREPLY=( $(compgen -W "list" -- "$cur") )
+            return ;;
+        completion)
+            COMPREPLY=( $(compgen -W "bash zsh fish" -- "$cur") )
+            return ;;
+        bench|bootstrap|vars|-host-override)
+            COMPREPLY=( $(compgen -W "$(henchman __complete hosts $words 2>/dev/null)" -- "$cur") )
+            return ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=( $(compgen -W "$(henchman __complete flags 2>/dev/null)" -- "$cur") )
+        return
+    fi
+    COMPREPLY=( $(compgen -W "$(henchman __complete commands 2>/dev/null)" -- "$cur") $(compgen -f -X '!*.y*ml' -- "$cur") )
+}
+complete -o default -F _henchman henchman
+`
+
+const zshCompletion = `autoload -U +X bashcompinit && bashcompinit
+` + bashCompletion
+
+const fishCompletion = `function __henchman_plans
+    commandline -opc | string match -r '\.ya?ml$'
+end
+complete -c henchman -n '__fish_use_subcommand' -a '(henchman __complete commands)'
+complete -c henchman -n '__fish_use_subcommand' -a '(__fish_complete_suffix .yaml)'
+complete -c henchman -n '__fish_seen_subcommand_from doc' -f -a '(henchman __complete modules)'
+complete -c henchman -n '__fish_seen_subcommand_from module; and not __fish_seen_subcommand_from list doc' -f -a 'list doc'
+complete -c henchman -n '__fish_seen_subcommand_from completion' -f -a 'bash zsh fish'
+complete -c henchman -n '__fish_seen_subcommand_from template; and not __fish_seen_subcommand_from render' -f -a 'render'
+complete -c henchman -n '__fish_seen_subcommand_from plugin; and not __fish_seen_subcommand_from list' -f -a 'list'
+complete -c henchman -n '__fish_seen_subcommand_from bench bootstrap vars' -f -a '(henchman __complete hosts (__henchman_plans))'
+`
+
+// Prints the completion script for the shell
+func runCompletion(args []string) {
+	scripts := map[string]string{"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+	if len(args) == 0 || scripts[args[0]] == "" {
+		fmt.Fprintf(os.Stderr, "Usage: completion bash|zsh|fish\n")
+		os.Exit(1)
+	}
+	fmt.Print(scripts[args[0]])
+}
+
+// Hosts of the given plans. Plans in the current directory are used when
+// none are given. Plans that don't parse are skipped since this runs while
+// the user is typing.
+func completeHosts(plans []string) []string {
+	if len(plans) == 0 {
+		plans, _ = filepath.Glob("*.yaml")
+		yml, _ := filepath.Glob("*.yml")
+		plans = append(plans, yml...)
+	}
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, planFile := range plans {
+		planBuf, err := ioutil.ReadFile(planFile)
+		if err != nil {
+			continue
+		}
+		plan, err := henchman.NewPlanFromYAML(planBuf, nil)
+		if err != nil {
+			continue
+		}
+		for _, host := range plan.Hosts {
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Prints the dynamic values the completion scripts ask for, one per line
+func runComplete(args []string, modulesPath string) {
+	if len(args) == 0 {
+		return
+	}
+	var values []string
+	switch args[0] {
+	case "commands":
+		values = commands
+	case "flags":
+		flag.VisitAll(func(f *flag.Flag) {
+			values = append(values, "-"+f.Name)
+		})
+	case "modules":
+		modules, _ := henchman.DiscoverModules(modulesPath)
+		for _, module := range modules {
+			values = append(values, module.Name)
+		}
+	case "hosts":
+		values = completeHosts(args[1:])
+	}
+	for _, value := range values {
+		fmt.Println(value)
+	}
+}
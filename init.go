@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Scaffolds a plan skeleton in the given directory, the current one by default
+func runInit(args []string) {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	files, err := henchman.Scaffold(dir)
+	if err != nil {
+		log.Fatalf("Couldn't scaffold the plan: %s", err)
+	}
+	for _, file := range files {
+		fmt.Printf("created %s\n", file)
+	}
+}
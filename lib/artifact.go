@@ -0,0 +1,47 @@
+package henchman
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/flosch/pongo2"
+)
+
+// Run identifies a single invocation of henchman. Per-host artifacts are
+// namespaced with it so that repeated runs don't clobber each other.
+type Run struct {
+	Id      string
+	Started time.Time
+}
+
+func NewRun() *Run {
+	return &Run{Id: uuid.New(), Started: time.Now()}
+}
+
+// Renders an artifact path for the machine. The path can refer to
+// {{ host }}, {{ run_id }} and {{ date }}.
+func (run *Run) ArtifactPath(pattern string, machine *Machine) (string, error) {
+	tmpl, err := pongo2.FromString(pattern)
+	if err != nil {
+		return "", err
+	}
+	return tmpl.Execute(pongo2.Context{
+		"host":   machine.Hostname,
+		"run_id": run.Id,
+		"date":   run.Started.Format("2006-01-02"),
+	})
+}
+
+// Creates the artifact file for the machine along with its parent directories.
+func (run *Run) CreateArtifact(pattern string, machine *Machine) (*os.File, error) {
+	artifact, err := run.ArtifactPath(pattern, machine)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(artifact), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(artifact)
+}
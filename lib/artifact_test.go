@@ -0,0 +1,41 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestArtifactPath(t *testing.T) {
+	run := Run{Id: "fake-run", Started: time.Date(2014, 9, 1, 10, 0, 0, 0, time.UTC)}
+	machine := Machine{Hostname: "foobar", Port: 22}
+
+	artifact, err := run.ArtifactPath("logs/{{ date }}/{{ run_id }}/{{ host }}.log", &machine)
+	if err != nil {
+		t.Fatalf("Couldn't render the artifact path: %s\n", err)
+	}
+	if artifact != "logs/2014-09-01/fake-run/foobar.log" {
+		t.Errorf("Artifact path mismatch. Got %s\n", artifact)
+	}
+}
+
+func TestCreateArtifact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	run := NewRun()
+	machine := Machine{Hostname: "foobar", Port: 22}
+	f, err := run.CreateArtifact(path.Join(dir, "{{ run_id }}", "{{ host }}.log"), &machine)
+	if err != nil {
+		t.Fatalf("Couldn't create the artifact: %s\n", err)
+	}
+	f.Close()
+	if _, err := os.Stat(path.Join(dir, run.Id, "foobar.log")); err != nil {
+		t.Errorf("Artifact wasn't created: %s\n", err)
+	}
+}
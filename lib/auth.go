@@ -1,8 +1,15 @@
 package henchman
 
 import (
-	"code.google.com/p/go.crypto/ssh"
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
 	"io/ioutil"
+	"sync"
+
+	"code.google.com/p/go.crypto/ssh"
 )
 
 const (
@@ -11,11 +18,27 @@ const (
 	TTY_OP_OSPEED = 129
 )
 
+// Asked for the passphrase of encrypted private keys. Encrypted keys can't
+// be used when nil.
+var KeyPassphrases CredentialProvider
+
+// Decrypted keys by file, so that keys shared by hosts and jump hosts only
+// need their passphrase once.
+var decryptedKeys = struct {
+	sync.Mutex
+	signers map[string]ssh.Signer
+}{signers: make(map[string]ssh.Signer)}
+
 func loadPEM(file string) (ssh.Signer, error) {
 	buf, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
+	if block, _ := pem.Decode(buf); block != nil && x509.IsEncryptedPEMBlock(block) {
+		return loadEncryptedPEM(file, block)
+	} else if block != nil && isEncryptedOpenSSHBlock(block) {
+		return nil, fmt.Errorf("%s: unsupported key format, passphrase protected OpenSSH keys can't be decrypted. Convert it with 'ssh-keygen -p -m PEM -f %s'", file, file)
+	}
 	key, err := ssh.ParsePrivateKey(buf)
 	if err != nil {
 		return nil, err
@@ -23,6 +46,66 @@ func loadPEM(file string) (ssh.Signer, error) {
 	return key, nil
 }
 
+// Keys in OpenSSH's own format, which ssh-keygen writes by default, start
+// with this magic followed by the name of their cipher
+const openSSHKeyMagic = "openssh-key-v1\x00"
+
+// Returns whether the block is an OpenSSH format key encrypted with a passphrase
+func isEncryptedOpenSSHBlock(block *pem.Block) bool {
+	if block.Type != "OPENSSH PRIVATE KEY" || !bytes.HasPrefix(block.Bytes, []byte(openSSHKeyMagic)) {
+		return false
+	}
+	rest := block.Bytes[len(openSSHKeyMagic):]
+	if len(rest) < 4 {
+		return false
+	}
+	length := binary.BigEndian.Uint32(rest)
+	if uint32(len(rest)-4) < length {
+		return false
+	}
+	return string(rest[4:4+length]) != "none"
+}
+
+func loadEncryptedPEM(file string, block *pem.Block) (ssh.Signer, error) {
+	decryptedKeys.Lock()
+	defer decryptedKeys.Unlock()
+	if signer, present := decryptedKeys.signers[file]; present {
+		return signer, nil
+	}
+	if KeyPassphrases == nil {
+		return nil, fmt.Errorf("%s is encrypted and there's no way to ask for its passphrase", file)
+	}
+	passphrase, err := KeyPassphrases.Credential(KeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+	if err == x509.IncorrectPasswordError {
+		return nil, fmt.Errorf("Wrong passphrase for %s", file)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	default:
+		err = fmt.Errorf("Unsupported encrypted key type '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	decryptedKeys.signers[file] = signer
+	return signer, nil
+}
+
 func ClientKeyAuth(keyFile string) (ssh.AuthMethod, error) {
 	key, err := loadPEM(keyFile)
 	return ssh.PublicKeys(key), err
@@ -0,0 +1,91 @@
+package henchman
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+type countingCredentials struct {
+	secret string
+	asked  int
+}
+
+func (provider *countingCredentials) Credential(kind string) (string, error) {
+	provider.asked++
+	return provider.secret, nil
+}
+
+func TestEncryptedKeyAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte("sesame"), x509.PEMCipherAES128)
+	if err != nil {
+		panic(err)
+	}
+	keyFile := path.Join(dir, "id_rsa")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+	defer func() { KeyPassphrases = nil }()
+
+	KeyPassphrases = &countingCredentials{secret: "wrong"}
+	if _, err := ClientKeyAuth(keyFile); err == nil {
+		t.Errorf("Expected a wrong passphrase to fail\n")
+	}
+
+	credentials := &countingCredentials{secret: "sesame"}
+	KeyPassphrases = credentials
+	for i := 0; i < 2; i++ {
+		if _, err := ClientKeyAuth(keyFile); err != nil {
+			t.Fatalf("Couldn't use the encrypted key: %s\n", err)
+		}
+	}
+	if credentials.asked != 1 {
+		t.Errorf("Expected the passphrase to be asked for once. Got %d\n", credentials.asked)
+	}
+}
+
+func TestEncryptedOpenSSHKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	key := func(cipher string) []byte {
+		body := []byte(openSSHKeyMagic)
+		body = append(body, 0, 0, 0, byte(len(cipher)))
+		body = append(body, cipher...)
+		body = append(body, "rest of the key"...)
+		return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: body})
+	}
+	keyFile := path.Join(dir, "id_ed25519")
+	ioutil.WriteFile(keyFile, key("aes256-ctr"), 0600)
+	credentials := &countingCredentials{secret: "sesame"}
+	KeyPassphrases = credentials
+	defer func() { KeyPassphrases = nil }()
+
+	_, err = ClientKeyAuth(keyFile)
+	if err == nil || !strings.Contains(err.Error(), "unsupported key format") {
+		t.Errorf("Expected encrypted OpenSSH keys to be refused. Got %v\n", err)
+	}
+	if credentials.asked != 0 {
+		t.Errorf("Expected no passphrase to be asked for. Got %d\n", credentials.asked)
+	}
+
+	ioutil.WriteFile(keyFile, key("none"), 0600)
+	if _, err := ClientKeyAuth(keyFile); err != nil && strings.Contains(err.Error(), "unsupported key format") {
+		t.Errorf("Unencrypted OpenSSH keys shouldn't be refused as encrypted. Got %s\n", err)
+	}
+}
@@ -0,0 +1,127 @@
+package henchman
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// Whether the task is a plain shell command that can be coalesced with
+// its neighbours into a single remote script.
+func (task *Task) batchable() bool {
+	return task.Action != "" && task.Script == "" && task.Sandbox == nil && task.Retry == nil && task.Env == nil && task.Copy == nil && task.Template == nil && task.Fetch == nil && task.Module == nil && task.Verify == nil && task.become() == nil && task.Group == "" && task.When == "" && task.WithItems == nil && task.Register == "" && task.Until == "" && task.Retries == 0 && task.Timeout == "" && !task.LocalAction
+}
+
+// Returns how many of the leading tasks can be run as a single batch
+func BatchLength(tasks []Task) int {
+	n := 0
+	for n < len(tasks) && tasks[n].batchable() {
+		n++
+	}
+	return n
+}
+
+// Each step runs in a subshell, like it would in a session of its own, and
+// is followed by a marker line with its exit code. The script stops at the
+// first step that fails unless the step ignores errors.
+func batchScript(tasks []Task, marker string) string {
+	var script []string
+	for i, task := range tasks {
+		script = append(script, "("+task.Action+")")
+		script = append(script, fmt.Sprintf(`code=$?; printf '\n%s %d %%d\n' $code`, marker, i))
+		if !task.IgnoreErrors {
+			script = append(script, `[ $code -eq 0 ] || exit $code`)
+		}
+	}
+	return strings.Join(script, "\n") + "\n"
+}
+
+type batchStep struct {
+	output string
+	code   int
+}
+
+// Splits the batch's output back into its steps. Steps that didn't get to
+// print a marker aren't returned, their output is returned as the rest.
+func parseBatchOutput(out string, marker string) ([]batchStep, string) {
+	var steps []batchStep
+	for {
+		at := strings.Index(out, "\n"+marker+" ")
+		if at < 0 {
+			return steps, out
+		}
+		line := out[at+1:]
+		end := strings.Index(line, "\n")
+		if end < 0 {
+			return steps, out
+		}
+		fields := strings.Fields(line[:end])
+		code, _ := strconv.Atoi(fields[len(fields)-1])
+		steps = append(steps, batchStep{out[:at], code})
+		out = line[end+1:]
+	}
+}
+
+// Runs the batchable tasks as a single script on the machine, saving a
+// round trip per task. The tasks are prepared in place like Run does.
+// Statuses are returned for the tasks that were attempted, along with the
+// error of the last one. The batch's duration is split evenly across them.
+func (machine *Machine) RunBatch(tasks []Task, vars *TaskVars) ([]*TaskStatus, error) {
+	for i := range tasks {
+		if err := tasks[i].prepare(vars, machine); err != nil {
+			// The tasks before it run as they would have one by one, it
+			// fails without running
+			failed := tasks[i].status("", err, 0)
+			if i == 0 {
+				return []*TaskStatus{failed}, err
+			}
+			statuses, batchErr := machine.runBatch(tasks[:i])
+			if len(statuses) < i || (batchErr != nil && !tasks[i-1].IgnoreErrors) {
+				return statuses, batchErr
+			}
+			return append(statuses, failed), err
+		}
+		log.Printf("%s: %s:%d '%s' (batched)\n", tasks[i].Id, machine.Hostname, machine.Port, tasks[i].Name)
+	}
+	return machine.runBatch(tasks)
+}
+
+func (machine *Machine) runBatch(tasks []Task) ([]*TaskStatus, error) {
+	marker := "__henchman_step_" + strings.Replace(uuid.New(), "-", "", -1)
+	start := time.Now()
+	out, err := machine.run("sh -s", strings.NewReader(batchScript(tasks, marker)))
+	if machine.Noop != nil {
+		// Nothing ran, so every step is taken to succeed
+		var statuses []*TaskStatus
+		for i := range tasks {
+			statuses = append(statuses, tasks[i].status("", nil, 0))
+		}
+		return statuses, nil
+	}
+	steps, rest := parseBatchOutput(out.String(), marker)
+	duration := time.Since(start) / time.Duration(len(tasks))
+
+	var statuses []*TaskStatus
+	var stepErr error
+	for i, step := range steps {
+		stepErr = nil
+		if step.code != 0 {
+			stepErr = fmt.Errorf("Process exited with status %d", step.code)
+		}
+		statuses = append(statuses, tasks[i].status(step.output, stepErr, duration))
+	}
+	last := len(steps) - 1
+	if len(steps) < len(tasks) && (last < 0 || steps[last].code == 0 || tasks[last].IgnoreErrors) {
+		// The batch was cut short before the step could finish
+		if err == nil {
+			err = fmt.Errorf("Batch ended before the step finished")
+		}
+		statuses = append(statuses, tasks[len(steps)].status(rest, err, duration))
+		return statuses, err
+	}
+	return statuses, stepErr
+}
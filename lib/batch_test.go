@@ -0,0 +1,65 @@
+package henchman
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestBatchLength(t *testing.T) {
+	tasks := []Task{
+		{Name: "one", Action: "ls"},
+		{Name: "two", Action: "uname -a"},
+		{Name: "three", Script: "scripts/setup.sh"},
+		{Name: "four", Action: "ls"},
+	}
+	if n := BatchLength(tasks); n != 2 {
+		t.Errorf("Batch length mismatch. Got %d\n", n)
+	}
+	if n := BatchLength(tasks[2:]); n != 0 {
+		t.Errorf("Expected script tasks not to be batched. Got %d\n", n)
+	}
+}
+
+func TestBatchScript(t *testing.T) {
+	tasks := []Task{
+		{Name: "one", Action: "echo one"},
+		{Name: "two", Action: "printf two; exit 3", IgnoreErrors: true},
+		{Name: "three", Action: "echo three; false"},
+		{Name: "four", Action: "echo four"},
+	}
+	marker := "__henchman_step_test"
+	out, _ := exec.Command("sh", "-c", batchScript(tasks, marker)).CombinedOutput()
+	steps, rest := parseBatchOutput(string(out), marker)
+	expected := []batchStep{{"one\n", 0}, {"two", 3}, {"three\n", 1}}
+	if len(steps) != len(expected) {
+		t.Fatalf("Number of steps mismatch. Got %v\n", steps)
+	}
+	for i, step := range steps {
+		if step != expected[i] {
+			t.Errorf("Step %d mismatch. Got %v instead of %v\n", i, step, expected[i])
+		}
+	}
+	if rest != "" {
+		t.Errorf("Expected the script to stop at the failed step. Got %s\n", rest)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	machine := Machine{Hostname: "127.0.0.1"}
+	tasks := []Task{
+		{Name: "one", Action: "echo {{ vars.greeting }}"},
+		{Name: "two", Action: "false"},
+		{Name: "three", Action: "echo three"},
+	}
+	vars := TaskVars{"greeting": "hello"}
+	statuses, err := machine.RunBatch(tasks, &vars)
+	if len(statuses) != 2 {
+		t.Fatalf("Expected the batch to stop at the failed task. Got %d statuses\n", len(statuses))
+	}
+	if statuses[0].Status != "ok" || statuses[0].Message != "hello\n" {
+		t.Errorf("First task mismatch. Got %s - %s\n", statuses[0].Status, statuses[0].Message)
+	}
+	if statuses[1].Status != "failed" || err == nil {
+		t.Errorf("Expected the second task to fail. Got %s\n", statuses[1].Status)
+	}
+}
@@ -0,0 +1,50 @@
+package henchman
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// Password fed to sudo for the tasks that run with it. sudo is run without
+// prompting when it's empty, failing if it would need a password.
+var BecomePassword string
+
+var errLocalBecome = errors.New("sudo isn't supported for local actions")
+
+// How a task runs its command with sudo
+type become struct {
+	User     string
+	Password string
+}
+
+// Returns how the task runs with sudo, nil if it doesn't
+func (task *Task) become() *become {
+	if task.Sudo == nil || !*task.Sudo {
+		return nil
+	}
+	return &become{User: task.BecomeUser, Password: BecomePassword}
+}
+
+// Wraps the command so that it runs with sudo. The password, if any, is fed
+// to sudo ahead of the command's own stdin. A nil become leaves the command
+// alone.
+func (become *become) wrap(command string, stdin io.Reader) (string, io.Reader) {
+	if become == nil {
+		return command, stdin
+	}
+	sudo := "sudo -n"
+	if become.Password != "" {
+		sudo = "sudo -S -p ''"
+		password := strings.NewReader(become.Password + "\n")
+		if stdin == nil {
+			stdin = password
+		} else {
+			stdin = io.MultiReader(password, stdin)
+		}
+	}
+	if become.User != "" {
+		sudo += " -u " + shellQuote(become.User)
+	}
+	return sudo + " sh -c " + shellQuote(command), stdin
+}
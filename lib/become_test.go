@@ -0,0 +1,59 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestBecomeWrap(t *testing.T) {
+	var none *become
+	if command, stdin := none.wrap("ls", nil); command != "ls" || stdin != nil {
+		t.Errorf("Expected the command to be left alone. Got %s\n", command)
+	}
+
+	command, stdin := (&become{User: "postgres"}).wrap("psql -c 'select 1'", nil)
+	if command != `sudo -n -u 'postgres' sh -c 'psql -c '\''select 1'\'''` || stdin != nil {
+		t.Errorf("Command mismatch. Got %s\n", command)
+	}
+
+	command, stdin = (&become{Password: "s3cret"}).wrap("sh -s", strings.NewReader("echo hi\n"))
+	if command != `sudo -S -p '' sh -c 'sh -s'` {
+		t.Errorf("Command mismatch. Got %s\n", command)
+	}
+	if buf, _ := ioutil.ReadAll(stdin); string(buf) != "s3cret\necho hi\n" {
+		t.Errorf("Expected the password ahead of the script. Got %q\n", buf)
+	}
+}
+
+func TestParsePlanWithSudo(t *testing.T) {
+	plan_string := `---
+name: "Sample plan"
+hosts:
+  - 192.168.1.2
+sudo: true
+become_user: deploy
+tasks:
+  - name: Restart app
+    action: systemctl restart app
+  - name: Vacuum
+    action: vacuumdb --all
+    become_user: postgres
+  - name: Whoami
+    action: whoami
+    sudo: false
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		panic(err)
+	}
+	if b := plan.Tasks[0].become(); b == nil || b.User != "deploy" {
+		t.Errorf("Expected the plan's sudo settings. Got %v\n", b)
+	}
+	if b := plan.Tasks[1].become(); b == nil || b.User != "postgres" {
+		t.Errorf("Expected the task's become_user. Got %v\n", b)
+	}
+	if b := plan.Tasks[2].become(); b != nil {
+		t.Errorf("Expected the task to opt out of sudo. Got %v\n", b)
+	}
+}
@@ -0,0 +1,143 @@
+package henchman
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// BenchResult holds the measurements taken against a single machine.
+// Throughputs are in bytes per second.
+type BenchResult struct {
+	Machine *Machine
+	Connect []time.Duration
+	Exec    []time.Duration
+	Put     []float64
+	Get     []float64
+	Err     error
+}
+
+// Benchmarks the SSH path to the machine. Every round dials a fresh connection
+// to measure the handshake, while the exec round trips and the transfers are
+// multiplexed as sessions over a single connection.
+func (machine *Machine) Bench(rounds int, size int) *BenchResult {
+	result := &BenchResult{Machine: machine}
+	for i := 0; i < rounds; i++ {
+		start := time.Now()
+		client, err := machine.dial()
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Connect = append(result.Connect, time.Since(start))
+		client.Close()
+	}
+
+	client, err := machine.dial()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer client.Close()
+
+	payload := make([]byte, size)
+	get := fmt.Sprintf("head -c %d /dev/zero", size)
+	for i := 0; i < rounds; i++ {
+		elapsed, err := benchSession(client, "true", nil, nil)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Exec = append(result.Exec, elapsed)
+
+		elapsed, err = benchSession(client, "cat > /dev/null", bytes.NewReader(payload), nil)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Put = append(result.Put, float64(size)/elapsed.Seconds())
+
+		elapsed, err = benchSession(client, get, nil, ioutil.Discard)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Get = append(result.Get, float64(size)/elapsed.Seconds())
+	}
+	return result
+}
+
+// Times a single command run over a new session, including the session setup.
+func benchSession(client *ssh.Client, command string, stdin io.Reader, stdout io.Writer) (time.Duration, error) {
+	start := time.Now()
+	session, err := client.NewSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+	session.Stdin = stdin
+	session.Stdout = stdout
+	err = session.Run(command)
+	return time.Since(start), err
+}
+
+// Returns the p-th percentile of the samples using the nearest-rank method.
+func percentile(samples []float64, p float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func seconds(durations []time.Duration) []float64 {
+	var samples []float64
+	for _, d := range durations {
+		samples = append(samples, d.Seconds())
+	}
+	return samples
+}
+
+func printPercentiles(label string, samples []float64, format func(float64) string) {
+	fmt.Printf("  %s:\tp50 %s\tp90 %s\tp99 %s\n", label,
+		format(percentile(samples, 50)),
+		format(percentile(samples, 90)),
+		format(percentile(samples, 99)))
+}
+
+// Prints the percentiles collected for every machine that was benchmarked
+func PrintBenchReport(results []*BenchResult) {
+	latency := func(s float64) string {
+		return fmt.Sprintf("%.1fms", s*1000)
+	}
+	throughput := func(bps float64) string {
+		return fmt.Sprintf("%.2fMB/s", bps/(1<<20))
+	}
+	fmt.Println()
+	fmt.Println("---")
+	fmt.Println("Bench Report")
+	for _, result := range results {
+		fmt.Println()
+		fmt.Println(result.Machine.address())
+		if result.Err != nil {
+			fmt.Printf("  error:\t%s\n", result.Err)
+			continue
+		}
+		printPercentiles("connect", seconds(result.Connect), latency)
+		printPercentiles("exec", seconds(result.Exec), latency)
+		printPercentiles("put", result.Put, throughput)
+		printPercentiles("get", result.Get, throughput)
+	}
+}
@@ -0,0 +1,23 @@
+package henchman
+
+import "testing"
+
+func TestPercentile(t *testing.T) {
+	samples := []float64{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+
+	if p := percentile(samples, 50); p != 5 {
+		t.Errorf("p50 mismatch. Got %f instead\n", p)
+	}
+	if p := percentile(samples, 90); p != 9 {
+		t.Errorf("p90 mismatch. Got %f instead\n", p)
+	}
+	if p := percentile(samples, 99); p != 10 {
+		t.Errorf("p99 mismatch. Got %f instead\n", p)
+	}
+	if samples[0] != 5 {
+		t.Errorf("percentile shouldn't reorder the samples\n")
+	}
+	if p := percentile(nil, 50); p != 0 {
+		t.Errorf("p50 of no samples should be 0. Got %f instead\n", p)
+	}
+}
@@ -0,0 +1,83 @@
+package henchman
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+var validUsername = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// Returns a shell script that creates the automation user, authorizes the
+// public key for it and gives it passwordless sudo. The script is idempotent
+// so that bootstrapping a machine twice is harmless.
+func BootstrapScript(user string, publicKey []byte) (string, error) {
+	if !validUsername.MatchString(user) {
+		return "", fmt.Errorf("Invalid username '%s'", user)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("Invalid public key: %s", err)
+	}
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	sudoers := "/etc/sudoers.d/henchman-" + user
+
+	script := []string{
+		"set -e",
+		fmt.Sprintf("id -u %s >/dev/null 2>&1 || useradd -m -s /bin/sh %s", user, user),
+		fmt.Sprintf("home=$(getent passwd %s | cut -d: -f6)", user),
+		`mkdir -p "$home/.ssh"`,
+		fmt.Sprintf(`grep -qxF '%s' "$home/.ssh/authorized_keys" 2>/dev/null || echo '%s' >> "$home/.ssh/authorized_keys"`, authorizedKey, authorizedKey),
+		`chmod 700 "$home/.ssh"`,
+		`chmod 600 "$home/.ssh/authorized_keys"`,
+		fmt.Sprintf(`chown -R %s "$home/.ssh"`, user),
+		fmt.Sprintf("echo '%s ALL=(ALL) NOPASSWD: ALL' > %s.tmp", user, sudoers),
+		fmt.Sprintf("visudo -cf %s.tmp", sudoers),
+		fmt.Sprintf("chmod 440 %s.tmp", sudoers),
+		fmt.Sprintf("mv %s.tmp %s", sudoers, sudoers),
+	}
+	return strings.Join(script, "\n") + "\n", nil
+}
+
+// Wraps the string in single quotes for the remote shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Runs the bootstrap script on the machine. Unless we're logged in as root,
+// the script is run with sudo and the password is fed to it on stdin. Sudo
+// gets a terminal since fresh hosts often have requiretty set.
+func (machine *Machine) Bootstrap(script string, password string) (*Output, error) {
+	command := "sh -c " + shellQuote(script)
+	if machine.SSHConfig.User == "root" {
+		return machine.Exec(command)
+	}
+	return machine.runTerminal("sudo -S -p '' "+command, strings.NewReader(password+"\n"))
+}
+
+// Like run, but requests a pseudo terminal for remote actions even though
+// they're fed stdin. Only fit for input that's read line by line.
+func (machine *Machine) runTerminal(action string, stdin io.Reader) (*Output, error) {
+	if machine.Noop != nil || machine.Transport != nil || machine.isLocal() {
+		return machine.run(action, stdin)
+	}
+	b := NewOutput()
+	defer b.Close()
+
+	session, err := machine.openSession()
+	if err != nil {
+		return b, err
+	}
+	defer session.Close()
+
+	if err := session.RequestPty("xterm", 80, 40, terminalModes); err != nil {
+		return b, err
+	}
+	session.Stdin = stdin
+	session.Stdout = b
+	session.Stderr = b
+	return b, machine.runSession(session, action)
+}
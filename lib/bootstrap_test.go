@@ -0,0 +1,59 @@
+package henchman
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPublicKey = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCwH8ynEshmPQKqfNpBRqsOAlvbhuO3UjjUOAuL/VWm6RPrK5M1K+yVxpFyX0mnrrYy3hPh+gHpVQ9L2z4hWqorFJ2ITjx6/IAfJGnrazFlF48iyfiHjuGrG35TQhF3/rFp0FUc7Lf0OGKcsIvARWjWJrmJGEKkbJ3fZEzQLJbW7C6GClE2HmvnXPPz3LLtiZbcsRaHhKK7VsIBUxXfp5GTyjTZDTCK7SfLlDkSIEg7bLKZKckwQG6Z9ZbzOejR9Y+FT+nsnEuTnGCSjR8xaAkK8PItgGnpikLIisq20O6ITJ/dij3DaDgdMMkGPDTqW9RqF1qSq/dy0XgdXpx0IXw1 sudharsh@cassini.lan"
+
+func TestBootstrapScript(t *testing.T) {
+	script, err := BootstrapScript("deploy", []byte(testPublicKey))
+	if err != nil {
+		t.Fatalf("Couldn't build the bootstrap script: %s\n", err)
+	}
+	if !strings.Contains(script, "useradd -m -s /bin/sh deploy") {
+		t.Errorf("Bootstrap script should create the user. Got %s\n", script)
+	}
+	if !strings.Contains(script, "visudo -cf /etc/sudoers.d/henchman-deploy.tmp") {
+		t.Errorf("Bootstrap script should validate the sudoers file. Got %s\n", script)
+	}
+	if strings.Contains(script, "sudharsh@cassini.lan") {
+		t.Errorf("The key comment shouldn't make it into the script. Got %s\n", script)
+	}
+}
+
+func TestBootstrapScriptValidation(t *testing.T) {
+	if _, err := BootstrapScript("deploy; rm -rf /", []byte(testPublicKey)); err == nil {
+		t.Errorf("Invalid usernames should be rejected\n")
+	}
+	if _, err := BootstrapScript("deploy", []byte("ssh-rsa not-a-key'")); err == nil {
+		t.Errorf("Invalid public keys should be rejected\n")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if quoted := shellQuote("echo 'hi'"); quoted != `'echo '\''hi'\'''` {
+		t.Errorf("Quoting mismatch. Got %s\n", quoted)
+	}
+}
+
+func TestBootstrapWithSudo(t *testing.T) {
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	var command, input string
+	server.stdin = func(c string, i []byte) {
+		command, input = c, string(i)
+	}
+	if _, err := server.machine().Bootstrap("true", "secret"); err != nil {
+		t.Fatalf("Bootstrap failed. Got %s\n", err)
+	}
+	if !strings.HasPrefix(command, "sudo -S") || input != "secret\n" {
+		t.Errorf("The script should be run with sudo fed the password. Got %q with %q\n", command, input)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.ptys != 1 {
+		t.Errorf("Sudo should get a terminal for requiretty. Got %d ptys\n", server.ptys)
+	}
+}
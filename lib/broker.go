@@ -0,0 +1,369 @@
+package henchman
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+var errBrokerUnavailable = errors.New("Connection broker unavailable")
+
+// A machine the broker connects to, with the per-host settings it would
+// be connected to with directly. Address is of the form host:port.
+type brokerHost struct {
+	User    string
+	Address string
+	Keyfile string
+	HostKey string
+}
+
+// Asks the broker to run a command on a machine, through the hops if any
+type brokerRequest struct {
+	brokerHost
+	Via      []brokerHost
+	Timeouts Timeouts
+	Command  string
+	Stdin    []byte
+}
+
+// Errors are sent along with their exit code, if the command exited, and
+// their category so that the client can rebuild them, see BrokerError.
+type brokerResponse struct {
+	Output   []byte
+	Error    string
+	Exited   bool
+	ExitCode int
+	Category string
+}
+
+// BrokerError is the error a command run by the broker failed with
+type BrokerError struct {
+	Message string
+	// Exit code of the command, -1 if it didn't exit
+	ExitCode int
+	Category string
+}
+
+func (e *BrokerError) Error() string {
+	return e.Message
+}
+
+type brokerClient struct {
+	client   *ssh.Client
+	lastUsed time.Time
+	// Commands running over the connection, which keep it from being reaped
+	inFlight int
+}
+
+// Broker keeps SSH connections to the machines it is asked to run commands
+// on open between henchman invocations, so that only the first run against
+// a host pays for the connection setup. Connections that are idle for longer
+// than Idle are closed.
+type Broker struct {
+	Config *ssh.ClientConfig
+	Idle   time.Duration
+
+	mutex   sync.Mutex
+	clients map[string]*brokerClient
+}
+
+func NewBroker(config *ssh.ClientConfig, idle time.Duration) *Broker {
+	return &Broker{Config: config, Idle: idle, clients: make(map[string]*brokerClient)}
+}
+
+// Serves requests on the unix socket until the listener fails. Refuses to
+// start if another broker is already listening on the socket.
+func (broker *Broker) ListenAndServe(socket string) error {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("A broker is already listening on %s", socket)
+	}
+	os.Remove(socket)
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	if err := os.Chmod(socket, 0600); err != nil {
+		return err
+	}
+
+	go broker.reap()
+	log.Printf("Broker listening on %s\n", socket)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go broker.serve(conn)
+	}
+}
+
+func (broker *Broker) reap() {
+	for _ = range time.Tick(broker.Idle / 2) {
+		broker.reapIdle()
+	}
+}
+
+// Closes the connections that no command has run over for longer than Idle
+func (broker *Broker) reapIdle() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for key, cached := range broker.clients {
+		if cached.inFlight == 0 && time.Since(cached.lastUsed) > broker.Idle {
+			log.Printf("Closing idle connection %s\n", key)
+			cached.client.Close()
+			delete(broker.clients, key)
+		}
+	}
+}
+
+// Builds the machine the request is for. Hosts without a keyfile of their
+// own use the broker's credentials.
+func (broker *Broker) machine(request *brokerRequest) (*Machine, error) {
+	build := func(host brokerHost) (*Machine, error) {
+		config := *broker.Config
+		config.User = host.User
+		if host.Keyfile != "" {
+			auth, err := ClientKeyAuth(host.Keyfile)
+			if err != nil {
+				return nil, fmt.Errorf("Keyfile of %s: %s", host.Address, err)
+			}
+			config.Auth = []ssh.AuthMethod{auth}
+		}
+		machine := Machines([]string{host.Address}, &config)[0]
+		machine.Timeouts = request.Timeouts
+		machine.Keyfile = host.Keyfile
+		if host.HostKey != "" {
+			machine.pinHostKey(host.HostKey)
+		}
+		return machine, nil
+	}
+	machine, err := build(request.brokerHost)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range request.Via {
+		hop, err := build(host)
+		if err != nil {
+			return nil, err
+		}
+		machine.Via = append(machine.Via, hop)
+	}
+	return machine, nil
+}
+
+// Connections are only shared by requests connecting the same way
+func (host brokerHost) key() string {
+	key := host.User + "@" + host.Address
+	if host.Keyfile != "" || host.HostKey != "" {
+		key += " " + host.Keyfile + " " + host.HostKey
+	}
+	return key
+}
+
+func (request *brokerRequest) key() string {
+	var chain []string
+	for _, hop := range request.Via {
+		chain = append(chain, hop.key())
+	}
+	chain = append(chain, request.brokerHost.key())
+	return strings.Join(chain, ",")
+}
+
+// Describes the machine to the broker
+func (machine *Machine) brokerHost() brokerHost {
+	return brokerHost{
+		User:    machine.SSHConfig.User,
+		Address: machine.address(),
+		Keyfile: machine.Keyfile,
+		HostKey: machine.HostKey,
+	}
+}
+
+// Returns the cached connection for the request, dialing it if needed. The
+// lock isn't held while dialing so that slow hosts don't hold up the rest.
+// The connection is in use until it is released.
+func (broker *Broker) client(request *brokerRequest) (*brokerClient, error) {
+	key := request.key()
+	broker.mutex.Lock()
+	if cached, present := broker.clients[key]; present {
+		cached.lastUsed = time.Now()
+		cached.inFlight++
+		broker.mutex.Unlock()
+		return cached, nil
+	}
+	broker.mutex.Unlock()
+
+	machine, err := broker.machine(request)
+	if err != nil {
+		return nil, err
+	}
+	client, err := machine.dial()
+	if err != nil {
+		return nil, err
+	}
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if cached, present := broker.clients[key]; present {
+		// Lost the race to another request for the same machine
+		client.Close()
+		cached.lastUsed = time.Now()
+		cached.inFlight++
+		return cached, nil
+	}
+	cached := &brokerClient{client: client, lastUsed: time.Now(), inFlight: 1}
+	broker.clients[key] = cached
+	return cached, nil
+}
+
+// Marks the connection idle from now on, unless other commands still run
+// over it
+func (broker *Broker) release(cached *brokerClient) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	cached.inFlight--
+	cached.lastUsed = time.Now()
+}
+
+// Closes the connection, which can't open sessions anymore
+func (broker *Broker) evict(request *brokerRequest, cached *brokerClient) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	cached.client.Close()
+	if broker.clients[request.key()] == cached {
+		delete(broker.clients, request.key())
+	}
+}
+
+func (broker *Broker) serve(conn net.Conn) {
+	defer conn.Close()
+	var request brokerRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		log.Printf("Bad broker request: %s\n", err)
+		return
+	}
+	// The client hangs up when its command is aborted
+	gone := make(chan struct{})
+	go func() {
+		conn.Read(make([]byte, 1))
+		close(gone)
+	}()
+	out, err := broker.run(&request, gone)
+	response := brokerResponse{Output: []byte(out.String())}
+	if err != nil {
+		response.Error = err.Error()
+		response.ExitCode, response.Exited = exitStatus(err)
+		response.Category = ErrorCategory(err)
+	}
+	json.NewEncoder(conn).Encode(&response)
+}
+
+// Runs the request over the cached connection. A cached connection that can't
+// open sessions anymore is assumed dead and is redialed once. The command is
+// killed if gone is closed before it finishes.
+func (broker *Broker) run(request *brokerRequest, gone <-chan struct{}) (*Output, error) {
+	b := NewOutput()
+	defer b.Close()
+	var session *ssh.Session
+	for attempt := 0; attempt < 2; attempt++ {
+		cached, err := broker.client(request)
+		if err != nil {
+			return b, err
+		}
+		if session, err = cached.client.NewSession(); err == nil {
+			defer broker.release(cached)
+			break
+		}
+		broker.release(cached)
+		broker.evict(request, cached)
+	}
+	if session == nil {
+		return b, fmt.Errorf("Unable to create session on %s", request.Address)
+	}
+	defer session.Close()
+
+	if request.Stdin == nil {
+		if err := session.RequestPty("xterm", 80, 40, terminalModes); err != nil {
+			return b, err
+		}
+	} else {
+		session.Stdin = bytes.NewReader(request.Stdin)
+	}
+	session.Stdout = b
+	session.Stderr = b
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-gone:
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		case <-finished:
+		}
+	}()
+	return b, session.Run(request.Command)
+}
+
+// Runs the action through the machine's broker. errBrokerUnavailable is
+// returned when the broker can't be reached so the caller can fall back
+// to connecting directly. The command timeout is enforced here, hanging up
+// makes the broker kill the command.
+func (machine *Machine) runViaBroker(action string, stdin io.Reader) (*Output, error) {
+	conn, err := net.Dial("unix", machine.Broker)
+	if err != nil {
+		return nil, errBrokerUnavailable
+	}
+	defer conn.Close()
+
+	request := brokerRequest{
+		brokerHost: machine.brokerHost(),
+		Timeouts:   machine.Timeouts,
+		Command:    action,
+	}
+	for _, hop := range machine.Via {
+		request.Via = append(request.Via, hop.brokerHost())
+	}
+	if stdin != nil {
+		if request.Stdin, err = ioutil.ReadAll(stdin); err != nil {
+			return NewOutput(), err
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(&request); err != nil {
+		return nil, errBrokerUnavailable
+	}
+
+	var response brokerResponse
+	err = machine.command(func() error {
+		return json.NewDecoder(conn).Decode(&response)
+	}, conn.Close)
+	if err != nil {
+		return NewOutput(), err
+	}
+	if response.Error != "" {
+		brokerErr := &BrokerError{Message: response.Error, ExitCode: -1, Category: response.Category}
+		if response.Exited {
+			brokerErr.ExitCode = response.ExitCode
+		}
+		err = brokerErr
+	}
+	out := NewOutput()
+	out.Write(response.Output)
+	out.Close()
+	return out, err
+}
@@ -0,0 +1,174 @@
+package henchman
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestBrokerMachine(t *testing.T) {
+	broker := NewBroker(&ssh.ClientConfig{User: "broker"}, 0)
+	request := brokerRequest{
+		brokerHost: brokerHost{User: "deploy", Address: "192.168.33.11:2222"},
+		Via:        []brokerHost{{User: "jump", Address: "bastion1:22"}, {User: "root", Address: "bastion2:22"}},
+	}
+	machine, err := broker.machine(&request)
+	if err != nil {
+		t.Fatalf("Couldn't build the machine: %s\n", err)
+	}
+
+	if machine.Hostname != "192.168.33.11" || machine.Port != 2222 {
+		t.Errorf("Machine mismatch. Got %s:%d instead\n", machine.Hostname, machine.Port)
+	}
+	if machine.SSHConfig.User != "deploy" {
+		t.Errorf("Machine user mismatch. Got %s instead\n", machine.SSHConfig.User)
+	}
+	if len(machine.Via) != 2 {
+		t.Fatalf("Number of hops mismatch. Got %d instead\n", len(machine.Via))
+	}
+	if machine.Via[0].Hostname != "bastion1" || machine.Via[0].SSHConfig.User != "jump" {
+		t.Errorf("First hop mismatch. Got %s@%s\n", machine.Via[0].SSHConfig.User, machine.Via[0].Hostname)
+	}
+	if broker.Config.User != "broker" {
+		t.Errorf("Request users shouldn't leak into the broker config. Got %s\n", broker.Config.User)
+	}
+	if key := request.key(); key != "jump@bastion1:22,root@bastion2:22,deploy@192.168.33.11:2222" {
+		t.Errorf("Connection key mismatch. Got %s\n", key)
+	}
+}
+
+func TestBrokerMachineHostSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	keyFile := path.Join(dir, "id_rsa")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey))
+	if err != nil {
+		panic(err)
+	}
+
+	// The client sends the settings the machine would be connected with
+	direct := Machines([]string{"db01:2222"}, &ssh.ClientConfig{User: "deploy"})[0]
+	direct.Timeouts = Timeouts{Connect: time.Second, Command: time.Minute}
+	HostOverride{Keyfile: keyFile}.apply(direct, ssh.Password("unused"))
+	PinHostKeys([]*Machine{direct}, map[string]string{"db01": Fingerprint(hostKey)})
+	request := brokerRequest{brokerHost: direct.brokerHost(), Timeouts: direct.Timeouts}
+
+	broker := NewBroker(&ssh.ClientConfig{User: "broker"}, 0)
+	machine, err := broker.machine(&request)
+	if err != nil {
+		t.Fatalf("Couldn't build the machine: %s\n", err)
+	}
+	if machine.Timeouts != direct.Timeouts {
+		t.Errorf("Timeouts mismatch. Got %+v\n", machine.Timeouts)
+	}
+	if len(machine.SSHConfig.Auth) != 1 || machine.Keyfile != keyFile {
+		t.Errorf("Expected the host's keyfile to be used. Got %s\n", machine.Keyfile)
+	}
+	if err := machine.SSHConfig.HostKeyCallback("db01:2222", nil, hostKey); err != nil {
+		t.Errorf("The pinned key should have been accepted. Got %s\n", err)
+	}
+	request.HostKey = "SHA256:somethingelse"
+	if machine, err = broker.machine(&request); err != nil {
+		t.Fatalf("Couldn't build the machine: %s\n", err)
+	}
+	if _, mismatch := machine.SSHConfig.HostKeyCallback("db01:2222", nil, hostKey).(*HostKeyMismatchError); !mismatch {
+		t.Errorf("A key other than the pinned one should be rejected\n")
+	}
+	if key := request.key(); key != "deploy@db01:2222 "+keyFile+" SHA256:somethingelse" {
+		t.Errorf("Expected the settings in the connection key. Got %s\n", key)
+	}
+
+	request.Keyfile = path.Join(dir, "missing")
+	if _, err := broker.machine(&request); err == nil {
+		t.Errorf("Expected a missing keyfile to fail\n")
+	}
+}
+
+func TestBrokerKeepsBusyConnections(t *testing.T) {
+	broker := NewBroker(&ssh.ClientConfig{User: "broker"}, time.Minute)
+	request := brokerRequest{brokerHost: brokerHost{User: "deploy", Address: "db01:22"}}
+	// A command that has been running for longer than the idle timeout
+	busy := &brokerClient{lastUsed: time.Now().Add(-time.Hour), inFlight: 1}
+	broker.clients[request.key()] = busy
+	broker.reapIdle()
+	if broker.clients[request.key()] != busy {
+		t.Fatalf("Expected the connection with a command running to be kept\n")
+	}
+	cached, err := broker.client(&request)
+	if err != nil || cached != busy || busy.inFlight != 2 {
+		t.Fatalf("Expected the cached connection to be shared. Got %v, %d in flight\n", err, busy.inFlight)
+	}
+	broker.release(cached)
+	broker.release(cached)
+	if busy.inFlight != 0 || time.Since(busy.lastUsed) > time.Second {
+		t.Errorf("Expected the released connection to be idle from now. Got %d in flight, used %s ago\n", busy.inFlight, time.Since(busy.lastUsed))
+	}
+	broker.reapIdle()
+	if broker.clients[request.key()] != busy {
+		t.Errorf("Expected the connection to be kept until it's idle for long enough\n")
+	}
+}
+
+func TestRunViaBrokerErrors(t *testing.T) {
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.status = func(command string) uint32 {
+		if command == "false" {
+			return 3
+		}
+		return 0
+	}
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "broker.sock")
+	broker := NewBroker(&ssh.ClientConfig{HostKeyCallback: server.machine().SSHConfig.HostKeyCallback}, time.Minute)
+	go broker.ListenAndServe(socket)
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			break
+		}
+	}
+
+	machine := server.machine()
+	machine.Broker = socket
+	out, err := machine.runViaBroker("false", nil)
+	brokerErr, ok := err.(*BrokerError)
+	if !ok || brokerErr.ExitCode != 3 || ErrorCategory(err) != "error" {
+		t.Fatalf("Expected the command's exit code back from the broker. Got %#v\n", err)
+	}
+	if result := registeredResult(&TaskStatus{Status: StatusFailed, Message: out.String()}, err); result["rc"] != 3 {
+		t.Errorf("Expected the exit code to be registered. Got %v\n", result["rc"])
+	}
+	if _, err := machine.runViaBroker("true", nil); err != nil {
+		t.Errorf("Expected the command to succeed. Got %s\n", err)
+	}
+}
+
+func TestRunViaUnavailableBroker(t *testing.T) {
+	machine := Machine{Hostname: "foobar", Port: 22, Broker: "/non/existent/broker.sock"}
+	if _, err := machine.runViaBroker("ls", nil); err != errBrokerUnavailable {
+		t.Errorf("Expected the broker to be unavailable. Got %s\n", err)
+	}
+}
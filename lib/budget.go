@@ -0,0 +1,32 @@
+package henchman
+
+import (
+	"fmt"
+	"time"
+)
+
+// How far over their expected duration tasks can run before they're
+// reported as over budget, e.g. 1.5 for 50% over
+var BudgetFactor = 1.5
+
+func validateBudget(expected string) error {
+	if expected == "" {
+		return nil
+	}
+	if budget, err := time.ParseDuration(expected); err != nil || budget <= 0 {
+		return fmt.Errorf("Invalid expected_duration '%s', it has to be a positive duration like '30s'", expected)
+	}
+	return nil
+}
+
+// Returns the task's expected duration, 0 if it has none
+func (task *Task) budget() time.Duration {
+	budget, _ := time.ParseDuration(task.ExpectedDuration)
+	return budget
+}
+
+// Whether the result took longer than its task's budget allows
+func (result *Result) OverBudget() bool {
+	return result.Budget > 0 && result.Status != StatusSkipped &&
+		float64(result.Duration) > float64(result.Budget)*BudgetFactor
+}
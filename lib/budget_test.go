@@ -0,0 +1,50 @@
+package henchman
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	plan_string := `---
+name: "Budgets"
+hosts:
+  - web1
+tasks:
+  - name: Install packages
+    action: apt-get install -y nginx
+    expected_duration: 10s
+  - name: Configure
+    action: echo
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		t.Fatalf("Couldn't read the plan: %s\n", err)
+	}
+	web1, web2 := &Machine{Hostname: "web1"}, &Machine{Hostname: "web2"}
+	plan.SaveStatus(web1, &plan.Tasks[0], &TaskStatus{Status: "ok", Duration: 12 * time.Second})
+	plan.SaveStatus(web2, &plan.Tasks[0], &TaskStatus{Status: "ok", Duration: 40 * time.Second})
+	plan.SaveStatus(web1, &plan.Tasks[1], &TaskStatus{Status: "ok", Duration: time.Hour})
+	report := plan.Report()
+	if len(report.OverBudget) != 1 || report.OverBudget[0].Host != "web2" || report.OverBudget[0].Budget != 10*time.Second {
+		t.Errorf("Expected only web2 over budget. Got %+v\n", report.OverBudget)
+	}
+
+	var html bytes.Buffer
+	if err := plan.WriteHTMLReport(&html); err != nil || !strings.Contains(html.String(), "Over budget") {
+		t.Errorf("Expected the HTML report to warn about the budget. Got %v\n", err)
+	}
+
+	plan_string = `---
+name: "Broken"
+tasks:
+  - name: Install packages
+    action: echo
+    expected_duration: soon
+`
+	if _, err := NewPlanFromYAML([]byte(plan_string), nil); err == nil {
+		t.Errorf("Expected a bad expected_duration to be refused\n")
+	}
+}
@@ -0,0 +1,433 @@
+package henchman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Builds the shell script a built-in module runs as from the task's args.
+// The scripts print the same JSON result executable modules do.
+var builtinScripts = map[string]func(args TaskVars) (string, error){
+	"service": serviceScript,
+	"file":    fileScript,
+	"user":    userScript,
+	"package": packageScript,
+
+	"verify_checksum": verifyChecksumScript,
+	"sudoers":         sudoersScript,
+}
+
+// Shared by the scripts. fail prints a failed result and exits. Scripts
+// run in check mode when check_mode=true precedes them.
+const modulePrelude = `changed=false
+check_mode=${check_mode:-false}
+fail() { printf '{"failed": true, "msg": %s}\n' "$1"; exit 1; }
+`
+
+func jsonString(s string) string {
+	buf, _ := json.Marshal(s)
+	return string(buf)
+}
+
+// Returns the command failing the script with the message
+func failWith(message string) string {
+	return "fail " + shellQuote(jsonString(message))
+}
+
+// Returns the command failing the script with the message followed by the
+// value of the shell variable, which has to be safe in JSON like a digest
+func failWithVar(message string, variable string) string {
+	prefix := jsonString(message)
+	return "fail " + shellQuote(prefix[:len(prefix)-1]) + `"$` + variable + `"'"'`
+}
+
+// Runs the command unless the check passes, failing with the message if the
+// command does. The machine is changed when the command runs. In check mode
+// the command doesn't run, the machine would just be changed.
+func ensure(check string, command string, message string) string {
+	return fmt.Sprintf(`%s || { [ "$check_mode" = true ] || %s || %s; changed=true; }`, check, command, failWith(message))
+}
+
+func moduleScript(body []string, message string) string {
+	return modulePrelude + strings.Join(body, "\n") + "\n" +
+		fmt.Sprintf(`printf '{"changed": %%s, "msg": %%s}\n' "$changed" %s`, shellQuote(jsonString(message))) + "\n"
+}
+
+func argString(args TaskVars, name string) string {
+	value, present := args[name]
+	if !present || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// Booleans can be given as YAML booleans or yes/no and true/false strings
+func argBool(args TaskVars, name string) (bool, bool, error) {
+	value, present := args[name]
+	if !present {
+		return false, false, nil
+	}
+	switch v := value.(type) {
+	case bool:
+		return v, true, nil
+	case string:
+		switch strings.ToLower(v) {
+		case "yes", "true":
+			return true, true, nil
+		case "no", "false":
+			return false, true, nil
+		}
+	}
+	return false, true, fmt.Errorf("%s should be yes or no, got '%v'", name, value)
+}
+
+// Lists can be given as YAML lists or comma separated strings
+func argList(args TaskVars, name string) []string {
+	var items []string
+	switch v := args[name].(type) {
+	case []interface{}:
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+func serviceScript(args TaskVars) (string, error) {
+	name := argString(args, "name")
+	if name == "" {
+		return "", errors.New("service needs a name")
+	}
+	q := shellQuote(name)
+	body := []string{
+		"if command -v systemctl >/dev/null 2>&1; then",
+		"  svc() { systemctl \"$1\" " + q + "; }",
+		"  active() { systemctl is-active --quiet " + q + "; }",
+		"else",
+		"  svc() { service " + q + " \"$1\"; }",
+		"  active() { service " + q + " status >/dev/null 2>&1; }",
+		"fi",
+	}
+	var done []string
+	state := argString(args, "state")
+	switch state {
+	case "":
+	case "started":
+		body = append(body, ensure("active", "svc start", "Couldn't start "+name))
+	case "stopped":
+		body = append(body, ensure("! active", "svc stop", "Couldn't stop "+name))
+	case "restarted", "reloaded":
+		verb := strings.TrimSuffix(state, "ed")
+		body = append(body, ensure("false", "svc "+verb, "Couldn't "+verb+" "+name))
+	default:
+		return "", fmt.Errorf("Unknown service state '%s'", state)
+	}
+	if state != "" {
+		done = append(done, state)
+	}
+	enabled, present, err := argBool(args, "enabled")
+	if err != nil {
+		return "", err
+	}
+	if present {
+		body = append(body, "command -v systemctl >/dev/null 2>&1 || "+failWith("enabled needs systemctl"))
+		if enabled {
+			body = append(body, ensure("systemctl is-enabled --quiet "+q, "systemctl enable "+q, "Couldn't enable "+name))
+			done = append(done, "enabled")
+		} else {
+			body = append(body, ensure("! systemctl is-enabled --quiet "+q, "systemctl disable "+q, "Couldn't disable "+name))
+			done = append(done, "disabled")
+		}
+	}
+	if len(done) == 0 {
+		return "", errors.New("service needs a state or enabled")
+	}
+	return moduleScript(body, name+" "+strings.Join(done, " and ")), nil
+}
+
+func fileScript(args TaskVars) (string, error) {
+	path := argString(args, "path")
+	if path == "" {
+		return "", errors.New("file needs a path")
+	}
+	q := shellQuote(path)
+	var body []string
+	state := argString(args, "state")
+	switch state {
+	case "", "file":
+		state = "file"
+		body = append(body, "[ -f "+q+" ] || "+failWith(path+" doesn't exist"))
+	case "directory":
+		body = append(body, ensure("[ -d "+q+" ]", "mkdir -p "+q, "Couldn't create "+path))
+	case "touch":
+		body = append(body, ensure("[ -e "+q+" ]", "touch "+q, "Couldn't create "+path))
+	case "absent":
+		body = append(body, ensure("[ ! -e "+q+" ]", "rm -rf "+q, "Couldn't remove "+path))
+	default:
+		return "", fmt.Errorf("Unknown file state '%s'", state)
+	}
+	mode, owner := argString(args, "mode"), argString(args, "owner")
+	if state == "absent" && (mode != "" || owner != "") {
+		return "", errors.New("Absent files can't have a mode or owner")
+	}
+	if mode != "" {
+		// stat prints modes without leading zeroes
+		bits, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return "", fmt.Errorf("Invalid file mode '%s'", mode)
+		}
+		octal := strconv.FormatUint(bits, 8)
+		current := "$(stat -c %a " + q + " 2>/dev/null || stat -f %Lp " + q + ")"
+		body = append(body, ensure(`[ "`+current+`" = `+octal+` ]`, "chmod "+octal+" "+q, "Couldn't set the mode of "+path))
+	}
+	if owner != "" {
+		current := "$(stat -c %U " + q + " 2>/dev/null || stat -f %Su " + q + ")"
+		if strings.Contains(owner, ":") {
+			current = "$(stat -c %U:%G " + q + " 2>/dev/null || stat -f %Su:%Sg " + q + ")"
+		}
+		body = append(body, ensure(`[ "`+current+`" = `+shellQuote(owner)+` ]`, "chown "+shellQuote(owner)+" "+q, "Couldn't set the owner of "+path))
+	}
+	return moduleScript(body, path+" is "+state), nil
+}
+
+func userScript(args TaskVars) (string, error) {
+	name := argString(args, "name")
+	if name == "" {
+		return "", errors.New("user needs a name")
+	}
+	q := shellQuote(name)
+	exists := "id -u " + q + " >/dev/null 2>&1"
+	state := argString(args, "state")
+	switch state {
+	case "", "present":
+		state = "present"
+		system, _, err := argBool(args, "system")
+		if err != nil {
+			return "", err
+		}
+		options := []string{"-m"}
+		if system {
+			options = []string{"-r"}
+		}
+		if shell := argString(args, "shell"); shell != "" {
+			options = append(options, "-s", shellQuote(shell))
+		}
+		if home := argString(args, "home"); home != "" {
+			options = append(options, "-d", shellQuote(home))
+		}
+		if groups := argList(args, "groups"); len(groups) > 0 {
+			options = append(options, "-G", shellQuote(strings.Join(groups, ",")))
+		}
+		command := "useradd " + strings.Join(options, " ") + " " + q
+		return moduleScript([]string{ensure(exists, command, "Couldn't add "+name)}, name+" is present"), nil
+	case "absent":
+		return moduleScript([]string{ensure("! "+exists, "userdel "+q, "Couldn't remove "+name)}, name+" is absent"), nil
+	}
+	return "", fmt.Errorf("Unknown user state '%s'", state)
+}
+
+// Shell functions for each package manager checking whether a package is
+// installed and installing or removing packages
+var packageManagers = map[string][]string{
+	"apt": {
+		"installed() { dpkg -s \"$1\" 2>/dev/null | grep -q '^Status: install ok installed'; }",
+		"install() { DEBIAN_FRONTEND=noninteractive apt-get install -y -q \"$@\"; }",
+		"remove() { DEBIAN_FRONTEND=noninteractive apt-get remove -y -q \"$@\"; }",
+	},
+	"dnf": {
+		"installed() { rpm -q \"$1\" >/dev/null 2>&1; }",
+		"install() { dnf install -y -q \"$@\"; }",
+		"remove() { dnf remove -y -q \"$@\"; }",
+	},
+	"yum": {
+		"installed() { rpm -q \"$1\" >/dev/null 2>&1; }",
+		"install() { yum install -y -q \"$@\"; }",
+		"remove() { yum remove -y -q \"$@\"; }",
+	},
+}
+
+// The commands the package managers are detected by, in order
+var packageManagerCommands = []struct{ manager, command string }{
+	{"apt", "apt-get"},
+	{"dnf", "dnf"},
+	{"yum", "yum"},
+}
+
+func packageScript(args TaskVars) (string, error) {
+	names := argList(args, "name")
+	if len(names) == 0 {
+		return "", errors.New("package needs a name")
+	}
+	var quoted []string
+	for _, name := range names {
+		if strings.ContainsAny(name, " \t'\"") {
+			return "", fmt.Errorf("Invalid package name '%s'", name)
+		}
+		quoted = append(quoted, shellQuote(name))
+	}
+
+	var body []string
+	manager := argString(args, "manager")
+	if manager != "" {
+		functions, known := packageManagers[manager]
+		if !known {
+			var managers []string
+			for name := range packageManagers {
+				managers = append(managers, name)
+			}
+			sort.Strings(managers)
+			return "", fmt.Errorf("Unknown package manager '%s', use one of %s", manager, strings.Join(managers, ", "))
+		}
+		body = append(body, functions...)
+	} else {
+		for i, detected := range packageManagerCommands {
+			keyword := "elif"
+			if i == 0 {
+				keyword = "if"
+			}
+			body = append(body, keyword+" command -v "+detected.command+" >/dev/null 2>&1; then")
+			for _, function := range packageManagers[detected.manager] {
+				body = append(body, "  "+function)
+			}
+		}
+		body = append(body, "else", "  "+failWith("No supported package manager"), "fi")
+	}
+
+	list := strings.Join(quoted, " ")
+	state := argString(args, "state")
+	switch state {
+	case "", "present":
+		state = "present"
+		body = append(body,
+			`pending=""; for p in `+list+`; do installed "$p" || pending="$pending $p"; done`,
+			ensure(`[ -z "$pending" ]`, "install $pending", "Couldn't install "+strings.Join(names, ", ")))
+	case "absent":
+		body = append(body,
+			`pending=""; for p in `+list+`; do ! installed "$p" || pending="$pending $p"; done`,
+			ensure(`[ -z "$pending" ]`, "remove $pending", "Couldn't remove "+strings.Join(names, ", ")))
+	default:
+		return "", fmt.Errorf("Unknown package state '%s'", state)
+	}
+	return moduleScript(body, strings.Join(names, ", ")+" "+state), nil
+}
+
+// Commands printing the digest of a file, GNU coreutils first then the
+// BSDs and macOS, by algorithm
+var checksumCommands = map[string]string{
+	"sha256": "sha256sum %[1]s 2>/dev/null || shasum -a 256 %[1]s",
+	"sha512": "sha512sum %[1]s 2>/dev/null || shasum -a 512 %[1]s",
+}
+
+var hexDigest = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// Checks the file's digest against the expected one without changing
+// anything. The digest can also be the URL of a checksum file like
+// app.tar.gz.sha256, fetched by the machine, in which case the line naming
+// the file is used, or the first line.
+func verifyChecksumScript(args TaskVars) (string, error) {
+	path := argString(args, "path")
+	if path == "" {
+		return "", errors.New("verify_checksum needs a path")
+	}
+	var algorithm, expected string
+	for _, name := range []string{"sha256", "sha512"} {
+		if value := argString(args, name); value != "" {
+			if algorithm != "" {
+				return "", errors.New("verify_checksum takes either sha256 or sha512")
+			}
+			algorithm, expected = name, strings.TrimSpace(value)
+		}
+	}
+	if algorithm == "" {
+		return "", errors.New("verify_checksum needs a sha256 or sha512")
+	}
+	q := shellQuote(path)
+	body := []string{"[ -f " + q + " ] || " + failWith(path+" doesn't exist")}
+	if strings.HasPrefix(expected, "http://") || strings.HasPrefix(expected, "https://") {
+		url := shellQuote(expected)
+		body = append(body,
+			"if command -v curl >/dev/null 2>&1; then sums=$(curl -fsSL "+url+"); else sums=$(wget -qO- "+url+"); fi || "+failWith("Couldn't download "+expected),
+			`expected=$(printf '%s\n' "$sums" | awk -v f=`+shellQuote(filepath.Base(path))+` '$2 == f || $2 == "*" f { print $1; exit }')`,
+			`[ -n "$expected" ] || expected=$(printf '%s\n' "$sums" | awk 'NR == 1 { print $1 }')`,
+			`expected=$(printf '%s' "$expected" | tr A-F a-f)`,
+			`case "$expected" in *[!0-9a-f]*|"") `+failWith("No "+algorithm+" digest in "+expected)+";; esac")
+	} else {
+		length := map[string]int{"sha256": 64, "sha512": 128}[algorithm]
+		if len(expected) != length || !hexDigest.MatchString(expected) {
+			return "", fmt.Errorf("Invalid %s '%s', it has to be %d hex digits or the URL of a checksum file", algorithm, expected, length)
+		}
+		body = append(body, "expected="+strings.ToLower(expected))
+	}
+	body = append(body,
+		"actual=$({ "+fmt.Sprintf(checksumCommands[algorithm], q)+"; } | cut -d ' ' -f 1 | tr A-F a-f)",
+		`[ -n "$actual" ] || `+failWith("Couldn't compute the "+algorithm+" of "+path),
+		`[ "$actual" = "$expected" ] || `+failWithVar(path+" doesn't match its "+algorithm+", it's ", "actual"))
+	return moduleScript(body, path+" matches its "+algorithm), nil
+}
+
+// Directory of the sudoers drop-ins, which sudo only reads files without
+// a dot or trailing ~ from
+const sudoersDir = "/etc/sudoers.d"
+
+var sudoersName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Writes the rules to a hidden temporary file next to the drop-in, which
+// sudo ignores, and only moves it in place once visudo accepts it, so that
+// a typo can't lock everyone out of sudo.
+func sudoersScript(args TaskVars) (string, error) {
+	name := argString(args, "name")
+	if !sudoersName.MatchString(name) {
+		return "", fmt.Errorf("Invalid sudoers name '%s', sudo only reads drop-ins named with letters, digits, - and _", name)
+	}
+	dest := sudoersDir + "/" + name
+	q := shellQuote(dest)
+	state := argString(args, "state")
+	switch state {
+	case "", "present":
+	case "absent":
+		if _, present := args["content"]; present {
+			return "", errors.New("Absent sudoers drop-ins can't have content")
+		}
+		return moduleScript([]string{ensure("[ ! -e "+q+" ]", "rm -f "+q, "Couldn't remove "+dest)}, dest+" is absent"), nil
+	default:
+		return "", fmt.Errorf("Unknown sudoers state '%s'", state)
+	}
+	var content string
+	switch v := args["content"].(type) {
+	case string:
+		content = strings.TrimRight(v, "\n")
+	case []interface{}:
+		var lines []string
+		for _, line := range v {
+			lines = append(lines, fmt.Sprint(line))
+		}
+		content = strings.Join(lines, "\n")
+	}
+	if strings.TrimSpace(content) == "" {
+		return "", errors.New("sudoers needs the content of the drop-in")
+	}
+	body := []string{
+		"PATH=$PATH:/usr/sbin:/sbin",
+		"command -v visudo >/dev/null 2>&1 || " + failWith("visudo isn't installed"),
+		"grep -Eq '^[@#]includedir[[:space:]]+" + sudoersDir + "/?[[:space:]]*$' /etc/sudoers || " + failWith("/etc/sudoers doesn't include "+sudoersDir),
+		"tmp=$(mktemp " + shellQuote(sudoersDir+"/."+name+".XXXXXX") + ") || " + failWith("Couldn't create a temporary file in "+sudoersDir),
+		`trap 'rm -f "$tmp"' EXIT`,
+		`printf '%s\n' ` + shellQuote(content) + ` > "$tmp" && chmod 0440 "$tmp" || ` + failWith("Couldn't write the rules for "+dest),
+		// visudo's complaint, made safe to put in the JSON result
+		`errors=$(visudo -c -f "$tmp" 2>&1) || { errors=$(printf '%s' "$errors" | sed 's/\\/\\\\/g; s/"/\\"/g' | tr '\n\t' '  '); ` + failWithVar(dest+" was left as is, visudo rejected the rules: ", "errors") + "; }",
+		ensure(`cmp -s "$tmp" `+q, `mv -f "$tmp" `+q, "Couldn't install "+dest),
+	}
+	return moduleScript(body, dest+" is present"), nil
+}
@@ -0,0 +1,134 @@
+package henchman
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileModule(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	data := filepath.Join(dir, "srv", "app")
+	localhost := Machine{Hostname: "127.0.0.1", Port: 0}
+
+	call := &ModuleCall{Name: "file", Args: TaskVars{"path": data, "state": "directory", "mode": "0750"}}
+	result, message, err := localhost.runModule(call, nil, false)
+	if err != nil {
+		t.Fatalf("Couldn't run the file module: %s %s\n", message, err)
+	}
+	if info, err := os.Stat(data); err != nil || !info.IsDir() || info.Mode().Perm() != 0750 || !result.Changed {
+		t.Errorf("Expected the directory to be created. Got %v %+v\n", err, result)
+	}
+	if message != data+" is directory" {
+		t.Errorf("File module message mismatch. Got %s\n", message)
+	}
+	if result, _, err := localhost.runModule(call, nil, false); err != nil || result.Changed {
+		t.Errorf("Expected nothing to change the second time. Got %+v %v\n", result, err)
+	}
+
+	missing := &ModuleCall{Name: "file", Args: TaskVars{"path": filepath.Join(dir, "missing")}}
+	if _, message, err := localhost.runModule(missing, nil, false); err == nil || !strings.Contains(message, "doesn't exist") {
+		t.Errorf("Expected a missing file to fail. Got %s\n", message)
+	}
+
+	absent := &ModuleCall{Name: "file", Args: TaskVars{"path": data, "state": "absent"}}
+	if result, _, err := localhost.runModule(absent, nil, false); err != nil || !result.Changed {
+		t.Errorf("Expected the directory to be removed. Got %+v %v\n", result, err)
+	}
+	if _, err := os.Stat(data); !os.IsNotExist(err) {
+		t.Errorf("The directory should have been removed\n")
+	}
+}
+
+func TestVerifyChecksumModule(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	release := filepath.Join(dir, "app.tar.gz")
+	ioutil.WriteFile(release, []byte("release\n"), 0644)
+	sum := sha256.Sum256([]byte("release\n"))
+	digest := hex.EncodeToString(sum[:])
+	localhost := Machine{Hostname: "127.0.0.1", Port: 0}
+
+	call := &ModuleCall{Name: "verify_checksum", Args: TaskVars{"path": release, "sha256": strings.ToUpper(digest)}}
+	if result, message, err := localhost.runModule(call, nil, false); err != nil || result.Changed {
+		t.Errorf("Expected the checksum to match. Got %s %v\n", message, err)
+	}
+
+	wrong := strings.Repeat("0", 64)
+	call = &ModuleCall{Name: "verify_checksum", Args: TaskVars{"path": release, "sha256": wrong}}
+	if _, message, err := localhost.runModule(call, nil, false); err == nil || !strings.Contains(message, "doesn't match its sha256, it's "+digest) {
+		t.Errorf("Expected a mismatch to fail with the actual digest. Got %s\n", message)
+	}
+
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("Needs curl to fetch checksum files")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  other.tar.gz\n%s  app.tar.gz\n", wrong, digest)
+	}))
+	defer server.Close()
+	call = &ModuleCall{Name: "verify_checksum", Args: TaskVars{"path": release, "sha256": server.URL + "/SHA256SUMS"}}
+	if _, message, err := localhost.runModule(call, nil, false); err != nil {
+		t.Errorf("Expected the file's line of the checksum file to match. Got %s %v\n", message, err)
+	}
+}
+
+func TestBuiltinModuleScripts(t *testing.T) {
+	script, err := serviceScript(TaskVars{"name": "nginx", "state": "started", "enabled": "yes"})
+	if err != nil {
+		t.Fatalf("Couldn't build the service script: %s\n", err)
+	}
+	for _, expected := range []string{"svc start", "systemctl enable 'nginx'", `"nginx started and enabled"`} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("Expected %s in the service script. Got %s\n", expected, script)
+		}
+	}
+	script, _ = userScript(TaskVars{"name": "app", "system": true, "groups": []interface{}{"www", "adm"}})
+	if !strings.Contains(script, "useradd -r -G 'www,adm' 'app'") {
+		t.Errorf("User script mismatch. Got %s\n", script)
+	}
+	script, _ = packageScript(TaskVars{"name": "nginx, curl", "manager": "apt"})
+	if !strings.Contains(script, "for p in 'nginx' 'curl'") || strings.Contains(script, "yum") {
+		t.Errorf("Package script mismatch. Got %s\n", script)
+	}
+
+	script, _ = sudoersScript(TaskVars{"name": "deploy", "content": []interface{}{"Defaults:deploy !requiretty", "deploy ALL=(root) NOPASSWD: /bin/systemctl restart app, /bin/systemctl reload app"}})
+	for _, expected := range []string{
+		`visudo -c -f "$tmp"`,
+		`mv -f "$tmp" '/etc/sudoers.d/deploy'`,
+		"'Defaults:deploy !requiretty\ndeploy ALL=(root) NOPASSWD: /bin/systemctl restart app, /bin/systemctl reload app'",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("Expected %s in the sudoers script. Got %s\n", expected, script)
+		}
+	}
+	if strings.Index(script, "visudo -c") > strings.Index(script, "mv -f") {
+		t.Errorf("Expected the rules to be checked before they're moved in place. Got %s\n", script)
+	}
+	if _, err := exec.Command("sh", "-n", "-c", script).CombinedOutput(); err != nil {
+		t.Errorf("Sudoers script isn't valid shell: %s\n", err)
+	}
+
+	for module, args := range map[string]TaskVars{
+		"service": {"name": "nginx"},
+		"file":    {"path": "/tmp/x", "state": "absent", "mode": "0644"},
+		"user":    {"name": "app", "state": "locked"},
+		"package": {"name": "nginx", "manager": "pacman"},
+
+		"verify_checksum": {"path": "/tmp/x", "sha256": "abc"},
+		"sudoers":         {"name": "deploy.conf", "content": "deploy ALL=(ALL) ALL"},
+	} {
+		if _, err := builtinScripts[module](args); err == nil {
+			t.Errorf("Expected bad %s args %v to fail\n", module, args)
+		}
+	}
+}
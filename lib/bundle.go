@@ -0,0 +1,164 @@
+package henchman
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the plan within a bundle
+const BundlePlan = "plan.yaml"
+
+// Directories next to the plan that are bundled along with it, besides the
+// lookup directories
+var bundleDirs = []string{"vars", "host_vars", "group_vars", HierarchyDataDir}
+
+type bundleWriter struct {
+	tar   *tar.Writer
+	added map[string]bool
+}
+
+// Adds the file, or the directory and everything in it, under the name
+func (bundle *bundleWriter) add(path string, name string) error {
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		entry := filepath.ToSlash(filepath.Join(name, rel))
+		if bundle.added[entry] || !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+		bundle.added[entry] = true
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = entry
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := bundle.tar.WriteHeader(header); err != nil || info.IsDir() {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(bundle.tar, f)
+		return err
+	})
+}
+
+// Writes the plan along with everything it refers to as a gzipped tarball
+// that runs without access to the original sources: the plan as plan.yaml,
+// its files, templates and vars directories, the files its tasks refer to
+// and the modules. Those files have to be within the plan's directory since
+// the plan refers to them relatively. The plan's files have to be resolved
+// already.
+func WriteBundle(w io.Writer, plan *Plan, planFile string, modulesPath string) error {
+	gz := gzip.NewWriter(w)
+	bundle := &bundleWriter{tar.NewWriter(gz), make(map[string]bool)}
+	if err := bundle.add(planFile, BundlePlan); err != nil {
+		return err
+	}
+	var dirs []string
+	for _, kind := range []string{"files", "templates"} {
+		for _, dir := range lookupDirs[kind] {
+			if dir != "." {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	for _, dir := range append(dirs, bundleDirs...) {
+		path := filepath.Join(plan.Dir, dir)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := bundle.add(path, dir); err != nil {
+			return err
+		}
+	}
+	for _, task := range plan.allTasks() {
+		for _, file := range task.localFiles() {
+			rel, err := filepath.Rel(plan.Dir, *file)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("Task '%s': %s is outside the plan directory", task.Name, *file)
+			}
+			if err := bundle.add(*file, rel); err != nil {
+				return err
+			}
+		}
+	}
+	// Modules already added from an earlier directory of the path shadow
+	// the later ones
+	for _, modulesDir := range filepath.SplitList(modulesPath) {
+		if err := bundle.add(modulesDir, "modules"); err != nil {
+			return err
+		}
+	}
+	if err := bundle.tar.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Extracts the bundle into the directory and returns the path of its plan.
+// Entries that would end up outside the directory are refused.
+func ExtractBundle(r io.Reader, dir string) (string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if rel, err := filepath.Rel(dir, path); err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("Bundle entry '%s' is outside the bundle", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return "", err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(archive, path, os.FileMode(header.Mode).Perm()); err != nil {
+				return "", err
+			}
+		}
+	}
+	planFile := filepath.Join(dir, BundlePlan)
+	if _, err := os.Stat(planFile); err != nil {
+		return "", fmt.Errorf("The bundle has no %s", BundlePlan)
+	}
+	return planFile, nil
+}
+
+func extractFile(r io.Reader, path string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
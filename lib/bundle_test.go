@@ -0,0 +1,60 @@
+package henchman
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	planDir := filepath.Join(dir, "plans")
+	modulesDir := filepath.Join(dir, "modules")
+	os.MkdirAll(filepath.Join(planDir, "files"), 0755)
+	os.MkdirAll(filepath.Join(planDir, "group_vars"), 0755)
+	os.MkdirAll(modulesDir, 0755)
+	ioutil.WriteFile(filepath.Join(planDir, "deploy.yaml"), []byte("tasks:\n  - name: Migrate\n    script: migrate.sh\n"), 0644)
+	ioutil.WriteFile(filepath.Join(planDir, "files", "setup.sh"), []byte("true\n"), 0644)
+	ioutil.WriteFile(filepath.Join(planDir, "migrate.sh"), []byte("migrate\n"), 0644)
+	ioutil.WriteFile(filepath.Join(planDir, "group_vars", "all.yaml"), []byte("port: 80\n"), 0644)
+	ioutil.WriteFile(filepath.Join(planDir, "unrelated.txt"), []byte("nope\n"), 0644)
+	ioutil.WriteFile(filepath.Join(modulesDir, "service"), []byte("#!/bin/sh\n"), 0755)
+
+	plan := &Plan{Dir: planDir, Tasks: []Task{{Name: "Migrate", Script: "migrate.sh"}}}
+	if err := plan.ResolveFiles(); err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteBundle(&buf, plan, filepath.Join(planDir, "deploy.yaml"), modulesDir); err != nil {
+		t.Fatalf("Couldn't write the bundle: %s\n", err)
+	}
+
+	extracted := filepath.Join(dir, "extracted")
+	planFile, err := ExtractBundle(&buf, extracted)
+	if err != nil {
+		t.Fatalf("Couldn't extract the bundle: %s\n", err)
+	}
+	if planFile != filepath.Join(extracted, BundlePlan) {
+		t.Errorf("Plan path mismatch. Got %s\n", planFile)
+	}
+	for _, name := range []string{BundlePlan, "files/setup.sh", "migrate.sh", "group_vars/all.yaml", "modules/service"} {
+		if _, err := os.Stat(filepath.Join(extracted, filepath.FromSlash(name))); err != nil {
+			t.Errorf("Expected %s in the bundle. Got %s\n", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(extracted, "unrelated.txt")); err == nil {
+		t.Errorf("Files the plan doesn't refer to shouldn't be bundled\n")
+	}
+	if info, err := os.Stat(filepath.Join(extracted, "modules", "service")); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Modules should stay executable. Got %s\n", info.Mode())
+	}
+
+	outside := &Plan{Dir: planDir, Tasks: []Task{{Name: "Elsewhere", Script: filepath.Join(modulesDir, "service")}}}
+	if err := WriteBundle(&bytes.Buffer{}, outside, filepath.Join(planDir, "deploy.yaml"), ""); err == nil {
+		t.Errorf("Scripts outside the plan directory should be refused\n")
+	}
+}
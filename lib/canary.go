@@ -0,0 +1,80 @@
+package henchman
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Picks the plan's canaries out of the machines. The canary spec is either
+// a percentage of the machines, e.g. '10%', of which at least one is taken
+// from the front, or comma separated patterns matched like LimitHosts'.
+func CanaryMachines(machines []*Machine, canary string) ([]*Machine, []*Machine, error) {
+	if strings.HasSuffix(canary, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(canary, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, nil, fmt.Errorf("Bad canary percentage '%s'", canary)
+		}
+		n := int(float64(len(machines))*percent/100 + 0.5)
+		if n < 1 {
+			n = 1
+		}
+		if n > len(machines) {
+			n = len(machines)
+		}
+		return machines[:n], machines[n:], nil
+	}
+	patterns, err := hostPatterns(canary, "canary")
+	if err != nil {
+		return nil, nil, err
+	}
+	var canaries, rest []*Machine
+	for _, machine := range machines {
+		if matchesHost(patterns, machine.Hostname) || matchesHost(patterns, machine.address()) {
+			canaries = append(canaries, machine)
+		} else {
+			rest = append(rest, machine)
+		}
+	}
+	if len(canaries) == 0 {
+		return nil, nil, fmt.Errorf("No hosts match the canary '%s'", canary)
+	}
+	return canaries, rest, nil
+}
+
+// Returns the batches the machines run in, see SerialBatches, with the
+// plan's canaries, if it has any, in a batch of their own first
+func (plan *Plan) Batches(machines []*Machine) ([]SerialBatch, error) {
+	if plan.Canary == "" {
+		return SerialBatches(machines, plan.SerialBy), nil
+	}
+	canaries, rest, err := CanaryMachines(machines, plan.Canary)
+	if err != nil {
+		return nil, err
+	}
+	batches := []SerialBatch{{Value: "canary", Machines: canaries, Canary: true}}
+	if len(rest) > 0 {
+		batches = append(batches, SerialBatches(rest, plan.SerialBy)...)
+	}
+	return batches, nil
+}
+
+// Runs the plan's canary check on the canaries, or on localhost for local
+// checks, returning the first failure
+func (plan *Plan) CheckCanaries(canaries []*Machine, localhost *Machine) error {
+	for _, machine := range canaries {
+		check := *plan.CanaryCheck
+		target := machine
+		if check.LocalAction {
+			target = localhost
+		}
+		status, err := check.Run(target, machineVars(plan.Vars, machine))
+		if status.Failed() {
+			if err == nil {
+				err = fmt.Errorf("%s", status.Message)
+			}
+			return fmt.Errorf("Canary check failed on %s: %s", machine.Hostname, err)
+		}
+	}
+	return nil
+}
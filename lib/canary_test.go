@@ -0,0 +1,64 @@
+package henchman
+
+import (
+	"testing"
+)
+
+func TestCanaryMachines(t *testing.T) {
+	var machines []*Machine
+	for _, hostname := range []string{"web-01", "web-02", "web-03", "web-04", "web-05"} {
+		machines = append(machines, &Machine{Hostname: hostname, Port: 22})
+	}
+	canaries, rest, err := CanaryMachines(machines, "20%")
+	if err != nil || len(canaries) != 1 || canaries[0].Hostname != "web-01" || len(rest) != 4 {
+		t.Errorf("Percentage canary mismatch. Got %v %v %v\n", canaries, rest, err)
+	}
+	if canaries, _, _ := CanaryMachines(machines, "1%"); len(canaries) != 1 {
+		t.Errorf("Expected at least one canary. Got %v\n", canaries)
+	}
+	canaries, rest, _ = CanaryMachines(machines, "web-03,web-05")
+	if len(canaries) != 2 || canaries[1].Hostname != "web-05" || len(rest) != 3 {
+		t.Errorf("Pattern canary mismatch. Got %v %v\n", canaries, rest)
+	}
+	for _, canary := range []string{"0%", "150%", "db-*"} {
+		if _, _, err := CanaryMachines(machines, canary); err == nil {
+			t.Errorf("Expected canary '%s' to be refused\n", canary)
+		}
+	}
+
+	plan := Plan{Canary: "web-02", SerialBy: "az"}
+	batches, err := plan.Batches(machines)
+	if err != nil || !batches[0].Canary || batches[0].Machines[0].Hostname != "web-02" || len(batches[1].Machines) != 4 {
+		t.Errorf("Expected the canary batch first. Got %+v %v\n", batches, err)
+	}
+}
+
+func TestCheckCanaries(t *testing.T) {
+	plan_string := `---
+name: "Canary"
+hosts:
+  - web-01
+canary: web-01
+canary_check:
+  name: Health check
+  action: curl -fsS http://localhost/health
+tasks:
+  - name: Deploy
+    action: deploy
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		t.Fatalf("Couldn't parse the plan: %s\n", err)
+	}
+	canary := &Machine{Hostname: "web-01", Port: 22}
+	NewNoopTransport().Attach(canary)
+	localhost := &Machine{Hostname: "127.0.0.1"}
+	if err := plan.CheckCanaries([]*Machine{canary}, localhost); err != nil {
+		t.Errorf("Expected the canary check to pass. Got %s\n", err)
+	}
+
+	plan.CanaryCheck = &Task{Name: "Failing check", Action: "false", LocalAction: true}
+	if err := plan.CheckCanaries([]*Machine{canary}, localhost); err == nil {
+		t.Errorf("Expected a failing canary check to fail\n")
+	}
+}
@@ -0,0 +1,77 @@
+package henchman
+
+import (
+	"errors"
+	"time"
+)
+
+var errCancelled = errors.New("The task was cancelled")
+
+// Returns a channel closed once the machine's task is cancelled, see Cancel
+func (machine *Machine) cancelled() <-chan struct{} {
+	machine.cancelMutex.Lock()
+	defer machine.cancelMutex.Unlock()
+	if machine.cancel == nil {
+		machine.cancel = make(chan struct{})
+	}
+	return machine.cancel
+}
+
+// Cancels the task running on the machine: the commands it runs are
+// aborted, whatever the transport, and new ones fail to start until Resume
+func (machine *Machine) Cancel() {
+	machine.cancelMutex.Lock()
+	if machine.cancel == nil {
+		machine.cancel = make(chan struct{})
+	}
+	select {
+	case <-machine.cancel:
+	default:
+		close(machine.cancel)
+	}
+	machine.cancelMutex.Unlock()
+	// Unblocks connections being set up and sessions being opened
+	machine.Close()
+}
+
+// Lets commands run on the machine again after Cancel
+func (machine *Machine) Resume() {
+	machine.cancelMutex.Lock()
+	defer machine.cancelMutex.Unlock()
+	select {
+	case <-machine.cancel:
+		machine.cancel = nil
+	default:
+	}
+}
+
+// Runs the command f within the command timeout, calling abort to stop it
+// if it times out or the machine's task is cancelled meanwhile
+func (machine *Machine) command(f func() error, abort func() error) error {
+	cancelled := machine.cancelled()
+	select {
+	case <-cancelled:
+		return errCancelled
+	default:
+	}
+	finished := make(chan bool)
+	defer close(finished)
+	go func() {
+		select {
+		case <-cancelled:
+			abort()
+		case <-finished:
+		}
+	}()
+	return withTimeout("command", machine.Timeouts.Command, f, abort)
+}
+
+// Waits between attempts, cut short if the machine's task is cancelled
+func (machine *Machine) sleep(delay time.Duration) error {
+	select {
+	case <-machine.cancelled():
+		return errCancelled
+	case <-time.After(delay):
+		return nil
+	}
+}
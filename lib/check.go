@@ -0,0 +1,157 @@
+package henchman
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Whether the module can be asked what it would change, see ModuleCall
+func (call *ModuleCall) supportsCheckMode() bool {
+	if call.Path == "" {
+		return builtinModules[call.Name].CheckMode
+	}
+	return call.CheckMode
+}
+
+// Describes what running the prepared task would do on the machine
+func (task *Task) describe(machine *Machine) (string, error) {
+	var who string
+	if become := task.become(); become != nil {
+		who = " with sudo"
+		if become.User != "" {
+			who = " as " + become.User
+		}
+	}
+	switch {
+	case task.Copy != nil:
+		return fmt.Sprintf("Would copy %s to %s%s", task.Copy.Src, task.Copy.Dest, who), nil
+	case task.Template != nil:
+		return fmt.Sprintf("Would render %s to %s%s", task.Template.Src, task.Template.Dest, who), nil
+	case task.Fetch != nil:
+		local, err := task.Fetch.localPath(machine)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Would fetch %s to %s", task.Fetch.Src, local), nil
+	case task.Module != nil:
+		return fmt.Sprintf("Would run module %s%s, it doesn't support check mode", task.Module.Name, who), nil
+	case task.Script != "":
+		return fmt.Sprintf("Would run the script %s%s", task.Script, who), nil
+	case len(task.Commands) > 0:
+		return fmt.Sprintf("Would send%s:\n%s", who, strings.Join(task.Commands, "\n")), nil
+	}
+	return fmt.Sprintf("Would run%s: %s", who, task.Action), nil
+}
+
+// Diffs the file the copy or template task would write against the remote
+// one, returning whether it would change
+func (task *Task) checkFile(machine *Machine, vars *TaskVars) (string, bool, error) {
+	spec := task.Copy
+	var contents io.ReadSeeker
+	if spec != nil {
+		f, err := os.Open(spec.Src)
+		if err != nil {
+			return "", false, err
+		}
+		defer f.Close()
+		contents = f
+	} else {
+		spec = task.Template
+		rendered, err := renderTemplateFile(spec, vars, machine)
+		if err != nil {
+			return "", false, err
+		}
+		contents = strings.NewReader(rendered)
+	}
+	diff, err := machine.fileDiff(spec, contents, task.become())
+	if err != nil || diff == "" {
+		return spec.Dest + " unchanged", false, err
+	}
+	return "Would change " + spec.Dest + "\n" + diff, true, nil
+}
+
+// Check resolves the task on the machine and returns what running it would
+// do without running it. Modules that support check mode are asked whether
+// they would change the machine, and with ShowDiffs the files copy and
+// template tasks would write are diffed against the remote ones. Nothing
+// else runs on the machine.
+func (task *Task) Check(machine *Machine, vars *TaskVars) (*TaskStatus, error) {
+	if task.WithItems != nil {
+		return task.loop(machine, vars, (*Task).Check)
+	}
+	start := time.Now()
+	if err := task.prepare(vars, machine); err != nil {
+		return task.status("", err, time.Since(start)), err
+	}
+	if status, err := task.skip(vars, machine, start); status != nil {
+		return status, err
+	}
+	if ShowDiffs && (task.Copy != nil || task.Template != nil) && !machine.isLocal() {
+		message, changed, err := task.checkFile(machine, vars)
+		return task.changedStatus(message, changed, err, time.Since(start)), err
+	}
+	if task.Module != nil && task.Module.supportsCheckMode() {
+		result, message, err := machine.runModule(task.Module, task.become(), true)
+		if err == nil {
+			if result.Changed {
+				message = "Would change: " + message
+			} else {
+				message = "Unchanged: " + message
+			}
+		}
+		if result == nil {
+			return task.status(message, err, time.Since(start)), err
+		}
+		return task.changedStatus(message, result.Changed, err, time.Since(start)), err
+	}
+	message, err := task.describe(machine)
+	if err == nil && task.Verify != nil {
+		check := task.Verify.Command
+		if check == "" {
+			check = task.Verify.URI
+		}
+		message += "\nThen verify " + check
+	}
+	return task.status(message, err, time.Since(start)), err
+}
+
+// Prints what each task would do on each host, going by the statuses saved
+// in check mode
+func (plan *Plan) PrintCheck() {
+	report := plan.Report()
+	byHost := make(map[string][]Result)
+	var hosts []string
+	for _, result := range report.Results {
+		if _, seen := byHost[result.Host]; !seen {
+			hosts = append(hosts, result.Host)
+		}
+		byHost[result.Host] = append(byHost[result.Host], result)
+	}
+	sort.Strings(hosts)
+
+	fmt.Println()
+	fmt.Println("---")
+	fmt.Printf("Plan Check: %s\n", plan.Name)
+	changes := 0
+	for _, host := range hosts {
+		fmt.Println()
+		fmt.Printf("%s:\n", host)
+		// A host's tasks run in order
+		for _, result := range byHost[host] {
+			if result.Changed {
+				changes++
+			}
+			fmt.Printf("  [%s] %s\n", result.Status, result.Task)
+			for _, line := range strings.Split(strings.TrimRight(result.Message, "\n"), "\n") {
+				fmt.Printf("      %s\n", line)
+			}
+		}
+	}
+	fmt.Println()
+	fmt.Printf("Hosts:\t%d\n", len(hosts))
+	fmt.Printf("Predicted changes (all hosts):\t%d\n", changes)
+}
@@ -0,0 +1,46 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckTask(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	data := filepath.Join(dir, "srv")
+	machine := &Machine{Hostname: "127.0.0.1"}
+	vars := TaskVars{"data": data}
+
+	task := Task{Name: "Create data", Module: &ModuleCall{Name: "file", Args: TaskVars{"path": "{{ vars.data }}", "state": "directory"}}}
+	status, err := task.Check(machine, &vars)
+	if err != nil || !status.Changed || status.Message != "Would change: "+data+" is directory" {
+		t.Errorf("Expected the module to predict a change. Got %v %v\n", status, err)
+	}
+	if _, err := os.Stat(data); !os.IsNotExist(err) {
+		t.Errorf("Check mode shouldn't have created %s\n", data)
+	}
+
+	os.Mkdir(data, 0755)
+	task = Task{Name: "Create data", Module: &ModuleCall{Name: "file", Args: TaskVars{"path": data, "state": "directory"}}}
+	if status, err := task.Check(machine, &vars); err != nil || status.Changed {
+		t.Errorf("Expected no predicted change. Got %v %v\n", status, err)
+	}
+
+	marker := filepath.Join(dir, "marker")
+	task = Task{Name: "Touch", Action: "touch " + marker, Verify: &Verify{Command: "test -e " + marker}}
+	status, err = task.Check(machine, &vars)
+	if err != nil || status.Message != "Would run: touch "+marker+"\nThen verify test -e "+marker {
+		t.Errorf("Check message mismatch. Got %q %v\n", status.Message, err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("Check mode shouldn't have run the action\n")
+	}
+
+	module := &ModuleCall{Name: "deploy", Path: "/modules/deploy"}
+	if module.supportsCheckMode() {
+		t.Errorf("Executable modules only support check mode when documented to\n")
+	}
+}
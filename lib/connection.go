@@ -0,0 +1,72 @@
+package henchman
+
+import (
+	"fmt"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// Returns the machine's SSH connection, dialing it the first time, and
+// whether it was already open. The connection is shared by the actions run
+// on the machine, which each get a session of their own over it, until the
+// machine is closed.
+func (machine *Machine) connection() (*ssh.Client, bool, error) {
+	if machine.Transport != nil && !machine.isNetwork() {
+		return nil, false, fmt.Errorf("%s is connected to over %s, not SSH", machine.Hostname, machine.Transport.Name())
+	}
+	machine.mutex.Lock()
+	defer machine.mutex.Unlock()
+	if machine.client != nil {
+		return machine.client, true, nil
+	}
+	client, err := machine.dial()
+	if err != nil {
+		return nil, false, err
+	}
+	machine.client = client
+	return client, false, nil
+}
+
+// Closes the connection and forgets it, unless it was replaced already
+func (machine *Machine) drop(client *ssh.Client) {
+	machine.mutex.Lock()
+	if machine.client == client {
+		machine.client = nil
+	}
+	machine.mutex.Unlock()
+	client.Close()
+}
+
+// Opens a session over the machine's connection. A connection that dropped
+// since the last action is replaced by a new one.
+func (machine *Machine) openSession() (*ssh.Session, error) {
+	client, reused, err := machine.connection()
+	if err != nil {
+		return nil, err
+	}
+	session, err := machine.newSession(client)
+	if err != nil && reused {
+		machine.drop(client)
+		if client, _, err = machine.connection(); err != nil {
+			return nil, err
+		}
+		session, err = machine.newSession(client)
+	}
+	if err != nil {
+		machine.drop(client)
+	}
+	return session, err
+}
+
+// Closes the machine's connection, if it has one. The next action on the
+// machine opens a new one.
+func (machine *Machine) Close() error {
+	machine.mutex.Lock()
+	defer machine.mutex.Unlock()
+	if machine.client == nil {
+		return nil
+	}
+	err := machine.client.Close()
+	machine.client = nil
+	return err
+}
@@ -0,0 +1,181 @@
+package henchman
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// Minimal SSH server echoing the commands it's asked to exec, unless
+// respond says otherwise, counting the connections it accepts. It serves
+// the sftp subsystem when sftp is set, and interactive shells with shell.
+// The commands' input is read before they answer, so that clients feeding
+// it don't see the channel close under them, and passed to stdin when it's
+// set. Commands exit with the status status returns, 0 when it isn't set.
+// Pseudo terminals requested are counted too.
+type testSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	respond  func(command string) string
+	stdin    func(command string, input []byte)
+	status   func(command string) uint32
+	sftp     *fakeSFTPServer
+	shell    func(channel ssh.Channel)
+
+	mutex sync.Mutex
+	conns []net.Conn
+	ptys  int
+}
+
+func newTestSSHServer() *testSSHServer {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		panic(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	server := &testSSHServer{listener: listener, config: config}
+	go server.serve()
+	return server
+}
+
+func (server *testSSHServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.mutex.Lock()
+		server.conns = append(server.conns, conn)
+		server.mutex.Unlock()
+		go server.handle(conn)
+	}
+}
+
+func (server *testSSHServer) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, server.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type == "subsystem" && server.sftp != nil {
+					req.Reply(true, nil)
+					server.sftp.serve(channel, channel)
+					return
+				}
+				if req.Type == "shell" && server.shell != nil {
+					req.Reply(true, nil)
+					server.shell(channel)
+					return
+				}
+				if req.Type == "pty-req" {
+					server.mutex.Lock()
+					server.ptys++
+					server.mutex.Unlock()
+				}
+				if req.Type != "exec" {
+					req.Reply(req.Type == "pty-req", nil)
+					continue
+				}
+				req.Reply(true, nil)
+				output := string(req.Payload[4:])
+				input, _ := ioutil.ReadAll(channel)
+				if server.stdin != nil {
+					server.stdin(output, input)
+				}
+				exitStatus := make([]byte, 4)
+				if server.status != nil {
+					binary.BigEndian.PutUint32(exitStatus, server.status(output))
+				}
+				if server.respond != nil {
+					output = server.respond(output)
+				}
+				channel.Write([]byte(output))
+				channel.SendRequest("exit-status", false, exitStatus)
+				return
+			}
+		}()
+	}
+}
+
+// Number of connections accepted so far
+func (server *testSSHServer) connections() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return len(server.conns)
+}
+
+// Drops the connections open so far
+func (server *testSSHServer) drop() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+}
+
+func (server *testSSHServer) machine() *Machine {
+	config := &ssh.ClientConfig{
+		User:            "deploy",
+		HostKeyCallback: func(string, net.Addr, ssh.PublicKey) error { return nil },
+	}
+	port := server.listener.Addr().(*net.TCPAddr).Port
+	return Machines([]string{"127.0.0.1:" + strconv.Itoa(port)}, config)[0]
+}
+
+func TestConnectionReuse(t *testing.T) {
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	machine := server.machine()
+	defer machine.Close()
+
+	for _, action := range []string{"uptime", "hostname"} {
+		out, err := machine.Exec(action)
+		if err != nil {
+			t.Fatalf("Couldn't run '%s': %s\n", action, err)
+		}
+		if out.String() != action {
+			t.Errorf("Output mismatch. Got %s\n", out)
+		}
+	}
+	if server.connections() != 1 {
+		t.Errorf("Expected the actions to share a connection. Got %d connections\n", server.connections())
+	}
+
+	server.drop()
+	if _, err := machine.Exec("uptime"); err != nil {
+		t.Errorf("Expected a dropped connection to be replaced. Got %s\n", err)
+	}
+	if server.connections() != 2 {
+		t.Errorf("Expected a new connection. Got %d connections\n", server.connections())
+	}
+
+	machine.Close()
+	machine.Exec("uptime")
+	if server.connections() != 3 {
+		t.Errorf("Expected a new connection after closing the machine. Got %d connections\n", server.connections())
+	}
+}
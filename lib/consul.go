@@ -0,0 +1,81 @@
+package henchman
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type consulServiceEntry struct {
+	Node           string
+	Address        string
+	ServiceAddress string
+}
+
+// Builds an inventory from a Consul catalog, given as
+// consul://host:port[?dc=datacenter], with a group per service holding the
+// addresses of its instances. The token is taken from CONSUL_HTTP_TOKEN and
+// the catalog is queried over https when CONSUL_HTTP_SSL is true.
+func ConsulInventory(address string) (*Inventory, error) {
+	consul, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	consul.Scheme = "http"
+	if os.Getenv("CONSUL_HTTP_SSL") == "true" {
+		consul.Scheme = "https"
+	}
+	query := consul.Query()
+
+	get := func(path string, v interface{}) error {
+		endpoint := *consul
+		endpoint.Path = path
+		endpoint.RawQuery = query.Encode()
+		request, err := http.NewRequest("GET", endpoint.String(), nil)
+		if err != nil {
+			return err
+		}
+		if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+			request.Header.Set("X-Consul-Token", token)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Consul responded to %s with %s", path, resp.Status)
+		}
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+
+	var services map[string][]string
+	if err := get("/v1/catalog/services", &services); err != nil {
+		return nil, err
+	}
+	inventory := &Inventory{Groups: make(map[string][]string)}
+	for service := range services {
+		var entries []consulServiceEntry
+		if err := get("/v1/catalog/service/"+url.PathEscape(service), &entries); err != nil {
+			return nil, err
+		}
+		var hosts []string
+		for _, entry := range entries {
+			// Services registered without an address of their own run on the node
+			host := entry.ServiceAddress
+			if host == "" {
+				host = entry.Address
+			}
+			hosts = append(hosts, host)
+		}
+		inventory.Groups[service] = hosts
+	}
+	return inventory, nil
+}
+
+func isConsulInventory(path string) bool {
+	return strings.HasPrefix(path, "consul://")
+}
@@ -0,0 +1,41 @@
+package henchman
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConsulInventory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dc") != "dc2" {
+			t.Errorf("Datacenter mismatch. Got %s\n", r.URL.RawQuery)
+		}
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			fmt.Fprint(w, `{"web": ["http"], "db": []}`)
+		case "/v1/catalog/service/web":
+			fmt.Fprint(w, `[{"Node": "web1", "Address": "10.0.0.1", "ServiceAddress": ""},
+				{"Node": "web2", "Address": "10.0.0.2", "ServiceAddress": "10.0.1.2"}]`)
+		case "/v1/catalog/service/db":
+			fmt.Fprint(w, `[{"Node": "db1", "Address": "10.0.0.3"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	inventory, err := LoadInventory(strings.Replace(server.URL, "http://", "consul://", 1) + "?dc=dc2")
+	if err != nil {
+		t.Fatalf("Couldn't load the Consul inventory: %s\n", err)
+	}
+	if web := inventory.Resolve([]string{"web"}); !reflect.DeepEqual(web, []string{"10.0.0.1", "10.0.1.2"}) {
+		t.Errorf("Service instances mismatch. Got %v\n", web)
+	}
+	if db := inventory.Resolve([]string{"db"}); !reflect.DeepEqual(db, []string{"10.0.0.3"}) {
+		t.Errorf("Service instances mismatch. Got %v\n", db)
+	}
+}
@@ -0,0 +1,206 @@
+package henchman
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errLocalCopy = errors.New("Copying isn't supported for local actions")
+
+// CopyFile transfers a local file to the machine over SFTP, or through sudo
+// if the task runs with it, so that root owned files can be written. The
+// file isn't transferred again when the remote one has the same checksum.
+// Owner, as user or user:group, and Mode, in octal, are set on the remote
+// file either way, with sudo if the task runs with it.
+type CopyFile struct {
+	Src   string
+	Dest  string
+	Owner string
+	Mode  string
+}
+
+// Checks the spec of a task of the given kind, copy or template
+func (spec *CopyFile) validate(kind string) error {
+	if spec.Src == "" || spec.Dest == "" {
+		return fmt.Errorf("%s needs a src and a dest", kind)
+	}
+	if spec.Mode != "" {
+		if _, err := strconv.ParseUint(spec.Mode, 8, 32); err != nil {
+			return fmt.Errorf("Invalid %s mode '%s'", kind, spec.Mode)
+		}
+	}
+	return nil
+}
+
+func fileChecksum(f io.ReadSeeker) (string, int64, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), size, nil
+}
+
+// Returns the checksum of the remote file, empty if it doesn't exist
+func (machine *Machine) remoteChecksum(path string, become *become) string {
+	quoted := shellQuote(path)
+	out, _ := machine.run(become.wrap("sha256sum "+quoted+" 2>/dev/null || shasum -a 256 "+quoted+" 2>/dev/null", nil))
+	fields := strings.Fields(out.String())
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// Runs f with a client of the machine's sftp subsystem, within the command
+// timeout
+func (machine *Machine) withSFTP(f func(client *sftpClient) error) error {
+	session, err := machine.openSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return err
+	}
+	return machine.command(func() error {
+		client := &sftpClient{w: w, r: r}
+		if err := client.init(); err != nil {
+			return err
+		}
+		return f(client)
+	}, session.Close)
+}
+
+// Uploads the contents to the path over the sftp subsystem
+func (machine *Machine) upload(path string, contents io.Reader) (int64, error) {
+	var written int64
+	err := machine.withSFTP(func(client *sftpClient) error {
+		var err error
+		written, err = client.upload(path, contents)
+		return err
+	})
+	return written, err
+}
+
+// Copies the file to the machine as the task describes, returning what
+// was done and whether the file changed
+func (machine *Machine) copyFile(spec *CopyFile, become *become) (string, bool, error) {
+	if machine.isLocal() {
+		return "", false, errLocalCopy
+	}
+	f, err := os.Open(spec.Src)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	return machine.putFile(spec, f, "copy", become)
+}
+
+// Renders the template at spec.Src with the vars and copies the result to
+// the machine like copyFile does
+func (machine *Machine) templateFile(spec *CopyFile, vars *TaskVars, become *become) (string, bool, error) {
+	if machine.isLocal() {
+		return "", false, errLocalCopy
+	}
+	rendered, err := renderTemplateFile(spec, vars, machine)
+	if err != nil {
+		return "", false, err
+	}
+	return machine.putFile(spec, strings.NewReader(rendered), "template", become)
+}
+
+// RenderTemplate renders the template file with the vars the way template
+// tasks do for the machine, without connecting to it
+func RenderTemplate(src string, vars TaskVars, machine *Machine) (string, error) {
+	return renderTemplateFile(&CopyFile{Src: src}, &vars, machine)
+}
+
+func renderTemplateFile(spec *CopyFile, vars *TaskVars, machine *Machine) (string, error) {
+	data, err := ioutil.ReadFile(spec.Src)
+	if err != nil {
+		return "", err
+	}
+	rendered, err := prepareTemplate(string(data), machineVars(vars, machine), machine)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't render %s: %s", spec.Src, err)
+	}
+	return rendered, nil
+}
+
+// Writes the contents to the path, over sftp as the login user or piped
+// to a shell run with sudo, which the login user may not be able to write
+// to
+func (machine *Machine) writeFile(path string, contents io.Reader, become *become) error {
+	if become == nil {
+		_, err := machine.upload(path, contents)
+		return err
+	}
+	out, err := machine.run(become.wrap("cat > "+shellQuote(path), contents))
+	if err != nil && strings.TrimSpace(out.String()) != "" {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(out.String()))
+	}
+	return err
+}
+
+// Transfers the contents to spec.Dest unless the remote file already has
+// them, then sets its owner and mode. The file changed if it was
+// transferred. The action, e.g. 'copy', is what estimates record it as.
+func (machine *Machine) putFile(spec *CopyFile, contents io.ReadSeeker, action string, become *become) (string, bool, error) {
+	checksum, size, err := fileChecksum(contents)
+	if err != nil {
+		return "", false, err
+	}
+	if machine.Noop != nil {
+		machine.Noop.record(machine, action+" "+spec.Dest, size)
+		return "", false, nil
+	}
+
+	message := spec.Dest + " unchanged"
+	changed := machine.remoteChecksum(spec.Dest, become) != checksum
+	if changed {
+		var diff string
+		if ShowDiffs {
+			if diff, err = machine.fileDiff(spec, contents, become); err != nil {
+				return "", false, err
+			}
+		}
+		if err := machine.writeFile(spec.Dest, contents, become); err != nil {
+			return "", false, fmt.Errorf("Couldn't copy %s to %s: %s", spec.Src, spec.Dest, err)
+		}
+		message = fmt.Sprintf("Copied %d bytes to %s", size, spec.Dest)
+		if diff != "" {
+			message += "\n" + diff
+		}
+	}
+	var commands []string
+	if spec.Mode != "" {
+		commands = append(commands, "chmod "+spec.Mode+" "+shellQuote(spec.Dest))
+	}
+	if spec.Owner != "" {
+		commands = append(commands, "chown "+shellQuote(spec.Owner)+" "+shellQuote(spec.Dest))
+	}
+	if len(commands) > 0 {
+		if out, err := machine.run(become.wrap(strings.Join(commands, " && "), nil)); err != nil {
+			return out.String(), changed, err
+		}
+	}
+	return message, changed, nil
+}
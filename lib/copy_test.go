@@ -0,0 +1,203 @@
+package henchman
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "app.conf")
+	contents := []byte("listen 8080\n")
+	ioutil.WriteFile(src, contents, 0644)
+
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.sftp = &fakeSFTPServer{files: make(map[string][]byte)}
+	var commands []string
+	server.respond = func(command string) string {
+		// Nothing's there yet
+		commands = append(commands, command)
+		return ""
+	}
+	machine := server.machine()
+	defer machine.Close()
+
+	spec := &CopyFile{Src: src, Dest: "/etc/app.conf", Mode: "0640"}
+	message, changed, err := machine.copyFile(spec, nil)
+	if err != nil {
+		t.Fatalf("Couldn't copy the file: %s\n", err)
+	}
+	if message != "Copied 12 bytes to /etc/app.conf" || !changed || !bytes.Equal(server.sftp.files["/etc/app.conf"], contents) {
+		t.Errorf("Copy mismatch. Got %s\n", message)
+	}
+	if commands[len(commands)-1] != "chmod 0640 '/etc/app.conf'" {
+		t.Errorf("Expected the mode to be set. Got %v\n", commands)
+	}
+
+	// The remote file now has the local checksum
+	f, _ := os.Open(src)
+	checksum, _, _ := fileChecksum(f)
+	f.Close()
+	server.respond = func(command string) string {
+		return checksum + "  /etc/app.conf\n"
+	}
+	server.sftp.files["/etc/app.conf"] = nil
+	if message, changed, err := machine.copyFile(spec, nil); err != nil || changed || message != "/etc/app.conf unchanged" {
+		t.Errorf("Expected an unchanged file to be skipped. Got %s %v\n", message, err)
+	}
+	if server.sftp.files["/etc/app.conf"] != nil {
+		t.Errorf("The unchanged file shouldn't have been transferred\n")
+	}
+}
+
+func TestCopyFileWithSudo(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "sudoers")
+	ioutil.WriteFile(src, []byte("deploy ALL=(ALL) ALL\n"), 0644)
+
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.sftp = &fakeSFTPServer{files: make(map[string][]byte)}
+	written := make(map[string]string)
+	server.stdin = func(command string, input []byte) {
+		written[command] = string(input)
+	}
+	server.respond = func(command string) string {
+		return ""
+	}
+	machine := server.machine()
+	defer machine.Close()
+
+	spec := &CopyFile{Src: src, Dest: "/etc/sudoers.d/deploy", Mode: "0440"}
+	message, changed, err := machine.copyFile(spec, &become{})
+	if err != nil || !changed || message != "Copied 21 bytes to /etc/sudoers.d/deploy" {
+		t.Fatalf("Couldn't copy the file with sudo: %s %v\n", message, err)
+	}
+	if content := written["sudo -n sh -c 'cat > '\\''/etc/sudoers.d/deploy'\\'''"]; content != "deploy ALL=(ALL) ALL\n" {
+		t.Errorf("Expected the file to be written through sudo. Got %q\n", written)
+	}
+	if len(server.sftp.files) != 0 {
+		t.Errorf("The login user shouldn't have written the file. Got %v\n", server.sftp.files)
+	}
+}
+
+func TestTemplateFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "app.conf.j2")
+	ioutil.WriteFile(src, []byte("listen {{ vars.port }}\n"), 0644)
+
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.sftp = &fakeSFTPServer{files: make(map[string][]byte)}
+	server.respond = func(command string) string {
+		return ""
+	}
+	machine := server.machine()
+	defer machine.Close()
+
+	vars := TaskVars{"port": 8080}
+	spec := &CopyFile{Src: src, Dest: "/etc/app.conf"}
+	message, _, err := machine.templateFile(spec, &vars, nil)
+	if err != nil {
+		t.Fatalf("Couldn't render the template: %s\n", err)
+	}
+	if message != "Copied 12 bytes to /etc/app.conf" || string(server.sftp.files["/etc/app.conf"]) != "listen 8080\n" {
+		t.Errorf("Template mismatch. Got %s, %q\n", message, server.sftp.files["/etc/app.conf"])
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "app.conf.j2")
+	ioutil.WriteFile(src, []byte("server_name {{ henchman_host }};\nlisten {{ vars.port }};\n"), 0644)
+	varsFile := filepath.Join(dir, "vars.yaml")
+	ioutil.WriteFile(varsFile, []byte("port: 8080\n"), 0644)
+
+	vars, err := LoadVarsFile(varsFile)
+	if err != nil {
+		t.Fatalf("Couldn't load the vars: %s\n", err)
+	}
+	rendered, err := RenderTemplate(src, vars, &Machine{Hostname: "web1"})
+	if err != nil || rendered != "server_name web1;\nlisten 8080;\n" {
+		t.Errorf("Render mismatch. Got %q %v\n", rendered, err)
+	}
+	if _, err := RenderTemplate(filepath.Join(dir, "missing.j2"), vars, nil); err == nil {
+		t.Errorf("Expected a missing template to fail\n")
+	}
+}
+
+func TestParsePlanWithCopy(t *testing.T) {
+	plan_string := `---
+name: "Sample plan"
+hosts:
+  - 192.168.1.2
+tasks:
+  - name: Configure app
+    copy:
+      src: app.conf
+      dest: /etc/app/{{ vars.env }}.conf
+      owner: app:app
+      mode: "0640"
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		panic(err)
+	}
+	spec := plan.Tasks[0].Copy
+	if spec == nil || spec.Src != "app.conf" || spec.Owner != "app:app" || spec.Mode != "0640" {
+		t.Errorf("Copy mismatch. Got %v\n", spec)
+	}
+	if _, err := NewPlanFromYAML([]byte(plan_string+"      mode: rw-r-----\n"), nil); err == nil {
+		t.Errorf("Expected an invalid mode to be refused\n")
+	}
+}
+
+func TestTemplateTaskWithSudo(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "nginx.conf.j2")
+	ioutil.WriteFile(src, []byte("listen {{ vars.port }};\n"), 0644)
+
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.sftp = &fakeSFTPServer{files: make(map[string][]byte)}
+	var mutex sync.Mutex
+	var written []string
+	server.stdin = func(command string, input []byte) {
+		if strings.Contains(command, "cat > ") {
+			mutex.Lock()
+			written = append(written, command+"\n"+string(input))
+			mutex.Unlock()
+		}
+	}
+	server.respond = func(command string) string {
+		return ""
+	}
+	machine := server.machine()
+	defer machine.Close()
+
+	sudo := true
+	task := Task{Name: "Configure nginx", Sudo: &sudo, Template: &CopyFile{Src: src, Dest: "/etc/nginx/nginx.conf"}}
+	status, err := task.Run(machine, &TaskVars{"port": 8080})
+	if err != nil || status.Status != StatusChanged {
+		t.Fatalf("Couldn't render the template with sudo: %v %v\n", status, err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(written) != 1 || !strings.HasPrefix(written[0], "sudo -n sh -c") || !strings.HasSuffix(written[0], "\nlisten 8080;\n") {
+		t.Errorf("Expected the rendered template to be written through sudo. Got %q\n", written)
+	}
+	if len(server.sftp.files) != 0 {
+		t.Errorf("The login user shouldn't have written the template. Got %v\n", server.sftp.files)
+	}
+}
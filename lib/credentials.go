@@ -0,0 +1,86 @@
+package henchman
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Kinds of secrets henchman asks credential providers for
+const (
+	SSHPassword   = "ssh-password"
+	KeyPassphrase = "key-passphrase"
+	SudoPassword  = "sudo-password"
+	VaultPassword = "vault-password"
+	WinRMPassword = "winrm-password"
+)
+
+// CredentialProvider supplies the secret of the given kind
+type CredentialProvider interface {
+	Credential(kind string) (string, error)
+}
+
+// Prompts the user for secrets with the given function
+type PromptCredentials struct {
+	Prompt func(prompt string) (string, error)
+}
+
+func (provider *PromptCredentials) Credential(kind string) (string, error) {
+	return provider.Prompt(strings.Replace(kind, "-", " ", -1) + ":")
+}
+
+// Reads secrets from environment variables named after their kind,
+// e.g. HENCHMAN_SSH_PASSWORD
+type EnvCredentials struct{}
+
+func credentialEnv(kind string) string {
+	return "HENCHMAN_" + strings.ToUpper(strings.Replace(kind, "-", "_", -1))
+}
+
+func (provider *EnvCredentials) Credential(kind string) (string, error) {
+	value := os.Getenv(credentialEnv(kind))
+	if value == "" {
+		return "", fmt.Errorf("%s isn't set", credentialEnv(kind))
+	}
+	return value, nil
+}
+
+// Reads the secret from a file, ignoring surrounding whitespace. The same
+// file is used for every kind of secret.
+type FileCredentials struct {
+	Path string
+}
+
+func (provider *FileCredentials) Credential(kind string) (string, error) {
+	buf, err := ioutil.ReadFile(provider.Path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// Runs a command, e.g. "pass show infra/ssh", and takes its first line of
+// output as the secret. The kind asked for is passed to the command in
+// HENCHMAN_CREDENTIAL.
+type CommandCredentials struct {
+	Command string
+}
+
+func (provider *CommandCredentials) Credential(kind string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", provider.Command)
+	} else {
+		cmd = exec.Command("sh", "-c", provider.Command)
+	}
+	cmd.Env = append(os.Environ(), "HENCHMAN_CREDENTIAL="+kind)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Credential command failed: %s", err)
+	}
+	return strings.TrimRight(strings.SplitN(string(out), "\n", 2)[0], "\r"), nil
+}
@@ -0,0 +1,57 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEnvCredentials(t *testing.T) {
+	os.Setenv("HENCHMAN_SUDO_PASSWORD", "s3cret")
+	defer os.Unsetenv("HENCHMAN_SUDO_PASSWORD")
+
+	provider := &EnvCredentials{}
+	if secret, err := provider.Credential(SudoPassword); err != nil || secret != "s3cret" {
+		t.Errorf("Env credential mismatch. Got '%s' (%s)\n", secret, err)
+	}
+	if _, err := provider.Credential(VaultPassword); err == nil {
+		t.Errorf("Missing env credentials should be an error\n")
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	f, err := ioutil.TempFile("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("s3cret\n")
+	f.Close()
+
+	provider := &FileCredentials{Path: f.Name()}
+	if secret, err := provider.Credential(SSHPassword); err != nil || secret != "s3cret" {
+		t.Errorf("File credential mismatch. Got '%s' (%s)\n", secret, err)
+	}
+}
+
+func TestCommandCredentials(t *testing.T) {
+	provider := &CommandCredentials{Command: "echo $HENCHMAN_CREDENTIAL; echo second line"}
+	if secret, err := provider.Credential(SSHPassword); err != nil || secret != SSHPassword {
+		t.Errorf("Command credential mismatch. Got '%s' (%s)\n", secret, err)
+	}
+	provider = &CommandCredentials{Command: "exit 1"}
+	if _, err := provider.Credential(SSHPassword); err == nil {
+		t.Errorf("Failing credential commands should be an error\n")
+	}
+}
+
+func TestPromptCredentials(t *testing.T) {
+	var asked string
+	provider := &PromptCredentials{Prompt: func(prompt string) (string, error) {
+		asked = prompt
+		return "s3cret", nil
+	}}
+	if secret, _ := provider.Credential(SSHPassword); secret != "s3cret" || asked != "ssh password:" {
+		t.Errorf("Prompt credential mismatch. Got '%s' for prompt '%s'\n", secret, asked)
+	}
+}
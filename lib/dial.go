@@ -0,0 +1,108 @@
+package henchman
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// Head start each connection attempt gets before the next address is tried,
+// so that a broken IPv6 route doesn't stall connecting over IPv4.
+var ConnectStagger = 250 * time.Millisecond
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// Alternates address families, starting with the resolver's preferred one
+func interleave(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	var preferred, others []net.IP
+	first := ips[0].To4() == nil
+	for _, ip := range ips {
+		if (ip.To4() == nil) == first {
+			preferred = append(preferred, ip)
+		} else {
+			others = append(others, ip)
+		}
+	}
+	var interleaved []net.IP
+	for i := 0; i < len(preferred) || i < len(others); i++ {
+		if i < len(preferred) {
+			interleaved = append(interleaved, preferred[i])
+		}
+		if i < len(others) {
+			interleaved = append(interleaved, others[i])
+		}
+	}
+	return interleaved
+}
+
+// Returns the addresses to try for the machine, in order
+func (machine *Machine) resolve() ([]string, error) {
+	port := strconv.Itoa(machine.Port)
+	if net.ParseIP(machine.Hostname) != nil {
+		return []string{net.JoinHostPort(machine.Hostname, port)}, nil
+	}
+	ips, err := net.LookupIP(machine.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, ip := range interleave(ips) {
+		addresses = append(addresses, net.JoinHostPort(ip.String(), port))
+	}
+	return addresses, nil
+}
+
+// Connects to the first of the addresses to answer, happy eyeballs style.
+// Attempts are started ConnectStagger apart, or right away when the previous
+// one fails. Connections that lose the race are closed.
+func raceConnect(addresses []string, timeout time.Duration) (net.Conn, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	results := make(chan dialResult, len(addresses))
+	pending, next := 0, 0
+	var firstErr error
+	for {
+		if next < len(addresses) {
+			go func(address string) {
+				dialer := net.Dialer{Deadline: deadline}
+				conn, err := dialer.Dial("tcp", address)
+				results <- dialResult{conn, err}
+			}(addresses[next])
+			next++
+			pending++
+		}
+		if pending == 0 {
+			return nil, firstErr
+		}
+		var stagger <-chan time.Time
+		if next < len(addresses) {
+			stagger = time.After(ConnectStagger)
+		}
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if lost := <-results; lost.conn != nil {
+							lost.conn.Close()
+						}
+					}
+				}(pending)
+				return result.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+		case <-stagger:
+		}
+	}
+}
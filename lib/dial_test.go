@@ -0,0 +1,56 @@
+package henchman
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInterleave(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+		net.ParseIP("2001:db8::3"),
+		net.ParseIP("192.0.2.1"),
+	}
+	expected := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "2001:db8::3"}
+	interleaved := interleave(ips)
+	if len(interleaved) != len(expected) {
+		t.Fatalf("Number of addresses mismatch. Got %d\n", len(interleaved))
+	}
+	for i, ip := range interleaved {
+		if ip.String() != expected[i] {
+			t.Errorf("Address %d mismatch. Got %s instead of %s\n", i, ip, expected[i])
+		}
+	}
+}
+
+func TestRaceConnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	closed.Close()
+
+	start := time.Now()
+	conn, err := raceConnect([]string{closed.Addr().String(), listener.Addr().String()}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expected the listening address to win. Got %s\n", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != listener.Addr().String() {
+		t.Errorf("Connected to the wrong address. Got %s\n", conn.RemoteAddr())
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Racing took too long. Got %s\n", elapsed)
+	}
+
+	if _, err := raceConnect([]string{closed.Addr().String()}, time.Second); err == nil {
+		t.Errorf("Expected connecting to a closed port to fail\n")
+	}
+}
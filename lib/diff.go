@@ -0,0 +1,167 @@
+package henchman
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Show a unified diff of the remote file in the messages of tasks that
+// change files, see putFile and Task.Check
+var ShowDiffs bool
+
+// Lines of context around the changes of a diff
+const diffContext = 3
+
+// Files with more lines than this, multiplied, aren't diffed line by line
+const maxDiffCells = 16 * 1024 * 1024
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+	// Positions in the old and new lines before the op
+	a, b int
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Returns the edit script turning a into b, from their longest common
+// subsequence
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// Returns the unified diff from the old to the new contents of the path,
+// empty if they're the same
+func unifiedDiff(path string, old string, new string) string {
+	if old == new {
+		return ""
+	}
+	header := fmt.Sprintf("--- %s (remote)\n+++ %s (new)\n", path, path)
+	if strings.ContainsRune(old, 0) || strings.ContainsRune(new, 0) {
+		return header + "Binary files differ\n"
+	}
+	a, b := splitLines(old), splitLines(new)
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return header + "Files too large to diff\n"
+	}
+	ops := diffLines(a, b)
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		// Only the trailing newline differs
+		return header + "Files differ in their trailing newline\n"
+	}
+	var diff strings.Builder
+	diff.WriteString(header)
+	for c := 0; c < len(changes); {
+		// Changes closer than twice the context share a hunk
+		last := c
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*diffContext {
+			last++
+		}
+		start := changes[c] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[last] + diffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+		hunk := ops[start:end]
+		oldCount, newCount := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		oldStart, newStart := hunk[0].a, hunk[0].b
+		if oldCount > 0 {
+			oldStart++
+		}
+		if newCount > 0 {
+			newStart++
+		}
+		fmt.Fprintf(&diff, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, op := range hunk {
+			diff.WriteByte(op.kind)
+			diff.WriteString(op.line)
+			diff.WriteByte('\n')
+		}
+		c = last + 1
+	}
+	return diff.String()
+}
+
+// Returns the contents of the remote file, empty if it doesn't exist. The
+// empty stdin keeps a pty from mangling the line endings.
+func (machine *Machine) remoteContents(path string, become *become) (string, error) {
+	quoted := shellQuote(path)
+	out, err := machine.run(become.wrap("if [ -e "+quoted+" ]; then cat "+quoted+"; fi", strings.NewReader("")))
+	if err != nil {
+		return "", fmt.Errorf("Couldn't read %s: %s", path, err)
+	}
+	return out.String(), nil
+}
+
+// Returns the diff from the remote file to the contents, which are left
+// rewound
+func (machine *Machine) fileDiff(spec *CopyFile, contents io.ReadSeeker, become *become) (string, error) {
+	data, err := ioutil.ReadAll(contents)
+	if err != nil {
+		return "", err
+	}
+	if _, err := contents.Seek(0, 0); err != nil {
+		return "", err
+	}
+	remote, err := machine.remoteContents(spec.Dest, become)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(spec.Dest, remote, string(data)), nil
+}
@@ -0,0 +1,79 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	old := "user www-data;\nworker_processes 2;\n\nevents {\n    worker_connections 768;\n}\n\nhttp {\n    sendfile on;\n}\n"
+	new := "user www-data;\nworker_processes 4;\n\nevents {\n    worker_connections 768;\n}\n\nhttp {\n    sendfile on;\n    gzip on;\n}\n"
+	expected := strings.Join([]string{
+		"--- /etc/nginx.conf (remote)",
+		"+++ /etc/nginx.conf (new)",
+		"@@ -1,5 +1,5 @@",
+		" user www-data;",
+		"-worker_processes 2;",
+		"+worker_processes 4;",
+		" ",
+		" events {",
+		"     worker_connections 768;",
+		"@@ -7,4 +7,5 @@",
+		" ",
+		" http {",
+		"     sendfile on;",
+		"+    gzip on;",
+		" }",
+	}, "\n") + "\n"
+	if diff := unifiedDiff("/etc/nginx.conf", old, new); diff != expected {
+		t.Errorf("Diff mismatch. Got\n%s\n", diff)
+	}
+	if diff := unifiedDiff("/etc/motd", "", "hello\n"); !strings.HasSuffix(diff, "@@ -0,0 +1,1 @@\n+hello\n") {
+		t.Errorf("Expected a new file to be all additions. Got\n%s\n", diff)
+	}
+	if diff := unifiedDiff("/etc/motd", "same\n", "same\n"); diff != "" {
+		t.Errorf("Expected no diff for the same contents. Got\n%s\n", diff)
+	}
+	if diff := unifiedDiff("/bin/app", "\x00old", "\x00new"); !strings.HasSuffix(diff, "Binary files differ\n") {
+		t.Errorf("Expected binary files not to be diffed. Got\n%s\n", diff)
+	}
+}
+
+func TestTemplateDiff(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "app.conf.j2")
+	ioutil.WriteFile(src, []byte("listen {{ vars.port }}\n"), 0644)
+
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.sftp = &fakeSFTPServer{files: make(map[string][]byte)}
+	server.respond = func(command string) string {
+		if strings.Contains(command, "cat '/etc/app.conf'") {
+			return "listen 80\n"
+		}
+		return ""
+	}
+	machine := server.machine()
+	defer machine.Close()
+	ShowDiffs = true
+	defer func() { ShowDiffs = false }()
+
+	vars := TaskVars{"port": 8080}
+	task := Task{Name: "Configure app", Template: &CopyFile{Src: src, Dest: "/etc/app.conf"}}
+	status, err := task.Check(machine, &vars)
+	if err != nil || !status.Changed || !strings.HasSuffix(status.Message, "@@ -1,1 +1,1 @@\n-listen 80\n+listen 8080\n") {
+		t.Errorf("Expected the check to show the diff. Got %q %v\n", status.Message, err)
+	}
+	if _, uploaded := server.sftp.files["/etc/app.conf"]; uploaded {
+		t.Errorf("Check mode shouldn't have uploaded the file\n")
+	}
+
+	message, _, err := machine.templateFile(&CopyFile{Src: src, Dest: "/etc/app.conf"}, &vars, nil)
+	if err != nil || !strings.HasPrefix(message, "Copied 12 bytes to /etc/app.conf\n--- /etc/app.conf (remote)") {
+		t.Errorf("Expected the copy message to carry the diff. Got %q %v\n", message, err)
+	}
+}
@@ -0,0 +1,70 @@
+package henchman
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// Connection host var value for containers, see ApplyHostSettings
+const DockerConnection = "docker"
+
+// Docker client the actions run through
+var dockerCommand = "docker"
+
+// Docker runs actions in the container named by the machine's hostname with
+// docker exec. The client talks to the local daemon unless ExtraArgs points
+// it elsewhere, e.g. "-H tcp://build01:2376 --tls", or DOCKER_HOST is set.
+// Like WinRM, only actions run over it.
+type Docker struct {
+	// User the actions run as, the container's when empty
+	User      string
+	ExtraArgs []string
+}
+
+func (docker *Docker) Name() string {
+	return DockerConnection
+}
+
+// Returns the docker arguments running the action in the container
+func (docker *Docker) args(machine *Machine, action string, interactive bool) []string {
+	args := append(append([]string(nil), docker.ExtraArgs...), "exec")
+	if interactive {
+		args = append(args, "-i")
+	}
+	if docker.User != "" {
+		args = append(args, "-u", docker.User)
+	}
+	return append(args, machine.Hostname, "sh", "-c", action)
+}
+
+// The action writes down its pid in the container, as killing the client
+// leaves it running there. Aborting it kills it along with the processes it
+// started.
+func (docker *Docker) Run(machine *Machine, action string, stdin io.Reader) (*Output, error) {
+	b := NewOutput()
+	defer b.Close()
+	pidfile := "/tmp/.henchman-" + uuid.New() + ".pid"
+	wrapped := "echo $$ > " + pidfile + "; sh -c " + shellQuote(action) + "; rc=$?; rm -f " + pidfile + "; exit $rc"
+	cmd := exec.Command(dockerCommand, docker.args(machine, wrapped, stdin != nil)...)
+	cmd.Stdin = stdin
+	cmd.Stdout = b
+	cmd.Stderr = b
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return b, err
+	}
+	err := machine.command(cmd.Wait, func() error {
+		killProcessGroup(cmd)
+		kill := "pid=$(cat " + pidfile + ") && { pkill -9 -P $pid; kill -9 $pid; }; rm -f " + pidfile
+		return exec.Command(dockerCommand, docker.args(machine, kill, false)...).Run()
+	})
+	return b, err
+}
+
+// Splits the extra args host var, which is a single string in inventories
+func dockerExtraArgs(vars TaskVars) []string {
+	return strings.Fields(hostSetting(vars, "docker_extra_args"))
+}
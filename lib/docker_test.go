@@ -0,0 +1,60 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Installs a docker client that records its args and runs the command
+// locally instead of in a container
+func fakeDocker(t *testing.T, dir string) string {
+	argsFile := filepath.Join(dir, "args")
+	client := filepath.Join(dir, "docker")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$*\" > " + argsFile + "\nwhile [ \"$1\" != sh ]; do shift; done\nexec \"$@\"\n"
+	if err := ioutil.WriteFile(client, []byte(script), 0755); err != nil {
+		t.Fatalf("Couldn't write the fake docker client: %s\n", err)
+	}
+	dockerCommand = client
+	return argsFile
+}
+
+func TestDockerRun(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	argsFile := fakeDocker(t, dir)
+	defer func() { dockerCommand = "docker" }()
+
+	machine := &Machine{Hostname: "web-1", Port: 22, Vars: TaskVars{
+		"ansible_connection":        "docker",
+		"ansible_user":              "www-data",
+		"ansible_docker_extra_args": "-H tcp://build01:2376",
+	}}
+	if err := ApplyHostSettings([]*Machine{machine}); err != nil {
+		t.Fatalf("Couldn't apply the host settings: %s\n", err)
+	}
+	out, err := machine.run("cat; echo ' done'", strings.NewReader("input"))
+	if err != nil {
+		t.Fatalf("Couldn't run in the container: %s\n", err)
+	}
+	if out.String() != "input done\n" {
+		t.Errorf("Output mismatch. Got %q\n", out.String())
+	}
+	args, _ := ioutil.ReadFile(argsFile)
+	if !strings.HasPrefix(string(args), "-H tcp://build01:2376 exec -i -u www-data web-1 sh -c echo $$ > /tmp/.henchman-") ||
+		!strings.Contains(string(args), "; sh -c 'cat; echo '\\'' done'\\'''; rc=$?") {
+		t.Errorf("Docker args mismatch. Got %q\n", args)
+	}
+
+	if _, err := machine.run("exit 2", nil); err == nil {
+		t.Errorf("Expected a failing action to be an error\n")
+	} else if status, ok := exitStatus(err); !ok || status != 2 {
+		t.Errorf("Expected exit status 2. Got %v\n", err)
+	}
+	args, _ = ioutil.ReadFile(argsFile)
+	if strings.Contains(string(args), " -i ") {
+		t.Errorf("Actions without stdin shouldn't be interactive. Got %q\n", args)
+	}
+}
@@ -0,0 +1,224 @@
+package henchman
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider for hosts of the form tag:<key>=<value>, resolving to the
+// running EC2 instances with that tag.
+var EC2 = &EC2Provider{}
+
+// EC2Provider looks up running EC2 instances by tag. Credentials and the
+// region not set explicitly are taken from the usual AWS_* environment
+// variables. Lookups are cached for the rest of the run.
+type EC2Provider struct {
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Defaults to the regional EC2 endpoint
+	Endpoint string
+	// Use private IPs even for instances that have a public one
+	PrivateIP bool
+
+	mutex sync.Mutex
+	cache map[string][]string
+}
+
+type ec2Instance struct {
+	InstanceId string `xml:"instanceId"`
+	PrivateIP  string `xml:"privateIpAddress"`
+	PublicIP   string `xml:"ipAddress"`
+}
+
+type describeInstancesResponse struct {
+	Instances []ec2Instance `xml:"reservationSet>item>instancesSet>item"`
+	NextToken string        `xml:"nextToken"`
+}
+
+type ec2ErrorResponse struct {
+	Code    string `xml:"Errors>Error>Code"`
+	Message string `xml:"Errors>Error>Message"`
+}
+
+func (provider *EC2Provider) configure() error {
+	firstEnv := func(value string, names ...string) string {
+		for _, name := range names {
+			if value == "" {
+				value = os.Getenv(name)
+			}
+		}
+		return value
+	}
+	provider.Region = firstEnv(provider.Region, "AWS_REGION", "AWS_DEFAULT_REGION")
+	provider.AccessKey = firstEnv(provider.AccessKey, "AWS_ACCESS_KEY_ID")
+	provider.SecretKey = firstEnv(provider.SecretKey, "AWS_SECRET_ACCESS_KEY")
+	provider.SessionToken = firstEnv(provider.SessionToken, "AWS_SESSION_TOKEN")
+	if provider.Region == "" || provider.AccessKey == "" || provider.SecretKey == "" {
+		return fmt.Errorf("EC2 lookups need AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if provider.Endpoint == "" {
+		provider.Endpoint = "https://ec2." + provider.Region + ".amazonaws.com/"
+	}
+	return nil
+}
+
+// Returns the addresses of the running instances with the tag, given as
+// key=value.
+func (provider *EC2Provider) Hosts(selector string) ([]string, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if hosts, present := provider.cache[selector]; present {
+		return hosts, nil
+	}
+	key_value := strings.SplitN(selector, "=", 2)
+	if len(key_value) != 2 || key_value[0] == "" {
+		return nil, fmt.Errorf("Expected tag:<key>=<value>, got tag:%s", selector)
+	}
+	if err := provider.configure(); err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	nextToken := ""
+	for {
+		params := url.Values{
+			"Action":           {"DescribeInstances"},
+			"Version":          {"2016-11-15"},
+			"Filter.1.Name":    {"tag:" + key_value[0]},
+			"Filter.1.Value.1": {key_value[1]},
+			"Filter.2.Name":    {"instance-state-name"},
+			"Filter.2.Value.1": {"running"},
+		}
+		if nextToken != "" {
+			params.Set("NextToken", nextToken)
+		}
+		response, err := provider.describeInstances(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range response.Instances {
+			host := instance.PublicIP
+			if host == "" || provider.PrivateIP {
+				host = instance.PrivateIP
+			}
+			if host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		if nextToken = response.NextToken; nextToken == "" {
+			break
+		}
+	}
+	if provider.cache == nil {
+		provider.cache = make(map[string][]string)
+	}
+	provider.cache[selector] = hosts
+	return hosts, nil
+}
+
+func (provider *EC2Provider) describeInstances(params url.Values) (*describeInstancesResponse, error) {
+	endpoint, err := url.Parse(provider.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.RawQuery = awsQuery(params)
+	request, err := http.NewRequest("GET", endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	provider.sign(request, time.Now().UTC())
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var ec2Err ec2ErrorResponse
+		if xml.Unmarshal(body, &ec2Err) == nil && ec2Err.Code != "" {
+			return nil, fmt.Errorf("EC2 %s: %s", ec2Err.Code, ec2Err.Message)
+		}
+		return nil, fmt.Errorf("EC2 responded with %s", resp.Status)
+	}
+	var response describeInstancesResponse
+	if err := xml.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Encodes the query the way signature version 4 wants it, sorted and with
+// spaces as %20.
+func awsQuery(params url.Values) string {
+	var keys []string
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range params[key] {
+			pairs = append(pairs, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Signs the bodyless request with AWS signature version 4
+func (provider *EC2Provider) sign(request *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Header.Set("X-Amz-Date", amzDate)
+	headers := "host:" + request.URL.Host + "\nx-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-date"
+	if provider.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", provider.SessionToken)
+		headers += "x-amz-security-token:" + provider.SessionToken + "\n"
+		signedHeaders += ";x-amz-security-token"
+	}
+	path := request.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	emptyHash := sha256.Sum256(nil)
+	canonical := strings.Join([]string{
+		request.Method, path, request.URL.RawQuery, headers, signedHeaders, hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + provider.Region + "/ec2/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+provider.SecretKey), date)
+	for _, part := range []string{provider.Region, "ec2", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+provider.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
@@ -0,0 +1,106 @@
+package henchman
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const describeInstancesPage = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <reservationSet>
+    <item>
+      <instancesSet>
+        <item><instanceId>i-1</instanceId><privateIpAddress>10.0.0.1</privateIpAddress><ipAddress>54.0.0.1</ipAddress></item>
+        <item><instanceId>i-2</instanceId><privateIpAddress>10.0.0.2</privateIpAddress></item>
+      </instancesSet>
+    </item>
+  </reservationSet>
+  %s
+</DescribeInstancesResponse>`
+
+func TestEC2Hosts(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		query := r.URL.Query()
+		if query.Get("Filter.1.Name") != "tag:role" || query.Get("Filter.1.Value.1") != "web" {
+			t.Errorf("Filter mismatch. Got %s\n", r.URL.RawQuery)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Errorf("Request isn't signed. Got %s\n", r.Header.Get("Authorization"))
+		}
+		if query.Get("NextToken") == "" {
+			fmt.Fprintf(w, describeInstancesPage, "<nextToken>page2</nextToken>")
+			return
+		}
+		fmt.Fprintf(w, describeInstancesPage, "")
+	}))
+	defer server.Close()
+
+	provider := &EC2Provider{Region: "us-east-1", AccessKey: "AKID", SecretKey: "secret", Endpoint: server.URL + "/"}
+	hosts, err := provider.Hosts("role=web")
+	if err != nil {
+		t.Fatalf("Couldn't look up the instances: %s\n", err)
+	}
+	if !reflect.DeepEqual(hosts, []string{"54.0.0.1", "10.0.0.2", "54.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Hosts mismatch. Got %v\n", hosts)
+	}
+	provider.Hosts("role=web")
+	if requests != 2 {
+		t.Errorf("Expected the lookup to be cached. Got %d requests\n", requests)
+	}
+	if _, err := provider.Hosts("role"); err == nil {
+		t.Errorf("Expected a selector without a value to be refused\n")
+	}
+}
+
+func TestEC2Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `<Response><Errors><Error><Code>AuthFailure</Code><Message>Bad credentials</Message></Error></Errors></Response>`)
+	}))
+	defer server.Close()
+	provider := &EC2Provider{Region: "us-east-1", AccessKey: "AKID", SecretKey: "secret", Endpoint: server.URL + "/"}
+	if _, err := provider.Hosts("role=web"); err == nil || !strings.Contains(err.Error(), "AuthFailure") {
+		t.Errorf("Expected the EC2 error to be passed on. Got %v\n", err)
+	}
+}
+
+// Credentials from the AWS signature version 4 documentation examples
+func TestEC2Signature(t *testing.T) {
+	provider := &EC2Provider{Region: "us-east-1", AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	request, _ := http.NewRequest("GET", "https://ec2.amazonaws.com/?Action=DescribeRegions&Version=2013-10-15", nil)
+	provider.sign(request, time.Date(2013, 10, 15, 12, 0, 0, 0, time.UTC))
+	authorization := request.Header.Get("Authorization")
+	if !strings.Contains(authorization, "Credential=AKIDEXAMPLE/20131015/us-east-1/ec2/aws4_request, SignedHeaders=host;x-amz-date, Signature=7f66e75dbeb01ffde8ab10df1a370456e96b45144f19db98ca046b89b42e2522") {
+		t.Errorf("Authorization mismatch. Got %s\n", authorization)
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	HostProviders["test"] = staticProvider{"web": {"10.0.0.1", "10.0.0.2"}}
+	defer delete(HostProviders, "test")
+	hosts, err := ResolveHosts([]string{"test:web", "10.0.0.1", "db1"})
+	if err != nil {
+		t.Fatalf("Couldn't resolve the hosts: %s\n", err)
+	}
+	if !reflect.DeepEqual(hosts, []string{"10.0.0.1", "10.0.0.2", "db1"}) {
+		t.Errorf("Hosts mismatch. Got %v\n", hosts)
+	}
+	if _, err := ResolveHosts([]string{"test:db"}); err == nil {
+		t.Errorf("Expected the provider's error to be passed on\n")
+	}
+}
+
+type staticProvider map[string][]string
+
+func (provider staticProvider) Hosts(selector string) ([]string, error) {
+	if hosts, present := provider[selector]; present {
+		return hosts, nil
+	}
+	return nil, fmt.Errorf("No hosts for %s", selector)
+}
@@ -0,0 +1,55 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v1"
+)
+
+// Environments that plans are only run against with an explicit confirmation
+var ProtectedEnvs = []string{"prod", "production"}
+
+func IsProtectedEnv(env string) bool {
+	for _, protected := range ProtectedEnvs {
+		if env == protected {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads the variables of the environment from vars/<env>.yaml next to the plan.
+// These take precedence over the plan's variables, but not over extra args.
+// Environments without a vars file have no variables of their own.
+func LoadEnvVars(planDir string, env string) (TaskVars, error) {
+	vars, err := LoadVarsFile(filepath.Join(planDir, "vars", env+".yaml"))
+	if os.IsNotExist(err) {
+		return make(TaskVars), nil
+	}
+	return vars, err
+}
+
+// Returns the inventory of the environment, inventory/<env> next to the plan
+// with or without a YAML extension, or "" if it has none.
+func EnvInventoryPath(planDir string, env string) string {
+	base := filepath.Join(planDir, "inventory", env)
+	for _, path := range []string{base, base + ".yaml", base + ".yml"} {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// Loads the variables in the YAML file
+func LoadVarsFile(path string) (TaskVars, error) {
+	vars := make(TaskVars)
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(buf, &vars)
+	return vars, err
+}
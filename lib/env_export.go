@@ -0,0 +1,109 @@
+package henchman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var errLocalEnv = errors.New("Exporting vars isn't supported for local actions")
+
+var envPrefixPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EnvExport exports vars to the task's command as environment variables so
+// that scripts reading their settings from the environment work unchanged.
+// Vars are named like in templates, nested ones with dots, e.g.
+// facts.os_family, and are exported as <prefix><NAME> with dots and other
+// characters that can't be in a variable name turned into underscores.
+//
+//	env:
+//	  prefix: APP_
+//	  vars: [http_port, facts.distribution]
+//
+// exports APP_HTTP_PORT and APP_FACTS_DISTRIBUTION. Vars that aren't set,
+// like facts missing on the machine, aren't exported.
+type EnvExport struct {
+	Prefix string
+	Vars   []string
+}
+
+func (export *EnvExport) validate() error {
+	if len(export.Vars) == 0 {
+		return errors.New("No vars to export")
+	}
+	if export.Prefix != "" && !envPrefixPattern.MatchString(export.Prefix) {
+		return fmt.Errorf("Invalid env prefix '%s'", export.Prefix)
+	}
+	return nil
+}
+
+var envNameReplacer = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func envName(prefix string, variable string) string {
+	name := prefix + strings.ToUpper(envNameReplacer.ReplaceAllString(variable, "_"))
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// Looks up a dotted var name through nested maps
+func lookupVar(vars TaskVars, variable string) (interface{}, bool) {
+	var value interface{} = vars
+	for _, key := range strings.Split(variable, ".") {
+		switch m := value.(type) {
+		case map[string]interface{}:
+			value = m[key]
+		case TaskVars:
+			value = m[key]
+		case map[interface{}]interface{}:
+			value = m[key]
+		default:
+			return nil, false
+		}
+		if value == nil {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Strings are exported as they are, anything else as JSON
+func envValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[interface{}]interface{}:
+		// YAML maps don't marshal to JSON
+		return fmt.Sprint(v)
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(buf)
+}
+
+// Returns the export statement to run before the command, sorted by name.
+// Empty if there's nothing to export.
+func (export *EnvExport) exports(vars *TaskVars) string {
+	if export == nil || vars == nil {
+		return ""
+	}
+	var assignments []string
+	for _, variable := range export.Vars {
+		value, present := lookupVar(*vars, variable)
+		if !present {
+			continue
+		}
+		assignments = append(assignments, envName(export.Prefix, variable)+"="+shellQuote(envValue(value)))
+	}
+	if len(assignments) == 0 {
+		return ""
+	}
+	sort.Strings(assignments)
+	return "export " + strings.Join(assignments, " ") + "; "
+}
@@ -0,0 +1,52 @@
+package henchman
+
+import (
+	"testing"
+)
+
+func TestEnvExports(t *testing.T) {
+	vars := TaskVars{
+		"http_port": 8080,
+		"motd":      "it's up",
+		"facts":     TaskVars{"distribution": "debian"},
+		"servers":   []interface{}{"a", "b"},
+	}
+	export := &EnvExport{Prefix: "APP_", Vars: []string{"http_port", "motd", "facts.distribution", "facts.missing", "servers"}}
+	exports := export.exports(&vars)
+	expected := `export APP_FACTS_DISTRIBUTION='debian' APP_HTTP_PORT='8080' APP_MOTD='it'\''s up' APP_SERVERS='["a","b"]'; `
+	if exports != expected {
+		t.Errorf("Exports mismatch. Got %s\n", exports)
+	}
+	if exports := (&EnvExport{Vars: []string{"missing"}}).exports(&vars); exports != "" {
+		t.Errorf("Missing vars shouldn't be exported. Got %s\n", exports)
+	}
+	var none *EnvExport
+	if exports := none.exports(&vars); exports != "" {
+		t.Errorf("Tasks without env shouldn't export anything. Got %s\n", exports)
+	}
+}
+
+func TestParsePlanWithEnv(t *testing.T) {
+	plan_string := `---
+name: "Sample plan"
+hosts:
+  - 192.168.1.2
+tasks:
+  - name: Legacy deploy
+    action: ./deploy.sh
+    env:
+      prefix: DEPLOY_
+      vars: [release, facts.os_family]
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		panic(err)
+	}
+	env := plan.Tasks[0].Env
+	if env == nil || env.Prefix != "DEPLOY_" || len(env.Vars) != 2 {
+		t.Errorf("Env mismatch. Got %v\n", env)
+	}
+	if _, err := NewPlanFromYAML([]byte(plan_string+"      prefix: 'DEPLOY-'\n"), nil); err == nil {
+		t.Errorf("Expected an invalid prefix to be refused\n")
+	}
+}
@@ -0,0 +1,63 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadEnvVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(path.Join(dir, "vars"), 0755)
+	ioutil.WriteFile(path.Join(dir, "vars", "staging.yaml"), []byte("service: foo-staging\n"), 0644)
+
+	vars, err := LoadEnvVars(dir, "staging")
+	if err != nil {
+		t.Fatalf("Couldn't load the env vars: %s\n", err)
+	}
+	if vars["service"] != "foo-staging" {
+		t.Errorf("Env var 'service' mismatch. Got %v\n", vars["service"])
+	}
+	if vars, err := LoadEnvVars(dir, "qa"); err != nil || len(vars) != 0 {
+		t.Errorf("An env without a vars file should have no vars. Got %v, %v\n", vars, err)
+	}
+	ioutil.WriteFile(path.Join(dir, "vars", "broken.yaml"), []byte("service: [foo\n"), 0644)
+	if _, err := LoadEnvVars(dir, "broken"); err == nil {
+		t.Errorf("Loading a malformed env vars file should fail\n")
+	}
+}
+
+func TestIsProtectedEnv(t *testing.T) {
+	if !IsProtectedEnv("prod") {
+		t.Errorf("prod should be protected\n")
+	}
+	if IsProtectedEnv("staging") {
+		t.Errorf("staging shouldn't be protected\n")
+	}
+}
+
+func TestEnvInventoryPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(path.Join(dir, "inventory"), 0755)
+	ioutil.WriteFile(path.Join(dir, "inventory", "staging"), []byte("[web]\nweb-staging1\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "inventory", "qa.yaml"), []byte("groups:\n  web: [web-qa1]\n"), 0644)
+
+	if inventoryPath := EnvInventoryPath(dir, "staging"); inventoryPath != path.Join(dir, "inventory", "staging") {
+		t.Errorf("Staging inventory mismatch. Got %s\n", inventoryPath)
+	}
+	if inventoryPath := EnvInventoryPath(dir, "qa"); inventoryPath != path.Join(dir, "inventory", "qa.yaml") {
+		t.Errorf("QA inventory mismatch. Got %s\n", inventoryPath)
+	}
+	if inventoryPath := EnvInventoryPath(dir, "prod"); inventoryPath != "" {
+		t.Errorf("Expected no inventory for prod. Got %s\n", inventoryPath)
+	}
+}
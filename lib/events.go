@@ -0,0 +1,182 @@
+package henchman
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	PlanStarted     = "plan_started"
+	TaskStarted     = "task_started"
+	TaskFinished    = "task_finished"
+	HostQuarantined = "host_quarantined"
+	PlanFinished    = "plan_finished"
+)
+
+// Event is a single entry in a run's event log. Only the fields relevant to
+// the event's type are set.
+type Event struct {
+	Time          time.Time     `json:"time"`
+	Type          string        `json:"type"`
+	Plan          string        `json:"plan,omitempty"`
+	Manifest      string        `json:"manifest,omitempty"`
+	Hosts         []string      `json:"hosts,omitempty"`
+	Tasks         []string      `json:"tasks,omitempty"`
+	Host          string        `json:"host,omitempty"`
+	TaskId        string        `json:"task_id,omitempty"`
+	Task          string        `json:"task,omitempty"`
+	TaskIndex     int           `json:"task_index,omitempty"`
+	Status        string        `json:"status,omitempty"`
+	Changed       bool          `json:"changed,omitempty"`
+	Message       string        `json:"message,omitempty"`
+	Duration      time.Duration `json:"duration,omitempty"`
+	ErrorCategory string        `json:"error_category,omitempty"`
+	Rollback      bool          `json:"rollback,omitempty"`
+	Handler       bool          `json:"handler,omitempty"`
+	Budget        time.Duration `json:"budget,omitempty"`
+	Reason        string        `json:"reason,omitempty"`
+}
+
+// EventLog writes the events of a run as newline delimited JSON, so that the
+// run can be replayed later regardless of how verbose it was.
+type EventLog struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	// Where the events are sent as well
+	sinks []EventSink
+}
+
+// EventSink is sent the events of a run as they happen, e.g. a callback
+// plugin or a Webhook
+type EventSink interface {
+	Send(event *Event) error
+}
+
+// Returns the log writing to w, or only sending the events to its sinks
+// when w is nil
+func NewEventLog(w io.Writer) *EventLog {
+	if w == nil {
+		return &EventLog{}
+	}
+	return &EventLog{encoder: json.NewEncoder(w)}
+}
+
+// Sends the events to the sink too
+func (events *EventLog) AddSink(sink EventSink) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	events.sinks = append(events.sinks, sink)
+}
+
+// Appends the event to the log. A nil log discards events. Sinks failing
+// are logged, they don't fail the run.
+func (events *EventLog) Emit(event Event) error {
+	if events == nil {
+		return nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	for _, sink := range events.sinks {
+		if err := sink.Send(&event); err != nil {
+			log.Printf("%s\n", err)
+		}
+	}
+	if events.encoder == nil {
+		return nil
+	}
+	return events.encoder.Encode(&event)
+}
+
+func (events *EventLog) PlanStarted(plan *Plan) error {
+	event := Event{Type: PlanStarted, Plan: plan.Name, Manifest: plan.Manifest, Hosts: plan.Hosts}
+	for _, task := range plan.Tasks {
+		event.Tasks = append(event.Tasks, task.Name)
+	}
+	return events.Emit(event)
+}
+
+func (events *EventLog) TaskStarted(machine *Machine, task *Task) error {
+	return events.Emit(Event{Type: TaskStarted, Host: machine.Hostname, TaskId: task.Id, Task: task.Name})
+}
+
+func (events *EventLog) TaskFinished(machine *Machine, task *Task, status *TaskStatus) error {
+	return events.Emit(Event{
+		Type:          TaskFinished,
+		Host:          machine.Hostname,
+		TaskId:        task.Id,
+		Task:          task.Name,
+		TaskIndex:     task.Index,
+		Status:        status.Status,
+		Changed:       status.Changed,
+		Message:       status.Message,
+		Duration:      status.Duration,
+		ErrorCategory: status.ErrorCategory,
+		Rollback:      task.isRollback,
+		Handler:       task.isHandler,
+		Budget:        task.budget(),
+	})
+}
+
+func (events *EventLog) HostQuarantined(machine *Machine, reason string) error {
+	return events.Emit(Event{Type: HostQuarantined, Host: machine.Hostname, Reason: reason})
+}
+
+func (events *EventLog) PlanFinished(plan *Plan) error {
+	return events.Emit(Event{Type: PlanFinished, Plan: plan.Name})
+}
+
+// Reads back an event log
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	decoder := json.NewDecoder(r)
+	for {
+		var event Event
+		if err := decoder.Decode(&event); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+// Rebuilds the plan and its results from an event log, so that its report
+// can be rendered again.
+func ReplayPlan(events []Event) *Plan {
+	plan := &Plan{}
+	for _, event := range events {
+		switch event.Type {
+		case PlanStarted:
+			plan.Name = event.Plan
+			plan.Manifest = event.Manifest
+			plan.Hosts = event.Hosts
+			for i, name := range event.Tasks {
+				plan.Tasks = append(plan.Tasks, Task{Name: name, Index: i + 1})
+			}
+		case TaskFinished:
+			plan.results = append(plan.results, Result{
+				Host:          event.Host,
+				TaskId:        event.TaskId,
+				Task:          event.Task,
+				TaskIndex:     event.TaskIndex,
+				Status:        event.Status,
+				Changed:       event.Changed,
+				Message:       event.Message,
+				Duration:      event.Duration,
+				ErrorCategory: event.ErrorCategory,
+				Rollback:      event.Rollback,
+				Handler:       event.Handler,
+				Budget:        event.Budget,
+			})
+		case HostQuarantined:
+			plan.Quarantine(&Machine{Hostname: event.Host}, event.Reason)
+		}
+	}
+	return plan
+}
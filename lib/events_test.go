@@ -0,0 +1,107 @@
+package henchman
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReplayEventLog(t *testing.T) {
+	plan := &Plan{
+		Name:  "Replayed plan",
+		Hosts: []string{"192.168.1.2", "192.168.1.3"},
+		Tasks: []Task{{Id: "1", Name: "Task one"}, {Id: "2", Name: "Task two"}},
+	}
+	var buf bytes.Buffer
+	events := NewEventLog(&buf)
+	events.PlanStarted(plan)
+	for _, machine := range Machines(plan.Hosts, nil) {
+		events.TaskStarted(machine, &plan.Tasks[0])
+		events.TaskFinished(machine, &plan.Tasks[0], &TaskStatus{Status: "ok", Message: "ok", Duration: time.Second})
+	}
+	events.TaskFinished(&Machine{Hostname: "192.168.1.3"}, &plan.Tasks[1], &TaskStatus{Status: "failed", ErrorCategory: "command timeout"})
+	events.PlanFinished(plan)
+
+	read, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("Couldn't read the events back: %s\n", err)
+	}
+	if len(read) != 7 {
+		t.Fatalf("Number of events mismatch. Got %d\n", len(read))
+	}
+	if read[2].Duration != time.Second || read[2].Message != "ok" {
+		t.Errorf("Event mismatch. Got %v\n", read[2])
+	}
+
+	report := ReplayPlan(read).Report()
+	if report.Plan != "Replayed plan" || report.Total != 4 || report.Attempted != 3 {
+		t.Errorf("Report mismatch. Got %s with %d/%d tasks\n", report.Plan, report.Attempted, report.Total)
+	}
+	if report.Counts["ok"] != 2 || report.Counts["failed"] != 1 || report.Counts["skipped"] != 1 {
+		t.Errorf("Counts mismatch. Got %v\n", report.Counts)
+	}
+	if report.Errors["command timeout"] != 1 {
+		t.Errorf("Error categories mismatch. Got %v\n", report.Errors)
+	}
+}
+
+func TestReplayRollbacksAndHandlers(t *testing.T) {
+	plan_string := `---
+name: "Replayed plan"
+hosts:
+  - web1
+tasks:
+  - name: Deploy
+    action: ./deploy
+    expected_duration: 1s
+    notify: [restart]
+    rollback:
+      - name: Undeploy
+        action: ./undeploy
+  - name: Migrate
+    action: ./migrate
+handlers:
+  - name: restart
+    action: systemctl restart app
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		t.Fatalf("Couldn't read the plan: %s\n", err)
+	}
+	plan.Manifest = "run-1"
+	web1 := &Machine{Hostname: "web1"}
+	var buf bytes.Buffer
+	events := NewEventLog(&buf)
+	events.PlanStarted(plan)
+	events.TaskFinished(web1, &plan.Tasks[0], &TaskStatus{Status: StatusChanged, Changed: true, Duration: 5 * time.Second})
+	events.TaskFinished(web1, &plan.Tasks[1], &TaskStatus{Status: StatusFailed})
+	events.TaskFinished(web1, &plan.Tasks[0].Rollback[0], &TaskStatus{Status: StatusChanged, Changed: true})
+	events.TaskFinished(web1, &plan.Handlers[0], &TaskStatus{Status: StatusOk})
+	events.HostQuarantined(web1, "1 consecutive failed tasks")
+	events.PlanFinished(plan)
+
+	read, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("Couldn't read the events back: %s\n", err)
+	}
+	report := ReplayPlan(read).Report()
+	if report.Attempted != 2 || report.RolledBack != 1 || report.Handlers != 1 {
+		t.Errorf("Report mismatch. Got %d attempted, %d rolled back, %d handlers\n", report.Attempted, report.RolledBack, report.Handlers)
+	}
+	if counts := report.HostCounts["web1"]; counts[StatusChanged] != 1 || counts[StatusFailed] != 1 || counts[StatusSkipped] != 0 {
+		t.Errorf("Host counts mismatch. Got %v\n", counts)
+	}
+	if len(report.OverBudget) != 1 || report.OverBudget[0].Task != "Deploy" {
+		t.Errorf("Expected Deploy to be over budget. Got %v\n", report.OverBudget)
+	}
+	if report.Quarantined["web1"] != "1 consecutive failed tasks" || report.Manifest != "run-1" {
+		t.Errorf("Expected the quarantine and manifest to be replayed. Got %v and '%s'\n", report.Quarantined, report.Manifest)
+	}
+}
+
+func TestNilEventLog(t *testing.T) {
+	var events *EventLog
+	if err := events.Emit(Event{Type: PlanFinished}); err != nil {
+		t.Errorf("Expected a nil log to discard events. Got %s\n", err)
+	}
+}
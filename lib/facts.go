@@ -0,0 +1,89 @@
+package henchman
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Shell snippets printing the facts of each subset as key=value lines. They
+// stick to POSIX tools so that minimal images can be gathered from too.
+var factGatherers = map[string]string{
+	"os": `echo "system=$(uname -s)"; echo "kernel=$(uname -r)"; ` +
+		`[ -r /etc/os-release ] && . /etc/os-release && ` +
+		`echo "os_family=${ID_LIKE:-$ID}" && echo "distribution=$ID" && echo "distribution_version=$VERSION_ID"`,
+	"network": `echo "hostname=$(hostname)"; echo "fqdn=$(hostname -f 2>/dev/null)"; ` +
+		`echo "ipv4=$(hostname -I 2>/dev/null | cut -d' ' -f1)"`,
+	"hardware": `echo "processors=$(getconf _NPROCESSORS_ONLN 2>/dev/null)"; ` +
+		`[ -r /proc/meminfo ] && awk '/^MemTotal:/ { print "memory_kb=" $2 }' /proc/meminfo`,
+}
+
+// Whether either the shell or the Windows gatherers know the subset
+func knownFactSubset(subset string) bool {
+	_, shell := factGatherers[subset]
+	_, windows := windowsFactGatherers[subset]
+	return shell || windows
+}
+
+// Parses the plan's gather_facts, either 'all' or a comma separated list of
+// subsets like 'network,os'. No facts are gathered when it's empty. Subsets
+// a host has no gatherer for, like features on Linux, gather nothing.
+func ParseFactSubsets(spec string) ([]string, error) {
+	var subsets []string
+	switch strings.TrimSpace(spec) {
+	case "", "none":
+		return nil, nil
+	case "all":
+		for subset := range factGatherers {
+			subsets = append(subsets, subset)
+		}
+		for subset := range windowsFactGatherers {
+			if _, present := factGatherers[subset]; !present {
+				subsets = append(subsets, subset)
+			}
+		}
+		sort.Strings(subsets)
+		return subsets, nil
+	}
+	for _, subset := range strings.Split(spec, ",") {
+		subset = strings.TrimSpace(subset)
+		if !knownFactSubset(subset) {
+			return nil, fmt.Errorf("Unknown facts subset '%s'", subset)
+		}
+		subsets = append(subsets, subset)
+	}
+	return subsets, nil
+}
+
+// Gathers the subsets of facts from the machine in a single round trip,
+// with PowerShell on hosts connected to over WinRM. Facts that couldn't be
+// found on the machine are left out, and network devices have none.
+func (machine *Machine) GatherFacts(subsets []string) (TaskVars, error) {
+	if machine.isNetwork() {
+		return TaskVars{}, nil
+	}
+	var out *Output
+	var err error
+	if _, windows := machine.Transport.(*WinRM); windows {
+		out, err = machine.run(windowsFactsScript(subsets), nil)
+	} else {
+		var script []string
+		for _, subset := range subsets {
+			if gatherer, present := factGatherers[subset]; present {
+				script = append(script, "("+gatherer+")")
+			}
+		}
+		out, err = machine.run("sh -s", strings.NewReader(strings.Join(script, "\n")+"\n"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't gather facts from %s: %s", machine.Hostname, err)
+	}
+	facts := make(TaskVars)
+	for _, line := range strings.Split(out.String(), "\n") {
+		key_value := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(key_value) == 2 && key_value[1] != "" {
+			facts[key_value[0]] = key_value[1]
+		}
+	}
+	return facts, nil
+}
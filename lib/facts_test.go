@@ -0,0 +1,61 @@
+package henchman
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFactSubsets(t *testing.T) {
+	if subsets, _ := ParseFactSubsets("network, os"); !reflect.DeepEqual(subsets, []string{"network", "os"}) {
+		t.Errorf("Subsets mismatch. Got %v\n", subsets)
+	}
+	if subsets, _ := ParseFactSubsets("all"); !reflect.DeepEqual(subsets, []string{"disks", "features", "hardware", "network", "os", "services"}) {
+		t.Errorf("Expected 'all' to gather every subset. Got %v\n", subsets)
+	}
+	if subsets, _ := ParseFactSubsets(""); subsets != nil {
+		t.Errorf("Expected no facts by default. Got %v\n", subsets)
+	}
+	if subsets, _ := ParseFactSubsets("os,features"); !reflect.DeepEqual(subsets, []string{"os", "features"}) {
+		t.Errorf("Expected Windows subsets to be known. Got %v\n", subsets)
+	}
+	if _, err := ParseFactSubsets("os,virtualization"); err == nil {
+		t.Errorf("Expected unknown subsets to be refused\n")
+	}
+}
+
+func TestGatherFacts(t *testing.T) {
+	machine := Machine{Hostname: "127.0.0.1"}
+	facts, err := machine.GatherFacts([]string{"os"})
+	if err != nil {
+		t.Fatalf("Couldn't gather facts: %s\n", err)
+	}
+	if facts["system"] == nil || facts["kernel"] == nil {
+		t.Errorf("OS facts missing. Got %v\n", facts)
+	}
+	if facts["hostname"] != nil {
+		t.Errorf("Expected only the os subset to be gathered. Got %v\n", facts)
+	}
+}
+
+func TestGatherWindowsFacts(t *testing.T) {
+	fake := &fakeWinRMServer{output: "system=Windows\r\nos_family=windows\r\ndisks=C,D\r\ndisk_C_free_kb=1024\r\nfeatures=\r\n"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	machine, err := winrmMachine(server.URL, TaskVars{"ansible_connection": "winrm", "ansible_password": "secret"})
+	if err != nil {
+		t.Fatalf("Couldn't apply the host settings: %s\n", err)
+	}
+
+	facts, err := machine.GatherFacts([]string{"os", "disks"})
+	if err != nil {
+		t.Fatalf("Couldn't gather facts: %s\n", err)
+	}
+	if facts["os_family"] != "windows" || facts["disk_C_free_kb"] != "1024" || facts["features"] != nil {
+		t.Errorf("Windows facts mismatch. Got %v\n", facts)
+	}
+	if !strings.Contains(fake.script, "Win32_OperatingSystem") || !strings.Contains(fake.script, "Win32_LogicalDisk") || strings.Contains(fake.script, "Get-Service") {
+		t.Errorf("Expected the os and disks gatherers only. Got %s\n", fake.script)
+	}
+}
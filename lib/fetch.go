@@ -0,0 +1,92 @@
+package henchman
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory fetched files are saved under when the task doesn't say
+const DefaultFetchDir = "fetched"
+
+var errLocalFetch = errors.New("Fetching isn't supported for local actions")
+
+// FetchFile transfers a file from the machine back over SFTP. It is saved
+// as <dest>/<hostname>/<src>, so the same file fetched from every host
+// doesn't clash. The file is read as the connecting user, sudo doesn't
+// apply.
+type FetchFile struct {
+	Src  string
+	Dest string
+}
+
+func (spec *FetchFile) validate() error {
+	if spec.Src == "" {
+		return errors.New("fetch needs a src")
+	}
+	return nil
+}
+
+// Returns the local path the machine's file is saved to
+func (spec *FetchFile) localPath(machine *Machine) (string, error) {
+	dest := spec.Dest
+	if dest == "" {
+		dest = DefaultFetchDir
+	}
+	hostDir := filepath.Join(dest, machine.Hostname)
+	local := filepath.Join(hostDir, filepath.FromSlash(spec.Src))
+	if rel, err := filepath.Rel(hostDir, local); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("Can't fetch %s, it is outside %s", spec.Src, hostDir)
+	}
+	return local, nil
+}
+
+// Downloads the path over the sftp subsystem into w
+func (machine *Machine) download(path string, w io.Writer) (int64, error) {
+	var read int64
+	err := machine.withSFTP(func(client *sftpClient) error {
+		var err error
+		read, err = client.download(path, w)
+		return err
+	})
+	return read, err
+}
+
+// Fetches the file from the machine as the task describes, returning what
+// was done. The local file is only replaced once the whole file arrived.
+func (machine *Machine) fetchFile(spec *FetchFile) (string, error) {
+	if machine.isLocal() {
+		return "", errLocalFetch
+	}
+	local, err := spec.localPath(machine)
+	if err != nil {
+		return "", err
+	}
+	if machine.Noop != nil {
+		machine.Noop.record(machine, "fetch "+spec.Src, 0)
+		return "", nil
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(local), ".fetch")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	size, err := machine.download(spec.Src, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("Couldn't fetch %s: %s", spec.Src, err)
+	}
+	if err := os.Rename(f.Name(), local); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Fetched %d bytes to %s", size, local), nil
+}
@@ -0,0 +1,39 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.sftp = &fakeSFTPServer{files: map[string][]byte{"/etc/ssl/app.crt": []byte("CERT\n")}}
+	machine := server.machine()
+	defer machine.Close()
+
+	spec := &FetchFile{Src: "/etc/ssl/app.crt", Dest: dir}
+	message, err := machine.fetchFile(spec)
+	if err != nil {
+		t.Fatalf("Couldn't fetch the file: %s\n", err)
+	}
+	local := filepath.Join(dir, machine.Hostname, "etc", "ssl", "app.crt")
+	if contents, _ := ioutil.ReadFile(local); string(contents) != "CERT\n" {
+		t.Errorf("Fetched file mismatch. Got %q\n", contents)
+	}
+	if message != "Fetched 5 bytes to "+local {
+		t.Errorf("Fetch message mismatch. Got %s\n", message)
+	}
+
+	if _, err := machine.fetchFile(&FetchFile{Src: "/etc/missing", Dest: dir}); err == nil {
+		t.Errorf("Expected a missing remote file to fail\n")
+	}
+	if _, err := machine.fetchFile(&FetchFile{Src: "../../etc/passwd", Dest: dir}); err == nil {
+		t.Errorf("Expected a src escaping the host directory to be refused\n")
+	}
+}
@@ -0,0 +1,59 @@
+package henchman
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GroupBy puts every host in a group named after the value of one of its
+// facts, e.g. hosts with the os_family fact 'debian' end up in
+// os_family_debian, or in os_debian with the 'os' prefix.
+type GroupBy struct {
+	Fact string
+	// Defaults to the fact's name
+	Prefix string
+}
+
+// Returns the group the facts put the host in, if the fact is known
+func (groupBy *GroupBy) group(facts TaskVars) (string, bool) {
+	value, present := facts[groupBy.Fact]
+	if !present {
+		return "", false
+	}
+	prefix := groupBy.Prefix
+	if prefix == "" {
+		prefix = groupBy.Fact
+	}
+	return prefix + "_" + strings.Replace(fmt.Sprint(value), " ", "_", -1), true
+}
+
+// Adds the machine to the groups its facts put it in
+func (machine *Machine) GroupByFacts(groupBys []GroupBy, facts TaskVars) {
+	for _, groupBy := range groupBys {
+		if group, ok := groupBy.group(facts); ok {
+			machine.Groups = append(machine.Groups, group)
+		}
+	}
+}
+
+// Whether the machine is in the group, either one made from its facts or
+// one of the inventory's
+func (machine *Machine) InGroup(group string) bool {
+	for _, joined := range machine.Groups {
+		if joined == group {
+			return true
+		}
+	}
+	if DefaultInventory == nil {
+		return false
+	}
+	if _, isGroup := DefaultInventory.Groups[group]; !isGroup {
+		return false
+	}
+	for _, host := range DefaultInventory.Resolve([]string{group}) {
+		if host == machine.Hostname || host == machine.address() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package henchman
+
+import "testing"
+
+func TestGroupByFacts(t *testing.T) {
+	machine := Machine{Hostname: "192.168.1.2", Port: 22}
+	facts := TaskVars{"os_family": "debian", "kernel": "3.13.0-24-generic"}
+	machine.GroupByFacts([]GroupBy{{Fact: "os_family", Prefix: "os"}, {Fact: "kernel"}, {Fact: "virtualization"}}, facts)
+	if len(machine.Groups) != 2 || machine.Groups[0] != "os_debian" || machine.Groups[1] != "kernel_3.13.0-24-generic" {
+		t.Errorf("Groups mismatch. Got %v\n", machine.Groups)
+	}
+	if !machine.InGroup("os_debian") || machine.InGroup("os_redhat") {
+		t.Errorf("Group membership mismatch for %v\n", machine.Groups)
+	}
+
+	DefaultInventory = &Inventory{Groups: map[string][]string{"webservers": {"192.168.1.2"}}}
+	defer func() { DefaultInventory = nil }()
+	if !machine.InGroup("webservers") {
+		t.Errorf("Expected inventory groups to count too\n")
+	}
+}
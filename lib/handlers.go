@@ -0,0 +1,70 @@
+package henchman
+
+import (
+	"fmt"
+	"log"
+)
+
+// Checks that the handlers have names of their own and that the tasks only
+// notify handlers the plan has
+func (plan *Plan) setupHandlers() error {
+	names := make(map[string]bool)
+	for i := range plan.Handlers {
+		handler := &plan.Handlers[i]
+		if handler.Name == "" {
+			return fmt.Errorf("Handlers need a name for tasks to notify them by")
+		}
+		if names[handler.Name] {
+			return fmt.Errorf("More than one handler is named '%s'", handler.Name)
+		}
+		names[handler.Name] = true
+		if len(handler.Notify) > 0 {
+			return fmt.Errorf("Handler '%s' can't notify other handlers", handler.Name)
+		}
+		if err := plan.setupTask(handler); err != nil {
+			return err
+		}
+		handler.isHandler = true
+	}
+	for _, task := range plan.allTasks() {
+		for _, name := range task.Notify {
+			if !names[name] {
+				return fmt.Errorf("Task '%s': there's no handler named '%s' to notify", task.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Adds the handlers the task notifies to notified if it changed the machine
+func (task *Task) NotifyHandlers(status *TaskStatus, notified map[string]bool) {
+	if status == nil || !status.Changed {
+		return
+	}
+	for _, name := range task.Notify {
+		notified[name] = true
+	}
+}
+
+// Runs the notified handlers on the machine with run, once each and in the
+// order the plan lists them, however many tasks notified them. The handlers
+// stop at the first one failing. Each handler's status is passed to record.
+func (plan *Plan) RunHandlers(machine *Machine, localhost *Machine, notified map[string]bool, run func(*Task, *Machine, *TaskVars) (*TaskStatus, error), record func(task *Task, status *TaskStatus)) error {
+	for _, handler := range plan.Handlers {
+		if !notified[handler.Name] {
+			continue
+		}
+		// The range copy keeps the shared handler unprepared
+		target := machine
+		if handler.LocalAction {
+			target = localhost
+		}
+		log.Printf("Running handler '%s' on %s\n", handler.Name, machine.Hostname)
+		status, err := run(&handler, target, plan.Vars)
+		record(&handler, status)
+		if status.Failed() {
+			return fmt.Errorf("Handler '%s' failed on %s: %s", handler.Name, machine.Hostname, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package henchman
+
+import (
+	"testing"
+)
+
+func TestHandlers(t *testing.T) {
+	plan_string := `---
+name: "Handlers"
+hosts:
+  - web1
+tasks:
+  - name: Configure nginx
+    action: echo
+    notify:
+      - restart nginx
+      - reload firewall
+  - name: Configure app
+    action: echo
+    notify:
+      - restart app
+handlers:
+  - name: restart app
+    action: echo
+  - name: restart nginx
+    action: echo
+  - name: reload firewall
+    action: echo
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		t.Fatalf("Couldn't read the plan: %s\n", err)
+	}
+	notified := make(map[string]bool)
+	plan.Tasks[0].NotifyHandlers(&TaskStatus{Status: "ok", Changed: true}, notified)
+	plan.Tasks[0].NotifyHandlers(&TaskStatus{Status: "ok", Changed: true}, notified)
+	plan.Tasks[1].NotifyHandlers(&TaskStatus{Status: "ok"}, notified)
+	if len(notified) != 2 || notified["restart app"] {
+		t.Errorf("Expected only the changed task's handlers notified. Got %v\n", notified)
+	}
+
+	machine := &Machine{Hostname: "web1"}
+	var ran []string
+	run := func(task *Task, machine *Machine, vars *TaskVars) (*TaskStatus, error) {
+		ran = append(ran, task.Name)
+		return &TaskStatus{Status: "ok"}, nil
+	}
+	record := func(task *Task, status *TaskStatus) {
+		plan.SaveStatus(machine, task, status)
+	}
+	if err := plan.RunHandlers(machine, machine, notified, run, record); err != nil {
+		t.Errorf("Expected the handlers to succeed. Got %s\n", err)
+	}
+	if len(ran) != 2 || ran[0] != "restart nginx" || ran[1] != "reload firewall" {
+		t.Errorf("Expected the notified handlers once each in the plan's order. Got %v\n", ran)
+	}
+	if report := plan.Report(); report.Handlers != 2 || report.Attempted != 0 {
+		t.Errorf("Expected the handlers counted apart from the tasks. Got %+v\n", report)
+	}
+
+	for _, broken := range []string{`
+tasks:
+  - name: Configure
+    action: echo
+    notify:
+      - restart missing
+`, `
+handlers:
+  - name: restart
+    action: echo
+  - name: restart
+    action: echo
+`, `
+handlers:
+  - action: echo
+`} {
+		if _, err := NewPlanFromYAML([]byte("name: Broken"+broken), nil); err == nil {
+			t.Errorf("Expected the handlers to be refused: %s\n", broken)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package henchman
+
+import "fmt"
+
+// HostHealth tracks how a host is faring over a run to tell when it should
+// be quarantined: once it fails MaxFailures tasks in a row, ignored failures
+// included, or runs into MaxConnectionErrors connection errors in total.
+// Zero limits never quarantine.
+type HostHealth struct {
+	MaxFailures         int
+	MaxConnectionErrors int
+
+	failures         int
+	connectionErrors int
+}
+
+var connectionErrorCategories = map[string]bool{
+	"unreachable":       true,
+	"connect timeout":   true,
+	"handshake timeout": true,
+	"session timeout":   true,
+}
+
+// Records the task's status, returning why the host should be quarantined
+// or an empty string if it's fine.
+func (health *HostHealth) Record(status *TaskStatus) string {
+	if status.Status == StatusSkipped {
+		return ""
+	}
+	if status.Succeeded() {
+		health.failures = 0
+	} else {
+		health.failures++
+	}
+	if connectionErrorCategories[status.ErrorCategory] {
+		health.connectionErrors++
+	}
+	if health.MaxConnectionErrors > 0 && health.connectionErrors >= health.MaxConnectionErrors {
+		return fmt.Sprintf("%d connection errors", health.connectionErrors)
+	}
+	if health.MaxFailures > 0 && health.failures >= health.MaxFailures {
+		return fmt.Sprintf("%d consecutive failed tasks", health.failures)
+	}
+	return ""
+}
+
+// Marks the machine as quarantined for the rest of the run
+func (plan *Plan) Quarantine(machine *Machine, reason string) {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	if plan.quarantined == nil {
+		plan.quarantined = make(map[string]string)
+	}
+	plan.quarantined[machine.Hostname] = reason
+}
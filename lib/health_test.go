@@ -0,0 +1,38 @@
+package henchman
+
+import "testing"
+
+func TestHostHealth(t *testing.T) {
+	health := HostHealth{MaxFailures: 3, MaxConnectionErrors: 2}
+	statuses := []*TaskStatus{
+		{Status: "ignored", ErrorCategory: "error"},
+		{Status: "ignored", ErrorCategory: "error"},
+		{Status: "ok"},
+		{Status: "ignored", ErrorCategory: "error"},
+		{Status: "ignored", ErrorCategory: "error"},
+	}
+	for _, status := range statuses {
+		if reason := health.Record(status); reason != "" {
+			t.Fatalf("Expected successes to reset the failures. Got quarantined after %s\n", reason)
+		}
+	}
+	if reason := health.Record(&TaskStatus{Status: "ignored", ErrorCategory: "error"}); reason != "3 consecutive failed tasks" {
+		t.Errorf("Expected the host to be quarantined. Got '%s'\n", reason)
+	}
+
+	health = HostHealth{MaxFailures: 0, MaxConnectionErrors: 2}
+	health.Record(&TaskStatus{Status: "ignored", ErrorCategory: "unreachable"})
+	health.Record(&TaskStatus{Status: "ok"})
+	if reason := health.Record(&TaskStatus{Status: "ignored", ErrorCategory: "connect timeout"}); reason != "2 connection errors" {
+		t.Errorf("Expected the host to be quarantined. Got '%s'\n", reason)
+	}
+}
+
+func TestReportQuarantined(t *testing.T) {
+	plan := Plan{Name: "Quarantine", Hosts: []string{"192.168.1.2", "192.168.1.3"}}
+	plan.Quarantine(&Machine{Hostname: "192.168.1.3"}, "2 connection errors")
+	report := plan.Report()
+	if report.Quarantined["192.168.1.3"] != "2 connection errors" || len(report.Quarantined) != 1 {
+		t.Errorf("Quarantined hosts mismatch. Got %v\n", report.Quarantined)
+	}
+}
@@ -0,0 +1,63 @@
+package henchman
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v1"
+)
+
+// Directory, relative to the plan, the hierarchy's data files are in
+const HierarchyDataDir = "data"
+
+// Resolves the vars of the machine from the plan's hierarchy, a list of data
+// files from the most specific to the most general, like
+//
+//	hierarchy:
+//	  - "hosts/{{ henchman_host }}.yaml"
+//	  - "datacenters/{{ vars.facts.datacenter }}.yaml"
+//	  - "environments/{{ vars.environment }}.yaml"
+//	  - common.yaml
+//
+// Paths are templates rendered with the machine's vars and facts, relative
+// to the data directory. A var is taken from the most specific file that
+// has it. Files that don't exist, like those of a fact the machine doesn't
+// have, are skipped.
+func (plan *Plan) HierarchyVars(machine *Machine) (TaskVars, error) {
+	layers, err := plan.HierarchyLayers(machine)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(TaskVars)
+	for _, layer := range layers {
+		mergeMap(&layer.Vars, &vars)
+	}
+	return vars, nil
+}
+
+// Returns the data files of the hierarchy the machine has, from the most
+// general to the most specific, see HierarchyVars
+func (plan *Plan) HierarchyLayers(machine *Machine) ([]VarLayer, error) {
+	var layers []VarLayer
+	for i := len(plan.Hierarchy) - 1; i >= 0; i-- {
+		level, err := prepareTemplate(plan.Hierarchy[i], machineVars(plan.Vars, machine), machine)
+		if err != nil {
+			return nil, fmt.Errorf("Hierarchy level '%s': %s", plan.Hierarchy[i], err)
+		}
+		path := filepath.Join(plan.Dir, HierarchyDataDir, filepath.FromSlash(level))
+		buf, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		data := make(TaskVars)
+		if err := yaml.Unmarshal(buf, &data); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		layers = append(layers, VarLayer{Source: "hierarchy " + filepath.ToSlash(filepath.Join(HierarchyDataDir, level)), Vars: data})
+	}
+	return layers, nil
+}
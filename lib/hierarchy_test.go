@@ -0,0 +1,48 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestHierarchyVars(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "data", "hosts"), 0755)
+	os.MkdirAll(filepath.Join(dir, "data", "datacenters"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "data", "hosts", "db01.yaml"), []byte("max_connections: 500\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "data", "datacenters", "fra1.yaml"), []byte("ntp_server: ntp.fra1\nmax_connections: 200\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "data", "common.yaml"), []byte("ntp_server: pool.ntp.org\nmax_connections: 100\nlog_level: info\n"), 0644)
+
+	vars := TaskVars{"region": "eu"}
+	plan := &Plan{
+		Dir:  dir,
+		Vars: &vars,
+		Hierarchy: []string{
+			"hosts/{{ henchman_host }}.yaml",
+			"datacenters/{{ vars.facts.datacenter }}.yaml",
+			"common.yaml",
+		},
+	}
+	machines := Machines([]string{"db01", "web01"}, &ssh.ClientConfig{User: "deploy"})
+	machines[0].Vars = TaskVars{"facts": TaskVars{"datacenter": "fra1"}}
+
+	db, err := plan.HierarchyVars(machines[0])
+	if err != nil {
+		t.Fatalf("Couldn't resolve the hierarchy: %s\n", err)
+	}
+	if db["max_connections"] != 500 || db["ntp_server"] != "ntp.fra1" || db["log_level"] != "info" {
+		t.Errorf("Expected the most specific level to win. Got %v\n", db)
+	}
+	web, err := plan.HierarchyVars(machines[1])
+	if err != nil {
+		t.Fatalf("Couldn't resolve the hierarchy: %s\n", err)
+	}
+	if web["max_connections"] != 100 || web["ntp_server"] != "pool.ntp.org" {
+		t.Errorf("Expected missing levels to be skipped. Got %v\n", web)
+	}
+}
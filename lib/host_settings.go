@@ -0,0 +1,184 @@
+package henchman
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// Host vars that set how the host is connected to. These are Ansible's so
+// that existing inventories work as they are, and so that plain vars like
+// 'port' don't change the connection by accident.
+var hostSettings = map[string][]string{
+	"user":    {"ansible_user", "ansible_ssh_user"},
+	"port":    {"ansible_port", "ansible_ssh_port"},
+	"keyfile": {"ansible_ssh_private_key_file"},
+
+	"connection":        {"ansible_connection"},
+	"password":          {"ansible_password"},
+	"winrm_scheme":      {"ansible_winrm_scheme"},
+	"winrm_cert_ignore": {"ansible_winrm_server_cert_validation"},
+	"docker_extra_args": {"ansible_docker_extra_args"},
+	"host_key":          {"host_key"},
+	"protected":         {"protected"},
+	"interpreter":       {"interpreter", "ansible_python_interpreter"},
+}
+
+// Ports WinRM listens on when the host vars don't give one
+const (
+	winrmHTTPPort  = 5985
+	winrmHTTPSPort = 5986
+)
+
+// Splits a host entry like "db01 port=2222 user=deploy" into the host and
+// its settings, which end up in its host vars. user, port, keyfile and
+// connection are short for their Ansible names.
+func ParseHostEntry(entry string) (string, TaskVars) {
+	fields := strings.Fields(entry)
+	if len(fields) == 0 {
+		return entry, nil
+	}
+	var settings TaskVars
+	for _, field := range fields[1:] {
+		key_value := strings.SplitN(field, "=", 2)
+		if len(key_value) != 2 {
+			continue
+		}
+		if settings == nil {
+			settings = make(TaskVars)
+		}
+		name := key_value[0]
+		if names, short := hostSettings[name]; short {
+			name = names[0]
+		}
+		settings[name] = key_value[1]
+	}
+	return fields[0], settings
+}
+
+// Moves the settings of host entries in the groups to the host vars
+func (inventory *Inventory) splitHostEntries() {
+	for group, members := range inventory.Groups {
+		for i, member := range members {
+			host, settings := ParseHostEntry(member)
+			members[i] = host
+			if len(settings) > 0 {
+				inventory.AddHostVars(map[string]TaskVars{host: settings})
+			}
+		}
+		inventory.Groups[group] = members
+	}
+}
+
+func hostSetting(vars TaskVars, setting string) string {
+	for _, name := range hostSettings[setting] {
+		if value, present := vars[name]; present {
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+// Expands a leading ~ to the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// Applies the user, port, keyfile and connection host vars of the machines, so that
+// hosts connected to differently can be in the same plan. The interpreter
+// host var is used for machines the plan doesn't give one. Keys are only
+// loaded once however many hosts use them.
+func ApplyHostSettings(machines []*Machine) error {
+	auths := make(map[string]ssh.AuthMethod)
+	for _, machine := range machines {
+		override := HostOverride{
+			User:    hostSetting(machine.Vars, "user"),
+			Keyfile: expandHome(hostSetting(machine.Vars, "keyfile")),
+		}
+		if port := hostSetting(machine.Vars, "port"); port != "" {
+			var err error
+			if override.Port, err = strconv.Atoi(port); err != nil {
+				return fmt.Errorf("Bad port '%s' for %s", port, machine.Hostname)
+			}
+		}
+		var auth ssh.AuthMethod
+		if override.Keyfile != "" {
+			if auth = auths[override.Keyfile]; auth == nil {
+				var err error
+				if auth, err = ClientKeyAuth(override.Keyfile); err != nil {
+					return fmt.Errorf("Keyfile of %s: %s", machine.Hostname, err)
+				}
+				auths[override.Keyfile] = auth
+			}
+		}
+		override.apply(machine, auth)
+		if machine.Interpreter == "" {
+			machine.Interpreter = hostSetting(machine.Vars, "interpreter")
+		}
+		if err := applyConnection(machine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets up the transport of hosts with connection=winrm, docker or local.
+// WinRM uses HTTPS when the scheme says so or on port 5986, like Ansible
+// does. Hosts still on the SSH port get WinRM's instead.
+func applyConnection(machine *Machine) error {
+	switch connection := hostSetting(machine.Vars, "connection"); connection {
+	case "", "ssh", "smart":
+		return nil
+	case WinRMConnection:
+		winrm := &WinRM{
+			Password: hostSetting(machine.Vars, "password"),
+			Insecure: hostSetting(machine.Vars, "winrm_cert_ignore") == "ignore",
+		}
+		switch scheme := hostSetting(machine.Vars, "winrm_scheme"); scheme {
+		case "":
+			winrm.HTTPS = machine.Port == winrmHTTPSPort
+		case "http", "https":
+			winrm.HTTPS = scheme == "https"
+		default:
+			return fmt.Errorf("Bad WinRM scheme '%s' for %s", scheme, machine.Hostname)
+		}
+		if machine.Port == 22 {
+			machine.Port = winrmHTTPPort
+			if winrm.HTTPS {
+				machine.Port = winrmHTTPSPort
+			}
+		}
+		machine.Transport = winrm
+		return nil
+	case LocalConnection:
+		machine.Transport = &Local{}
+		return nil
+	case NetworkConnection:
+		machine.Transport = &NetworkCLI{}
+		return nil
+	case DockerConnection:
+		machine.Transport = &Docker{
+			User:      hostSetting(machine.Vars, "user"),
+			ExtraArgs: dockerExtraArgs(machine.Vars),
+		}
+		return nil
+	default:
+		if transport, present := Transports[connection]; present {
+			var err error
+			machine.Transport, err = transport(machine)
+			return err
+		}
+		return fmt.Errorf("Unknown connection '%s' for %s", connection, machine.Hostname)
+	}
+}
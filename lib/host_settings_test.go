@@ -0,0 +1,50 @@
+package henchman
+
+import (
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestParseHostEntry(t *testing.T) {
+	host, settings := ParseHostEntry("db01 port=2222 user=deploy ansible_ssh_private_key_file=~/.ssh/db")
+	if host != "db01" {
+		t.Errorf("Host mismatch. Got %s\n", host)
+	}
+	if settings["ansible_port"] != "2222" || settings["ansible_user"] != "deploy" || settings["ansible_ssh_private_key_file"] != "~/.ssh/db" {
+		t.Errorf("Settings mismatch. Got %v\n", settings)
+	}
+	if host, settings := ParseHostEntry("web01:2200"); host != "web01:2200" || settings != nil {
+		t.Errorf("Expected a plain host. Got %s %v\n", host, settings)
+	}
+}
+
+func TestApplyHostSettings(t *testing.T) {
+	inventory, err := ParseInventoryINI([]byte("[db]\ndb01 ansible_port=2222 ansible_user=postgres\ndb02\n"))
+	if err != nil {
+		t.Fatalf("Couldn't parse the inventory: %s\n", err)
+	}
+	if hosts := inventory.Resolve([]string{"db"}); len(hosts) != 2 || hosts[0] != "db01" {
+		t.Errorf("Settings shouldn't be part of the host. Got %v\n", hosts)
+	}
+	DefaultInventory = inventory
+	defer func() { DefaultInventory = nil }()
+
+	config := &ssh.ClientConfig{User: "deploy"}
+	machines := Machines([]string{"db"}, config)
+	machines[1].Vars = TaskVars{"port": 8080}
+	if err := ApplyHostSettings(machines); err != nil {
+		t.Fatalf("Couldn't apply the host settings: %s\n", err)
+	}
+	if machines[0].Port != 2222 || machines[0].SSHConfig.User != "postgres" {
+		t.Errorf("Settings weren't applied to db01. Got %s@%s:%d\n", machines[0].SSHConfig.User, machines[0].Hostname, machines[0].Port)
+	}
+	if machines[1].Port != 22 || machines[1].SSHConfig != config {
+		t.Errorf("Plain vars shouldn't change the connection. Got %s@%s:%d\n", machines[1].SSHConfig.User, machines[1].Hostname, machines[1].Port)
+	}
+
+	machines[1].Vars = TaskVars{"ansible_ssh_private_key_file": "/non/existent/key"}
+	if err := ApplyHostSettings(machines[1:]); err == nil {
+		t.Errorf("A missing keyfile should be an error\n")
+	}
+}
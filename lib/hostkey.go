@@ -0,0 +1,81 @@
+package henchman
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// HostKeyMismatchError is returned when a host presents a key other than
+// the one pinned for it.
+type HostKeyMismatchError struct {
+	Host     string
+	Expected string
+	Got      string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("SECURITY: host key for %s doesn't match the pinned key. Expected %s, got %s",
+		e.Host, e.Expected, e.Got)
+}
+
+// Returns the OpenSSH style SHA256 fingerprint of the key
+func Fingerprint(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Returns the fingerprint pinned for the hostname. An exact match wins over
+// patterns, which are tried in sorted order.
+func pinnedKey(pins map[string]string, hostname string) (string, bool) {
+	if fingerprint, present := pins[hostname]; present {
+		return fingerprint, true
+	}
+	var patterns []string
+	for pattern := range pins {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, hostname); matched {
+			return pins[pattern], true
+		}
+	}
+	return "", false
+}
+
+// Makes the machines that have a pinned fingerprint accept only that host key.
+// The host_key host var, from the inventory or host_vars, pins the key of a
+// host or of a group's hosts. Pins are otherwise looked up by hostname or
+// glob pattern. Other machines are untouched.
+func PinHostKeys(machines []*Machine, pins map[string]string) {
+	for _, machine := range machines {
+		expected, present := hostSetting(machine.Vars, "host_key"), true
+		if expected == "" {
+			expected, present = pinnedKey(pins, machine.Hostname)
+		}
+		if !present || machine.SSHConfig == nil {
+			continue
+		}
+		machine.pinHostKey(expected)
+	}
+}
+
+// Makes the machine accept only the host key with the fingerprint
+func (machine *Machine) pinHostKey(expected string) {
+	config := *machine.SSHConfig
+	host := machine.Hostname
+	config.HostKeyCallback = func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		if got := Fingerprint(key); got != expected {
+			return &HostKeyMismatchError{host, expected, got}
+		}
+		return nil
+	}
+	machine.SSHConfig = &config
+	machine.HostKey = expected
+}
@@ -0,0 +1,56 @@
+package henchman
+
+import (
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestPinHostKeys(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey))
+	if err != nil {
+		panic(err)
+	}
+	fingerprint := Fingerprint(key)
+	machines := Machines([]string{"db01", "web01", "cache01"}, &ssh.ClientConfig{User: "deploy"})
+	PinHostKeys(machines, map[string]string{
+		"db*":   fingerprint,
+		"web01": "SHA256:somethingelse",
+	})
+
+	if err := machines[0].SSHConfig.HostKeyCallback("db01:22", nil, key); err != nil {
+		t.Errorf("The pinned key should have been accepted. Got %s\n", err)
+	}
+	err = machines[1].SSHConfig.HostKeyCallback("web01:22", nil, key)
+	if _, mismatch := err.(*HostKeyMismatchError); !mismatch {
+		t.Errorf("A key other than the pinned one should be rejected. Got %v\n", err)
+	}
+	if machines[2].SSHConfig.HostKeyCallback != nil {
+		t.Errorf("Machines without pins shouldn't be touched\n")
+	}
+}
+
+func TestPinHostKeysFromHostVars(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey))
+	if err != nil {
+		panic(err)
+	}
+	fingerprint := Fingerprint(key)
+	DefaultInventory = &Inventory{
+		Groups:    map[string][]string{"databases": {"db01"}, "webservers": {"web01"}},
+		GroupVars: map[string]TaskVars{"databases": {"host_key": fingerprint}},
+		HostVars:  map[string]TaskVars{"web01": {"host_key": "SHA256:somethingelse"}},
+	}
+	defer func() { DefaultInventory = nil }()
+	machines := Machines([]string{"db01", "web01"}, &ssh.ClientConfig{User: "deploy"})
+	// The host vars win over the plan's pins
+	PinHostKeys(machines, map[string]string{"*": "SHA256:fromtheplan"})
+
+	if err := machines[0].SSHConfig.HostKeyCallback("db01:22", nil, key); err != nil {
+		t.Errorf("The key pinned in the group vars should have been accepted. Got %s\n", err)
+	}
+	err = machines[1].SSHConfig.HostKeyCallback("web01:22", nil, key)
+	if mismatch, ok := err.(*HostKeyMismatchError); !ok || mismatch.Expected != "SHA256:somethingelse" {
+		t.Errorf("Expected the key pinned in the host vars to be checked. Got %v\n", err)
+	}
+}
@@ -0,0 +1,107 @@
+package henchman
+
+import (
+	"html/template"
+	"io"
+	"time"
+)
+
+// HostResults are the results of a single host, in the order they were saved
+type HostResults struct {
+	Host    string
+	Failed  bool
+	Results []Result
+}
+
+// Groups the results by host, keeping the order hosts first reported in
+func (report *Report) ByHost() []HostResults {
+	var hosts []HostResults
+	index := make(map[string]int)
+	for _, result := range report.Results {
+		i, present := index[result.Host]
+		if !present {
+			i = len(hosts)
+			index[result.Host] = i
+			hosts = append(hosts, HostResults{Host: result.Host})
+		}
+		hosts[i].Results = append(hosts[i].Results, result)
+		if failedStatus(result.Status) {
+			hosts[i].Failed = true
+		}
+	}
+	return hosts
+}
+
+var htmlReport = template.Must(template.New("html").Funcs(template.FuncMap{
+	"width": func(d time.Duration, slowest time.Duration) int {
+		if slowest <= 0 {
+			return 0
+		}
+		return int(100 * d / slowest)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Plan Report: {{ .Report.Plan }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+summary { cursor: pointer; font-weight: bold; padding: 0.3em 0; }
+table { border-collapse: collapse; width: 100%; }
+td, th { border-bottom: 1px solid #ddd; padding: 0.3em; text-align: left; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; }
+.bar { background: #4a90d9; height: 0.8em; }
+.ok { color: #2e7d32; }
+.changed { color: #ef6c00; }
+.ignored { color: #b58900; }
+.skipped { color: #757575; }
+.failed, .unreachable { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>Plan Report: {{ .Report.Plan }}</h1>
+{{ if .Report.Manifest }}<p>Manifest: {{ .Report.Manifest }}</p>
+{{ end }}<table>
+{{ range $status, $count := .Report.Counts }}<tr><th class="{{ $status }}">{{ $status }}</th><td>{{ $count }}</td></tr>
+{{ end }}<tr><th>total</th><td>{{ .Report.Total }}</td></tr>
+<tr><th>attempted</th><td>{{ .Report.Attempted }}</td></tr>
+</table>
+{{ if .Report.OverBudget }}<h2>Over budget</h2>
+<table>
+<tr><th>Host</th><th>Task</th><th>Duration</th><th>Expected</th></tr>
+{{ range .Report.OverBudget }}<tr><td>{{ .Host }}</td><td>{{ .Task }}</td><td class="ignored">{{ .Duration }}</td><td>{{ .Budget }}</td></tr>
+{{ end }}</table>
+{{ end }}{{ $slowest := .Slowest }}{{ range .Hosts }}
+<details{{ if .Failed }} open{{ end }}>
+<summary class="{{ if .Failed }}failed{{ else }}ok{{ end }}">{{ .Host }}</summary>
+<table>
+<tr><th>Task</th><th>Status</th><th>Duration</th><th>Output</th></tr>
+{{ range .Results }}<tr>
+<td>{{ .Task }}</td>
+<td class="{{ .Status }}">{{ .Status }}</td>
+<td{{ if .OverBudget }} class="ignored" title="expected {{ .Budget }}"{{ end }}>{{ .Duration }}<div class="bar" style="width: {{ width .Duration $slowest }}%"></div></td>
+<td><details><summary>output</summary><pre>{{ .Message }}</pre></details></td>
+</tr>
+{{ end }}</table>
+</details>
+{{ end }}
+</body>
+</html>
+`))
+
+// Writes a self-contained HTML report with the results of every host
+// and bars charting the task durations.
+func (plan *Plan) WriteHTMLReport(w io.Writer) error {
+	report := plan.Report()
+	var slowest time.Duration
+	for _, result := range report.Results {
+		if result.Duration > slowest {
+			slowest = result.Duration
+		}
+	}
+	return htmlReport.Execute(w, map[string]interface{}{
+		"Report":  report,
+		"Hosts":   report.ByHost(),
+		"Slowest": slowest,
+	})
+}
@@ -0,0 +1,113 @@
+package henchman
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Interpreters probed, in order of preference, on machines that don't have
+// an explicit interpreter. Minimal images often ship just a busybox sh.
+var interpreterCandidates = []string{"python3", "python2", "python", "sh"}
+
+// Discovered interpreters keyed by the machine address, so that the probe
+// runs only once per host for the whole run. Each host has a lock of its own
+// so that a slow host doesn't hold up probing the others.
+var interpreterCache = struct {
+	sync.Mutex
+	probes map[string]*interpreterProbe
+}{probes: make(map[string]*interpreterProbe)}
+
+type interpreterProbe struct {
+	sync.Mutex
+	found string
+}
+
+func probeInterpreter(machine *Machine) (string, error) {
+	if machine.isLocal() {
+		for _, candidate := range interpreterCandidates {
+			if found, err := exec.LookPath(candidate); err == nil {
+				return found, nil
+			}
+		}
+		return "", fmt.Errorf("No interpreter found locally")
+	}
+
+	var probes []string
+	for _, candidate := range interpreterCandidates {
+		probes = append(probes, "command -v "+candidate)
+	}
+	out, err := machine.Exec(strings.Join(probes, " || "))
+	if err != nil {
+		return "", fmt.Errorf("No interpreter found on %s: %s", machine.Hostname, out.String())
+	}
+	found := strings.TrimSpace(strings.Split(out.String(), "\n")[0])
+	if found == "" {
+		return "", fmt.Errorf("No interpreter found on %s", machine.Hostname)
+	}
+	return found, nil
+}
+
+// Returns the interpreter that script tasks are run with on the machine.
+// An explicit interpreter always wins, otherwise the first of the candidates
+// present on the machine is used and cached.
+func (machine *Machine) DiscoverInterpreter() (string, error) {
+	if machine.Interpreter != "" {
+		return machine.Interpreter, nil
+	}
+	interpreterCache.Lock()
+	probe, present := interpreterCache.probes[machine.address()]
+	if !present {
+		probe = &interpreterProbe{}
+		interpreterCache.probes[machine.address()] = probe
+	}
+	interpreterCache.Unlock()
+
+	probe.Lock()
+	defer probe.Unlock()
+	if probe.found == "" {
+		found, err := probeInterpreter(machine)
+		if err != nil {
+			return "", err
+		}
+		probe.found = found
+	}
+	machine.Interpreter = probe.found
+	return probe.found, nil
+}
+
+// Shells want '-s' to read the script from stdin, python and friends want '-'.
+// The last word is looked at so that overrides like "busybox sh" work.
+func scriptCommand(interpreter string) string {
+	words := strings.Fields(interpreter)
+	switch path.Base(words[len(words)-1]) {
+	case "sh", "bash", "ash", "dash":
+		return interpreter + " -s"
+	}
+	return interpreter + " -"
+}
+
+// Runs the script on the machine by piping it to the machine's interpreter.
+func (machine *Machine) ExecScript(script []byte) (*Output, error) {
+	return machine.execScript(script, nil, "", nil)
+}
+
+// Runs the script within the sandbox, if any, after the exports, if any,
+// with sudo if the task asks for it
+func (machine *Machine) execScript(script []byte, sandbox *Sandbox, exports string, become *become) (*Output, error) {
+	interpreter, err := machine.DiscoverInterpreter()
+	if err != nil {
+		return NewOutput(), err
+	}
+	if sandbox != nil && machine.isLocal() {
+		return NewOutput(), errLocalSandbox
+	}
+	command, err := sandbox.wrap(exports + scriptCommand(interpreter))
+	if err != nil {
+		return NewOutput(), err
+	}
+	return machine.run(become.wrap(command, bytes.NewReader(script)))
+}
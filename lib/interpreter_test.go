@@ -0,0 +1,93 @@
+package henchman
+
+import (
+	"testing"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestDiscoverInterpreterOverride(t *testing.T) {
+	machine := Machine{Hostname: "foobar", Port: 22, Interpreter: "/usr/local/bin/python3"}
+	interpreter, err := machine.DiscoverInterpreter()
+	if err != nil {
+		t.Errorf("An explicit interpreter shouldn't need discovery. Got %s\n", err)
+	}
+	if interpreter != "/usr/local/bin/python3" {
+		t.Errorf("Interpreter mismatch. Got %s instead\n", interpreter)
+	}
+}
+
+func TestDiscoverInterpreterLocal(t *testing.T) {
+	machine := Machine{Hostname: "127.0.0.1"}
+	interpreter, err := machine.DiscoverInterpreter()
+	if err != nil {
+		t.Errorf("Couldn't discover the local interpreter: %s\n", err)
+	}
+	if interpreter == "" || machine.Interpreter != interpreter {
+		t.Errorf("Discovered interpreter wasn't saved on the machine. Got '%s'\n", machine.Interpreter)
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	commands := map[string]string{
+		"/usr/bin/python3": "/usr/bin/python3 -",
+		"/bin/sh":          "/bin/sh -s",
+		"/bin/busybox sh":  "/bin/busybox sh -s",
+	}
+	for interpreter, expected := range commands {
+		if command := scriptCommand(interpreter); command != expected {
+			t.Errorf("Script command mismatch for %s. Got %s instead\n", interpreter, command)
+		}
+	}
+}
+
+func TestDiscoverInterpreterConcurrently(t *testing.T) {
+	slow := newTestSSHServer()
+	defer slow.listener.Close()
+	probed := make(chan bool)
+	slow.respond = func(command string) string {
+		<-probed
+		return "/usr/bin/python3\n"
+	}
+	fast := newTestSSHServer()
+	defer fast.listener.Close()
+	fast.respond = func(command string) string { return "/bin/sh\n" }
+
+	slowMachine, fastMachine := slow.machine(), fast.machine()
+	defer slowMachine.Close()
+	defer fastMachine.Close()
+	go slowMachine.DiscoverInterpreter()
+	// The slow host's probe is in flight and mustn't hold up the fast one's
+	time.Sleep(50 * time.Millisecond)
+	discovered := make(chan string, 1)
+	go func() {
+		interpreter, _ := fastMachine.DiscoverInterpreter()
+		discovered <- interpreter
+	}()
+	defer close(probed)
+	select {
+	case interpreter := <-discovered:
+		if interpreter != "/bin/sh" {
+			t.Errorf("Interpreter mismatch. Got %s\n", interpreter)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Probing the fast host waited for the slow one\n")
+	}
+}
+
+func TestInterpreterHostVar(t *testing.T) {
+	machines := Machines([]string{"web01", "web02"}, &ssh.ClientConfig{User: "deploy"})
+	machines[0].Vars = TaskVars{"ansible_python_interpreter": "/usr/bin/python3"}
+	machines[1].Vars = TaskVars{"interpreter": "/usr/bin/python3"}
+	machines[1].Interpreter = "/opt/python/bin/python"
+	if err := ApplyHostSettings(machines); err != nil {
+		t.Fatalf("Couldn't apply the host settings: %s\n", err)
+	}
+	if machines[0].Interpreter != "/usr/bin/python3" {
+		t.Errorf("Expected the interpreter host var to be used. Got %s\n", machines[0].Interpreter)
+	}
+	if machines[1].Interpreter != "/opt/python/bin/python" {
+		t.Errorf("Expected the plan's interpreter to win. Got %s\n", machines[1].Interpreter)
+	}
+}
@@ -0,0 +1,423 @@
+package henchman
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v1"
+)
+
+// Inventory used to resolve group names into hosts in Machines. Hostnames
+// are taken as they are when nil.
+var DefaultInventory *Inventory
+
+// Inventory defines the hosts plans can run against, in named groups.
+// Members of a group that name another group expand to that group's hosts.
+// The implicit 'all' group holds every host.
+type Inventory struct {
+	Groups map[string][]string
+	// Variables layered over the plan's vars, keyed by host and by group
+	HostVars  map[string]TaskVars `yaml:"host_vars"`
+	GroupVars map[string]TaskVars `yaml:"group_vars"`
+}
+
+// Windows has no executable bit, so only the usual script and binary
+// extensions make for executable inventories there.
+func isExecutableInventory(path string, info os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".exe", ".bat", ".cmd":
+			return true
+		}
+		return false
+	}
+	return info.Mode().Perm()&0111 != 0
+}
+
+// Loads an inventory. consul:// addresses are looked up in the Consul
+// catalog and executables are run and their JSON output parsed. Otherwise
+// the file is parsed as YAML if it has a YAML extension and as INI if it
+// doesn't.
+func LoadInventory(path string) (*Inventory, error) {
+	if isConsulInventory(path) {
+		return ConsulInventory(path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if isExecutableInventory(path, info) {
+		return runInventory(path)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return ParseInventoryYAML(buf)
+	}
+	return ParseInventoryINI(buf)
+}
+
+// Parses an inventory of the form
+//
+//	groups:
+//	  webservers:
+//	    - web1
+//	    - web2:2222
+//	  production: [webservers, db1]
+//	  databases:
+//	    - db2 port=2222 user=postgres keyfile=~/.ssh/db
+//
+// Settings after a host are host vars, see ApplyHostSettings.
+func ParseInventoryYAML(buf []byte) (*Inventory, error) {
+	inventory := &Inventory{}
+	if err := yaml.Unmarshal(buf, inventory); err != nil {
+		return nil, err
+	}
+	inventory.splitHostEntries()
+	return inventory, inventory.validate()
+}
+
+// Parses an INI inventory with a host per line under [group] sections,
+// groups under [group:children] sections and key=value group vars under
+// [group:vars] sections. Hosts before the first section are in the
+// 'ungrouped' group. key=value settings after the host on a line, like
+// "db01 ansible_port=2222 ansible_user=deploy", are host vars.
+func ParseInventoryINI(buf []byte) (*Inventory, error) {
+	inventory := &Inventory{Groups: make(map[string][]string)}
+	group := "ungrouped"
+	kind := ""
+	var children []string
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("Line %d: unterminated section '%s'", line, text)
+			}
+			group, kind = strings.Trim(text, "[]"), ""
+			if i := strings.LastIndex(group, ":"); i >= 0 {
+				group, kind = group[:i], group[i+1:]
+			}
+			switch kind {
+			case "", "children":
+				if _, present := inventory.Groups[group]; !present {
+					inventory.Groups[group] = nil
+				}
+			case "vars":
+			default:
+				return nil, fmt.Errorf("Line %d: unknown section type '%s'", line, kind)
+			}
+			continue
+		}
+		switch kind {
+		case "vars":
+			key_value := strings.SplitN(text, "=", 2)
+			if len(key_value) != 2 {
+				return nil, fmt.Errorf("Line %d: expected key=value in [%s:vars], got '%s'", line, group, text)
+			}
+			variable := strings.TrimSpace(key_value[0])
+			inventory.AddGroupVars(map[string]TaskVars{group: {variable: strings.TrimSpace(key_value[1])}})
+		case "children":
+			children = append(children, text)
+			fallthrough
+		default:
+			inventory.Groups[group] = append(inventory.Groups[group], text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// Children don't need a section of their own to be groups
+	for _, child := range children {
+		if _, present := inventory.Groups[child]; !present {
+			inventory.Groups[child] = nil
+		}
+	}
+	inventory.splitHostEntries()
+	return inventory, inventory.validate()
+}
+
+// Runs the inventory script with --list, like Ansible does, so that existing
+// dynamic inventory scripts work as they are.
+func runInventory(path string) (*Inventory, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, "--list")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Inventory script %s failed: %s %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return ParseInventoryJSON(out)
+}
+
+// Parses the output of a dynamic inventory script. Groups are either lists
+// of hosts or objects with 'hosts', 'children' and group 'vars', and per
+// host variables are under '_meta'.
+//
+//	{
+//	  "webservers": ["web1", "web2:2222"],
+//	  "production": {"hosts": ["db1"], "children": ["webservers"], "vars": {"env": "prod"}},
+//	  "_meta": {"hostvars": {"web1": {"http_port": 8080}}}
+//	}
+func ParseInventoryJSON(buf []byte) (*Inventory, error) {
+	var groups map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &groups); err != nil {
+		return nil, err
+	}
+	inventory := &Inventory{Groups: make(map[string][]string)}
+	for name, raw := range groups {
+		if name == "_meta" {
+			var meta struct {
+				HostVars map[string]TaskVars `json:"hostvars"`
+			}
+			if err := json.Unmarshal(raw, &meta); err != nil {
+				return nil, fmt.Errorf("Inventory '_meta': %s", err)
+			}
+			inventory.HostVars = meta.HostVars
+			continue
+		}
+		var hosts []string
+		if err := json.Unmarshal(raw, &hosts); err == nil {
+			inventory.Groups[name] = hosts
+			continue
+		}
+		var group struct {
+			Hosts    []string
+			Children []string
+			Vars     TaskVars
+		}
+		if err := json.Unmarshal(raw, &group); err != nil {
+			return nil, fmt.Errorf("Inventory group '%s': %s", name, err)
+		}
+		inventory.Groups[name] = append(group.Hosts, group.Children...)
+		if len(group.Vars) > 0 {
+			inventory.AddGroupVars(map[string]TaskVars{name: group.Vars})
+		}
+	}
+	return inventory, inventory.validate()
+}
+
+// Returns the variables of the host, if any. Group vars come first, the
+// 'all' group's and then the other groups' the host is in by name, and
+// host vars take precedence over them. Vars of host:port entries fall back
+// to the vars of the bare hostname.
+func (inventory *Inventory) Vars(host string) TaskVars {
+	layers := inventory.VarLayers(host)
+	if len(layers) == 0 {
+		return nil
+	}
+	vars := make(TaskVars)
+	for _, layer := range layers {
+		for variable, value := range layer.Vars {
+			vars[variable] = value
+		}
+	}
+	return vars
+}
+
+// Returns the groups with vars the host is in, directly or through child
+// groups, in the order their vars apply
+func (inventory *Inventory) groupsOf(host string) []string {
+	var groups []string
+	for group := range inventory.GroupVars {
+		if group == "all" {
+			continue
+		}
+		for _, member := range inventory.Resolve([]string{group}) {
+			if member == host || member == strings.Split(host, ":")[0] {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	sort.Strings(groups)
+	if _, present := inventory.GroupVars["all"]; present {
+		groups = append([]string{"all"}, groups...)
+	}
+	return groups
+}
+
+// HostProvider looks up hosts dynamically, from a cloud API for instance.
+// Hosts of the form <prefix>:<selector> are resolved by the provider
+// registered for the prefix in HostProviders.
+type HostProvider interface {
+	Hosts(selector string) ([]string, error)
+}
+
+var HostProviders = map[string]HostProvider{
+	"tag": EC2,
+}
+
+// Expands the inventory groups and then the hosts left that are meant for
+// a host provider.
+func ResolveHosts(names []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, name := range DefaultInventory.Resolve(names) {
+		found := []string{name}
+		prefix_selector := strings.SplitN(name, ":", 2)
+		if provider, present := HostProviders[prefix_selector[0]]; present && len(prefix_selector) == 2 {
+			var err error
+			if found, err = provider.Hosts(prefix_selector[1]); err != nil {
+				return nil, fmt.Errorf("Couldn't look up '%s': %s", name, err)
+			}
+		}
+		for _, host := range found {
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// Loads <kind>/<name>.yaml files from the directory, keyed by name. A
+// missing directory isn't an error.
+func loadVarsDir(dir string, kind string) (map[string]TaskVars, error) {
+	loaded := make(map[string]TaskVars)
+	files, err := ioutil.ReadDir(filepath.Join(dir, kind))
+	if os.IsNotExist(err) {
+		return loaded, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, info := range files {
+		ext := filepath.Ext(info.Name())
+		if info.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(dir, kind, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		vars := make(TaskVars)
+		if err := yaml.Unmarshal(buf, &vars); err != nil {
+			return nil, fmt.Errorf("%s: %s", info.Name(), err)
+		}
+		loaded[strings.TrimSuffix(info.Name(), ext)] = vars
+	}
+	return loaded, nil
+}
+
+// Loads host_vars/<hostname>.yaml files from the directory, keyed by hostname
+func LoadHostVars(dir string) (map[string]TaskVars, error) {
+	return loadVarsDir(dir, "host_vars")
+}
+
+// Loads group_vars/<group>.yaml files from the directory, keyed by group
+func LoadGroupVars(dir string) (map[string]TaskVars, error) {
+	return loadVarsDir(dir, "group_vars")
+}
+
+func layerVars(layers map[string]TaskVars, vars map[string]TaskVars) map[string]TaskVars {
+	if layers == nil {
+		layers = make(map[string]TaskVars)
+	}
+	for name, layer := range vars {
+		merged := make(TaskVars)
+		for variable, value := range layers[name] {
+			merged[variable] = value
+		}
+		for variable, value := range layer {
+			merged[variable] = value
+		}
+		layers[name] = merged
+	}
+	return layers
+}
+
+// Layers the host vars over the ones the inventory already has
+func (inventory *Inventory) AddHostVars(hostVars map[string]TaskVars) {
+	inventory.HostVars = layerVars(inventory.HostVars, hostVars)
+}
+
+// Layers the group vars over the ones the inventory already has
+func (inventory *Inventory) AddGroupVars(groupVars map[string]TaskVars) {
+	inventory.GroupVars = layerVars(inventory.GroupVars, groupVars)
+}
+
+// Refuses groups that contain themselves, directly or not
+func (inventory *Inventory) validate() error {
+	var visit func(group string, visiting map[string]bool) error
+	visit = func(group string, visiting map[string]bool) error {
+		if visiting[group] {
+			return fmt.Errorf("Inventory group '%s' contains itself", group)
+		}
+		visiting[group] = true
+		defer delete(visiting, group)
+		for _, member := range inventory.Groups[group] {
+			if _, isGroup := inventory.Groups[member]; isGroup {
+				if err := visit(member, visiting); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for group := range inventory.Groups {
+		if err := visit(group, make(map[string]bool)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inventory *Inventory) expand(name string, seen map[string]bool, hosts []string) []string {
+	members, isGroup := inventory.Groups[name]
+	if !isGroup && name == "all" {
+		return inventory.all(seen, hosts)
+	}
+	if !isGroup {
+		if !seen[name] {
+			seen[name] = true
+			hosts = append(hosts, name)
+		}
+		return hosts
+	}
+	for _, member := range members {
+		hosts = inventory.expand(member, seen, hosts)
+	}
+	return hosts
+}
+
+func (inventory *Inventory) all(seen map[string]bool, hosts []string) []string {
+	var groups []string
+	for group := range inventory.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		hosts = inventory.expand(group, seen, hosts)
+	}
+	return hosts
+}
+
+// Expands the group names into their hosts. Names that aren't groups are
+// hosts themselves. Hosts are listed once, in the order they're found.
+func (inventory *Inventory) Resolve(names []string) []string {
+	if inventory == nil {
+		return names
+	}
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, name := range names {
+		hosts = inventory.expand(name, seen, hosts)
+	}
+	return hosts
+}
@@ -0,0 +1,221 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseInventoryYAML(t *testing.T) {
+	inventory_string := `---
+groups:
+  webservers:
+    - web1
+    - web2:2222
+  dbservers: [db1]
+  production: [webservers, dbservers, cache1]
+`
+	inventory, err := ParseInventoryYAML([]byte(inventory_string))
+	if err != nil {
+		t.Fatalf("Couldn't parse the inventory: %s\n", err)
+	}
+	resolved := inventory.Resolve([]string{"production", "web1", "10.0.0.1"})
+	expected := []string{"web1", "web2:2222", "db1", "cache1", "10.0.0.1"}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("Resolved hosts mismatch. Got %v\n", resolved)
+	}
+	if all := inventory.Resolve([]string{"all"}); len(all) != 4 {
+		t.Errorf("Expected 'all' to hold every host. Got %v\n", all)
+	}
+}
+
+func TestParseInventoryINI(t *testing.T) {
+	inventory_string := `
+bastion
+[webservers]
+web1 ansible_port=22
+web2:2222
+; comment
+[production:children]
+webservers
+`
+	inventory, err := ParseInventoryINI([]byte(inventory_string))
+	if err != nil {
+		t.Fatalf("Couldn't parse the inventory: %s\n", err)
+	}
+	if resolved := inventory.Resolve([]string{"production"}); !reflect.DeepEqual(resolved, []string{"web1", "web2:2222"}) {
+		t.Errorf("Resolved hosts mismatch. Got %v\n", resolved)
+	}
+	if resolved := inventory.Resolve([]string{"ungrouped"}); !reflect.DeepEqual(resolved, []string{"bastion"}) {
+		t.Errorf("Ungrouped hosts mismatch. Got %v\n", resolved)
+	}
+}
+
+func TestParseInventoryINIGroupVars(t *testing.T) {
+	inventory_string := `
+[atlanta]
+host1
+host2 http_port=8080
+
+[raleigh]
+host3
+
+[southeast:children]
+atlanta
+raleigh
+empty
+
+[atlanta:vars]
+http_port = 80
+ntp_server=ntp.atlanta.example.com
+
+[southeast:vars]
+region=southeast
+`
+	inventory, err := ParseInventoryINI([]byte(inventory_string))
+	if err != nil {
+		t.Fatalf("Couldn't parse the inventory: %s\n", err)
+	}
+	if resolved := inventory.Resolve([]string{"southeast"}); !reflect.DeepEqual(resolved, []string{"host1", "host2", "host3"}) {
+		t.Errorf("Nested group hosts mismatch. Got %v\n", resolved)
+	}
+	if _, present := inventory.Groups["atlanta:vars"]; present {
+		t.Errorf("Expected the vars section not to be a group. Got %v\n", inventory.Groups)
+	}
+	vars := inventory.Vars("host1")
+	if vars["http_port"] != "80" || vars["ntp_server"] != "ntp.atlanta.example.com" || vars["region"] != "southeast" {
+		t.Errorf("host1 vars mismatch. Got %v\n", vars)
+	}
+	if vars := inventory.Vars("host2"); vars["http_port"] != "8080" {
+		t.Errorf("Expected host vars to win over group vars. Got %v\n", vars)
+	}
+	if vars := inventory.Vars("host3"); vars["region"] != "southeast" || vars["http_port"] != nil {
+		t.Errorf("host3 vars mismatch. Got %v\n", vars)
+	}
+
+	if _, err := ParseInventoryINI([]byte("[web:vars]\nhttp_port\n")); err == nil {
+		t.Errorf("Expected group vars without a value to be refused\n")
+	}
+	if _, err := ParseInventoryINI([]byte("[web:hosts]\nweb1\n")); err == nil {
+		t.Errorf("Expected unknown section types to be refused\n")
+	}
+}
+
+func TestInventoryCycle(t *testing.T) {
+	if _, err := ParseInventoryINI([]byte("[a:children]\nb\n[b:children]\na\n")); err == nil {
+		t.Errorf("Expected groups containing themselves to be refused\n")
+	}
+}
+
+func TestMachinesFromGroups(t *testing.T) {
+	DefaultInventory = &Inventory{Groups: map[string][]string{"webservers": {"web1", "web2:2222"}}}
+	defer func() { DefaultInventory = nil }()
+	machines := Machines([]string{"webservers"}, nil)
+	if len(machines) != 2 || machines[1].Hostname != "web2" || machines[1].Port != 2222 {
+		t.Errorf("Machines mismatch. Got %d machines\n", len(machines))
+	}
+}
+
+func TestInventoryScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	script := `#!/bin/sh
+[ "$1" = "--list" ] || exit 1
+cat <<EOF
+{
+  "webservers": ["web1", "web2:2222"],
+  "production": {"hosts": ["db1"], "children": ["webservers"], "vars": {"env": "prod", "http_port": 80}},
+  "all": {"children": ["production"], "vars": {"ntp": "pool.ntp.org"}},
+  "_meta": {"hostvars": {"web1": {"http_port": 8080}}}
+}
+EOF
+`
+	ioutil.WriteFile(path.Join(dir, "cmdb.sh"), []byte(script), 0755)
+	inventory, err := LoadInventory(path.Join(dir, "cmdb.sh"))
+	if err != nil {
+		t.Fatalf("Couldn't run the inventory script: %s\n", err)
+	}
+	if resolved := inventory.Resolve([]string{"production"}); !reflect.DeepEqual(resolved, []string{"db1", "web1", "web2:2222"}) {
+		t.Errorf("Resolved hosts mismatch. Got %v\n", resolved)
+	}
+	if port := inventory.Vars("web1")["http_port"]; port != float64(8080) {
+		t.Errorf("Host var mismatch. Got %v\n", port)
+	}
+	if vars := inventory.Vars("web2:2222"); vars["env"] != "prod" || vars["http_port"] != float64(80) || vars["ntp"] != "pool.ntp.org" {
+		t.Errorf("Group vars mismatch. Got %v\n", vars)
+	}
+
+	DefaultInventory = inventory
+	defer func() { DefaultInventory = nil }()
+	machine := Machines([]string{"web1"}, nil)[0]
+	vars := TaskVars{"http_port": 80, "user": "www"}
+	merged := *machineVars(&vars, machine)
+	if merged["http_port"] != float64(8080) || merged["user"] != "www" {
+		t.Errorf("Machine vars mismatch. Got %v\n", merged)
+	}
+	if vars["http_port"] != 80 {
+		t.Errorf("Machine vars shouldn't leak into the plan's vars. Got %v\n", vars["http_port"])
+	}
+}
+
+func TestLoadHostVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if hostVars, err := LoadHostVars(dir); err != nil || len(hostVars) != 0 {
+		t.Errorf("Expected no host vars without a host_vars directory. Got %v, %v\n", hostVars, err)
+	}
+	os.Mkdir(path.Join(dir, "host_vars"), 0755)
+	ioutil.WriteFile(path.Join(dir, "host_vars", "web2.yaml"), []byte("http_port: 8081\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "host_vars", "README"), []byte("Not vars\n"), 0644)
+
+	hostVars, err := LoadHostVars(dir)
+	if err != nil {
+		t.Fatalf("Couldn't load the host vars: %s\n", err)
+	}
+	if len(hostVars) != 1 {
+		t.Errorf("Expected only YAML files to be loaded. Got %v\n", hostVars)
+	}
+	inventory := &Inventory{HostVars: map[string]TaskVars{"web2": {"http_port": 80, "user": "www"}}}
+	inventory.AddHostVars(hostVars)
+	vars := inventory.Vars("web2:2222")
+	if vars["http_port"] != 8081 || vars["user"] != "www" {
+		t.Errorf("Host vars mismatch. Got %v\n", vars)
+	}
+}
+
+func TestGroupVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(path.Join(dir, "group_vars"), 0755)
+	ioutil.WriteFile(path.Join(dir, "group_vars", "all.yaml"), []byte("http_port: 80\nuser: www\nenv: prod\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "group_vars", "webservers.yml"), []byte("http_port: 8080\nuser: nginx\n"), 0644)
+
+	groupVars, err := LoadGroupVars(dir)
+	if err != nil {
+		t.Fatalf("Couldn't load the group vars: %s\n", err)
+	}
+	inventory := &Inventory{
+		Groups:   map[string][]string{"webservers": {"web1", "web2:2222"}, "production": {"webservers"}},
+		HostVars: map[string]TaskVars{"web2": {"user": "deploy"}},
+	}
+	inventory.AddGroupVars(groupVars)
+
+	vars := inventory.Vars("web2:2222")
+	if vars["http_port"] != 8080 || vars["user"] != "deploy" || vars["env"] != "prod" {
+		t.Errorf("Expected host vars > group vars > all. Got %v\n", vars)
+	}
+	if vars := inventory.Vars("db1"); vars["http_port"] != 80 || vars["user"] != "www" {
+		t.Errorf("Expected hosts outside the groups to get the 'all' vars. Got %v\n", vars)
+	}
+}
@@ -0,0 +1,104 @@
+package henchman
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Returns the items the task loops over on the machine. with_items is
+// either a list, or the name of a list var like 'vars.packages' or
+// '{{ vars.facts.disks }}', looked up in the vars.
+func (task *Task) items(vars *TaskVars) ([]interface{}, error) {
+	switch items := task.WithItems.(type) {
+	case []interface{}:
+		return items, nil
+	case string:
+		name := strings.TrimSpace(items)
+		if strings.HasPrefix(name, "{{") && strings.HasSuffix(name, "}}") {
+			name = strings.TrimSpace(name[2 : len(name)-2])
+		}
+		var value interface{} = *vars
+		for _, key := range strings.Split(strings.TrimPrefix(name, "vars."), ".") {
+			switch m := value.(type) {
+			case TaskVars:
+				value = m[key]
+			case map[interface{}]interface{}:
+				value = m[key]
+			case map[string]interface{}:
+				value = m[key]
+			default:
+				value = nil
+			}
+		}
+		switch list := value.(type) {
+		case []interface{}:
+			return list, nil
+		case []string:
+			converted := make([]interface{}, len(list))
+			for i, item := range list {
+				converted[i] = item
+			}
+			return converted, nil
+		case string:
+			// Comma separated facts, e.g. vars.facts.disks
+			var converted []interface{}
+			for _, item := range strings.Split(list, ",") {
+				converted = append(converted, item)
+			}
+			return converted, nil
+		case nil:
+			return nil, fmt.Errorf("with_items '%s' isn't defined", items)
+		}
+		return nil, fmt.Errorf("with_items '%s' isn't a list", items)
+	}
+	return nil, fmt.Errorf("with_items has to be a list or the name of a list var")
+}
+
+// Runs the task once per item with run, the item available to templates
+// as {{ item }}. The items run in order and stop at the first failure
+// unless errors are ignored. The task's status sums theirs up, one line
+// per item.
+func (task *Task) loop(machine *Machine, vars *TaskVars, run func(*Task, *Machine, *TaskVars) (*TaskStatus, error)) (*TaskStatus, error) {
+	start := time.Now()
+	merged := machineVars(vars, machine)
+	task.AssignId()
+	if name, err := prepareTemplate(task.Name, merged, machine); err == nil {
+		task.Name = name
+	}
+	items, err := task.items(merged)
+	if err != nil {
+		return task.status("", err, time.Since(start)), err
+	}
+	if len(items) == 0 {
+		return &TaskStatus{Status: StatusSkipped, Message: "no items"}, nil
+	}
+	var messages []string
+	var ignored error
+	changed, skipped := false, 0
+	for _, item := range items {
+		itemVars := make(TaskVars)
+		if vars != nil {
+			mergeMap(vars, &itemVars)
+		}
+		itemVars["item"] = item
+		itemTask := *task
+		itemTask.WithItems = nil
+		status, err := run(&itemTask, machine, &itemVars)
+		messages = append(messages, strings.TrimSpace(fmt.Sprintf("[%v] %s", item, strings.TrimRight(status.Message, "\n"))))
+		changed = changed || status.Changed
+		if status.Status == StatusSkipped {
+			skipped++
+		}
+		if err != nil {
+			if !task.IgnoreErrors {
+				return task.changedStatus(strings.Join(messages, "\n"), changed, err, time.Since(start)), err
+			}
+			ignored = err
+		}
+	}
+	if skipped == len(items) {
+		return &TaskStatus{Status: StatusSkipped, Message: strings.Join(messages, "\n"), Duration: time.Since(start)}, nil
+	}
+	return task.changedStatus(strings.Join(messages, "\n"), changed, ignored, time.Since(start)), ignored
+}
@@ -0,0 +1,45 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithItems(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	machine := &Machine{Hostname: "127.0.0.1"}
+	vars := TaskVars{"dir": dir, "files": []interface{}{"c", "d"}}
+	sudo := false
+
+	task := Task{Name: "Touch", Sudo: &sudo, Action: "touch {{ vars.dir }}/{{ item }}", WithItems: []interface{}{"a", "b"}}
+	status, err := task.Run(machine, &vars)
+	if err != nil || status.Status != "ok" || status.Message != "[a]\n[b]" {
+		t.Errorf("Expected the task to run per item. Got %q %v\n", status.Message, err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be created: %s\n", name, err)
+		}
+	}
+
+	task = Task{Name: "Touch", Sudo: &sudo, Action: "touch {{ vars.dir }}/{{ item }}", WithItems: "{{ vars.files }}", When: `item != "d"`}
+	if status, err := task.Run(machine, &vars); err != nil || status.Status != "ok" {
+		t.Errorf("Expected the items of the var to run. Got %v %v\n", status, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "d")); !os.IsNotExist(err) {
+		t.Errorf("The item the condition is false for shouldn't have run\n")
+	}
+
+	task = Task{Name: "Fail", Sudo: &sudo, Action: "test {{ item }} = ok", WithItems: []interface{}{"bad", "ok"}}
+	if status, err := task.Run(machine, &vars); err == nil || status.Status != "failed" || status.Message != "[bad] "+err.Error() {
+		t.Errorf("Expected the loop to stop at the failed item. Got %q %v\n", status.Message, err)
+	}
+
+	task = Task{Name: "Missing", Sudo: &sudo, Action: "true", WithItems: "vars.packages"}
+	if _, err := task.Run(machine, &vars); err == nil {
+		t.Errorf("Expected an undefined list var to fail\n")
+	}
+}
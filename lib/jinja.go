@@ -0,0 +1,510 @@
+package henchman
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/flosch/pongo2"
+	"gopkg.in/yaml.v1"
+)
+
+// Template dialect for plans carrying templates written for Ansible, see
+// Plan.TemplateDialect. Jinja2 templates are translated to pongo2 ones:
+// filters take their arguments in parentheses, vars can be referred to
+// without the vars. prefix, loop.index and friends work in for loops,
+// '... is defined' tests work, nothing is HTML escaped and raw blocks are
+// left alone. The common Ansible filters are available.
+const JinjaDialect = "jinja2"
+
+func validateDialect(dialect string) error {
+	switch dialect {
+	case "", "pongo2", JinjaDialect:
+		return nil
+	}
+	return fmt.Errorf("Unknown template dialect '%s', expected pongo2 or jinja2", dialect)
+}
+
+// Jinja2 filters and the pongo2 ones they're rendered with
+var jinjaFilters = map[string]string{
+	"d":             "jinja_default",
+	"default":       "jinja_default",
+	"join":          "join",
+	"upper":         "upper",
+	"lower":         "lower",
+	"title":         "title",
+	"capitalize":    "jinja_capitalize",
+	"length":        "length",
+	"count":         "length",
+	"first":         "first",
+	"last":          "last",
+	"int":           "integer",
+	"float":         "float",
+	"string":        "jinja_string",
+	"bool":          "jinja_bool",
+	"trim":          "jinja_trim",
+	"replace":       "jinja_replace",
+	"regex_replace": "jinja_regex_replace",
+	"to_json":       "jinja_to_json",
+	"to_nice_json":  "jinja_to_nice_json",
+	"to_yaml":       "jinja_to_yaml",
+	"b64encode":     "jinja_b64encode",
+	"b64decode":     "jinja_b64decode",
+	"basename":      "jinja_basename",
+	"dirname":       "jinja_dirname",
+	"quote":         "jinja_quote",
+	"mandatory":     "jinja_mandatory",
+}
+
+// Rewrites of the names Jinja2 has for what pongo2 calls differently
+var jinjaNames = []struct {
+	pattern *regexp.Regexp
+	pongo   string
+}{
+	{regexp.MustCompile(`\bloop\.index0\b`), "forloop.Counter0"},
+	{regexp.MustCompile(`\bloop\.index\b`), "forloop.Counter"},
+	{regexp.MustCompile(`\bloop\.revindex0\b`), "forloop.Revcounter0"},
+	{regexp.MustCompile(`\bloop\.revindex\b`), "forloop.Revcounter"},
+	{regexp.MustCompile(`\bloop\.first\b`), "forloop.First"},
+	{regexp.MustCompile(`\bloop\.last\b`), "forloop.Last"},
+	{regexp.MustCompile(`\.(items|iteritems|keys)\(\)`), ""},
+	{regexp.MustCompile(`\bTrue\b`), "true"},
+	{regexp.MustCompile(`\bFalse\b`), "false"},
+	{regexp.MustCompile(`~`), "+"},
+	{regexp.MustCompile(`([\w.]+(?:\[[^\]]*\])*)\s+is\s+not\s+(defined|none)\b`), "not jinja_$2($1)"},
+	{regexp.MustCompile(`([\w.]+(?:\[[^\]]*\])*)\s+is\s+(defined|none)\b`), "jinja_$2($1)"},
+	{regexp.MustCompile(`([\w.]+(?:\[[^\]]*\])*)\s+is\s+undefined\b`), "not jinja_defined($1)"},
+}
+
+var jinjaTag = regexp.MustCompile(`\{[{%#]`)
+var jinjaRaw = regexp.MustCompile(`^\{%-?\s*raw\s*-?%\}`)
+var jinjaEndRaw = regexp.MustCompile(`\{%-?\s*endraw\s*-?%\}`)
+
+// Translates the Jinja2 template to pongo2
+func jinjaToPongo(source string) string {
+	var out strings.Builder
+	out.WriteString("{% autoescape off %}")
+	trimNext := false
+	for source != "" {
+		start := len(source)
+		if loc := jinjaTag.FindStringIndex(source); loc != nil {
+			start = loc[0]
+		}
+		text := source[:start]
+		source = source[start:]
+		if trimNext {
+			text = strings.TrimLeft(text, " \t\r\n")
+		}
+		if len(source) > 2 && source[2] == '-' {
+			text = strings.TrimRight(text, " \t\r\n")
+		}
+		out.WriteString(text)
+		if source == "" {
+			break
+		}
+		if raw := jinjaRaw.FindString(source); raw != "" {
+			end := jinjaEndRaw.FindStringIndex(source)
+			if end == nil {
+				end = []int{len(source), len(source)}
+			}
+			out.WriteString(escapePongo(source[len(raw):end[0]]))
+			source = source[end[1]:]
+			trimNext = false
+			continue
+		}
+		closing := map[byte]string{'{': "}}", '%': "%}", '#': "#}"}[source[1]]
+		end := tagEnd(source, closing)
+		tag := source[:end]
+		source = source[end:]
+		trimNext = strings.HasSuffix(tag, "-"+closing)
+		// Comments are dropped
+		if tag[1] == '#' {
+			continue
+		}
+		inner := strings.TrimSuffix(strings.TrimSuffix(tag[2:], closing), "-")
+		inner = strings.TrimPrefix(inner, "-")
+		out.WriteString(tag[:2] + translateJinjaExpression(inner) + closing)
+	}
+	out.WriteString("{% endautoescape %}")
+	return out.String()
+}
+
+// Returns the length of the tag at the start of the source up to its
+// closing delimiter, skipping quoted strings
+func tagEnd(source string, closing string) int {
+	var quote byte
+	for i := 2; i < len(source); i++ {
+		switch {
+		case quote != 0:
+			if source[i] == '\\' {
+				i++
+			} else if source[i] == quote {
+				quote = 0
+			}
+		case source[i] == '\'' || source[i] == '"':
+			quote = source[i]
+		case strings.HasPrefix(source[i:], closing):
+			return i + len(closing)
+		}
+	}
+	return len(source)
+}
+
+// Escapes the delimiters of the raw text so that pongo2 outputs it as is
+func escapePongo(text string) string {
+	return strings.NewReplacer(
+		"{{", "{% templatetag openvariable %}",
+		"}}", "{% templatetag closevariable %}",
+		"{%", "{% templatetag openblock %}",
+		"%}", "{% templatetag closeblock %}",
+		"{#", "{% templatetag opencomment %}",
+		"#}", "{% templatetag closecomment %}",
+	).Replace(text)
+}
+
+// Splits the expression into its quoted strings and the rest, quoted
+// strings at odd indexes
+func splitQuoted(expression string) []string {
+	var parts []string
+	var quote byte
+	last := 0
+	for i := 0; i < len(expression); i++ {
+		c := expression[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			parts = append(parts, expression[last:i+1])
+			last, quote = i+1, 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			parts = append(parts, expression[last:i])
+			last, quote = i, c
+		}
+	}
+	return append(parts, expression[last:])
+}
+
+// Returns the index of the parenthesis closing the one before the start
+func closingParen(expression string, start int) int {
+	depth := 1
+	for i, part := range splitQuoted(expression[start:]) {
+		if i%2 == 1 {
+			start += len(part)
+			continue
+		}
+		for j, c := range part {
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				if depth--; depth == 0 {
+					return start + j
+				}
+			}
+		}
+		start += len(part)
+	}
+	return -1
+}
+
+// Splits the arguments on their top level commas
+func splitArgs(args string) []string {
+	var split []string
+	depth, last, offset := 0, 0, 0
+	for i, part := range splitQuoted(args) {
+		if i%2 == 0 {
+			for j, c := range part {
+				switch c {
+				case '(', '[':
+					depth++
+				case ')', ']':
+					depth--
+				case ',':
+					if depth == 0 {
+						split = append(split, strings.TrimSpace(args[last:offset+j]))
+						last = offset + j + 1
+					}
+				}
+			}
+		}
+		offset += len(part)
+	}
+	return append(split, strings.TrimSpace(args[last:]))
+}
+
+// Rewrites the quoted Jinja2 string for pongo2, which only knows the \\
+// and \" escapes. Other backslashes are kept as Python keeps unknown
+// escapes, e.g. in regex_replace('(\w+)', '\1').
+func pongoString(quoted string) string {
+	var value strings.Builder
+	body := quoted[1:]
+	if len(body) > 0 && body[len(body)-1] == quoted[0] {
+		body = body[:len(body)-1]
+	}
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\\' && i+1 < len(body) && strings.IndexByte("\\'\"", body[i+1]) >= 0 {
+			i++
+		}
+		value.WriteByte(body[i])
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value.String()) + `"`
+}
+
+var jinjaFilterCall = regexp.MustCompile(`\|\s*(\w+)\s*(\()?`)
+
+// Translates the inside of a Jinja2 tag
+func translateJinjaExpression(expression string) string {
+	parts := splitQuoted(expression)
+	for i := range parts {
+		if i%2 == 1 {
+			parts[i] = pongoString(parts[i])
+			continue
+		}
+		for _, name := range jinjaNames {
+			parts[i] = name.pattern.ReplaceAllString(parts[i], name.pongo)
+		}
+	}
+	expression = strings.Join(parts, "")
+
+	var out strings.Builder
+	for {
+		// Filters outside quoted strings
+		match := []int(nil)
+		offset := 0
+		for i, part := range splitQuoted(expression) {
+			if i%2 == 0 {
+				if m := jinjaFilterCall.FindStringSubmatchIndex(part); m != nil {
+					match = m
+					for j := range match {
+						if match[j] >= 0 {
+							match[j] += offset
+						}
+					}
+					break
+				}
+			}
+			offset += len(part)
+		}
+		if match == nil {
+			out.WriteString(expression)
+			return out.String()
+		}
+		name := expression[match[2]:match[3]]
+		filter, known := jinjaFilters[name]
+		if !known {
+			filter = name
+		}
+		out.WriteString(expression[:match[0]] + "|" + filter)
+		rest := expression[match[1]:]
+		if match[4] < 0 {
+			expression = rest
+			continue
+		}
+		end := closingParen(rest, 0)
+		if end < 0 {
+			end = len(rest)
+		}
+		var args []string
+		for _, arg := range splitArgs(rest[:end]) {
+			if arg != "" {
+				args = append(args, translateJinjaExpression(arg))
+			}
+		}
+		switch len(args) {
+		case 0:
+		case 1:
+			out.WriteString(":" + args[0])
+		default:
+			out.WriteString(":jinja_args(" + strings.Join(args, ", ") + ")")
+		}
+		if end < len(rest) {
+			end++
+		}
+		expression = rest[end:]
+	}
+}
+
+// Functions the translated templates call
+var jinjaFunctions = pongo2.Context{
+	"jinja_args": func(args ...interface{}) []interface{} {
+		return args
+	},
+	"jinja_defined": func(value *pongo2.Value) bool {
+		return !value.IsNil()
+	},
+	"jinja_none": func(value *pongo2.Value) bool {
+		return value.IsNil()
+	},
+}
+
+// Returns the filter's arguments, more than one coming as jinja_args
+func filterArgs(param *pongo2.Value) []*pongo2.Value {
+	if args, multiple := param.Interface().([]interface{}); multiple {
+		values := make([]*pongo2.Value, len(args))
+		for i, arg := range args {
+			values[i] = pongo2.AsValue(arg)
+		}
+		return values
+	}
+	return []*pongo2.Value{param}
+}
+
+func filterError(name string, err error) *pongo2.Error {
+	return &pongo2.Error{Sender: "filter:" + name, OrigError: err}
+}
+
+// Ansible's filters render values as JSON
+func toJSON(value *pongo2.Value, indent bool) (string, error) {
+	var data []byte
+	var err error
+	if indent {
+		data, err = json.MarshalIndent(jsonValue(value.Interface()), "", "    ")
+	} else {
+		data, err = json.Marshal(jsonValue(value.Interface()))
+	}
+	return string(data), err
+}
+
+var jinjaFilterFuncs = map[string]pongo2.FilterFunction{
+	// Only undefined values get the default, unless the second argument
+	// is true as in default('x', true)
+	"jinja_default": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		args := filterArgs(param)
+		if in.IsNil() || (len(args) > 1 && args[1].IsTrue() && !in.IsTrue()) {
+			return args[0], nil
+		}
+		return in, nil
+	},
+	"jinja_capitalize": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		s := strings.ToLower(in.String())
+		if s == "" {
+			return pongo2.AsValue(s), nil
+		}
+		return pongo2.AsValue(strings.ToUpper(s[:1]) + s[1:]), nil
+	},
+	"jinja_string": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		return pongo2.AsValue(in.String()), nil
+	},
+	"jinja_bool": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		if in.IsString() {
+			switch strings.ToLower(strings.TrimSpace(in.String())) {
+			case "yes", "on", "true", "1":
+				return pongo2.AsValue(true), nil
+			}
+			return pongo2.AsValue(false), nil
+		}
+		return pongo2.AsValue(in.IsTrue()), nil
+	},
+	"jinja_trim": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		return pongo2.AsValue(strings.TrimSpace(in.String())), nil
+	},
+	"jinja_replace": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		args := filterArgs(param)
+		if len(args) != 2 {
+			return nil, filterError("replace", fmt.Errorf("replace takes the old and the new string"))
+		}
+		return pongo2.AsValue(strings.Replace(in.String(), args[0].String(), args[1].String(), -1)), nil
+	},
+	"jinja_regex_replace": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		args := filterArgs(param)
+		if len(args) != 2 {
+			return nil, filterError("regex_replace", fmt.Errorf("regex_replace takes the pattern and the replacement"))
+		}
+		pattern, err := regexp.Compile(args[0].String())
+		if err != nil {
+			return nil, filterError("regex_replace", err)
+		}
+		// Python refers to groups as \1
+		replacement := regexp.MustCompile(`\\(\d+)`).ReplaceAllString(args[1].String(), "$${$1}")
+		return pongo2.AsValue(pattern.ReplaceAllString(in.String(), replacement)), nil
+	},
+	"jinja_to_json": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		s, err := toJSON(in, false)
+		if err != nil {
+			return nil, filterError("to_json", err)
+		}
+		return pongo2.AsValue(s), nil
+	},
+	"jinja_to_nice_json": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		s, err := toJSON(in, true)
+		if err != nil {
+			return nil, filterError("to_nice_json", err)
+		}
+		return pongo2.AsValue(s), nil
+	},
+	"jinja_to_yaml": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		data, err := yaml.Marshal(in.Interface())
+		if err != nil {
+			return nil, filterError("to_yaml", err)
+		}
+		return pongo2.AsValue(strings.TrimSuffix(string(data), "\n")), nil
+	},
+	"jinja_b64encode": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		return pongo2.AsValue(base64.StdEncoding.EncodeToString([]byte(in.String()))), nil
+	},
+	"jinja_b64decode": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		data, err := base64.StdEncoding.DecodeString(in.String())
+		if err != nil {
+			return nil, filterError("b64decode", err)
+		}
+		return pongo2.AsValue(string(data)), nil
+	},
+	"jinja_basename": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		return pongo2.AsValue(path.Base(in.String())), nil
+	},
+	"jinja_dirname": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		return pongo2.AsValue(path.Dir(in.String())), nil
+	},
+	"jinja_quote": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		return pongo2.AsValue(shellQuote(in.String())), nil
+	},
+	"jinja_mandatory": func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		if in.IsNil() {
+			return nil, filterError("mandatory", fmt.Errorf("Mandatory variable not defined"))
+		}
+		return in, nil
+	},
+}
+
+func init() {
+	for name, filter := range jinjaFilterFuncs {
+		pongo2.RegisterFilter(name, filter)
+	}
+}
+
+// Adds the functions Jinja2 templates call along with inventory_hostname
+func addJinjaContext(context pongo2.Context, machine *Machine) {
+	for name, function := range jinjaFunctions {
+		context[name] = function
+	}
+	if machine != nil {
+		context["inventory_hostname"] = machine.Hostname
+	}
+}
+
+// Adds the vars and the gathered facts to the context by their own names,
+// vars winning over facts. Names pongo2 can't refer to and the ones already
+// in the context are left out.
+func addBareVars(context pongo2.Context, vars *TaskVars) {
+	if vars == nil {
+		return
+	}
+	bare := make(TaskVars)
+	if facts, ok := (*vars)["facts"].(TaskVars); ok {
+		for name, value := range facts {
+			bare[name] = value
+		}
+	}
+	for name, value := range *vars {
+		bare[name] = value
+	}
+	for name, value := range bare {
+		if _, builtin := context[name]; !builtin && jinjaIdentifier.MatchString(name) {
+			context[name] = value
+		}
+	}
+}
+
+var jinjaIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
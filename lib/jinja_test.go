@@ -0,0 +1,71 @@
+package henchman
+
+import (
+	"testing"
+)
+
+func TestJinjaToPongo(t *testing.T) {
+	cases := map[string]string{
+		"{{ port | default(80) }}":                 "{{ port |jinja_default:80 }}",
+		"{{ items|join(', ') }}":                   `{{ items|join:", " }}`,
+		"{{ path | replace('/', '_') }}":           `{{ path |jinja_replace:jinja_args("/", "_") }}`,
+		"{% if tls is not defined %}":              "{% if not jinja_defined(tls) %}",
+		"{% for k, v in users.items() %}":          "{% for k, v in users %}",
+		"{{ loop.index }}":                         "{{ forloop.Counter }}",
+		"{{ 'a|b(c)' }}":                           `{{ "a|b(c)" }}`,
+		"{# note #}x":                              "x",
+		"{% raw %}{{ not templated }}{% endraw %}": "{% templatetag openvariable %} not templated {% templatetag closevariable %}",
+		"a  {%- if True -%}\n  b":                  "a{% if true %}b",
+	}
+	for jinja, expected := range cases {
+		expected = "{% autoescape off %}" + expected + "{% endautoescape %}"
+		if translated := jinjaToPongo(jinja); translated != expected {
+			t.Errorf("Translation of %q mismatch. Got %q\n", jinja, translated)
+		}
+	}
+}
+
+func TestJinjaDialect(t *testing.T) {
+	plan_string := `---
+name: "Ansible templates"
+template_dialect: jinja2
+hosts:
+  - web1
+vars:
+  port: 8080
+  users:
+    - alice
+    - bob
+  motd: "<welcome>"
+tasks:
+  - name: Render
+    action: echo
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		t.Fatalf("Couldn't parse the plan: %s\n", err)
+	}
+	SetRunVars(NewRun(), plan)
+	defer SetRunVars(NewRun(), &Plan{})
+
+	machine := &Machine{Hostname: "web1"}
+	template := "listen {{ port }};\n" +
+		"{% for user in users %}{{ loop.index }}:{{ user | upper }}{% if not loop.last %},{% endif %}{% endfor %}\n" +
+		"{{ tls_port | default(443) }} {{ motd }} {{ inventory_hostname }}\n" +
+		"{{ 'www-data' | regex_replace('^(\\w+)-(\\w+)$', '\\2_\\1') }} {{ users | to_json }}\n" +
+		"{% if tls is defined %}tls{% else %}plain{% endif %}\n"
+	expected := "listen 8080;\n1:ALICE,2:BOB\n443 <welcome> web1\ndata_www [\"alice\",\"bob\"]\nplain\n"
+	if out, err := prepareTemplate(template, plan.Vars, machine); err != nil || out != expected {
+		t.Errorf("Render mismatch. Got %q %v\n", out, err)
+	}
+	for _, host := range []string{"web1", "web2"} {
+		out, err := prepareTemplate("server_name {{ inventory_hostname }}", plan.Vars, &Machine{Hostname: host})
+		if err != nil || out != "server_name "+host {
+			t.Errorf("Expected each host to render its own name. Got %q %v\n", out, err)
+		}
+	}
+
+	if _, err := NewPlanFromYAML([]byte(plan_string+"\ntemplate_dialect: mustache\n"), nil); err == nil {
+		t.Errorf("Expected an unknown dialect to be refused\n")
+	}
+}
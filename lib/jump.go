@@ -0,0 +1,111 @@
+package henchman
+
+import (
+	"net"
+	"strings"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// Hop is an intermediate SSH server connections are tunnelled through.
+// User and Keyfile are optional and default to the credentials of the
+// machines being reached.
+type Hop struct {
+	Host    string
+	User    string
+	Keyfile string
+}
+
+// Builds the machines for a chain of hops, in the order they are dialed.
+func JumpHosts(hops []Hop, config *ssh.ClientConfig) ([]*Machine, error) {
+	var via []*Machine
+	for _, hop := range hops {
+		hopConfig := *config
+		if hop.User != "" {
+			hopConfig.User = hop.User
+		}
+		if hop.Keyfile != "" {
+			auth, err := ClientKeyAuth(hop.Keyfile)
+			if err != nil {
+				return nil, err
+			}
+			hopConfig.Auth = []ssh.AuthMethod{auth}
+		}
+		via = append(via, Machines([]string{hop.Host}, &hopConfig)[0])
+	}
+	return via, nil
+}
+
+// Parses a bastion of the form [user@]host[:port] into a hop
+func ParseBastion(spec string) Hop {
+	hop := Hop{Host: spec}
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		hop.User, hop.Host = spec[:i], spec[i+1:]
+	}
+	return hop
+}
+
+// Tunnels the machines that have a 'bastion' var, set in the inventory for
+// instance, through that bastion instead of the plan's jump hosts. Machines
+// behind the same bastion share its machine. Returns the bastions' machines.
+func UseBastions(machines []*Machine, config *ssh.ClientConfig) ([]*Machine, error) {
+	bastions := make(map[string][]*Machine)
+	var hops []*Machine
+	for _, machine := range machines {
+		spec, _ := machine.Vars["bastion"].(string)
+		if spec == "" {
+			continue
+		}
+		if _, present := bastions[spec]; !present {
+			via, err := JumpHosts([]Hop{ParseBastion(spec)}, config)
+			if err != nil {
+				return nil, err
+			}
+			bastions[spec] = via
+			hops = append(hops, via...)
+		}
+		machine.Via = bastions[spec]
+	}
+	return hops, nil
+}
+
+// Dials the machine hop by hop through its jump hosts. Each hop's connection
+// is opened over a channel of the previous one. The intermediate connections
+// are torn down once the connection to the machine itself is closed.
+func (machine *Machine) dialVia() (*ssh.Client, error) {
+	var chain []*Machine
+	chain = append(chain, machine.Via[1:]...)
+	chain = append(chain, machine)
+	client, err := machine.Via[0].dial()
+	if err != nil {
+		return nil, err
+	}
+	clients := []*ssh.Client{client}
+	closeAll := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+	for _, hop := range chain {
+		var conn net.Conn
+		err := withTimeout("connect", hop.Timeouts.Connect, func() error {
+			var err error
+			conn, err = client.Dial("tcp", hop.address())
+			return err
+		}, client.Close)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if client, err = hop.handshake(conn); err != nil {
+			closeAll()
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	go func() {
+		client.Wait()
+		closeAll()
+	}()
+	return client, nil
+}
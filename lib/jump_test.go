@@ -0,0 +1,72 @@
+package henchman
+
+import (
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestJumpHosts(t *testing.T) {
+	config := &ssh.ClientConfig{User: "deploy"}
+	hops := []Hop{
+		{Host: "bastion1"},
+		{Host: "bastion2:2222", User: "jump"},
+	}
+	via, err := JumpHosts(hops, config)
+	if err != nil {
+		t.Fatalf("Couldn't build the jump hosts: %s\n", err)
+	}
+	if len(via) != 2 {
+		t.Fatalf("Number of hops mismatch. Got %d instead\n", len(via))
+	}
+	if via[0].Hostname != "bastion1" || via[0].Port != 22 {
+		t.Errorf("First hop mismatch. Got %s:%d instead\n", via[0].Hostname, via[0].Port)
+	}
+	if via[0].SSHConfig.User != "deploy" {
+		t.Errorf("First hop should default to the machine user. Got %s\n", via[0].SSHConfig.User)
+	}
+	if via[1].Port != 2222 || via[1].SSHConfig.User != "jump" {
+		t.Errorf("Second hop mismatch. Got %s@%s:%d\n", via[1].SSHConfig.User, via[1].Hostname, via[1].Port)
+	}
+	if config.User != "deploy" {
+		t.Errorf("Hop credentials shouldn't leak into the machine config. Got %s\n", config.User)
+	}
+}
+
+func TestJumpHostsMissingKeyfile(t *testing.T) {
+	config := &ssh.ClientConfig{User: "deploy"}
+	_, err := JumpHosts([]Hop{{Host: "bastion1", Keyfile: "/non/existent/key"}}, config)
+	if err == nil {
+		t.Errorf("A missing hop keyfile should be an error\n")
+	}
+}
+
+func TestParseBastion(t *testing.T) {
+	hop := ParseBastion("jump@bastion.example.com:2222")
+	if hop.User != "jump" || hop.Host != "bastion.example.com:2222" {
+		t.Errorf("Bastion mismatch. Got %+v\n", hop)
+	}
+	if hop := ParseBastion("bastion.example.com"); hop.User != "" || hop.Host != "bastion.example.com" {
+		t.Errorf("Bastion mismatch. Got %+v\n", hop)
+	}
+}
+
+func TestUseBastions(t *testing.T) {
+	config := &ssh.ClientConfig{User: "deploy"}
+	machines := Machines([]string{"db01", "db02", "web01"}, config)
+	machines[0].Vars = TaskVars{"bastion": "jump@bastion-db"}
+	machines[1].Vars = TaskVars{"bastion": "jump@bastion-db"}
+	bastions, err := UseBastions(machines, config)
+	if err != nil {
+		t.Fatalf("Couldn't set up the bastions: %s\n", err)
+	}
+	if len(bastions) != 1 || bastions[0].Hostname != "bastion-db" || bastions[0].SSHConfig.User != "jump" {
+		t.Fatalf("Bastions mismatch. Got %v\n", bastions)
+	}
+	if len(machines[0].Via) != 1 || machines[0].Via[0] != bastions[0] || machines[1].Via[0] != bastions[0] {
+		t.Errorf("Hosts behind the same bastion should share it. Got %v and %v\n", machines[0].Via, machines[1].Via)
+	}
+	if machines[2].Via != nil {
+		t.Errorf("Hosts without a bastion shouldn't be touched. Got %v\n", machines[2].Via)
+	}
+}
@@ -0,0 +1,200 @@
+package henchman
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// Host key checking modes, named after OpenSSH's StrictHostKeyChecking
+const (
+	// Only hosts already in known_hosts are accepted
+	StrictHostKeys = "yes"
+	// Unknown hosts are accepted and recorded, changed keys are refused
+	AcceptNewHostKeys = "accept-new"
+	// Any host key is accepted. Insecure.
+	InsecureHostKeys = "no"
+)
+
+type knownHost struct {
+	patterns []string
+	key      ssh.PublicKey
+	revoked  bool
+}
+
+// KnownHosts verifies host keys against an OpenSSH known_hosts file.
+type KnownHosts struct {
+	Path string
+	Mode string
+
+	mutex sync.Mutex
+	hosts []knownHost
+}
+
+// Loads the known_hosts file. A missing file is taken as empty.
+func LoadKnownHosts(path string, mode string) (*KnownHosts, error) {
+	switch mode {
+	case StrictHostKeys, AcceptNewHostKeys, InsecureHostKeys:
+	default:
+		return nil, fmt.Errorf("Unknown host key checking mode '%s'", mode)
+	}
+	knownHosts := &KnownHosts{Path: path, Mode: mode}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return knownHosts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		host := knownHost{}
+		if strings.HasPrefix(fields[0], "@") {
+			if fields[0] != "@revoked" {
+				// Certificate authorities aren't supported
+				continue
+			}
+			host.revoked = true
+			fields = fields[1:]
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected hosts, key type and key", path, line)
+		}
+		host.patterns = strings.Split(fields[0], ",")
+		if host.key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(strings.Join(fields[1:], " "))); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		knownHosts.hosts = append(knownHosts.hosts, host)
+	}
+	return knownHosts, scanner.Err()
+}
+
+// Returns the host the way known_hosts refers to it, with the port only
+// when it isn't the default one.
+func knownHostName(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if port == "22" {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+func matchesHashed(pattern string, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)) == parts[3]
+}
+
+// Patterns only have * and ? wildcards, brackets are part of [host]:port
+var bracketEscaper = strings.NewReplacer("[", "\\[", "]", "\\]", "\\", "\\\\")
+
+func (host *knownHost) matches(name string) bool {
+	matched := false
+	for _, pattern := range host.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		var ok bool
+		if strings.HasPrefix(pattern, "|") {
+			ok = matchesHashed(pattern, name)
+		} else {
+			ok, _ = path.Match(bracketEscaper.Replace(pattern), name)
+		}
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// Checks the key the host presented. Keys of a type the host has no known
+// key for are treated like unknown hosts.
+func (knownHosts *KnownHosts) check(address string, key ssh.PublicKey) error {
+	if knownHosts.Mode == InsecureHostKeys {
+		return nil
+	}
+	name := knownHostName(address)
+	knownHosts.mutex.Lock()
+	defer knownHosts.mutex.Unlock()
+
+	var expected ssh.PublicKey
+	for _, host := range knownHosts.hosts {
+		if !host.matches(name) {
+			continue
+		}
+		same := bytes.Equal(host.key.Marshal(), key.Marshal())
+		if host.revoked && same {
+			return fmt.Errorf("SECURITY: host key for %s is revoked", name)
+		}
+		if host.revoked {
+			continue
+		}
+		if same {
+			return nil
+		}
+		if host.key.Type() == key.Type() {
+			expected = host.key
+		}
+	}
+	if expected != nil {
+		return &HostKeyMismatchError{name, Fingerprint(expected), Fingerprint(key)}
+	}
+	if knownHosts.Mode == StrictHostKeys {
+		return fmt.Errorf("Host key for %s (%s) isn't known, add it to %s or use -strict-host-key-checking=accept-new",
+			name, Fingerprint(key), knownHosts.Path)
+	}
+	return knownHosts.record(name, key)
+}
+
+// Appends the new host key to the known_hosts file
+func (knownHosts *KnownHosts) record(name string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(knownHosts.Path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(knownHosts.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append([]byte(name+" "), ssh.MarshalAuthorizedKey(key)...)); err != nil {
+		return err
+	}
+	knownHosts.hosts = append(knownHosts.hosts, knownHost{patterns: []string{name}, key: key})
+	log.Printf("Added the host key for %s (%s) to %s\n", name, Fingerprint(key), knownHosts.Path)
+	return nil
+}
+
+// Returns the callback to set as the ssh.ClientConfig's HostKeyCallback
+func (knownHosts *KnownHosts) Callback() func(string, net.Addr, ssh.PublicKey) error {
+	return func(address string, _ net.Addr, key ssh.PublicKey) error {
+		return knownHosts.check(address, key)
+	}
+}
@@ -0,0 +1,112 @@
+package henchman
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func hashedHost(host string) string {
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func otherTestKey() ssh.PublicKey {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	key, err := ssh.NewPublicKey(&private.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func TestKnownHosts(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey))
+	if err != nil {
+		panic(err)
+	}
+	other := otherTestKey()
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "known_hosts")
+	contents := "# comment\n" +
+		"db01,10.0.0.1 " + testPublicKey + "\n" +
+		hashedHost("[cache01]:2222") + " " + testPublicKey + "\n" +
+		"web01 " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(other))) + "\n" +
+		"@cert-authority *.example.com " + testPublicKey + "\n"
+	ioutil.WriteFile(path, []byte(contents), 0600)
+
+	knownHosts, err := LoadKnownHosts(path, StrictHostKeys)
+	if err != nil {
+		t.Fatalf("Couldn't load the known hosts. Got %s\n", err)
+	}
+	callback := knownHosts.Callback()
+	if err := callback("db01:22", nil, key); err != nil {
+		t.Errorf("The known key should have been accepted. Got %s\n", err)
+	}
+	if err := callback("cache01:2222", nil, key); err != nil {
+		t.Errorf("Hashed hosts with ports should match. Got %s\n", err)
+	}
+	err = callback("web01:22", nil, key)
+	if _, mismatch := err.(*HostKeyMismatchError); !mismatch {
+		t.Errorf("A changed host key should be rejected. Got %v\n", err)
+	}
+	if err := callback("new01:22", nil, key); err == nil {
+		t.Errorf("Unknown hosts should be rejected in strict mode\n")
+	}
+}
+
+func TestKnownHostsAcceptNew(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey))
+	if err != nil {
+		panic(err)
+	}
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ssh", "known_hosts")
+
+	knownHosts, err := LoadKnownHosts(path, AcceptNewHostKeys)
+	if err != nil {
+		t.Fatalf("A missing known_hosts file should be fine. Got %s\n", err)
+	}
+	if err := knownHosts.Callback()("new01:2222", nil, key); err != nil {
+		t.Errorf("Unknown hosts should be accepted. Got %s\n", err)
+	}
+	buf, _ := ioutil.ReadFile(path)
+	if !strings.HasPrefix(string(buf), "[new01]:2222 ssh-rsa ") {
+		t.Errorf("The new key should have been recorded. Got %s\n", buf)
+	}
+
+	reloaded, err := LoadKnownHosts(path, StrictHostKeys)
+	if err != nil {
+		t.Fatalf("Couldn't reload the known hosts. Got %s\n", err)
+	}
+	if err := reloaded.Callback()("new01:2222", nil, key); err != nil {
+		t.Errorf("The recorded key should be known. Got %s\n", err)
+	}
+	if err := reloaded.Callback()("new01:2222", nil, otherTestKey()); err == nil {
+		t.Errorf("Keys other than the recorded one should be rejected\n")
+	}
+
+	insecure, _ := LoadKnownHosts(path, InsecureHostKeys)
+	if err := insecure.Callback()("new01:2222", nil, otherTestKey()); err != nil {
+		t.Errorf("Insecure mode should accept any key. Got %s\n", err)
+	}
+	if _, err := LoadKnownHosts(path, "maybe"); err == nil {
+		t.Errorf("Unknown modes should be rejected\n")
+	}
+}
@@ -0,0 +1,118 @@
+package henchman
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// Returns the comma separated glob patterns with the ones naming an
+// inventory group replaced by the group's hosts
+func hostPatterns(spec string, kind string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(spec, ",") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Bad %s pattern '%s'", kind, pattern)
+		}
+		if DefaultInventory != nil {
+			if _, isGroup := DefaultInventory.Groups[pattern]; isGroup {
+				patterns = append(patterns, DefaultInventory.Resolve([]string{pattern})...)
+				continue
+			}
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// Whether the host, with or without its port, matches one of the patterns
+func matchesHost(patterns []string, host string) bool {
+	hostname := strings.Split(host, ":")[0]
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, hostname); matched || pattern == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Restricts the hosts to the ones matching the comma separated glob patterns,
+// e.g. 'web-*,db-01'. Patterns are matched against the hostname without the
+// port, and patterns naming an inventory group match the group's hosts.
+func LimitHosts(hosts []string, limit string) ([]string, error) {
+	patterns, err := hostPatterns(limit, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	var limited []string
+	for _, host := range hosts {
+		if matchesHost(patterns, host) {
+			limited = append(limited, host)
+		}
+	}
+	if len(limited) == 0 {
+		return nil, fmt.Errorf("No hosts match the limit '%s'", limit)
+	}
+	return limited, nil
+}
+
+// Reads the patterns listed in the file, one per line. Blank lines and
+// lines starting with '#' are skipped.
+func readPatterns(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// Drops the hosts matching the comma separated patterns, which are matched
+// like LimitHosts' are. Entries starting with '@' name a file listing more
+// patterns, e.g. '@downhosts.txt,db-03'.
+func ExcludeHosts(hosts []string, exclude string) ([]string, error) {
+	var entries []string
+	for _, entry := range strings.Split(exclude, ",") {
+		if !strings.HasPrefix(entry, "@") {
+			entries = append(entries, entry)
+			continue
+		}
+		listed, err := readPatterns(entry[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read the excluded hosts: %s", err)
+		}
+		entries = append(entries, listed...)
+	}
+	if len(entries) == 0 {
+		return hosts, nil
+	}
+	patterns, err := hostPatterns(strings.Join(entries, ","), "exclude")
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []string
+	for _, host := range hosts {
+		if matchesHost(patterns, host) {
+			log.Printf("Excluding %s\n", host)
+			continue
+		}
+		kept = append(kept, host)
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("No hosts left after excluding '%s'", exclude)
+	}
+	return kept, nil
+}
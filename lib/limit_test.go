@@ -0,0 +1,54 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLimitHosts(t *testing.T) {
+	hosts := []string{"web-01", "web-02:2222", "db-01", "db-02"}
+	limited, err := LimitHosts(hosts, "web-*,db-01")
+	if err != nil {
+		t.Fatalf("Couldn't limit the hosts: %s\n", err)
+	}
+	if !reflect.DeepEqual(limited, []string{"web-01", "web-02:2222", "db-01"}) {
+		t.Errorf("Limited hosts mismatch. Got %v\n", limited)
+	}
+	if _, err := LimitHosts(hosts, "cache-*"); err == nil {
+		t.Errorf("Expected a limit matching nothing to fail\n")
+	}
+	if _, err := LimitHosts(hosts, "web-["); err == nil {
+		t.Errorf("Expected a bad pattern to fail\n")
+	}
+
+	DefaultInventory = &Inventory{Groups: map[string][]string{"databases": {"db-02"}}}
+	defer func() { DefaultInventory = nil }()
+	if limited, _ := LimitHosts(hosts, "databases"); !reflect.DeepEqual(limited, []string{"db-02"}) {
+		t.Errorf("Expected groups to limit to their hosts. Got %v\n", limited)
+	}
+}
+
+func TestExcludeHosts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	down := filepath.Join(dir, "downhosts.txt")
+	ioutil.WriteFile(down, []byte("# Paged at 3am\nweb-02\n\ndb-*\n"), 0644)
+
+	hosts := []string{"web-01", "web-02:2222", "web-03", "db-01", "db-02"}
+	kept, err := ExcludeHosts(hosts, "@"+down+",web-03")
+	if err != nil {
+		t.Fatalf("Couldn't exclude the hosts: %s\n", err)
+	}
+	if !reflect.DeepEqual(kept, []string{"web-01"}) {
+		t.Errorf("Excluded hosts mismatch. Got %v\n", kept)
+	}
+	if _, err := ExcludeHosts(hosts, "*"); err == nil {
+		t.Errorf("Expected excluding every host to fail\n")
+	}
+	if _, err := ExcludeHosts(hosts, "@"+filepath.Join(dir, "missing.txt")); err == nil {
+		t.Errorf("Expected a missing exclude file to fail\n")
+	}
+}
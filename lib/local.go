@@ -0,0 +1,43 @@
+package henchman
+
+import (
+	"io"
+	"log"
+	"os/exec"
+	"runtime"
+)
+
+// Connection host var value for hosts that are the control machine itself
+const LocalConnection = "local"
+
+// Local runs actions on the control machine with os/exec, so that local
+// actions and hosts with connection=local don't need an sshd. Actions run
+// in the shell, cmd on Windows.
+type Local struct{}
+
+func (local *Local) Name() string {
+	return LocalConnection
+}
+
+func (local *Local) Run(machine *Machine, action string, stdin io.Reader) (*Output, error) {
+	b := NewOutput()
+	defer b.Close()
+	log.Printf("Machines and action: %s\n", action)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", action)
+	} else {
+		cmd = exec.Command("sh", "-c", action)
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = b
+	cmd.Stderr = b
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return b, err
+	}
+	err := machine.command(cmd.Wait, func() error {
+		return killProcessGroup(cmd)
+	})
+	return b, err
+}
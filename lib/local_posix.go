@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package henchman
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Starts the command in a process group of its own, see killProcessGroup
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Kills the command along with the processes it started, which would
+// otherwise keep its output open
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
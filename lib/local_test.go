@@ -0,0 +1,49 @@
+package henchman
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalConnection(t *testing.T) {
+	machine := &Machine{Hostname: "127.0.0.1", Port: 22, Vars: TaskVars{"ansible_connection": "local"}}
+	if err := ApplyHostSettings([]*Machine{machine}); err != nil {
+		t.Fatalf("Couldn't apply the host settings: %s\n", err)
+	}
+	if !machine.isLocal() {
+		t.Fatalf("Expected connection=local hosts to be local\n")
+	}
+	out, err := machine.run("tr a-z A-Z | sed 's/$/ done/'", strings.NewReader("input\n"))
+	if err != nil {
+		t.Fatalf("Couldn't run locally: %s\n", err)
+	}
+	if out.String() != "INPUT done\n" {
+		t.Errorf("Output mismatch. Got %q\n", out.String())
+	}
+	if _, err := machine.run("exit 4", nil); err == nil {
+		t.Errorf("Expected a failing action to be an error\n")
+	} else if status, ok := exitStatus(err); !ok || status != 4 {
+		t.Errorf("Expected exit status 4. Got %v\n", err)
+	}
+	if _, _, err := machine.copyFile(&CopyFile{Src: "local_test.go", Dest: "/tmp/x"}, nil); err != errLocalCopy {
+		t.Errorf("Expected copies to local hosts to be refused. Got %v\n", err)
+	}
+}
+
+func TestLocalActionRenderedForHost(t *testing.T) {
+	web1 := &Machine{Hostname: "web1", Port: 22, Vars: TaskVars{"app": "shop"}}
+	web1.Register(&Task{Register: "deployed"}, &TaskStatus{Status: StatusChanged, Changed: true, Message: "v2"}, nil)
+	localhost := &Machine{Hostname: "127.0.0.1", Transport: &Local{}, OnBehalfOf: web1}
+	task := Task{
+		Name:   "Notify",
+		Action: "echo {{ henchman_host }} {{ app }} {{ deployed.stdout }}",
+		When:   "deployed.changed",
+	}
+	status, err := task.Run(localhost, &TaskVars{})
+	if err != nil || status.Status != StatusOk {
+		t.Fatalf("Expected the local action to run. Got %v, %v\n", status, err)
+	}
+	if strings.TrimSpace(status.Message) != "web1 shop v2" {
+		t.Errorf("Expected the local action to be rendered for web1. Got %q\n", status.Message)
+	}
+}
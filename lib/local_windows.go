@@ -0,0 +1,10 @@
+package henchman
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// Kills the command. The processes it started are left alone.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,71 @@
+package henchman
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directories, relative to the plan, searched in order for files of each
+// kind. Scripts and other task sources are looked up under files/ and
+// templates under templates/, before falling back to the plan directory.
+var lookupDirs = map[string][]string{
+	"files":     {"files", "."},
+	"templates": {"templates", "."},
+}
+
+// Finds the file of the given kind. Absolute paths are used as they are,
+// relative ones are looked up in the plan's lookup directories and never
+// against the current directory.
+func (plan *Plan) Lookup(kind string, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	var tried []string
+	for _, dir := range lookupDirs[kind] {
+		candidate := filepath.Join(plan.Dir, dir, filepath.FromSlash(name))
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+		tried = append(tried, candidate)
+	}
+	return "", fmt.Errorf("Couldn't find '%s', looked in %s", name, strings.Join(tried, ", "))
+}
+
+// Returns the local files the task refers to
+func (task *Task) localFiles() []*string {
+	var files []*string
+	if task.Script != "" {
+		files = append(files, &task.Script)
+	}
+	if task.Copy != nil {
+		files = append(files, &task.Copy.Src)
+	}
+	if task.Template != nil {
+		files = append(files, &task.Template.Src)
+	}
+	return files
+}
+
+// Resolves the local files the tasks refer to, so that missing files are
+// reported before anything runs.
+func (plan *Plan) ResolveFiles() error {
+	for _, task := range plan.allTasks() {
+		for _, file := range task.localFiles() {
+			kind := "files"
+			if task.Template != nil && file == &task.Template.Src {
+				kind = "templates"
+			}
+			found, err := plan.Lookup(kind, *file)
+			if err != nil {
+				return fmt.Errorf("Task '%s': %s", task.Name, err)
+			}
+			*file = found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(path.Join(dir, "files", "scripts"), 0755)
+	os.MkdirAll(path.Join(dir, "scripts"), 0755)
+	os.Mkdir(path.Join(dir, "templates"), 0755)
+	ioutil.WriteFile(path.Join(dir, "files", "scripts", "setup.sh"), []byte("echo files\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "scripts", "setup.sh"), []byte("echo plan\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "scripts", "hello.sh"), []byte("echo hello\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "templates", "report.tmpl"), []byte("{{ .Plan }}\n"), 0644)
+
+	plan := Plan{Dir: dir}
+	lookups := map[string]string{
+		"scripts/setup.sh": path.Join(dir, "files", "scripts", "setup.sh"),
+		"scripts/hello.sh": path.Join(dir, "scripts", "hello.sh"),
+	}
+	for name, expected := range lookups {
+		if found, err := plan.Lookup("files", name); found != expected {
+			t.Errorf("Lookup mismatch for %s. Got %s (%v) instead of %s\n", name, found, err, expected)
+		}
+	}
+	if found, _ := plan.Lookup("templates", "report.tmpl"); found != path.Join(dir, "templates", "report.tmpl") {
+		t.Errorf("Template lookup mismatch. Got %s\n", found)
+	}
+	if _, err := plan.Lookup("files", "report.tmpl"); err == nil {
+		t.Errorf("Templates shouldn't be found as files\n")
+	}
+	absolute := path.Join(dir, "scripts", "hello.sh")
+	if found, _ := plan.Lookup("files", absolute); found != absolute {
+		t.Errorf("Absolute paths should be used as they are. Got %s\n", found)
+	}
+
+	plan.Tasks = []Task{{Name: "setup", Script: "scripts/setup.sh"}, {Name: "uname", Action: "uname -a"}}
+	if err := plan.ResolveFiles(); err != nil {
+		t.Fatalf("Couldn't resolve the task files: %s\n", err)
+	}
+	if plan.Tasks[0].Script != lookups["scripts/setup.sh"] {
+		t.Errorf("Script wasn't resolved. Got %s\n", plan.Tasks[0].Script)
+	}
+	plan.Tasks = []Task{{Name: "missing", Script: "scripts/missing.sh"}}
+	if err := plan.ResolveFiles(); err == nil {
+		t.Errorf("Expected a missing script to fail\n")
+	}
+}
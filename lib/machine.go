@@ -1,11 +1,11 @@
 package henchman
 
 import (
-	"bytes"
+	"io"
 	"log"
-	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 
 	"code.google.com/p/go.crypto/ssh"
 )
@@ -14,11 +14,73 @@ type Machine struct {
 	Hostname  string
 	Port      int
 	SSHConfig *ssh.ClientConfig
+
+	// Interpreter used for script tasks. Discovered on first use when empty.
+	Interpreter string
+
+	// Jump hosts, in order, that the connection to the machine is tunnelled through
+	Via []*Machine
+
+	// Socket of the connection broker to run actions through. The machine
+	// is connected to directly when empty.
+	Broker string
+
+	Timeouts  Timeouts
+	Reconnect Reconnect
+
+	// Keyfile authenticated with instead of the plan's credentials and the
+	// fingerprint the host key is pinned to, if any. See ApplyHostSettings
+	// and PinHostKeys.
+	Keyfile string
+	HostKey string
+
+	// Variables layered over the plan's vars for this machine only
+	Vars TaskVars
+
+	// Host that the machine runs local actions for. Its vars, facts and
+	// registered results are the ones the actions are rendered with, see
+	// renderedFor.
+	OnBehalfOf *Machine
+
+	// Groups the machine was put in during the run, see GroupByFacts
+	Groups []string
+
+	// Records the actions instead of running them when set, see Attach
+	Noop *NoopTransport
+
+	// Runs the actions instead of SSH when set, see ApplyHostSettings
+	Transport Transport
+
+	// Connection reused by the actions run on the machine, see Close
+	mutex  sync.Mutex
+	client *ssh.Client
+
+	// Closed when the machine's task is cancelled, see Cancel
+	cancelMutex sync.Mutex
+	cancel      chan struct{}
+}
+
+// Transport runs actions on machines that aren't connected to over SSH
+type Transport interface {
+	Name() string
+	Run(machine *Machine, action string, stdin io.Reader) (*Output, error)
+}
+
+// Transports of the connection types henchman doesn't know, keyed by the
+// connection host var's value, e.g. registered by programs embedding
+// henchman. See ApplyHostSettings.
+var Transports = map[string]func(machine *Machine) (Transport, error){}
+
+var terminalModes = ssh.TerminalModes{
+	ECHO:          0,
+	TTY_OP_ISPEED: 14400,
+	TTY_OP_OSPEED: 14400,
 }
 
+// Returns the machines for the hostnames, expanding inventory groups
 func Machines(hostnames []string, config *ssh.ClientConfig) []*Machine {
 	var machines []*Machine
-	for _, hostname := range hostnames {
+	for _, hostname := range DefaultInventory.Resolve(hostnames) {
 		port := 22
 		hostname_port := strings.Split(hostname, ":")
 		if len(hostname_port) == 2 {
@@ -28,48 +90,89 @@ func Machines(hostnames []string, config *ssh.ClientConfig) []*Machine {
 				panic(err)
 			}
 		}
-		m := Machine{hostname_port[0], port, config}
+		m := Machine{Hostname: hostname_port[0], Port: port, SSHConfig: config, Vars: DefaultInventory.Vars(hostname)}
 		machines = append(machines, &m)
 	}
 	return machines
 }
 
+// Returns the host that templates and conditions run on the machine are
+// rendered for. That's another host for local actions.
+func (machine *Machine) renderedFor() *Machine {
+	if machine != nil && machine.OnBehalfOf != nil {
+		return machine.OnBehalfOf
+	}
+	return machine
+}
+
+func (machine *Machine) address() string {
+	return machine.Hostname + ":" + strconv.Itoa(machine.Port)
+}
+
+// Opens a new SSH connection to the machine, once.
+func (machine *Machine) dialOnce() (*ssh.Client, error) {
+	if len(machine.Via) > 0 {
+		return machine.dialVia()
+	}
+	conn, err := machine.connect()
+	if err != nil {
+		return nil, err
+	}
+	return machine.handshake(conn)
+}
+
 // Exec this action on the machine
 // TODO: Handle modules here
-func (machine *Machine) Exec(action string) (*bytes.Buffer, error) {
-
-	var b bytes.Buffer
-
-	if machine.Hostname == "127.0.0.1" && machine.Port == 0 {
-		log.Printf("Machines and action: %s\n", action)
-		commands := strings.Split(action, " ")
-		cmd := exec.Command(commands[0], commands[1:]...)
-		cmd.Stdout = &b
-		cmd.Stderr = &b
-		err := cmd.Run()
-		return &b, err
+func (machine *Machine) Exec(action string) (*Output, error) {
+	return machine.run(action, nil)
+}
+
+func (machine *Machine) isLocal() bool {
+	if _, local := machine.Transport.(*Local); local {
+		return true
 	}
+	return machine.Hostname == "127.0.0.1" && machine.Port == 0
+}
 
-	client, err := ssh.Dial("tcp", machine.Hostname+":"+strconv.Itoa(machine.Port), machine.SSHConfig)
-	if err != nil {
-		log.Fatalf("Failed to dial: " + err.Error())
+// Runs the action feeding it stdin, if any. A pseudo terminal is only
+// requested for remote actions without stdin since the pty would otherwise
+// swallow the end of the input.
+func (machine *Machine) run(action string, stdin io.Reader) (*Output, error) {
+	if machine.Noop != nil {
+		return machine.Noop.run(machine, action, stdin)
 	}
-	session, err := client.NewSession()
+	if machine.Transport != nil {
+		return machine.Transport.Run(machine, action, stdin)
+	}
+
+	if machine.isLocal() {
+		return (&Local{}).Run(machine, action, stdin)
+	}
+
+	b := NewOutput()
+	defer b.Close()
+
+	if machine.Broker != "" {
+		out, err := machine.runViaBroker(action, stdin)
+		if err != errBrokerUnavailable {
+			return out, err
+		}
+		log.Printf("Broker unavailable, connecting to %s directly\n", machine.Hostname)
+	}
+
+	session, err := machine.openSession()
 	if err != nil {
-		log.Fatalf("Unable to create session: " + err.Error())
+		return b, err
 	}
 	defer session.Close()
-	defer client.Close()
 
-	modes := ssh.TerminalModes{
-		ECHO:          0,
-		TTY_OP_ISPEED: 14400,
-		TTY_OP_OSPEED: 14400,
-	}
-	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
-		log.Fatalf("request for pseudo terminal failed: " + err.Error())
+	if stdin == nil {
+		if err := session.RequestPty("xterm", 80, 40, terminalModes); err != nil {
+			return b, err
+		}
 	}
-	session.Stdout = &b
-	session.Stderr = &b
-	return &b, session.Run(action)
+	session.Stdin = stdin
+	session.Stdout = b
+	session.Stderr = b
+	return b, machine.runSession(session, action)
 }
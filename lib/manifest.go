@@ -0,0 +1,130 @@
+package henchman
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Version of henchman, recorded in run manifests. Release builds set it with
+// -ldflags "-X github.com/sudharsh/henchman/lib.Version=<version>".
+var Version = "dev"
+
+// Names of vars whose values are kept out of manifests
+var secretVarPattern = regexp.MustCompile(`(?i)pass(word|wd|phrase)?|secret|token|api_?key|private_?key|credential`)
+
+const redacted = "[redacted]"
+
+// Manifest records what a run was about to do before it did it: the plan
+// as it was on disk, the hosts it resolved to along with their vars, and
+// the henchman that ran it. Secrets are redacted.
+type Manifest struct {
+	// The run's id, which reports refer to the manifest by
+	Id       string         `json:"id"`
+	Created  time.Time      `json:"created"`
+	Version  string         `json:"henchman_version"`
+	Plan     string         `json:"plan"`
+	PlanFile string         `json:"plan_file"`
+	PlanHash string         `json:"plan_sha256"`
+	Vars     TaskVars       `json:"vars"`
+	Hosts    []ManifestHost `json:"hosts"`
+}
+
+type ManifestHost struct {
+	Host       string   `json:"host"`
+	Port       int      `json:"port"`
+	User       string   `json:"user,omitempty"`
+	Connection string   `json:"connection"`
+	Groups     []string `json:"groups,omitempty"`
+	Vars       TaskVars `json:"vars,omitempty"`
+}
+
+// Returns the manifest of the run of the plan read from planFile, whose
+// content is planBuf, on the machines
+func NewManifest(run *Run, plan *Plan, planFile string, planBuf []byte, machines []*Machine) *Manifest {
+	hash := sha256.Sum256(planBuf)
+	manifest := &Manifest{
+		Id:       run.Id,
+		Created:  time.Now(),
+		Version:  Version,
+		Plan:     plan.Name,
+		PlanFile: planFile,
+		PlanHash: hex.EncodeToString(hash[:]),
+	}
+	if plan.Vars != nil {
+		manifest.Vars = redactVars(*plan.Vars)
+	}
+	for _, machine := range machines {
+		host := ManifestHost{
+			Host:       machine.Hostname,
+			Port:       machine.Port,
+			Connection: "ssh",
+			Groups:     machine.Groups,
+			Vars:       redactVars(machine.Vars),
+		}
+		if machine.SSHConfig != nil {
+			host.User = machine.SSHConfig.User
+		}
+		if machine.Transport != nil {
+			host.Connection = machine.Transport.Name()
+		}
+		manifest.Hosts = append(manifest.Hosts, host)
+	}
+	return manifest
+}
+
+// Returns a copy of the vars with the values of secret looking names
+// replaced, in nested maps too
+func redactVars(vars TaskVars) TaskVars {
+	if vars == nil {
+		return nil
+	}
+	copied := make(TaskVars)
+	for name, value := range vars {
+		if secretVarPattern.MatchString(name) {
+			copied[name] = redacted
+			continue
+		}
+		copied[name] = redactValue(jsonValue(value))
+	}
+	return copied
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return map[string]interface{}(redactVars(TaskVars(v)))
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = redactValue(item)
+		}
+		return copied
+	}
+	return value
+}
+
+// Writes the manifest to the path, refusing to overwrite an existing one.
+// The file is read only, manifests aren't meant to change once written.
+func (manifest *Manifest) Write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
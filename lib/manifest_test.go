@@ -0,0 +1,68 @@
+package henchman
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	plan_string := `---
+name: "Deploy"
+hosts:
+  - web1
+vars:
+  release: v1.2.3
+  db_password: hunter2
+  api:
+    url: https://api.example.com
+    token: abc123
+tasks:
+  - name: Echo
+    action: echo {{ vars.release }}
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		t.Fatalf("Couldn't read the plan: %s\n", err)
+	}
+	machines := []*Machine{
+		{Hostname: "web1", Port: 22, Vars: TaskVars{"role": "web", "ssh_pass": "letmein"}},
+		{Hostname: "db1", Port: 22, Transport: &Local{}},
+	}
+	run := NewRun()
+	manifest := NewManifest(run, plan, "deploy.yaml", []byte(plan_string), machines)
+	path := filepath.Join(dir, "manifests", run.Id+".json")
+	if err := manifest.Write(path); err != nil {
+		t.Fatalf("Couldn't write the manifest: %s\n", err)
+	}
+	if err := manifest.Write(path); err == nil {
+		t.Errorf("Expected the manifest not to be overwritten\n")
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	for _, secret := range []string{"hunter2", "abc123", "letmein"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Expected %s to be redacted. Got %s\n", secret, data)
+		}
+	}
+	var written Manifest
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("Couldn't read the manifest back: %s\n", err)
+	}
+	if written.Id != run.Id || len(written.PlanHash) != 64 || written.Version != Version || written.Vars["release"] != "v1.2.3" {
+		t.Errorf("Manifest mismatch. Got %+v\n", written)
+	}
+	if len(written.Hosts) != 2 || written.Hosts[0].Vars["role"] != "web" || written.Hosts[1].Connection != "local" {
+		t.Errorf("Expected the resolved hosts. Got %+v\n", written.Hosts)
+	}
+
+	plan.Manifest = run.Id
+	if report := plan.Report(); report.Manifest != run.Id {
+		t.Errorf("Expected the report to refer to the manifest. Got %s\n", report.Manifest)
+	}
+}
@@ -0,0 +1,267 @@
+package henchman
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v1"
+)
+
+// ModuleDoc describes a module's arguments for plan authors. Modules on the
+// modules path embed it as a YAML block in their leading comments:
+//
+//	# ---
+//	# description: Manages services
+//	# args:
+//	#   name: Name of the service
+//	# ...
+type ModuleDoc struct {
+	Description string
+	Args        map[string]string
+	Example     string
+	// Whether the module honours _check_mode, see ModuleCall
+	CheckMode bool `yaml:"check_mode"`
+}
+
+// Module is a unit of work henchman knows how to run on machines. Modules
+// are either built into henchman or executables found on the modules path.
+type Module struct {
+	Name string
+	// Empty for built-in modules
+	Path string
+	Doc  ModuleDoc
+
+	// Modules of the same name it takes the place of, by path, or
+	// 'built-in'
+	Shadows []string
+}
+
+// Modules implemented by henchman itself, keyed by name. They are run as
+// scripts built by builtinScripts.
+var builtinModules = map[string]ModuleDoc{
+	"service": {
+		Description: "Starts, stops, restarts or reloads a service and enables it at boot, with systemctl or service",
+		Args: map[string]string{
+			"name":    "Name of the service",
+			"state":   "started, stopped, restarted or reloaded",
+			"enabled": "Whether the service starts at boot, needs systemctl",
+		},
+		Example:   "- name: Start nginx\n  module:\n    name: service\n    args:\n      name: nginx\n      state: started\n      enabled: yes\n",
+		CheckMode: true,
+	},
+	"file": {
+		Description: "Makes sure a file or directory exists, or doesn't, with the given mode and owner",
+		Args: map[string]string{
+			"path":  "Path of the file",
+			"state": "file (the default, it has to exist), directory, touch or absent",
+			"mode":  "Mode in octal, e.g. 0644",
+			"owner": "Owner as user or user:group",
+		},
+		Example:   "- name: Create the data directory\n  module:\n    name: file\n    args:\n      path: /srv/app\n      state: directory\n      owner: app:app\n      mode: \"0750\"\n",
+		CheckMode: true,
+	},
+	"user": {
+		Description: "Creates or removes a user. Existing users aren't modified",
+		Args: map[string]string{
+			"name":   "Name of the user",
+			"state":  "present (the default) or absent",
+			"shell":  "Login shell",
+			"home":   "Home directory",
+			"groups": "Comma separated supplementary groups",
+			"system": "Whether it's a system user, which gets no home directory",
+		},
+		Example:   "- name: Add the app user\n  module:\n    name: user\n    args:\n      name: app\n      system: yes\n",
+		CheckMode: true,
+	},
+	"package": {
+		Description: "Installs or removes packages with apt, dnf or yum",
+		Args: map[string]string{
+			"name":    "Package, or list of packages",
+			"state":   "present (the default) or absent",
+			"manager": "apt, dnf or yum. Detected when unset",
+		},
+		Example:   "- name: Install nginx\n  module:\n    name: package\n    args:\n      name: [nginx, curl]\n",
+		CheckMode: true,
+	},
+	"sudoers": {
+		Description: "Manages a drop-in under /etc/sudoers.d, checked with visudo before it replaces the current one",
+		Args: map[string]string{
+			"name":    "Name of the drop-in, letters, digits, - and _ only",
+			"content": "Rules of the drop-in, as a string or list of lines",
+			"state":   "present (the default) or absent",
+		},
+		Example:   "- name: Let deploy restart the app\n  module:\n    name: sudoers\n    args:\n      name: deploy\n      content: \"deploy ALL=(root) NOPASSWD: /bin/systemctl restart app\"\n",
+		CheckMode: true,
+	},
+	"verify_checksum": {
+		Description: "Fails unless a file matches its sha256 or sha512 digest. Nothing is changed",
+		Args: map[string]string{
+			"path":   "Path of the file",
+			"sha256": "Expected digest, or the URL of a checksum file like app.tar.gz.sha256",
+			"sha512": "Same as sha256, for sha512 digests",
+		},
+		Example:   "- name: Verify the release\n  module:\n    name: verify_checksum\n    args:\n      path: /srv/app/app.tar.gz\n      sha256: https://releases.example.com/app.tar.gz.sha256\n",
+		CheckMode: true,
+	},
+}
+
+// Extracts the documentation block from a module's leading comments
+func parseModuleDoc(source []byte) (ModuleDoc, error) {
+	var doc ModuleDoc
+	var block []string
+	inBlock := false
+	scanner := bufio.NewScanner(strings.NewReader(string(source)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var comment string
+		switch {
+		case strings.HasPrefix(line, "#"):
+			comment = strings.TrimPrefix(line, "#")
+		case strings.HasPrefix(line, "//"):
+			comment = strings.TrimPrefix(line, "//")
+		default:
+			continue
+		}
+		comment = strings.TrimPrefix(comment, " ")
+		if !inBlock {
+			inBlock = comment == "---"
+			continue
+		}
+		if comment == "..." {
+			break
+		}
+		block = append(block, comment)
+	}
+	if len(block) == 0 {
+		return doc, nil
+	}
+	err := yaml.Unmarshal([]byte(strings.Join(block, "\n")), &doc)
+	return doc, err
+}
+
+func loadModule(modulesDir string, info os.FileInfo) (*Module, error) {
+	modulePath := filepath.Join(modulesDir, info.Name())
+	source, err := ioutil.ReadFile(modulePath)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseModuleDoc(source)
+	if err != nil {
+		return nil, fmt.Errorf("Bad documentation in module %s: %s", modulePath, err)
+	}
+	name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+	return &Module{Name: name, Path: modulePath, Doc: doc}, nil
+}
+
+// Modules are executables. Windows has no executable bit, so any file that
+// isn't a README is taken for a module there.
+func isModuleFile(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return !strings.HasPrefix(strings.ToUpper(info.Name()), "README")
+	}
+	return info.Mode().Perm()&0111 != 0
+}
+
+// Returns the built-in modules along with the executables in the modules
+// path, a list of directories like $PATH, sorted by name. A module shadows
+// the ones of the same name in the directories after its own, and the
+// built-in one.
+func DiscoverModules(modulesPath string) ([]*Module, error) {
+	found := make(map[string]*Module)
+	for _, modulesDir := range filepath.SplitList(modulesPath) {
+		infos, err := ioutil.ReadDir(modulesDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if !isModuleFile(info) {
+				continue
+			}
+			module, err := loadModule(modulesDir, info)
+			if err != nil {
+				return nil, err
+			}
+			if first, present := found[module.Name]; present {
+				first.Shadows = append(first.Shadows, module.Path)
+				continue
+			}
+			found[module.Name] = module
+		}
+	}
+	for name, doc := range builtinModules {
+		if module, present := found[name]; present {
+			module.Shadows = append(module.Shadows, "built-in")
+			continue
+		}
+		found[name] = &Module{Name: name, Doc: doc}
+	}
+
+	var names []string
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var modules []*Module
+	for _, name := range names {
+		modules = append(modules, found[name])
+	}
+	return modules, nil
+}
+
+// Looks up a single module by name
+func FindModule(modulesPath string, name string) (*Module, error) {
+	modules, err := DiscoverModules(modulesPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, module := range modules {
+		if module.Name == name {
+			return module, nil
+		}
+	}
+	return nil, fmt.Errorf("No module named '%s'", name)
+}
+
+// Prints the module's documentation
+func (module *Module) PrintDoc() {
+	source := "built-in"
+	if module.Path != "" {
+		source = module.Path
+	}
+	fmt.Printf("%s (%s)\n", module.Name, source)
+	for _, shadowed := range module.Shadows {
+		fmt.Printf("  shadows %s\n", shadowed)
+	}
+	if module.Doc.Description != "" {
+		fmt.Printf("\n  %s\n", module.Doc.Description)
+	}
+	if module.Doc.CheckMode {
+		fmt.Printf("\n  Supports check mode\n")
+	}
+	if len(module.Doc.Args) > 0 {
+		var args []string
+		for arg := range module.Doc.Args {
+			args = append(args, arg)
+		}
+		sort.Strings(args)
+		fmt.Println("\nArguments:")
+		for _, arg := range args {
+			fmt.Printf("  %s\t%s\n", arg, module.Doc.Args[arg])
+		}
+	}
+	if module.Doc.Example != "" {
+		fmt.Println("\nExample:")
+		for _, line := range strings.Split(strings.TrimRight(module.Doc.Example, "\n"), "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+}
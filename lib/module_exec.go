@@ -0,0 +1,220 @@
+package henchman
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Directory, relative to the remote user's home, modules are uploaded to
+const remoteModulesDir = ".henchman/modules"
+
+// ModuleCall runs an executable from the modules path on the machine. The
+// module is uploaded to ~/.henchman/modules, once per version, and run with
+// Args as a JSON object on its stdin. It prints a JSON object as its result:
+//
+//	{"changed": true, "failed": false, "msg": "Restarted nginx", "pid": 42}
+//
+// Keys other than changed, failed and msg are facts that later tasks on the
+// machine see as vars.facts.<key>.
+//
+// In check mode, modules documented to support it get "_check_mode": true
+// in their args. They mustn't change anything then and report whether they
+// would have as changed.
+type ModuleCall struct {
+	Name string
+	Args TaskVars
+
+	// Local path of the module, see ResolveModules. Empty for built-in
+	// modules.
+	Path string `yaml:"-"`
+	// Whether the module supports check mode, see ResolveModules
+	CheckMode bool `yaml:"-"`
+}
+
+func (call *ModuleCall) validate() error {
+	if call.Name == "" {
+		return errors.New("module needs a name")
+	}
+	return nil
+}
+
+// Finds the modules the tasks call on the modules path, see
+// DiscoverModules. The modules they shadow are logged.
+func (plan *Plan) ResolveModules(modulesPath string) error {
+	var modules []*Module
+	for _, task := range plan.allTasks() {
+		if task.Module == nil {
+			continue
+		}
+		if modules == nil {
+			var err error
+			if modules, err = DiscoverModules(modulesPath); err != nil {
+				return err
+			}
+		}
+		found := false
+		for _, module := range modules {
+			if module.Name == task.Module.Name {
+				task.Module.Path, found = module.Path, true
+				task.Module.CheckMode = module.Doc.CheckMode
+				if len(module.Shadows) > 0 {
+					log.Printf("Task '%s': using module '%s' from %s, it shadows %s\n", task.Name, module.Name, module.Path, strings.Join(module.Shadows, ", "))
+				}
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Task '%s': no module named '%s' in %s", task.Name, task.Module.Name, modulesPath)
+		}
+	}
+	return nil
+}
+
+// Converts the YAML maps in the value to ones that marshal to JSON
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{})
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return converted
+	case TaskVars:
+		converted := make(map[string]interface{})
+		for key, item := range v {
+			converted[key] = jsonValue(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = jsonValue(item)
+		}
+		return converted
+	}
+	return value
+}
+
+type moduleResult struct {
+	Changed bool
+	Failed  bool
+	Msg     string
+	Facts   TaskVars
+}
+
+// Finds the module's JSON result in its output, which might be preceded by
+// whatever else it printed
+func parseModuleResult(out string) (*moduleResult, error) {
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.Join(lines[i:], "\n")), &fields); err != nil {
+			continue
+		}
+		result := &moduleResult{Facts: make(TaskVars)}
+		for key, value := range fields {
+			switch key {
+			case "changed":
+				result.Changed, _ = value.(bool)
+			case "failed":
+				result.Failed, _ = value.(bool)
+			case "msg":
+				result.Msg = fmt.Sprint(value)
+			default:
+				result.Facts[key] = value
+			}
+		}
+		return result, nil
+	}
+	return nil, errors.New("Module didn't print a JSON result")
+}
+
+// Layers the facts over the ones the machine already has
+func (machine *Machine) addFacts(facts TaskVars) {
+	if len(facts) == 0 {
+		return
+	}
+	merged := make(TaskVars)
+	if existing, ok := machine.Vars["facts"].(TaskVars); ok {
+		mergeMap(&existing, &merged)
+	}
+	mergeMap(&facts, &merged)
+	if machine.Vars == nil {
+		machine.Vars = make(TaskVars)
+	}
+	machine.Vars["facts"] = merged
+}
+
+// Uploads the module unless the machine has it already and runs it,
+// returning its result. Local machines run it from the modules path.
+// Built-in modules are piped to sh instead. In check mode the result is
+// the module's prediction.
+func (machine *Machine) runModule(call *ModuleCall, become *become, check bool) (*moduleResult, string, error) {
+	callArgs := call.Args
+	if check {
+		callArgs = TaskVars{"_check_mode": true}
+		mergeMap(&call.Args, &callArgs)
+	}
+	args, err := json.Marshal(jsonValue(callArgs))
+	if err != nil {
+		return nil, "", err
+	}
+	command := call.Path
+	if call.Path == "" {
+		build, builtin := builtinScripts[call.Name]
+		if !builtin {
+			return nil, "", fmt.Errorf("No module named '%s'", call.Name)
+		}
+		script, err := build(call.Args)
+		if err != nil {
+			return nil, "", err
+		}
+		if check {
+			script = "check_mode=true\n" + script
+		}
+		command, args = "sh -s", []byte(script)
+	} else if !machine.isLocal() {
+		f, err := os.Open(call.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+		if _, err := machine.run("mkdir -p "+remoteModulesDir, nil); err != nil {
+			return nil, "", err
+		}
+		dest := path.Join(remoteModulesDir, filepath.Base(call.Path))
+		if _, _, err := machine.putFile(&CopyFile{Src: call.Path, Dest: dest, Mode: "0755"}, f, "module", nil); err != nil {
+			return nil, "", err
+		}
+		command = shellQuote("./" + dest)
+	}
+	out, err := machine.run(become.wrap(command, bytes.NewReader(args)))
+	if machine.Noop != nil {
+		return &moduleResult{}, "", nil
+	}
+	result, parseErr := parseModuleResult(out.String())
+	if parseErr != nil {
+		if err == nil {
+			err = parseErr
+		}
+		return nil, out.String(), err
+	}
+	if result.Failed {
+		message := result.Msg
+		if message == "" {
+			message = "Module failed"
+		}
+		return result, result.Msg, errors.New(message)
+	}
+	return result, result.Msg, err
+}
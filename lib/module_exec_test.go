@@ -0,0 +1,72 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseModuleResult(t *testing.T) {
+	out := "Restarting nginx...\n{\n  \"changed\": true,\n  \"msg\": \"Restarted nginx\",\n  \"pid\": 4242\n}\n"
+	result, err := parseModuleResult(out)
+	if err != nil {
+		t.Fatalf("Couldn't parse the result: %s\n", err)
+	}
+	if !result.Changed || result.Failed || result.Msg != "Restarted nginx" || result.Facts["pid"] != float64(4242) {
+		t.Errorf("Module result mismatch. Got %+v\n", result)
+	}
+	if _, err := parseModuleResult("Segmentation fault\n"); err == nil {
+		t.Errorf("Expected output without a result to fail\n")
+	}
+}
+
+func TestRunModule(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "service.sh"), []byte("#!/bin/sh\n"), 0755)
+
+	plan := &Plan{Tasks: []Task{{Name: "Restart nginx", Module: &ModuleCall{Name: "service", Args: TaskVars{"name": "nginx"}}}}}
+	if err := plan.ResolveModules(dir); err != nil {
+		t.Fatalf("Couldn't resolve the modules: %s\n", err)
+	}
+	call := plan.Tasks[0].Module
+	if call.Path != filepath.Join(dir, "service.sh") {
+		t.Errorf("Module path mismatch. Got %s\n", call.Path)
+	}
+
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.sftp = &fakeSFTPServer{files: make(map[string][]byte)}
+	var commands []string
+	server.respond = func(command string) string {
+		commands = append(commands, command)
+		if strings.HasPrefix(command, "'./.henchman/modules/service.sh'") {
+			return `{"changed": true, "msg": "Restarted nginx", "pid": 4242}`
+		}
+		return ""
+	}
+	machine := server.machine()
+	defer machine.Close()
+
+	result, message, err := machine.runModule(call, nil, false)
+	if err != nil {
+		t.Fatalf("Couldn't run the module: %s\n", err)
+	}
+	if message != "Restarted nginx" || !result.Changed {
+		t.Errorf("Module result mismatch. Got %s %+v\n", message, result)
+	}
+	if _, uploaded := server.sftp.files[".henchman/modules/service.sh"]; !uploaded {
+		t.Errorf("Expected the module to be uploaded. Got %v\n", commands)
+	}
+	machine.addFacts(result.Facts)
+	if facts, _ := machine.Vars["facts"].(TaskVars); facts["pid"] != float64(4242) {
+		t.Errorf("Expected the module's facts on the machine. Got %v\n", machine.Vars)
+	}
+
+	plan.Tasks[0].Module.Name = "missing"
+	if err := plan.ResolveModules(dir); err == nil {
+		t.Errorf("Expected a missing module to fail\n")
+	}
+}
@@ -0,0 +1,98 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const sampleModule = `#!/bin/sh
+# ---
+# description: Manages services
+# args:
+#   name: Name of the service
+#   state: started or stopped
+# example: |
+#   - name: Restart nginx
+#     module: service name=nginx state=started
+# ...
+echo "not part of the docs"
+`
+
+func TestParseModuleDoc(t *testing.T) {
+	doc, err := parseModuleDoc([]byte(sampleModule))
+	if err != nil {
+		t.Fatalf("Couldn't parse the module doc: %s\n", err)
+	}
+	if doc.Description != "Manages services" {
+		t.Errorf("Module description mismatch. Got %s\n", doc.Description)
+	}
+	if len(doc.Args) != 2 || doc.Args["name"] != "Name of the service" {
+		t.Errorf("Module args mismatch. Got %v\n", doc.Args)
+	}
+	if doc.Example == "" {
+		t.Errorf("Module example should have been parsed\n")
+	}
+}
+
+func TestDiscoverModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "service.sh"), []byte(sampleModule), 0755)
+	ioutil.WriteFile(path.Join(dir, "README"), []byte("Not a module"), 0644)
+
+	modules, err := DiscoverModules(dir)
+	if err != nil {
+		t.Fatalf("Couldn't discover modules: %s\n", err)
+	}
+	module, err := FindModule(dir, "service")
+	if err != nil {
+		t.Fatalf("Couldn't find the service module: %s\n", err)
+	}
+	if module.Path != path.Join(dir, "service.sh") {
+		t.Errorf("Module path mismatch. Got %s\n", module.Path)
+	}
+	for _, m := range modules {
+		if m.Name == "README" {
+			t.Errorf("Non executables shouldn't be discovered as modules\n")
+		}
+	}
+	if _, err := FindModule(dir, "nonexistent"); err == nil {
+		t.Errorf("Finding a nonexistent module should fail\n")
+	}
+}
+
+func TestDiscoverModulesOnPath(t *testing.T) {
+	project, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(project)
+	shared, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(shared)
+	ioutil.WriteFile(path.Join(project, "deploy.sh"), []byte(sampleModule), 0755)
+	ioutil.WriteFile(path.Join(shared, "deploy"), []byte(sampleModule), 0755)
+	ioutil.WriteFile(path.Join(shared, "service"), []byte(sampleModule), 0755)
+	ioutil.WriteFile(path.Join(shared, "backup"), []byte(sampleModule), 0755)
+
+	modulesPath := project + string(filepath.ListSeparator) + shared
+	deploy, err := FindModule(modulesPath, "deploy")
+	if err != nil {
+		t.Fatalf("Couldn't find the deploy module: %s\n", err)
+	}
+	if deploy.Path != path.Join(project, "deploy.sh") || !reflect.DeepEqual(deploy.Shadows, []string{path.Join(shared, "deploy")}) {
+		t.Errorf("Expected the project module to shadow the shared one. Got %+v\n", deploy)
+	}
+	if service, _ := FindModule(modulesPath, "service"); !reflect.DeepEqual(service.Shadows, []string{"built-in"}) {
+		t.Errorf("Expected the shared service module to shadow the built-in one. Got %+v\n", service)
+	}
+	if backup, _ := FindModule(modulesPath, "backup"); backup == nil || backup.Path != path.Join(shared, "backup") {
+		t.Errorf("Expected modules further down the path to be found. Got %+v\n", backup)
+	}
+	if _, err := DiscoverModules(modulesPath + string(filepath.ListSeparator) + path.Join(shared, "missing")); err == nil {
+		t.Errorf("Expected a missing directory on the path to fail\n")
+	}
+}
@@ -0,0 +1,155 @@
+package henchman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Connection host var value for switches, routers and appliances that only
+// offer a CLI over SSH, see ApplyHostSettings
+const NetworkConnection = "network_cli"
+
+var errNetworkTask = errors.New("Network devices only take actions and commands, without sudo, env or sandboxes")
+
+var (
+	// Prompts like switch>, router(config-if)#, admin@fw01> or [edit]
+	defaultCLIPrompt = regexp.MustCompile(`^[\w.\-@:/()\[\] ]*[>#$\]] ?$`)
+	// Pagers asking for a key before showing more output
+	defaultCLIPager = regexp.MustCompile(`(?i)-+ ?more ?-+|<--- more --->|press any key`)
+	// Answers of devices refusing a command
+	defaultCLIErrors = regexp.MustCompile(`(?im)^(% ?(invalid|incomplete|ambiguous|unknown|error|bad)|(syntax )?error:|unknown command).*$`)
+)
+
+// NetworkCLI sends actions to the interactive shell of network devices over
+// the machine's SSH connection, for devices without SFTP or a POSIX shell.
+// Each line of the action is a command sent once the device shows its
+// prompt, paging through long output, and fails the action if the device
+// answers with an error. Nothing is uploaded and no facts are gathered.
+type NetworkCLI struct {
+	// Patterns of the device's prompt, pager and errors, the defaults when
+	// nil
+	Prompt *regexp.Regexp
+	Pager  *regexp.Regexp
+	Errors *regexp.Regexp
+}
+
+func (cli *NetworkCLI) Name() string {
+	return NetworkConnection
+}
+
+func (cli *NetworkCLI) patterns() (prompt, pager, errors *regexp.Regexp) {
+	prompt, pager, errors = defaultCLIPrompt, defaultCLIPager, defaultCLIErrors
+	if cli.Prompt != nil {
+		prompt = cli.Prompt
+	}
+	if cli.Pager != nil {
+		pager = cli.Pager
+	}
+	if cli.Errors != nil {
+		errors = cli.Errors
+	}
+	return
+}
+
+// Reads the device's output up to its next prompt, which is left out,
+// answering pagers with a space
+func (cli *NetworkCLI) readUntilPrompt(r io.Reader, w io.Writer) (string, error) {
+	prompt, pager, _ := cli.patterns()
+	var output bytes.Buffer
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+		output.Write(bytes.Replace(chunk[:n], []byte("\r"), nil, -1))
+		text := output.String()
+		lastLine := text[strings.LastIndex(text, "\n")+1:]
+		if pager.MatchString(lastLine) {
+			output.Truncate(output.Len() - len(lastLine))
+			if _, err := io.WriteString(w, " "); err != nil {
+				return output.String(), err
+			}
+		} else if prompt.MatchString(lastLine) {
+			return text[:len(text)-len(lastLine)], nil
+		}
+		if err != nil {
+			if err == io.EOF {
+				return text, errors.New("The device closed the session before showing its prompt")
+			}
+			return text, err
+		}
+	}
+}
+
+func (cli *NetworkCLI) Run(machine *Machine, action string, stdin io.Reader) (*Output, error) {
+	b := NewOutput()
+	defer b.Close()
+	if stdin != nil {
+		return b, errors.New("Network devices don't take input")
+	}
+	session, err := machine.openSession()
+	if err != nil {
+		return b, err
+	}
+	defer session.Close()
+	// Wide enough for devices not to wrap long lines
+	if err := session.RequestPty("vt100", 24, 511, terminalModes); err != nil {
+		return b, err
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		return b, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return b, err
+	}
+	if err := session.Shell(); err != nil {
+		return b, err
+	}
+	_, _, deviceErrors := cli.patterns()
+	return b, machine.command(func() error {
+		// Skips the banner
+		if _, err := cli.readUntilPrompt(r, w); err != nil {
+			return err
+		}
+		for _, command := range strings.Split(action, "\n") {
+			command = strings.TrimSpace(command)
+			if command == "" {
+				continue
+			}
+			if _, err := io.WriteString(w, command+"\n"); err != nil {
+				return err
+			}
+			output, err := cli.readUntilPrompt(r, w)
+			// Devices echo the command back
+			if lines := strings.SplitN(output, "\n", 2); strings.HasSuffix(strings.TrimSpace(lines[0]), command) {
+				output = ""
+				if len(lines) == 2 {
+					output = lines[1]
+				}
+			}
+			b.Write([]byte(output))
+			if err != nil {
+				return err
+			}
+			if refused := deviceErrors.FindString(output); refused != "" {
+				return fmt.Errorf("'%s' failed: %s", command, strings.TrimSpace(refused))
+			}
+		}
+		return nil
+	}, session.Close)
+}
+
+// Whether the task only sends commands, which is all network devices take
+func (task *Task) networkable(exports string, become *become) bool {
+	return exports == "" && become == nil && task.Sandbox == nil && task.Script == "" &&
+		task.Copy == nil && task.Template == nil && task.Fetch == nil && task.Module == nil
+}
+
+func (machine *Machine) isNetwork() bool {
+	_, network := machine.Transport.(*NetworkCLI)
+	return network
+}
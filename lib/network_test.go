@@ -0,0 +1,85 @@
+package henchman
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// Switch CLI echoing the commands, paging "show running-config" and
+// refusing commands it doesn't know
+func fakeSwitch(received *[]string) func(ssh.Channel) {
+	return func(channel ssh.Channel) {
+		channel.Write([]byte("Welcome to sw01\r\nsw01#"))
+		lines := bufio.NewReader(channel)
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSpace(line)
+			*received = append(*received, command)
+			channel.Write([]byte(command + "\r\n"))
+			switch {
+			case command == "show running-config":
+				channel.Write([]byte("hostname sw01\r\n --More-- "))
+				if _, err := lines.ReadByte(); err != nil {
+					return
+				}
+				channel.Write([]byte("\r\ninterface Gi0/1\r\n"))
+			case strings.HasPrefix(command, "interface"), command == "configure terminal", command == "end":
+			case strings.HasPrefix(command, "description"):
+			default:
+				channel.Write([]byte("% Invalid input detected at '^' marker.\r\n"))
+			}
+			prompt := "sw01#"
+			if command == "configure terminal" {
+				prompt = "sw01(config)#"
+			}
+			channel.Write([]byte(prompt))
+		}
+	}
+}
+
+func TestNetworkCLI(t *testing.T) {
+	var received []string
+	server := newTestSSHServer()
+	defer server.listener.Close()
+	server.shell = fakeSwitch(&received)
+	machine := server.machine()
+	defer machine.Close()
+	machine.Vars = TaskVars{"ansible_connection": "network_cli"}
+	if err := applyConnection(machine); err != nil {
+		t.Fatalf("Couldn't apply the connection: %s\n", err)
+	}
+
+	out, err := machine.Exec("show running-config")
+	if err != nil || out.String() != "hostname sw01\n\ninterface Gi0/1\n" {
+		t.Errorf("Expected the paged output without the echo. Got %q %v\n", out.String(), err)
+	}
+
+	sudo := false
+	task := Task{Name: "Describe uplink", Sudo: &sudo, Commands: []string{"configure terminal", "interface {{ vars.uplink }}", "description uplink", "end"}}
+	vars := TaskVars{"uplink": "Gi0/48"}
+	received = nil
+	if status, err := task.Run(machine, &vars); err != nil || status.Status != "ok" {
+		t.Errorf("Expected the commands to succeed. Got %v %v\n", status, err)
+	}
+	if strings.Join(received, ";") != "configure terminal;interface Gi0/48;description uplink;end" {
+		t.Errorf("Commands mismatch. Got %v\n", received)
+	}
+
+	if _, err := machine.Exec("configure terminal\nshutdown\nend"); err == nil || !strings.Contains(err.Error(), "'shutdown' failed: % Invalid input") {
+		t.Errorf("Expected the refused command to fail the action. Got %v\n", err)
+	}
+
+	task = Task{Name: "Copy config", Sudo: &sudo, Copy: &CopyFile{Src: "startup.cfg", Dest: "/flash/startup.cfg"}}
+	if _, err := task.Run(machine, &vars); err != errNetworkTask {
+		t.Errorf("Expected copies to be refused on network devices. Got %v\n", err)
+	}
+	if facts, err := machine.GatherFacts([]string{"os"}); err != nil || len(facts) != 0 {
+		t.Errorf("Expected no facts from network devices. Got %v %v\n", facts, err)
+	}
+}
@@ -0,0 +1,110 @@
+package henchman
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"sync"
+)
+
+// HostEstimate is what running the plan would take on a host
+type HostEstimate struct {
+	Connections int
+	Commands    int
+	// Bytes of the commands and of what's piped to them, like scripts
+	BytesSent int64
+}
+
+// NoopTransport accepts every action on the machines it's attached to
+// without connecting anywhere and records what would have been run, to
+// estimate a run before committing to it. Actions succeed with no output.
+type NoopTransport struct {
+	mutex sync.Mutex
+	hosts map[string]*HostEstimate
+}
+
+func NewNoopTransport() *NoopTransport {
+	return &NoopTransport{hosts: make(map[string]*HostEstimate)}
+}
+
+// Routes the machine's actions through the transport. Machines without an
+// explicit interpreter get sh since there's nothing to probe.
+func (transport *NoopTransport) Attach(machine *Machine) {
+	machine.Noop = transport
+	if machine.Interpreter == "" {
+		machine.Interpreter = "sh"
+	}
+}
+
+func (transport *NoopTransport) run(machine *Machine, action string, stdin io.Reader) (*Output, error) {
+	var sent int64
+	if stdin != nil {
+		sent, _ = io.Copy(ioutil.Discard, stdin)
+	}
+	transport.record(machine, action, sent)
+	return NewOutput(), nil
+}
+
+// Records the action along with the bytes it would send on top of itself
+func (transport *NoopTransport) record(machine *Machine, action string, sent int64) {
+	log.Printf("Estimating %s: %s\n", machine.Hostname, action)
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	estimate, present := transport.hosts[machine.Hostname]
+	if !present {
+		// The connection is reused for the whole plan, see Machine.connection
+		estimate = &HostEstimate{Connections: 1 + len(machine.Via)}
+		transport.hosts[machine.Hostname] = estimate
+	}
+	estimate.Commands++
+	estimate.BytesSent += int64(len(action)) + sent
+}
+
+// Returns the estimates keyed by host
+func (transport *NoopTransport) Estimates() map[string]HostEstimate {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	estimates := make(map[string]HostEstimate)
+	for host, estimate := range transport.hosts {
+		estimates[host] = *estimate
+	}
+	return estimates
+}
+
+// Prints the estimate of running the plan per host and in total
+func (transport *NoopTransport) PrintEstimate(plan *Plan) {
+	tasks := make(map[string]int)
+	for _, result := range plan.Report().Results {
+		tasks[result.Host]++
+	}
+	estimates := transport.Estimates()
+	var hosts []string
+	for host := range estimates {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	fmt.Println()
+	fmt.Println("---")
+	fmt.Printf("Plan Estimate: %s\n", plan.Name)
+	fmt.Println()
+	fmt.Printf("Host\tTasks\tCommands\tConnections\tBytes sent\n")
+	var total HostEstimate
+	totalTasks := 0
+	for _, host := range hosts {
+		estimate := estimates[host]
+		fmt.Printf("%s\t%d\t%d\t%d\t%d\n", host, tasks[host], estimate.Commands, estimate.Connections, estimate.BytesSent)
+		totalTasks += tasks[host]
+		total.Commands += estimate.Commands
+		total.Connections += estimate.Connections
+		total.BytesSent += estimate.BytesSent
+	}
+	fmt.Println()
+	fmt.Printf("Hosts:\t%d\n", len(hosts))
+	fmt.Printf("Tasks (all hosts):\t%d\n", totalTasks)
+	fmt.Printf("Commands (all hosts):\t%d\n", total.Commands)
+	fmt.Printf("Connections (all hosts):\t%d\n", total.Connections)
+	fmt.Printf("Bytes sent (all hosts):\t%d\n", total.BytesSent)
+}
@@ -0,0 +1,37 @@
+package henchman
+
+import (
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestNoopTransport(t *testing.T) {
+	config := &ssh.ClientConfig{User: "deploy"}
+	machines := Machines([]string{"db01", "web01"}, config)
+	machines[1].Via = Machines([]string{"bastion"}, config)
+	transport := NewNoopTransport()
+	for _, machine := range machines {
+		transport.Attach(machine)
+	}
+
+	if out, err := machines[0].Exec("uptime"); err != nil || out.String() != "" {
+		t.Errorf("Expected actions to succeed without output. Got %v %s\n", err, out)
+	}
+	if _, err := machines[0].ExecScript([]byte("echo hello\n")); err != nil {
+		t.Errorf("Expected scripts to succeed without probing. Got %s\n", err)
+	}
+	statuses, err := machines[1].RunBatch([]Task{{Name: "a", Action: "true"}, {Name: "b", Action: "false"}}, nil)
+	if err != nil || len(statuses) != 2 || statuses[1].Status != "ok" {
+		t.Errorf("Expected every batched step to succeed. Got %v %v\n", statuses, err)
+	}
+
+	estimates := transport.Estimates()
+	db := estimates["db01"]
+	if db.Connections != 1 || db.Commands != 2 || db.BytesSent != int64(len("uptime")+len("sh -s")+len("echo hello\n")) {
+		t.Errorf("Estimate mismatch for db01. Got %+v\n", db)
+	}
+	if web := estimates["web01"]; web.Connections != 2 || web.Commands != 1 {
+		t.Errorf("Expected the bastion connection to be counted for web01. Got %+v\n", web)
+	}
+}
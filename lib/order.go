@@ -0,0 +1,62 @@
+package henchman
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Checks the plan's order, see OrderHosts
+func validateOrder(order string) error {
+	switch order {
+	case "", "inventory", "sorted", "reverse", "shuffle":
+		return nil
+	}
+	if seed := strings.TrimPrefix(order, "shuffle:"); seed != order {
+		if _, err := strconv.ParseInt(seed, 10, 64); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown order '%s', use inventory, sorted, reverse, shuffle or shuffle:<seed>", order)
+}
+
+// Returns the hosts in the order they should be run on:
+//
+//	inventory       as listed, the default
+//	sorted          by name
+//	reverse         as listed, last first
+//	shuffle         randomly, the seed is logged so that the order can be
+//	                repeated with shuffle:<seed>
+//	shuffle:<seed>  randomly, the same way for the same seed
+//
+// With forks, the hosts are started in this order.
+func OrderHosts(hosts []string, order string) ([]string, error) {
+	if err := validateOrder(order); err != nil {
+		return nil, err
+	}
+	ordered := append([]string(nil), hosts...)
+	switch {
+	case order == "" || order == "inventory":
+	case order == "sorted":
+		sort.Strings(ordered)
+	case order == "reverse":
+		for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		}
+	default:
+		seed := time.Now().UnixNano()
+		if order == "shuffle" {
+			log.Printf("Shuffling the hosts with seed %d\n", seed)
+		} else {
+			seed, _ = strconv.ParseInt(strings.TrimPrefix(order, "shuffle:"), 10, 64)
+		}
+		for i, j := range rand.New(rand.NewSource(seed)).Perm(len(hosts)) {
+			ordered[i] = hosts[j]
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,41 @@
+package henchman
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestOrderHosts(t *testing.T) {
+	hosts := []string{"web-02", "db-01", "web-01"}
+	for order, expected := range map[string][]string{
+		"":          {"web-02", "db-01", "web-01"},
+		"inventory": {"web-02", "db-01", "web-01"},
+		"sorted":    {"db-01", "web-01", "web-02"},
+		"reverse":   {"web-01", "db-01", "web-02"},
+	} {
+		if ordered, err := OrderHosts(hosts, order); err != nil || !reflect.DeepEqual(ordered, expected) {
+			t.Errorf("Hosts in %s order mismatch. Got %v %v\n", order, ordered, err)
+		}
+	}
+	if hosts[0] != "web-02" {
+		t.Errorf("The hosts shouldn't be reordered in place. Got %v\n", hosts)
+	}
+
+	first, _ := OrderHosts(hosts, "shuffle:42")
+	second, _ := OrderHosts(hosts, "shuffle:42")
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected the same seed to shuffle the same way. Got %v and %v\n", first, second)
+	}
+	shuffled, _ := OrderHosts(hosts, "shuffle")
+	sort.Strings(shuffled)
+	if !reflect.DeepEqual(shuffled, []string{"db-01", "web-01", "web-02"}) {
+		t.Errorf("Shuffling shouldn't lose hosts. Got %v\n", shuffled)
+	}
+
+	for _, order := range []string{"random", "shuffle:abc"} {
+		if _, err := OrderHosts(hosts, order); err == nil {
+			t.Errorf("Expected order '%s' to be refused\n", order)
+		}
+	}
+}
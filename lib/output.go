@@ -0,0 +1,126 @@
+package henchman
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+var (
+	// Bytes of a command's output kept in memory. Larger outputs are spilled
+	// to a file in SpillDir and only their head and tail are kept in memory.
+	OutputLimit = 64 << 10
+	// Caps the bytes captured per command, in memory and spilled alike.
+	// Anything past it is dropped. A non-positive value doesn't cap outputs.
+	OutputCap = 0
+	SpillDir  = os.TempDir()
+)
+
+// Output captures the combined output of a command without holding all of
+// it in memory. Once the output outgrows the limit, everything is streamed
+// to a spill file and only the first and last halves of the limit are kept
+// around for the report.
+type Output struct {
+	mutex    sync.Mutex
+	head     []byte
+	tail     []byte
+	total    int
+	dropped  int
+	limit    int
+	cap      int
+	spilling bool
+	spill    *os.File
+	spillErr error
+}
+
+func NewOutput() *Output {
+	return &Output{limit: OutputLimit, cap: OutputCap}
+}
+
+// Only trims once the tail is twice its size so that appends stay cheap
+func (o *Output) trimTail(force bool) {
+	keep := o.limit - o.limit/2
+	if len(o.tail) > 2*keep || (force && len(o.tail) > keep) {
+		o.tail = append([]byte(nil), o.tail[len(o.tail)-keep:]...)
+	}
+}
+
+func (o *Output) startSpilling() {
+	o.spilling = true
+	o.spill, o.spillErr = ioutil.TempFile(SpillDir, "henchman-output-")
+	if o.spillErr == nil {
+		o.spill.Write(o.head)
+	}
+	head := o.limit / 2
+	o.tail = append([]byte(nil), o.head[head:]...)
+	o.head = o.head[:head]
+	o.trimTail(false)
+}
+
+func (o *Output) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	n := len(p)
+	if o.cap > 0 && o.total+len(p) > o.cap {
+		keep := o.cap - o.total
+		if keep < 0 {
+			keep = 0
+		}
+		o.dropped += len(p) - keep
+		p = p[:keep]
+	}
+	o.total += len(p)
+
+	if !o.spilling {
+		o.head = append(o.head, p...)
+		if len(o.head) > o.limit {
+			o.startSpilling()
+		}
+		return n, nil
+	}
+	if o.spill != nil {
+		o.spill.Write(p)
+	}
+	o.tail = append(o.tail, p...)
+	o.trimTail(false)
+	return n, nil
+}
+
+// Path of the file the full output was spilled to, if any
+func (o *Output) SpillPath() string {
+	if o.spill == nil {
+		return ""
+	}
+	return o.spill.Name()
+}
+
+// Closes the spill file. The file itself is left behind for inspection.
+func (o *Output) Close() error {
+	if o.spill == nil {
+		return nil
+	}
+	return o.spill.Close()
+}
+
+// Returns the captured output, marking where the middle was left out
+func (o *Output) String() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	out := string(o.head)
+	if o.spilling {
+		o.trimTail(true)
+		omitted := o.total - len(o.head) - len(o.tail)
+		where := ""
+		if o.spill != nil {
+			where = ", full output in " + o.spill.Name()
+		} else if o.spillErr != nil {
+			where = ", couldn't spill: " + o.spillErr.Error()
+		}
+		out += fmt.Sprintf("\n... %d bytes omitted%s ...\n%s", omitted, where, o.tail)
+	}
+	if o.dropped > 0 {
+		out += fmt.Sprintf("\n... %d bytes dropped past the output cap ...\n", o.dropped)
+	}
+	return out
+}
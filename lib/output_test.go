@@ -0,0 +1,47 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOutputWithinLimit(t *testing.T) {
+	out := &Output{limit: 16}
+	out.Write([]byte("hello "))
+	out.Write([]byte("world"))
+	out.Close()
+	if out.String() != "hello world" || out.SpillPath() != "" {
+		t.Errorf("Small outputs should be kept as is. Got '%s'\n", out.String())
+	}
+}
+
+func TestOutputSpills(t *testing.T) {
+	out := &Output{limit: 8}
+	for _, chunk := range []string{"0123", "4567", "89ab", "cdef"} {
+		out.Write([]byte(chunk))
+	}
+	out.Close()
+	defer os.Remove(out.SpillPath())
+
+	captured := out.String()
+	if !strings.HasPrefix(captured, "0123\n") || !strings.HasSuffix(captured, "\ncdef") {
+		t.Errorf("Only the head and tail should be kept. Got '%s'\n", captured)
+	}
+	if !strings.Contains(captured, "8 bytes omitted, full output in "+out.SpillPath()) {
+		t.Errorf("The omission should be noted. Got '%s'\n", captured)
+	}
+	spilled, err := ioutil.ReadFile(out.SpillPath())
+	if err != nil || string(spilled) != "0123456789abcdef" {
+		t.Errorf("The full output should have been spilled. Got '%s' (%s)\n", spilled, err)
+	}
+}
+
+func TestOutputCap(t *testing.T) {
+	out := &Output{limit: 64, cap: 10}
+	out.Write([]byte("0123456789abcdef"))
+	if captured := out.String(); captured != "0123456789\n... 6 bytes dropped past the output cap ...\n" {
+		t.Errorf("Output past the cap should be dropped. Got '%s'\n", captured)
+	}
+}
@@ -0,0 +1,111 @@
+package henchman
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// HostOverride changes the connection settings of the machines whose hostname
+// matches Pattern, a shell glob. Empty fields are left untouched.
+type HostOverride struct {
+	Pattern string
+	User    string
+	Port    int
+	Keyfile string
+
+	ConnectTimeout time.Duration
+	CommandTimeout time.Duration
+}
+
+// Parses overrides of the form "db*:user=postgres,port=2202,keyfile=/path/to/key".
+// Timeouts are set with connect_timeout=30s and command_timeout=10m.
+func ParseHostOverride(spec string) (HostOverride, error) {
+	var override HostOverride
+	pattern_settings := strings.SplitN(spec, ":", 2)
+	if len(pattern_settings) != 2 || pattern_settings[0] == "" {
+		return override, fmt.Errorf("Host override '%s' should be of the form <pattern>:key=value,...", spec)
+	}
+	override.Pattern = pattern_settings[0]
+	if _, err := path.Match(override.Pattern, ""); err != nil {
+		return override, fmt.Errorf("Bad host pattern '%s': %s", override.Pattern, err)
+	}
+	for _, setting := range strings.Split(pattern_settings[1], ",") {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return override, fmt.Errorf("Bad host override setting '%s'", setting)
+		}
+		switch kv[0] {
+		case "user":
+			override.User = kv[1]
+		case "port":
+			port, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return override, fmt.Errorf("Bad port '%s' in host override", kv[1])
+			}
+			override.Port = port
+		case "keyfile":
+			override.Keyfile = kv[1]
+		case "connect_timeout", "command_timeout":
+			timeout, err := time.ParseDuration(kv[1])
+			if err != nil {
+				return override, fmt.Errorf("Bad %s '%s' in host override", kv[0], kv[1])
+			}
+			if kv[0] == "connect_timeout" {
+				override.ConnectTimeout = timeout
+			} else {
+				override.CommandTimeout = timeout
+			}
+		default:
+			return override, fmt.Errorf("Unknown host override setting '%s'", kv[0])
+		}
+	}
+	return override, nil
+}
+
+// Applies the override to the matching machines. Each matching machine gets
+// its own copy of the SSH config so that the others are unaffected.
+func (override HostOverride) Apply(machines []*Machine) error {
+	var auth ssh.AuthMethod
+	if override.Keyfile != "" {
+		var err error
+		if auth, err = ClientKeyAuth(expandHome(override.Keyfile)); err != nil {
+			return err
+		}
+	}
+	for _, machine := range machines {
+		if matched, _ := path.Match(override.Pattern, machine.Hostname); matched {
+			override.apply(machine, auth)
+		}
+	}
+	return nil
+}
+
+// Applies the override to the machine with the auth of its keyfile, if any
+func (override HostOverride) apply(machine *Machine, auth ssh.AuthMethod) {
+	if override.Port != 0 {
+		machine.Port = override.Port
+	}
+	if override.ConnectTimeout != 0 {
+		machine.Timeouts.Connect = override.ConnectTimeout
+	}
+	if override.CommandTimeout != 0 {
+		machine.Timeouts.Command = override.CommandTimeout
+	}
+	if machine.SSHConfig == nil || (override.User == "" && auth == nil) {
+		return
+	}
+	config := *machine.SSHConfig
+	if override.User != "" {
+		config.User = override.User
+	}
+	if auth != nil {
+		config.Auth = []ssh.AuthMethod{auth}
+		machine.Keyfile = expandHome(override.Keyfile)
+	}
+	machine.SSHConfig = &config
+}
@@ -0,0 +1,50 @@
+package henchman
+
+import (
+	"testing"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestParseHostOverride(t *testing.T) {
+	override, err := ParseHostOverride("db*:user=postgres,port=2202")
+	if err != nil {
+		t.Fatalf("Couldn't parse the override: %s\n", err)
+	}
+	if override.Pattern != "db*" || override.User != "postgres" || override.Port != 2202 {
+		t.Errorf("Override mismatch. Got %+v\n", override)
+	}
+
+	override, err = ParseHostOverride("db*:connect_timeout=30s,command_timeout=10m")
+	if err != nil {
+		t.Fatalf("Couldn't parse the override: %s\n", err)
+	}
+	if override.ConnectTimeout != 30*time.Second || override.CommandTimeout != 10*time.Minute {
+		t.Errorf("Override timeouts mismatch. Got %+v\n", override)
+	}
+
+	for _, spec := range []string{"db*", ":user=foo", "db*:port=abc", "db*:colour=red", "db[:user=foo", "db*:connect_timeout=soon"} {
+		if _, err := ParseHostOverride(spec); err == nil {
+			t.Errorf("Override '%s' should have been rejected\n", spec)
+		}
+	}
+}
+
+func TestApplyHostOverride(t *testing.T) {
+	config := &ssh.ClientConfig{User: "deploy"}
+	machines := Machines([]string{"db01", "web01:2222"}, config)
+	override := HostOverride{Pattern: "db*", User: "postgres", Port: 2202}
+	if err := override.Apply(machines); err != nil {
+		t.Fatalf("Couldn't apply the override: %s\n", err)
+	}
+
+	if machines[0].Port != 2202 || machines[0].SSHConfig.User != "postgres" {
+		t.Errorf("Override wasn't applied to db01. Got %s@%s:%d\n",
+			machines[0].SSHConfig.User, machines[0].Hostname, machines[0].Port)
+	}
+	if machines[1].Port != 2222 || machines[1].SSHConfig.User != "deploy" {
+		t.Errorf("Override shouldn't apply to web01. Got %s@%s:%d\n",
+			machines[1].SSHConfig.User, machines[1].Hostname, machines[1].Port)
+	}
+}
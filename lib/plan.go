@@ -3,7 +3,10 @@ package henchman
 import (
 	"fmt"
 	"gopkg.in/yaml.v1"
+	"log"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type TaskVars map[string]interface{}
@@ -17,8 +20,75 @@ type Plan struct {
 	Vars  *TaskVars
 	Name  string
 
-	report map[string]string
-	tasks  []map[string]string `yaml:"tasks"`
+	// Id of the run's manifest, if one was written, see Manifest
+	Manifest string `yaml:"-"`
+
+	// Tasks that only run at the end of the plan, on the machines where a
+	// task notifying them changed something, see Task.Notify
+	Handlers []Task
+
+	// Interpreter overrides for script tasks keyed by hostname. They win
+	// over the interpreter host var.
+	Interpreters map[string]string
+
+	// Jump hosts that connections to all the hosts are tunnelled through
+	Via []Hop
+
+	// Single jump host, [user@]host[:port], shorthand for via. Hosts with
+	// a 'bastion' var go through theirs instead.
+	Bastion string
+
+	// Patterns of hosts that need a confirmation before the plan runs on
+	// them, on top of the hosts the inventory marks protected
+	Protected []string
+
+	// Expected host key fingerprints keyed by hostname or pattern, for hosts
+	// without a host_key host var
+	HostKeys map[string]string `yaml:"host_keys"`
+
+	// Subsets of facts gathered from every host before the tasks run, e.g.
+	// 'network,os' or 'all'. Tasks see them as vars.facts.
+	GatherFacts string `yaml:"gather_facts"`
+
+	// Dynamic groups made from the gathered facts that tasks can target
+	GroupBy []GroupBy `yaml:"group_by"`
+
+	// Data files vars are looked up in per host, see HierarchyVars
+	Hierarchy []string
+
+	// Order the hosts are run on, see OrderHosts
+	Order string
+
+	// Host var the hosts are run in batches by, one batch after another,
+	// see SerialBatches
+	SerialBy string `yaml:"serial_by"`
+
+	// Hosts the plan runs on first, as comma separated patterns or a
+	// percentage like '10%'. The rest only follow once the canaries are
+	// through the plan and CanaryCheck passes on them, or once the run is
+	// confirmed when there's no check.
+	Canary      string
+	CanaryCheck *Task `yaml:"canary_check"`
+
+	// Run the tasks with sudo, as BecomeUser if set, unless they say
+	// otherwise
+	Sudo       bool
+	BecomeUser string `yaml:"become_user"`
+
+	// Default timeout of the tasks, see Task.Timeout
+	Timeout string
+
+	// Syntax of the plan's templates, pongo2 by default or jinja2 for
+	// templates carried over from Ansible, see JinjaDialect
+	TemplateDialect string `yaml:"template_dialect"`
+
+	// Directory of the plan file that relative files are looked up from
+	Dir string `yaml:"-"`
+
+	mutex       sync.Mutex
+	results     []Result
+	quarantined map[string]string
+	tasks       []map[string]string `yaml:"tasks"`
 }
 
 func mergeMap(source *TaskVars, destination *TaskVars) {
@@ -29,6 +99,26 @@ func mergeMap(source *TaskVars, destination *TaskVars) {
 	}
 }
 
+// Hosts overridden from extra args are comma separated while the ones from
+// vars files may be a list too.
+func overrideHosts(hosts interface{}) ([]string, error) {
+	switch hosts := hosts.(type) {
+	case string:
+		return strings.Split(hosts, ","), nil
+	case []interface{}:
+		var names []string
+		for _, host := range hosts {
+			name, ok := host.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid host '%v' in the hosts override", host)
+			}
+			names = append(names, name)
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("The hosts override should be a comma separated string or a list. Got '%v'", hosts)
+}
+
 // Returns a new plan with a collection of tasks. The planBuf should be a valid
 // 'yaml' representation. Additionally, this function also takes in any variable
 // overrides that takes precedence over the variables present in the plan.
@@ -45,15 +135,127 @@ func NewPlanFromYAML(planBuf []byte, overrides *TaskVars) (*Plan, error) {
 	if overrides != nil {
 		mergeMap(overrides, plan.Vars)
 		if hosts, present := (*overrides)["hosts"]; present {
-			plan.Hosts = strings.Split(hosts.(string), ",")
+			if plan.Hosts, err = overrideHosts(hosts); err != nil {
+				return nil, err
+			}
 		}
 
 	}
-	plan.report = make(map[string]string)
+	if err := validateOrder(plan.Order); err != nil {
+		return nil, err
+	}
+	if err := validateDialect(plan.TemplateDialect); err != nil {
+		return nil, err
+	}
 	plan.parseTasks()
+	if plan.CanaryCheck != nil {
+		if plan.CanaryCheck.Action == "" {
+			return nil, fmt.Errorf("The canary check needs an action")
+		}
+		if plan.CanaryCheck.Sudo == nil {
+			plan.CanaryCheck.Sudo = &plan.Sudo
+		}
+		if plan.CanaryCheck.BecomeUser == "" {
+			plan.CanaryCheck.BecomeUser = plan.BecomeUser
+		}
+	}
+	for i := range plan.Tasks {
+		task := &plan.Tasks[i]
+		task.Index = i + 1
+		if err := plan.setupTask(task); err != nil {
+			return nil, err
+		}
+	}
+	if err := plan.setupHandlers(); err != nil {
+		return nil, err
+	}
 	return &plan, nil
 }
 
+// Gives the task the plan's defaults and validates it
+func (plan *Plan) setupTask(task *Task) error {
+	if task.Sudo == nil {
+		task.Sudo = &plan.Sudo
+	}
+	if task.BecomeUser == "" {
+		task.BecomeUser = plan.BecomeUser
+	}
+	if task.Timeout == "" {
+		task.Timeout = plan.Timeout
+	}
+	if len(task.Commands) > 0 && (task.Action != "" || task.Script != "") {
+		return fmt.Errorf("Task '%s': commands can't be combined with an action or script", task.Name)
+	}
+	switch task.WithItems.(type) {
+	case nil, []interface{}, string:
+	default:
+		return fmt.Errorf("Task '%s': with_items has to be a list or the name of a list var", task.Name)
+	}
+	if task.Register != "" && !jinjaIdentifier.MatchString(task.Register) {
+		return fmt.Errorf("Task '%s': can't register '%s', it isn't a valid var name", task.Name, task.Register)
+	}
+	if task.When != "" {
+		if _, err := compileCondition(task.When, plan.TemplateDialect); err != nil {
+			return fmt.Errorf("Task '%s': bad when '%s': %s", task.Name, task.When, err)
+		}
+	}
+	if err := task.validateUntil(plan.TemplateDialect); err != nil {
+		return fmt.Errorf("Task '%s': %s", task.Name, err)
+	}
+	if err := validateBudget(task.ExpectedDuration); err != nil {
+		return fmt.Errorf("Task '%s': %s", task.Name, err)
+	}
+	if err := validateTaskTimeout(task.Timeout); err != nil {
+		return fmt.Errorf("Task '%s': %s", task.Name, err)
+	}
+	if task.Retry != nil {
+		if err := task.Retry.validate(); err != nil {
+			return fmt.Errorf("Task '%s': %s", task.Name, err)
+		}
+	}
+	if task.Env != nil {
+		if err := task.Env.validate(); err != nil {
+			return fmt.Errorf("Task '%s': %s", task.Name, err)
+		}
+	}
+	if task.Copy != nil {
+		if err := task.Copy.validate("copy"); err != nil {
+			return fmt.Errorf("Task '%s': %s", task.Name, err)
+		}
+	}
+	if task.Template != nil {
+		if err := task.Template.validate("template"); err != nil {
+			return fmt.Errorf("Task '%s': %s", task.Name, err)
+		}
+	}
+	if task.Fetch != nil {
+		if err := task.Fetch.validate(); err != nil {
+			return fmt.Errorf("Task '%s': %s", task.Name, err)
+		}
+	}
+	if task.Module != nil {
+		if err := task.Module.validate(); err != nil {
+			return fmt.Errorf("Task '%s': %s", task.Name, err)
+		}
+	}
+	if task.Verify != nil {
+		if err := task.Verify.validate(); err != nil {
+			return fmt.Errorf("Task '%s': %s", task.Name, err)
+		}
+	}
+	for i := range task.Rollback {
+		rollback := &task.Rollback[i]
+		if len(rollback.Rollback) > 0 {
+			return fmt.Errorf("Task '%s': rollback tasks can't have rollbacks of their own", task.Name)
+		}
+		if err := plan.setupTask(rollback); err != nil {
+			return err
+		}
+		rollback.isRollback = true
+	}
+	return nil
+}
+
 func (plan *Plan) parseTasks() {
 	for _, t := range plan.tasks {
 		task := Task{}
@@ -69,44 +271,124 @@ func (plan *Plan) parseTasks() {
 
 // Prints the summary of the Plan execution across all the hosts
 func (plan *Plan) PrintReport() {
-	var counts = make(map[string]int)
-
-	total := len(plan.Tasks) * len(plan.Hosts)
-	attempted := len(plan.report)
-	skipped := total - attempted
-
-	counts["skipped"] = skipped
-	for _, status := range plan.report {
-		_, present := counts[status]
-		if !present {
-			counts[status] = 1
-		} else {
-			counts[status]++
-		}
-	}
+	report := plan.Report()
 	fmt.Println()
 	fmt.Println("---")
 	fmt.Printf("Plan Report: %s\n", plan.Name)
+	if report.Manifest != "" {
+		fmt.Printf("Manifest: %s\n", report.Manifest)
+	}
 	fmt.Println()
-	for k, v := range counts {
+	for k, v := range report.Counts {
 		fmt.Printf("%s (all hosts):\t%d\n", k, v)
 	}
+	printHostCounts(report)
+	fmt.Println()
+	fmt.Printf("Tasks total (all hosts):\t%d\n", report.Total)
+	fmt.Printf("Tasks attempted (all hosts):\t%d\n", report.Attempted)
+	if report.RolledBack > 0 {
+		fmt.Printf("Rollback tasks (all hosts):\t%d\n", report.RolledBack)
+	}
+	if report.Handlers > 0 {
+		fmt.Printf("Handlers run (all hosts):\t%d\n", report.Handlers)
+	}
+	if len(report.OverBudget) > 0 {
+		fmt.Println()
+		fmt.Printf("Over budget (all hosts):\t%d\n", len(report.OverBudget))
+		for _, result := range report.OverBudget {
+			fmt.Printf("  %s: '%s' took %s, expected %s\n", result.Host, result.Task, result.Duration, result.Budget)
+		}
+	}
+	if len(report.Errors) == 0 {
+		printQuarantined(report)
+		return
+	}
 	fmt.Println()
-	fmt.Printf("Tasks total (all hosts):\t%d\n",
-		len(plan.Tasks)*len(plan.Hosts))
-	fmt.Printf("Tasks attempted (all hosts):\t%d\n", len(plan.report))
+	for category, count := range report.Errors {
+		fmt.Printf("%s (all hosts):\t%d\n", category, count)
+	}
+	for _, result := range report.Results {
+		if result.ErrorCategory != "" {
+			fmt.Printf("  %s: '%s' - %s\n", result.Host, result.Task, result.ErrorCategory)
+		}
+	}
+	printQuarantined(report)
 }
 
-// Mark a given task's status.
-// NOTE: Skipped tasks are not tracked here.
-func (plan *Plan) SaveStatus(task *Task, status string) {
-	plan.report[task.Id] = status
+// Statuses in the order they're summed up per host
+var hostStatuses = []string{StatusOk, StatusChanged, StatusSkipped, StatusFailed, StatusUnreachable, StatusIgnored}
+
+// Prints a line per host like 'web1: ok=3 changed=1 skipped=0 failed=0
+// unreachable=0', with ignored failures if there are any
+func printHostCounts(report *Report) {
+	if len(report.HostCounts) == 0 {
+		return
+	}
+	var hosts []string
+	for host := range report.HostCounts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	fmt.Println()
+	for _, host := range hosts {
+		counts := report.HostCounts[host]
+		var summary []string
+		for _, status := range hostStatuses {
+			if status != StatusIgnored || counts[status] > 0 {
+				summary = append(summary, fmt.Sprintf("%s=%d", status, counts[status]))
+			}
+		}
+		fmt.Printf("%s:\t%s\n", host, strings.Join(summary, " "))
+	}
+}
+
+func printQuarantined(report *Report) {
+	if len(report.Quarantined) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Printf("Quarantined hosts (degraded):\t%d\n", len(report.Quarantined))
+	for host, reason := range report.Quarantined {
+		fmt.Printf("  %s: %s\n", host, reason)
+	}
+}
+
+// Records that the machine couldn't be readied for the plan's tasks, e.g.
+// because its facts couldn't be gathered, as a failed or unreachable result
+// of the step. The machine then shows up in the report and keeps the later
+// serial batches from running.
+func (plan *Plan) SaveSetupFailure(machine *Machine, step string, err error) {
+	task := Task{Name: step}
+	plan.SaveStatus(machine, &task, task.status(err.Error(), err, 0))
+}
+
+// Mark a given task's status on the machine.
+func (plan *Plan) SaveStatus(machine *Machine, task *Task, status *TaskStatus) {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	plan.results = append(plan.results, Result{
+		Host:          machine.Hostname,
+		TaskId:        task.Id,
+		Task:          task.Name,
+		TaskIndex:     task.Index,
+		Status:        status.Status,
+		Changed:       status.Changed,
+		Message:       status.Message,
+		Duration:      status.Duration,
+		ErrorCategory: status.ErrorCategory,
+		Rollback:      task.isRollback,
+		Handler:       task.isHandler,
+		Budget:        task.budget(),
+	})
+	if result := plan.results[len(plan.results)-1]; result.OverBudget() {
+		log.Printf("Warning: '%s' took %s on %s, over its expected %s\n", task.Name, result.Duration, machine.Hostname, result.Budget)
+	}
 }
 
 func (plan *Plan) String() string {
 	status := fmt.Sprintf("Plan '%s' with %d tasks:", plan.Name, len(plan.Tasks))
-	for k, v := range plan.report {
-		status = status + fmt.Sprintf(" %s - %s;", k, v)
+	for _, result := range plan.Report().Results {
+		status = status + fmt.Sprintf(" %s - %s;", result.TaskId, result.Status)
 	}
 	return status
 }
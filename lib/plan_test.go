@@ -60,6 +60,20 @@ tasks:
 	if plan.Hosts[0] != "overridden1" {
 		t.Errorf("Hosts mismatch. Parsed %s hosts instead\n", plan.Hosts[0])
 	}
+
+	// vars/<env>.yaml may list the hosts instead
+	tv["hosts"] = []interface{}{"staging1", "staging2"}
+	plan, err = NewPlanFromYAML([]byte(plan_string), &tv)
+	if err != nil {
+		t.Fatalf("Couldn't read the plan with listed hosts: %s\n", err)
+	}
+	if len(plan.Hosts) != 2 || plan.Hosts[1] != "staging2" {
+		t.Errorf("Hosts mismatch. Parsed %v instead\n", plan.Hosts)
+	}
+	tv["hosts"] = map[interface{}]interface{}{"web": "web1"}
+	if _, err := NewPlanFromYAML([]byte(plan_string), &tv); err == nil {
+		t.Errorf("Expected hosts that aren't a string or a list to be rejected\n")
+	}
 }
 
 func TestParsePlanWithOverrides(t *testing.T) {
@@ -101,3 +115,58 @@ tasks:
 		t.Errorf("The task '%s' had ignore_errors set to false. Got %t\n", second_task.Name, second_task.IgnoreErrors)
 	}
 }
+
+func TestParsePlanWithInterpreters(t *testing.T) {
+	plan_string := `---
+name: Sample plan
+hosts:
+  - 127.0.0.1
+  - 192.168.1.2
+interpreters:
+  192.168.1.2: /usr/libexec/platform-python
+tasks:
+  - name: Run a script
+    script: scripts/check.py
+ `
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		panic(err)
+	}
+	if plan.Interpreters["192.168.1.2"] != "/usr/libexec/platform-python" {
+		t.Errorf("Interpreter override mismatch. Got %s\n", plan.Interpreters["192.168.1.2"])
+	}
+	if plan.Tasks[0].Script != "scripts/check.py" {
+		t.Errorf("Task script mismatch. Got %s\n", plan.Tasks[0].Script)
+	}
+}
+
+func TestParsePlanWithRetries(t *testing.T) {
+	plan_string := `---
+name: "Sample plan"
+hosts:
+  - 192.168.1.2
+tasks:
+  - name: Install packages
+    action: apt-get install -y nginx
+    retry:
+      attempts: 5
+      delay: 10s
+      errors: [unreachable, "rc=100", "stderr=Could not get lock"]
+  - name: Badly retried task
+    action: ls
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		panic(err)
+	}
+	retry := plan.Tasks[0].Retry
+	if retry == nil || retry.Attempts != 5 || retry.Delay != "10s" || len(retry.Errors) != 3 {
+		t.Errorf("Retry policy mismatch. Got %v\n", retry)
+	}
+	if plan.Tasks[1].Retry != nil {
+		t.Errorf("Expected no retry policy. Got %v\n", plan.Tasks[1].Retry)
+	}
+	if _, err := NewPlanFromYAML([]byte(plan_string+"    retry: {delay: soon}\n"), nil); err == nil {
+		t.Errorf("Expected an invalid retry policy to be refused\n")
+	}
+}
@@ -0,0 +1,299 @@
+package henchman
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Version of the protocol plugins speak over their stdin and stdout. Each
+// message is a JSON object on a line of its own. henchman sends requests
+//
+//	{"id": 1, "method": "hosts", "params": {"selector": "role=web"}}
+//
+// which the plugin answers in order with
+//
+//	{"id": 1, "result": ["web-01", "web-02"]}
+//
+// or {"id": 1, "error": "..."}. Notifications have no id and get no answer.
+// The first request is always a handshake, params {"protocol": 1, "kind":
+// "inventory"}, answered with the protocol the plugin speaks along with its
+// name and version. Then, depending on the kind:
+//
+//	inventory  "hosts" {"selector": s} -> hosts for <plugin>:<selector>
+//	lookups    "lookup" {"args": [...]} -> the value of lookup('<plugin>', ...)
+//	callbacks  "event" notifications with the run's events, see Event
+//	transports "run" {"host": h, "port": p, "user": u, "vars": {...},
+//	           "action": a, "stdin": base64} -> {"output": o, "exit_code": n}
+//	           for hosts with connection=<plugin>
+//
+// The plugin's stdin is closed when henchman is done with it. Anything it
+// writes to stderr is passed through.
+const PluginProtocol = 1
+
+type pluginRequest struct {
+	Id     int         `json:"id,omitempty"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type pluginResponse struct {
+	Id     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// PluginProcess is a running plugin, see PluginProtocol
+type PluginProcess struct {
+	Plugin *Plugin
+	// What the plugin said it is in the handshake
+	Name    string
+	Version string
+
+	mutex   sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	replies *json.Decoder
+	nextId  int
+}
+
+// Starts the plugin and shakes hands with it
+func StartPlugin(plugin *Plugin) (*PluginProcess, error) {
+	cmd := exec.Command(plugin.Path)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Couldn't start the %s plugin %s: %s", plugin.Kind, plugin.Name, err)
+	}
+	process := &PluginProcess{Plugin: plugin, cmd: cmd, stdin: stdin, replies: json.NewDecoder(bufio.NewReader(stdout))}
+	var handshake struct {
+		Protocol int
+		Name     string
+		Version  string
+	}
+	params := map[string]interface{}{"protocol": PluginProtocol, "kind": plugin.Kind}
+	if err := process.Call("handshake", params, &handshake); err != nil {
+		process.Close()
+		return nil, err
+	}
+	if handshake.Protocol != PluginProtocol {
+		process.Close()
+		return nil, fmt.Errorf("The %s plugin %s speaks protocol %d, henchman speaks %d", plugin.Kind, plugin.Name, handshake.Protocol, PluginProtocol)
+	}
+	process.Name, process.Version = handshake.Name, handshake.Version
+	return process, nil
+}
+
+func (process *PluginProcess) send(request *pluginRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if _, err := process.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("The %s plugin %s is gone: %s", process.Plugin.Kind, process.Plugin.Name, err)
+	}
+	return nil
+}
+
+// Sends the request and decodes the plugin's result into result
+func (process *PluginProcess) Call(method string, params interface{}, result interface{}) error {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+	process.nextId++
+	id := process.nextId
+	if err := process.send(&pluginRequest{Id: id, Method: method, Params: params}); err != nil {
+		return err
+	}
+	var response pluginResponse
+	if err := process.replies.Decode(&response); err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("it exited")
+		}
+		return fmt.Errorf("Bad answer to '%s' from the %s plugin %s: %s", method, process.Plugin.Kind, process.Plugin.Name, err)
+	}
+	if response.Id != id {
+		return fmt.Errorf("The %s plugin %s answered request %d instead of %d", process.Plugin.Kind, process.Plugin.Name, response.Id, id)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("%s plugin %s: %s", process.Plugin.Kind, process.Plugin.Name, response.Error)
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// Sends the notification, which gets no answer
+func (process *PluginProcess) Notify(method string, params interface{}) error {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+	return process.send(&pluginRequest{Method: method, Params: params})
+}
+
+// Sends the event to the callback plugin, see EventSink
+func (process *PluginProcess) Send(event *Event) error {
+	return process.Notify("event", event)
+}
+
+// Closes the plugin's stdin and waits for it to exit
+func (process *PluginProcess) Close() error {
+	process.stdin.Close()
+	return process.cmd.Wait()
+}
+
+// Returns the running process of the plugin, starting it the first time
+func (registry *PluginRegistry) Process(kind string, name string) (*PluginProcess, error) {
+	plugin, present := registry.Lookup(kind, name)
+	if !present {
+		return nil, fmt.Errorf("No %s plugin named '%s'", kind, name)
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.processes == nil {
+		registry.processes = make(map[*Plugin]*PluginProcess)
+	}
+	if process, running := registry.processes[plugin]; running {
+		return process, nil
+	}
+	process, err := StartPlugin(plugin)
+	if err != nil {
+		return nil, err
+	}
+	registry.processes[plugin] = process
+	return process, nil
+}
+
+// Stops the plugins started so far
+func (registry *PluginRegistry) Close() {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for plugin, process := range registry.processes {
+		process.Close()
+		delete(registry.processes, plugin)
+	}
+}
+
+// Looks hosts up with an inventory plugin
+type pluginHostProvider struct {
+	registry *PluginRegistry
+	name     string
+}
+
+func (provider *pluginHostProvider) Hosts(selector string) ([]string, error) {
+	process, err := provider.registry.Process(InventoryPlugins, provider.name)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	err = process.Call("hosts", map[string]string{"selector": selector}, &hosts)
+	return hosts, err
+}
+
+// Makes the inventory plugins host providers, so that hosts like
+// netbox:role=web are looked up by plugins/inventory/netbox. Built-in
+// providers keep their prefix.
+func (registry *PluginRegistry) RegisterHostProviders() {
+	for _, plugin := range registry.List(InventoryPlugins) {
+		if _, builtin := HostProviders[plugin.Name]; builtin {
+			continue
+		}
+		HostProviders[plugin.Name] = &pluginHostProvider{registry: registry, name: plugin.Name}
+	}
+}
+
+// Returns the value the lookup plugin has for the args, for templates'
+// lookup('<plugin>', ...)
+func (registry *PluginRegistry) lookup(name string, args ...interface{}) (interface{}, error) {
+	process, err := registry.Process(LookupPlugins, name)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = process.Call("lookup", map[string]interface{}{"args": jsonValue(args)}, &value)
+	return value, err
+}
+
+// Runs actions with a transport plugin. Actions on all the hosts using the
+// plugin go through the one process, in turn.
+type pluginTransport struct {
+	registry *PluginRegistry
+	name     string
+}
+
+// PluginExitError is the error of an action a transport plugin ran that
+// exited with a non-zero code
+type PluginExitError struct {
+	ExitCode int
+}
+
+func (e *PluginExitError) Error() string {
+	return fmt.Sprintf("Process exited with status %d", e.ExitCode)
+}
+
+func (transport *pluginTransport) Name() string {
+	return transport.name
+}
+
+func (transport *pluginTransport) Run(machine *Machine, action string, stdin io.Reader) (*Output, error) {
+	b := NewOutput()
+	defer b.Close()
+	process, err := transport.registry.Process(TransportPlugins, transport.name)
+	if err != nil {
+		return b, err
+	}
+	params := map[string]interface{}{
+		"host":   machine.Hostname,
+		"port":   machine.Port,
+		"vars":   jsonValue(machine.Vars),
+		"action": action,
+	}
+	if machine.SSHConfig != nil {
+		params["user"] = machine.SSHConfig.User
+	}
+	if stdin != nil {
+		input, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return b, err
+		}
+		params["stdin"] = input
+	}
+	var result struct {
+		Output   string
+		ExitCode int `json:"exit_code"`
+	}
+	if err := process.Call("run", params, &result); err != nil {
+		return b, err
+	}
+	b.Write([]byte(result.Output))
+	if result.ExitCode != 0 {
+		return b, &PluginExitError{result.ExitCode}
+	}
+	return b, nil
+}
+
+// Makes the transport plugins the transports of their connection type, so
+// that hosts with connection=kubectl are run on by plugins/transports/kubectl.
+// Transports registered by programs embedding henchman take precedence.
+func (registry *PluginRegistry) RegisterTransports() {
+	for _, plugin := range registry.List(TransportPlugins) {
+		if _, registered := Transports[plugin.Name]; registered {
+			continue
+		}
+		transport := &pluginTransport{registry: registry, name: plugin.Name}
+		Transports[plugin.Name] = func(machine *Machine) (Transport, error) {
+			return transport, nil
+		}
+	}
+}
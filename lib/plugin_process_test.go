@@ -0,0 +1,104 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Answers requests by method, appending the events it's sent to $EVENTS
+const fakePlugin = `#!/bin/sh
+while read -r line; do
+	id=$(echo "$line" | sed -n 's/^{"id":\([0-9]*\).*/\1/p')
+	case "$line" in
+	*'"method":"handshake"'*) echo '{"id":'$id',"result":{"protocol":1,"name":"fake","version":"0.1.0"}}' ;;
+	*'"method":"hosts"'*'"role=web"'*) echo '{"id":'$id',"result":["web-01","web-02"]}' ;;
+	*'"method":"hosts"'*) echo '{"id":'$id',"error":"unknown selector"}' ;;
+	*'"method":"lookup"'*'"args":["db/password"]'*) echo '{"id":'$id',"result":"s3cret"}' ;;
+	*'"method":"event"'*) echo "$line" >> "$EVENTS" ;;
+	*'"method":"run"'*'"action":"false"'*) echo '{"id":'$id',"result":{"output":"nope","exit_code":3}}' ;;
+	*'"method":"run"'*'"host":"appliance"'*) echo '{"id":'$id',"result":{"output":"ran","exit_code":0}}' ;;
+	esac
+done
+`
+
+func writeFakePlugin(t *testing.T, dir string, kind string, name string, script string) {
+	path := filepath.Join(dir, kind, name)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("Couldn't write the plugin: %s\n", err)
+	}
+}
+
+func TestPluginProcesses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The fake plugins are shell scripts")
+	}
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	for _, kind := range []string{InventoryPlugins, LookupPlugins, CallbackPlugins, TransportPlugins} {
+		writeFakePlugin(t, dir, kind, "fake", fakePlugin)
+	}
+	writeFakePlugin(t, dir, InventoryPlugins, "future", "#!/bin/sh\nread line\necho '{\"id\":1,\"result\":{\"protocol\":2}}'\n")
+	events := filepath.Join(dir, "events.jsonl")
+	os.Setenv("EVENTS", events)
+	defer os.Unsetenv("EVENTS")
+
+	registry := NewPluginRegistry()
+	registry.Discover(dir)
+	defer registry.Close()
+
+	provider := &pluginHostProvider{registry: registry, name: "fake"}
+	hosts, err := provider.Hosts("role=web")
+	if err != nil || strings.Join(hosts, ",") != "web-01,web-02" {
+		t.Errorf("Expected the plugin's hosts. Got %v, %v\n", hosts, err)
+	}
+	if _, err := provider.Hosts("role=db"); err == nil || !strings.Contains(err.Error(), "unknown selector") {
+		t.Errorf("Expected the plugin's error. Got %v\n", err)
+	}
+	process, err := registry.Process(InventoryPlugins, "fake")
+	if err != nil || process.Name != "fake" || process.Version != "0.1.0" {
+		t.Errorf("Expected the running plugin to be reused. Got %+v, %v\n", process, err)
+	}
+	if _, err := registry.Process(InventoryPlugins, "future"); err == nil || !strings.Contains(err.Error(), "protocol 2") {
+		t.Errorf("Expected a protocol mismatch. Got %v\n", err)
+	}
+
+	value, err := registry.lookup("fake", "db/password")
+	if err != nil || value != "s3cret" {
+		t.Errorf("Expected the looked up value. Got %v, %v\n", value, err)
+	}
+	if _, err := registry.lookup("missing"); err == nil {
+		t.Errorf("Expected an error for a missing lookup plugin\n")
+	}
+
+	registry.RegisterTransports()
+	defer delete(Transports, "fake")
+	machine := &Machine{Hostname: "appliance", Port: 22, Vars: TaskVars{"ansible_connection": "fake"}}
+	if err := applyConnection(machine); err != nil || machine.Transport == nil {
+		t.Fatalf("Expected the transport plugin. Got %v\n", err)
+	}
+	if out, err := machine.Exec("true"); err != nil || out.String() != "ran" {
+		t.Errorf("Expected the plugin to run the action. Got %q, %v\n", out.String(), err)
+	}
+	_, err = machine.Exec("false")
+	if code, ok := exitStatus(err); !ok || code != 3 {
+		t.Errorf("Expected the plugin's exit code. Got %v\n", err)
+	}
+
+	callback, err := registry.Process(CallbackPlugins, "fake")
+	if err != nil {
+		t.Fatalf("Couldn't start the callback: %s\n", err)
+	}
+	log := NewEventLog(nil)
+	log.AddSink(callback)
+	log.Emit(Event{Type: "plan_started", Plan: "deploy"})
+	registry.Close()
+	sent, _ := ioutil.ReadFile(events)
+	if !strings.Contains(string(sent), `"method":"event"`) || !strings.Contains(string(sent), `"deploy"`) {
+		t.Errorf("Expected the callback to be sent the event. Got %s\n", sent)
+	}
+}
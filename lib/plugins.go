@@ -0,0 +1,154 @@
+package henchman
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kinds of plugins. Each is found in the subdirectory of the same name of
+// the directories on the plugins path.
+const (
+	ModulePlugins    = "modules"
+	LookupPlugins    = "lookups"
+	CallbackPlugins  = "callbacks"
+	InventoryPlugins = "inventory"
+	TransportPlugins = "transports"
+)
+
+var PluginKinds = []string{ModulePlugins, LookupPlugins, CallbackPlugins, InventoryPlugins, TransportPlugins}
+
+// Plugin is an executable that third parties drop in a plugins directory to
+// extend henchman without rebuilding it
+type Plugin struct {
+	Kind string
+	// File name without its extension
+	Name string
+	Path string
+
+	// Plugins of the same kind and name it takes the place of, by path
+	Shadows []string
+}
+
+// PluginRegistry holds the plugins of each kind by name. The first plugin
+// registered under a name shadows the ones registered after it, the way
+// the first directory on the plugins path shadows the next ones.
+type PluginRegistry struct {
+	mutex   sync.Mutex
+	plugins map[string]map[string]*Plugin
+	// Plugins started so far, see Process
+	processes map[*Plugin]*PluginProcess
+}
+
+func NewPluginRegistry() *PluginRegistry {
+	return &PluginRegistry{plugins: make(map[string]map[string]*Plugin)}
+}
+
+// Plugins discovered at startup, see Discover
+var Plugins = NewPluginRegistry()
+
+// Registers the plugin, returning false if one of the same kind and name
+// was registered before and shadows it
+func (registry *PluginRegistry) Register(plugin *Plugin) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	byName := registry.plugins[plugin.Kind]
+	if byName == nil {
+		byName = make(map[string]*Plugin)
+		registry.plugins[plugin.Kind] = byName
+	}
+	if first, present := byName[plugin.Name]; present {
+		first.Shadows = append(first.Shadows, plugin.Path)
+		return false
+	}
+	byName[plugin.Name] = plugin
+	return true
+}
+
+// Returns the plugin of the kind registered under the name
+func (registry *PluginRegistry) Lookup(kind string, name string) (*Plugin, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	plugin, present := registry.plugins[kind][name]
+	return plugin, present
+}
+
+// Returns the plugins of the kind, sorted by name
+func (registry *PluginRegistry) List(kind string) []*Plugin {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	var plugins []*Plugin
+	for _, plugin := range registry.plugins[kind] {
+		plugins = append(plugins, plugin)
+	}
+	sort.Sort(pluginsByName(plugins))
+	return plugins
+}
+
+type pluginsByName []*Plugin
+
+func (plugins pluginsByName) Len() int           { return len(plugins) }
+func (plugins pluginsByName) Less(i, j int) bool { return plugins[i].Name < plugins[j].Name }
+func (plugins pluginsByName) Swap(i, j int)      { plugins[i], plugins[j] = plugins[j], plugins[i] }
+
+// Registers the plugins found on the plugins path, a list of directories
+// like $PATH. Each directory has a subdirectory per kind of plugin, e.g.
+// plugins/inventory/netbox. Missing directories are skipped so that the
+// default path needn't exist.
+func (registry *PluginRegistry) Discover(pluginsPath string) error {
+	for _, pluginsDir := range filepath.SplitList(pluginsPath) {
+		for _, kind := range PluginKinds {
+			dir := filepath.Join(pluginsDir, kind)
+			infos, err := ioutil.ReadDir(dir)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Couldn't read the plugins in %s: %s", dir, err)
+			}
+			for _, info := range infos {
+				// Plugins are executables like modules
+				if !isModuleFile(info) {
+					continue
+				}
+				name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+				registry.Register(&Plugin{Kind: kind, Name: name, Path: filepath.Join(dir, info.Name())})
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the modules directories on the plugins path that exist, as a
+// path searched after the modules path
+func PluginModulesPath(pluginsPath string) string {
+	var dirs []string
+	for _, pluginsDir := range filepath.SplitList(pluginsPath) {
+		dir := filepath.Join(pluginsDir, ModulePlugins)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return strings.Join(dirs, string(filepath.ListSeparator))
+}
+
+// Prints the plugins of every kind along with the ones they shadow
+func (registry *PluginRegistry) Print() {
+	for _, kind := range PluginKinds {
+		plugins := registry.List(kind)
+		if len(plugins) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", kind)
+		for _, plugin := range plugins {
+			fmt.Printf("  %s\t%s\n", plugin.Name, plugin.Path)
+			for _, shadowed := range plugin.Shadows {
+				fmt.Printf("  \tshadows %s\n", shadowed)
+			}
+		}
+	}
+}
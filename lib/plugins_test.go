@@ -0,0 +1,57 @@
+package henchman
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiscoverPlugins(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	site, vendor := filepath.Join(dir, "site"), filepath.Join(dir, "vendor")
+	for _, plugin := range []string{"site/inventory/netbox.py", "vendor/inventory/netbox", "vendor/callbacks/slack", "vendor/modules/nginx"} {
+		path := filepath.Join(dir, filepath.FromSlash(plugin))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755)
+	}
+	ioutil.WriteFile(filepath.Join(vendor, "callbacks", "README"), []byte("Not a plugin\n"), 0644)
+
+	registry := NewPluginRegistry()
+	pluginsPath := strings.Join([]string{site, vendor, filepath.Join(dir, "missing")}, string(filepath.ListSeparator))
+	if err := registry.Discover(pluginsPath); err != nil {
+		t.Fatalf("Couldn't discover the plugins: %s\n", err)
+	}
+	netbox, present := registry.Lookup(InventoryPlugins, "netbox")
+	if !present || netbox.Path != filepath.Join(site, "inventory", "netbox.py") || len(netbox.Shadows) != 1 {
+		t.Errorf("Expected the first netbox to shadow the second. Got %+v\n", netbox)
+	}
+	if callbacks := registry.List(CallbackPlugins); len(callbacks) != 1 || callbacks[0].Name != "slack" {
+		t.Errorf("Expected only the executable callback. Got %v\n", callbacks)
+	}
+	if modulesPath := PluginModulesPath(pluginsPath); modulesPath != filepath.Join(vendor, "modules") {
+		t.Errorf("Modules path mismatch. Got %s\n", modulesPath)
+	}
+}
+
+type fakeTransport struct{}
+
+func (transport *fakeTransport) Name() string { return "fake" }
+
+func (transport *fakeTransport) Run(machine *Machine, action string, stdin io.Reader) (*Output, error) {
+	return NewOutput(), nil
+}
+
+func TestRegisteredTransport(t *testing.T) {
+	Transports["fake"] = func(machine *Machine) (Transport, error) {
+		return &fakeTransport{}, nil
+	}
+	defer delete(Transports, "fake")
+	machine := &Machine{Hostname: "appliance", Port: 22, Vars: TaskVars{"ansible_connection": "fake"}}
+	if err := applyConnection(machine); err != nil || machine.Transport == nil || machine.Transport.Name() != "fake" {
+		t.Errorf("Expected the registered transport. Got %v %v\n", machine.Transport, err)
+	}
+}
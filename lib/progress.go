@@ -0,0 +1,70 @@
+package henchman
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Returns the task the slowest machine is on, counting from 1, and how many
+// machines are through with the plan
+func (scheduler *Scheduler) progress() (int, int) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if len(scheduler.tasks) == 0 {
+		return 0, scheduler.hosts
+	}
+	task := len(scheduler.tasks)
+	for i, done := range scheduler.done {
+		if done < scheduler.hosts {
+			task = i + 1
+			break
+		}
+	}
+	return task, scheduler.done[len(scheduler.done)-1]
+}
+
+// Returns the hosts that failed a task or were quarantined so far
+func (plan *Plan) failedHosts() map[string]bool {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	failed := make(map[string]bool)
+	for _, result := range plan.results {
+		if failedStatus(result.Status) {
+			failed[result.Host] = true
+		}
+	}
+	for host := range plan.quarantined {
+		failed[host] = true
+	}
+	return failed
+}
+
+// Returns a one line summary of the run, like
+// "task 5/20, 140/200 hosts ok, 3 failed". Hosts are ok once they're
+// through the plan without failing.
+func Progress(scheduler *Scheduler, plan *Plan) string {
+	task, finished := scheduler.progress()
+	failed := len(plan.failedHosts())
+	ok := finished - failed
+	if ok < 0 {
+		ok = 0
+	}
+	return fmt.Sprintf("task %d/%d, %d/%d hosts ok, %d failed", task, len(scheduler.tasks), ok, scheduler.hosts, failed)
+}
+
+// Writes the progress line every interval until stop is closed, and once
+// more then. Meant for logs that aren't a terminal, like CI's.
+func ReportProgress(w io.Writer, interval time.Duration, scheduler *Scheduler, plan *Plan, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fmt.Fprintln(w, Progress(scheduler, plan))
+		case <-stop:
+			fmt.Fprintln(w, Progress(scheduler, plan))
+			return
+		}
+	}
+}
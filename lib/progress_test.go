@@ -0,0 +1,39 @@
+package henchman
+
+import (
+	"testing"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestProgress(t *testing.T) {
+	tasks := []Task{{Name: "first"}, {Name: "second"}}
+	plan := &Plan{Tasks: tasks}
+	scheduler := NewScheduler(tasks, 4, 0)
+	machines := Machines([]string{"web01", "web02", "web03"}, &ssh.ClientConfig{User: "deploy"})
+
+	if line := Progress(scheduler, plan); line != "task 1/2, 0/4 hosts ok, 0 failed" {
+		t.Errorf("Progress mismatch. Got %s\n", line)
+	}
+
+	// web01 and web02 are through, web03 failed the first task
+	for i := range tasks {
+		scheduler.TaskStarted(i)
+		scheduler.TaskDone(i)
+		scheduler.TaskStarted(i)
+		scheduler.TaskDone(i)
+	}
+	scheduler.TaskStarted(0)
+	scheduler.TaskDone(0)
+	scheduler.SkipFrom(1)
+	plan.SaveStatus(machines[2], &tasks[0], &TaskStatus{Status: "failed"})
+	if line := Progress(scheduler, plan); line != "task 1/2, 2/4 hosts ok, 1 failed" {
+		t.Errorf("Progress mismatch. Got %s\n", line)
+	}
+
+	scheduler.TaskStarted(0)
+	scheduler.TaskDone(0)
+	if line := Progress(scheduler, plan); line != "task 2/2, 2/4 hosts ok, 1 failed" {
+		t.Errorf("Progress mismatch. Got %s\n", line)
+	}
+}
@@ -0,0 +1,32 @@
+package henchman
+
+import (
+	"path"
+	"strings"
+)
+
+// Returns the machines marked protected in the inventory, with a truthy
+// protected host or group var, along with the ones whose hostname matches
+// any of the plan's protected patterns
+func ProtectedMachines(machines []*Machine, patterns []string) []*Machine {
+	var protected []*Machine
+	for _, machine := range machines {
+		if isProtectedMachine(machine, patterns) {
+			protected = append(protected, machine)
+		}
+	}
+	return protected
+}
+
+func isProtectedMachine(machine *Machine, patterns []string) bool {
+	switch strings.ToLower(hostSetting(machine.Vars, "protected")) {
+	case "true", "yes", "1":
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, machine.Hostname); matched {
+			return true
+		}
+	}
+	return false
+}
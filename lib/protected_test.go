@@ -0,0 +1,42 @@
+package henchman
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProtectedMachines(t *testing.T) {
+	machines := Machines([]string{"db01", "db02:2222", "web01"}, nil)
+	protected := ProtectedMachines(machines, []string{"db*", "cache*"})
+	if len(protected) != 2 {
+		t.Fatalf("Number of protected machines mismatch. Got %d instead\n", len(protected))
+	}
+	if protected[0].Hostname != "db01" || protected[1].Hostname != "db02" {
+		t.Errorf("Protected machines mismatch. Got %s and %s\n", protected[0].Hostname, protected[1].Hostname)
+	}
+	if len(ProtectedMachines(machines, nil)) != 0 {
+		t.Errorf("No machines should be protected without patterns\n")
+	}
+}
+
+func TestProtectedMachinesFromInventory(t *testing.T) {
+	inventory, err := ParseInventoryINI([]byte("[databases]\ndb01\ndb02\n[web]\nweb01\nweb02 protected=true\n[databases:vars]\nprotected=true\n"))
+	if err != nil {
+		t.Fatalf("Couldn't parse the inventory: %s\n", err)
+	}
+	DefaultInventory = inventory
+	defer func() { DefaultInventory = nil }()
+	machines := Machines([]string{"databases", "web"}, nil)
+	protected := ProtectedMachines(machines, nil)
+	var hostnames []string
+	for _, machine := range protected {
+		hostnames = append(hostnames, machine.Hostname)
+	}
+	if !reflect.DeepEqual(hostnames, []string{"db01", "db02", "web02"}) {
+		t.Errorf("Protected machines mismatch. Got %v\n", hostnames)
+	}
+	// The plan's patterns only add to the inventory's
+	if protected := ProtectedMachines(machines, []string{"web01"}); len(protected) != 4 {
+		t.Errorf("Expected the plan's patterns to protect web01 too. Got %d machines\n", len(protected))
+	}
+}
@@ -0,0 +1,63 @@
+package henchman
+
+import (
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// Reconnect retries connecting to a machine that can't be reached, waiting
+// twice as long after each failed attempt, so that a network blip doesn't
+// fail the host. Only connecting is retried: a command whose connection
+// dropped while it ran might have had effects already, see RetryPolicy for
+// retrying those.
+type Reconnect struct {
+	// Total number of attempts, 1 or less doesn't retry
+	Attempts int
+	// Pause after the first failed attempt
+	Backoff time.Duration
+	// Longest pause between attempts, if set
+	MaxBackoff time.Duration
+}
+
+// Returns how long to wait after the attempt failed
+func (reconnect Reconnect) delay(attempt int) time.Duration {
+	delay := reconnect.Backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if reconnect.MaxBackoff > 0 && delay >= reconnect.MaxBackoff {
+			return reconnect.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// Whether the error is from the network rather than from the machine
+// refusing us, like a failed authentication
+func isConnectionError(err error) bool {
+	switch ErrorCategory(err) {
+	case "unreachable", "connect timeout", "handshake timeout":
+		return true
+	}
+	// Dropped connections fail the handshake with a possibly wrapped EOF
+	return err == io.EOF || strings.HasSuffix(err.Error(), "EOF") || strings.Contains(err.Error(), "connection reset")
+}
+
+// Opens a new SSH connection to the machine, reconnecting on connection
+// errors as configured
+func (machine *Machine) dial() (*ssh.Client, error) {
+	for attempt := 1; ; attempt++ {
+		client, err := machine.dialOnce()
+		if err == nil || attempt >= machine.Reconnect.Attempts || !isConnectionError(err) {
+			return client, err
+		}
+		delay := machine.Reconnect.delay(attempt)
+		log.Printf("Couldn't connect to %s: %s, reconnecting in %s (%d/%d)\n", machine.Hostname, err, delay, attempt+1, machine.Reconnect.Attempts)
+		if err := machine.sleep(delay); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package henchman
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	reconnect := Reconnect{Attempts: 5, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if delay := reconnect.delay(attempt); delay != expected {
+			t.Errorf("Delay after attempt %d mismatch. Got %s\n", attempt, delay)
+		}
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	if !isConnectionError(&TimeoutError{"connect", time.Second}) {
+		t.Errorf("Connect timeouts should be connection errors\n")
+	}
+	if isConnectionError(&TimeoutError{"command", time.Second}) {
+		t.Errorf("Command timeouts shouldn't be connection errors\n")
+	}
+	if isConnectionError(errors.New("ssh: unable to authenticate")) {
+		t.Errorf("Authentication failures shouldn't be connection errors\n")
+	}
+}
+
+func TestDialReconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	accepted := make(chan bool, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Drop the connection before the handshake
+			accepted <- true
+			conn.Close()
+		}
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	config := &ssh.ClientConfig{
+		User:            "deploy",
+		HostKeyCallback: func(string, net.Addr, ssh.PublicKey) error { return nil },
+	}
+	machine := Machines([]string{"127.0.0.1:" + strconv.Itoa(port)}, config)[0]
+	machine.Reconnect = Reconnect{Attempts: 3, Backoff: time.Millisecond}
+	if _, err := machine.dial(); err == nil {
+		t.Fatalf("Expected the dial to fail\n")
+	}
+	if len(accepted) != 3 {
+		t.Errorf("Expected 3 connection attempts. Got %d\n", len(accepted))
+	}
+}
@@ -0,0 +1,45 @@
+package henchman
+
+import (
+	"strings"
+)
+
+// Stores the task's result in the machine's vars under the task's register
+// name, so that later tasks on the machine can use it in their templates
+// and conditions, e.g. {{ vars.result.stdout }} or 'result.rc == 0'.
+// Commands that didn't get to exit have an rc of -1.
+func (machine *Machine) Register(task *Task, status *TaskStatus, err error) {
+	if task.Register == "" || status == nil {
+		return
+	}
+	if machine.Vars == nil {
+		machine.Vars = make(TaskVars)
+	}
+	machine.Vars[task.Register] = registeredResult(status, err)
+}
+
+// Returns the result of the task as registered vars see it
+func registeredResult(status *TaskStatus, err error) map[string]interface{} {
+	rc := 0
+	if err != nil {
+		if code, exited := exitStatus(err); exited {
+			rc = code
+		} else {
+			rc = -1
+		}
+	}
+	stdout := strings.TrimRight(status.Message, "\n")
+	lines := []string{}
+	if stdout != "" {
+		lines = strings.Split(stdout, "\n")
+	}
+	return map[string]interface{}{
+		"stdout":       stdout,
+		"stdout_lines": lines,
+		"rc":           rc,
+		"changed":      status.Changed,
+		"status":       status.Status,
+		"failed":       status.Failed() || status.Status == StatusIgnored,
+		"skipped":      status.Status == StatusSkipped,
+	}
+}
@@ -0,0 +1,46 @@
+package henchman
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	machine := &Machine{Hostname: "127.0.0.1"}
+	vars := TaskVars{}
+	sudo := false
+
+	task := Task{Name: "Release", Sudo: &sudo, Action: "echo v1.2.3; echo v1.2.2", Register: "releases"}
+	status, err := task.Run(machine, &vars)
+	machine.Register(&task, status, err)
+	result, ok := machine.Vars["releases"].(map[string]interface{})
+	if !ok || result["stdout"] != "v1.2.3\nv1.2.2" || result["rc"] != 0 || len(result["stdout_lines"].([]string)) != 2 {
+		t.Errorf("Expected the task's output registered. Got %v\n", machine.Vars["releases"])
+	}
+
+	failing := Task{Name: "Missing", Sudo: &sudo, Action: "exit 3", IgnoreErrors: true, Register: "missing"}
+	status, err = failing.Run(machine, &vars)
+	machine.Register(&failing, status, err)
+	if missing := machine.Vars["missing"].(map[string]interface{}); missing["rc"] != 3 || missing["failed"] != true {
+		t.Errorf("Expected the exit code registered. Got %v\n", missing)
+	}
+
+	echo := Task{Name: "Latest", Sudo: &sudo, Action: "echo {{ vars.releases.stdout_lines.0 }}", When: "missing.rc == 3"}
+	status, err = echo.Run(machine, &vars)
+	if err != nil || strings.TrimSpace(status.Message) != "v1.2.3" {
+		t.Errorf("Expected later tasks to see the registered result. Got %v %v\n", status, err)
+	}
+
+	plan_string := `---
+name: "Register"
+hosts:
+  - web1
+tasks:
+  - name: Bad
+    action: echo
+    register: not-a-var
+`
+	if _, err := NewPlanFromYAML([]byte(plan_string), nil); err == nil {
+		t.Errorf("Expected a bad register name to be refused\n")
+	}
+}
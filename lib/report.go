@@ -0,0 +1,136 @@
+package henchman
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+	"time"
+)
+
+// Result is the outcome of a task on a single machine
+type Result struct {
+	Host   string
+	TaskId string
+	Task   string
+	// Position of the task in the plan, 0 if unknown
+	TaskIndex     int
+	Status        string
+	Changed       bool
+	Message       string
+	Duration      time.Duration
+	ErrorCategory string
+	// Whether the task rolled back another after a failure
+	Rollback bool
+	// Whether the task is a handler another notified
+	Handler bool
+	// How long the task was expected to take, 0 if it wasn't, see
+	// OverBudget
+	Budget time.Duration
+}
+
+// Report is the structured summary of a plan's execution. Custom report
+// templates are rendered against it.
+type Report struct {
+	Plan string
+	// Id of the run's manifest, if one was written
+	Manifest string
+	Hosts    []string
+	Results  []Result
+	Counts   map[string]int
+	// Counts of each host, keyed by host then status
+	HostCounts map[string]map[string]int
+	Errors     map[string]int
+	// Why hosts were quarantined, keyed by host
+	Quarantined map[string]string
+	Total       int
+	Attempted   int
+	// Rollback tasks and handlers run, which don't count towards the above
+	RolledBack int
+	Handlers   int
+	// Results that took longer than their budget allows
+	OverBudget []Result
+}
+
+// Returns the report for the results saved so far
+func (plan *Plan) Report() *Report {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+
+	report := &Report{
+		Plan:        plan.Name,
+		Manifest:    plan.Manifest,
+		Hosts:       plan.Hosts,
+		Results:     labelDuplicates(plan.results),
+		Counts:      make(map[string]int),
+		HostCounts:  make(map[string]map[string]int),
+		Errors:      make(map[string]int),
+		Quarantined: make(map[string]string),
+		Total:       len(plan.Tasks) * len(plan.Hosts),
+	}
+	for host, reason := range plan.quarantined {
+		report.Quarantined[host] = reason
+	}
+	for _, result := range plan.results {
+		if result.Rollback {
+			report.RolledBack++
+		} else if result.Handler {
+			report.Handlers++
+		} else {
+			// Hosts that skipped every task still get their line
+			counts := report.HostCounts[result.Host]
+			if counts == nil {
+				counts = make(map[string]int)
+				report.HostCounts[result.Host] = counts
+			}
+			if result.Status != StatusSkipped {
+				report.Attempted++
+				report.Counts[result.Status]++
+				counts[result.Status]++
+			}
+		}
+		if result.ErrorCategory != "" {
+			report.Errors[result.ErrorCategory]++
+		}
+		if result.OverBudget() {
+			report.OverBudget = append(report.OverBudget, result)
+		}
+	}
+	report.Counts[StatusSkipped] = report.Total - report.Attempted
+	for _, counts := range report.HostCounts {
+		attempted := 0
+		for _, count := range counts {
+			attempted += count
+		}
+		counts[StatusSkipped] = len(plan.Tasks) - attempted
+	}
+	return report
+}
+
+// Returns a copy of the results where the names shared by different tasks
+// of the plan are suffixed with the task's position, e.g. 'restart #4', so
+// that every row can be traced back to its task.
+func labelDuplicates(results []Result) []Result {
+	indices := make(map[string]map[int]bool)
+	for _, result := range results {
+		if indices[result.Task] == nil {
+			indices[result.Task] = make(map[int]bool)
+		}
+		indices[result.Task][result.TaskIndex] = true
+	}
+	labelled := append([]Result(nil), results...)
+	for i, result := range labelled {
+		if len(indices[result.Task]) > 1 && result.TaskIndex > 0 {
+			labelled[i].Task = fmt.Sprintf("%s #%d", result.Task, result.TaskIndex)
+		}
+	}
+	return labelled
+}
+
+// Renders the report with the given Go template text
+func (plan *Plan) RenderReport(w io.Writer, text string) error {
+	tmpl, err := template.New("report").Parse(text)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, plan.Report())
+}
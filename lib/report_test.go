@@ -0,0 +1,134 @@
+package henchman
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	plan := Plan{Name: "Sample plan", Hosts: []string{"foo", "bar"}, Tasks: []Task{{Name: "one"}, {Name: "two"}}}
+	foo := Machine{Hostname: "foo"}
+	plan.SaveStatus(&foo, &plan.Tasks[0], &TaskStatus{Status: "ok"})
+	plan.SaveStatus(&foo, &plan.Tasks[1], &TaskStatus{Status: "failed", Message: "boom"})
+
+	report := plan.Report()
+	if report.Total != 4 || report.Attempted != 2 {
+		t.Errorf("Report totals mismatch. Got %d total, %d attempted\n", report.Total, report.Attempted)
+	}
+	if report.Counts["skipped"] != 2 || report.Counts["ok"] != 1 || report.Counts["failed"] != 1 {
+		t.Errorf("Report counts mismatch. Got %v\n", report.Counts)
+	}
+	if counts := report.HostCounts["foo"]; counts["ok"] != 1 || counts["failed"] != 1 || counts["skipped"] != 0 {
+		t.Errorf("Host counts mismatch. Got %v\n", report.HostCounts)
+	}
+	if _, present := report.HostCounts["bar"]; present {
+		t.Errorf("Expected no counts for a host without results. Got %v\n", report.HostCounts)
+	}
+
+	// Tasks skipped on a host outside their group are recorded as skipped
+	bar := Machine{Hostname: "bar"}
+	plan.SaveStatus(&bar, &plan.Tasks[0], &TaskStatus{Status: StatusSkipped, Message: "not in group db"})
+	plan.SaveStatus(&bar, &plan.Tasks[1], &TaskStatus{Status: StatusSkipped, Message: "not in group db"})
+	report = plan.Report()
+	if counts := report.HostCounts["bar"]; counts == nil || counts["skipped"] != 2 || counts["ok"] != 0 {
+		t.Errorf("Skipped host counts mismatch. Got %v\n", report.HostCounts)
+	}
+	if report.Counts["skipped"] != 2 || report.Attempted != 2 {
+		t.Errorf("Report counts mismatch after skips. Got %v\n", report.Counts)
+	}
+	if report.Results[1].Host != "foo" || report.Results[1].Message != "boom" {
+		t.Errorf("Report result mismatch. Got %+v\n", report.Results[1])
+	}
+}
+
+func TestRenderReport(t *testing.T) {
+	plan := Plan{Name: "Sample plan", Hosts: []string{"foo"}, Tasks: []Task{{Name: "one"}}}
+	foo := Machine{Hostname: "foo"}
+	plan.SaveStatus(&foo, &plan.Tasks[0], &TaskStatus{Status: "ok"})
+
+	var b bytes.Buffer
+	err := plan.RenderReport(&b, "{{ .Plan }}:{{ range .Results }} {{ .Host }}/{{ .Task }}={{ .Status }}{{ end }}")
+	if err != nil {
+		t.Fatalf("Couldn't render the report: %s\n", err)
+	}
+	if b.String() != "Sample plan: foo/one=ok" {
+		t.Errorf("Rendered report mismatch. Got %s\n", b.String())
+	}
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	plan := Plan{Name: "Sample plan", Hosts: []string{"foo", "bar"}, Tasks: []Task{{Name: "one"}}}
+	foo := Machine{Hostname: "foo"}
+	bar := Machine{Hostname: "bar"}
+	plan.SaveStatus(&foo, &plan.Tasks[0], &TaskStatus{Status: "ok", Duration: time.Second})
+	plan.SaveStatus(&bar, &plan.Tasks[0], &TaskStatus{Status: "failed", Message: "<boom>", Duration: 2 * time.Second})
+
+	hosts := plan.Report().ByHost()
+	if len(hosts) != 2 || hosts[0].Host != "foo" || hosts[0].Failed || !hosts[1].Failed {
+		t.Errorf("Results by host mismatch. Got %+v\n", hosts)
+	}
+
+	var b bytes.Buffer
+	if err := plan.WriteHTMLReport(&b); err != nil {
+		t.Fatalf("Couldn't write the HTML report: %s\n", err)
+	}
+	html := b.String()
+	for _, expected := range []string{"&lt;boom&gt;", `width: 50%`, `width: 100%`, "<details open>"} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("HTML report is missing %s\n", expected)
+		}
+	}
+}
+
+func TestReportDuplicateTaskNames(t *testing.T) {
+	plan_string := `---
+name: "Duplicates"
+hosts:
+  - foo
+tasks:
+  - name: restart
+    action: service app restart
+  - name: migrate
+    action: app migrate
+  - name: restart
+    action: service app restart
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		t.Fatalf("Couldn't parse the plan: %s\n", err)
+	}
+	foo := Machine{Hostname: "foo"}
+	for i := range plan.Tasks {
+		plan.SaveStatus(&foo, &plan.Tasks[i], &TaskStatus{Status: "ok"})
+	}
+	var names []string
+	for _, result := range plan.Report().Results {
+		names = append(names, result.Task)
+	}
+	if strings.Join(names, ",") != "restart #1,migrate,restart #3" {
+		t.Errorf("Duplicate task names weren't told apart. Got %v\n", names)
+	}
+}
+
+func TestSaveSetupFailure(t *testing.T) {
+	plan := Plan{Name: "Sample plan", Hosts: []string{"foo", "bar"}, Tasks: []Task{{Name: "one"}, {Name: "two"}}}
+	foo := &Machine{Hostname: "foo"}
+	bar := &Machine{Hostname: "bar"}
+	plan.SaveSetupFailure(foo, "Gathering facts", &net.OpError{Op: "dial", Err: errors.New("refused")})
+	plan.SaveSetupFailure(bar, "Resolving the hierarchy", errors.New("bad data"))
+
+	report := plan.Report()
+	if counts := report.HostCounts["foo"]; counts[StatusUnreachable] != 1 || counts[StatusSkipped] != 1 {
+		t.Errorf("Host counts of the unreachable host mismatch. Got %v\n", counts)
+	}
+	if counts := report.HostCounts["bar"]; counts[StatusFailed] != 1 {
+		t.Errorf("Host counts of the failed host mismatch. Got %v\n", counts)
+	}
+	if !plan.AnyFailed([]*Machine{foo}) || !plan.AnyFailed([]*Machine{bar}) {
+		t.Errorf("Expected the hosts that couldn't be set up to count as failed\n")
+	}
+}
@@ -0,0 +1,120 @@
+package henchman
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// RetryPolicy retries a task that failed with one of the listed error
+// classes, so that transient infrastructure errors don't fail the plan
+// while genuine failures still fail fast. The classes are
+//
+//	any                                     any failure
+//	unreachable, timeout, <phase> timeout   see ErrorCategory
+//	connection reset, dns                   network errors
+//	rc=<n>                                  the command exited with n
+//	output=<regexp>, stderr=<regexp>        the output matches
+//
+// Outputs are captured with stdout and stderr combined, so stderr patterns
+// are matched against both.
+type RetryPolicy struct {
+	// Total number of attempts, 3 if not set
+	Attempts int
+	// Pause between attempts, e.g. 5s
+	Delay  string
+	Errors []string
+}
+
+func (policy *RetryPolicy) validate() error {
+	if policy.Delay != "" {
+		if _, err := time.ParseDuration(policy.Delay); err != nil {
+			return fmt.Errorf("Invalid retry delay '%s'", policy.Delay)
+		}
+	}
+	for _, class := range policy.Errors {
+		switch {
+		case strings.HasPrefix(class, "rc="):
+			if _, err := strconv.Atoi(class[3:]); err != nil {
+				return fmt.Errorf("Invalid retry exit code '%s'", class)
+			}
+		case strings.HasPrefix(class, "output="), strings.HasPrefix(class, "stderr="):
+			if _, err := regexp.Compile(strings.SplitN(class, "=", 2)[1]); err != nil {
+				return fmt.Errorf("Invalid retry pattern '%s': %s", class, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (policy *RetryPolicy) attempts() int {
+	if policy == nil {
+		return 1
+	}
+	if policy.Attempts <= 0 {
+		return 3
+	}
+	return policy.Attempts
+}
+
+func (policy *RetryPolicy) delay() time.Duration {
+	delay, _ := time.ParseDuration(policy.Delay)
+	return delay
+}
+
+// Returns the exit code of a command that ran and failed
+func exitStatus(err error) (int, bool) {
+	switch e := err.(type) {
+	case *ssh.ExitError:
+		return e.ExitStatus(), true
+	case *exec.ExitError:
+		return e.ExitCode(), true
+	case *BrokerError:
+		return e.ExitCode, e.ExitCode >= 0
+	case *PluginExitError:
+		return e.ExitCode, true
+	}
+	return 0, false
+}
+
+// Whether the error, and the output that came with it, is one of the
+// policy's retryable classes
+func (policy *RetryPolicy) retryable(err error, output string) bool {
+	if policy == nil || err == nil {
+		return false
+	}
+	category := ErrorCategory(err)
+	for _, class := range policy.Errors {
+		switch {
+		case class == "any", class == category:
+			return true
+		case class == "timeout" && strings.HasSuffix(category, " timeout"):
+			return true
+		case class == "connection reset" && strings.Contains(err.Error(), "connection reset"):
+			return true
+		case class == "dns":
+			if e, ok := err.(*net.OpError); ok {
+				err = e.Err
+			}
+			if _, ok := err.(*net.DNSError); ok {
+				return true
+			}
+		case strings.HasPrefix(class, "rc="):
+			if code, ok := exitStatus(err); ok && strconv.Itoa(code) == class[3:] {
+				return true
+			}
+		case strings.HasPrefix(class, "output="), strings.HasPrefix(class, "stderr="):
+			pattern, err := regexp.Compile(strings.SplitN(class, "=", 2)[1])
+			if err == nil && pattern.MatchString(output) {
+				return true
+			}
+		}
+	}
+	return false
+}
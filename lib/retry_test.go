@@ -0,0 +1,60 @@
+package henchman
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 75").Run()
+	policy := &RetryPolicy{Errors: []string{"unreachable", "timeout", "dns", "connection reset", "rc=75", "stderr=Temporary failure"}}
+	retryable := map[error]string{
+		&net.OpError{Op: "dial", Err: errors.New("refused")}:              "",
+		&TimeoutError{"handshake", time.Second}:                           "",
+		&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host"}}: "",
+		errors.New("read tcp: connection reset by peer"):                  "",
+		exitErr: "",
+		errors.New("Process exited with status 1"): "apt: Temporary failure resolving",
+	}
+	for err, output := range retryable {
+		if !policy.retryable(err, output) {
+			t.Errorf("Expected %v to be retried\n", err)
+		}
+	}
+	if policy.retryable(errors.New("Process exited with status 1"), "No such file") {
+		t.Errorf("Expected genuine failures not to be retried\n")
+	}
+	if (*RetryPolicy)(nil).retryable(exitErr, "") {
+		t.Errorf("Expected tasks without a policy not to be retried\n")
+	}
+}
+
+func TestRetryValidation(t *testing.T) {
+	invalid := []RetryPolicy{
+		{Delay: "soon"},
+		{Errors: []string{"rc=one"}},
+		{Errors: []string{"output=("}},
+	}
+	for _, policy := range invalid {
+		if err := policy.validate(); err == nil {
+			t.Errorf("Expected %v to be refused\n", policy)
+		}
+	}
+}
+
+func TestTaskRetries(t *testing.T) {
+	machine := Machine{Hostname: "127.0.0.1"}
+	task := Task{Name: "flaky", Action: "false", Retry: &RetryPolicy{Attempts: 3, Delay: "50ms", Errors: []string{"rc=1"}}}
+	vars := TaskVars{}
+	start := time.Now()
+	status, _ := task.Run(&machine, &vars)
+	if status.Status != "failed" {
+		t.Errorf("Expected the task to fail. Got %s\n", status.Status)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the task to be retried twice. Took %s\n", elapsed)
+	}
+}
@@ -0,0 +1,45 @@
+package henchman
+
+import "log"
+
+// Returns the plan's tasks followed by their rollback tasks and the
+// handlers, for what applies to all, like resolving files and modules
+func (plan *Plan) allTasks() []*Task {
+	var tasks []*Task
+	for i := range plan.Tasks {
+		tasks = append(tasks, &plan.Tasks[i])
+	}
+	for i := range plan.Tasks {
+		for j := range plan.Tasks[i].Rollback {
+			tasks = append(tasks, &plan.Tasks[i].Rollback[j])
+		}
+	}
+	for i := range plan.Handlers {
+		tasks = append(tasks, &plan.Handlers[i])
+	}
+	return tasks
+}
+
+// Rolls the machine back after a task failed on it by running the rollback
+// tasks of the tasks that succeeded before, latest task first. Each task's
+// rollback tasks run in the order they are listed. The failed task's
+// own rollback doesn't run since it didn't get to change anything. A
+// rollback that fails doesn't stop the others from running, so that as
+// much as possible is undone. Each rollback's status is passed to record.
+func (plan *Plan) Rollback(machine *Machine, localhost *Machine, succeeded []*Task, record func(task *Task, status *TaskStatus)) {
+	for i := len(succeeded) - 1; i >= 0; i-- {
+		for _, rollback := range succeeded[i].Rollback {
+			// The range copy keeps the shared rollback unprepared
+			target := machine
+			if rollback.LocalAction {
+				target = localhost
+			}
+			log.Printf("Rolling back '%s' on %s with '%s'\n", succeeded[i].Name, machine.Hostname, rollback.Name)
+			status, err := rollback.Run(target, plan.Vars)
+			if err != nil {
+				log.Printf("Rollback '%s' failed on %s: %s\n", rollback.Name, machine.Hostname, err)
+			}
+			record(&rollback, status)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRollback(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	plan_string := `---
+name: "Deploy"
+hosts:
+  - 127.0.0.1
+tasks:
+  - name: Stage release
+    action: mkdir DIR/release
+    rollback:
+      - name: Unstage release
+        action: rmdir DIR/release
+  - name: Mark release
+    action: touch DIR/release/marker
+    rollback:
+      - name: Unmark release
+        action: rm DIR/release/marker
+      - name: Record rollback
+        action: touch DIR/rolled-back
+  - name: Migrate
+    action: "false"
+`
+	plan, err := NewPlanFromYAML([]byte(strings.Replace(plan_string, "DIR", dir, -1)), nil)
+	if err != nil {
+		t.Fatalf("Couldn't parse the plan: %s\n", err)
+	}
+	machine := &Machine{Hostname: "127.0.0.1"}
+	var succeeded []*Task
+	for i := range plan.Tasks {
+		task := plan.Tasks[i]
+		status, _ := task.Run(machine, plan.Vars)
+		plan.SaveStatus(machine, &task, status)
+		if status.Status == "failed" {
+			break
+		}
+		succeeded = append(succeeded, &task)
+	}
+
+	var rolledBack []string
+	plan.Rollback(machine, machine, succeeded, func(task *Task, status *TaskStatus) {
+		rolledBack = append(rolledBack, task.Name+" "+status.Status)
+		plan.SaveStatus(machine, task, status)
+	})
+	if strings.Join(rolledBack, ", ") != "Unmark release ok, Record rollback ok, Unstage release ok" {
+		t.Errorf("Rollback order mismatch. Got %v\n", rolledBack)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "release")); !os.IsNotExist(err) {
+		t.Errorf("Expected the release to be rolled back\n")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rolled-back")); err != nil {
+		t.Errorf("Expected the rollback to be recorded\n")
+	}
+
+	report := plan.Report()
+	if report.Attempted != 3 || report.RolledBack != 3 || report.Counts["ok"] != 2 || report.Counts["skipped"] != 0 {
+		t.Errorf("Rollbacks shouldn't count as attempted tasks. Got %d attempted, %d rolled back, %v\n", report.Attempted, report.RolledBack, report.Counts)
+	}
+}
+
+func TestParsePlanWithNestedRollback(t *testing.T) {
+	plan_string := `---
+name: "Sample plan"
+hosts:
+  - 192.168.1.2
+tasks:
+  - name: Deploy
+    action: deploy
+    rollback:
+      - name: Undeploy
+        action: undeploy
+        rollback:
+          - name: Redeploy
+            action: deploy
+`
+	if _, err := NewPlanFromYAML([]byte(plan_string), nil); err == nil {
+		t.Errorf("Expected rollbacks of rollbacks to be refused\n")
+	}
+}
@@ -0,0 +1,61 @@
+package henchman
+
+import (
+	"os"
+	"sync"
+
+	"github.com/flosch/pongo2"
+)
+
+// Built-in variables templates can refer to directly, like
+// {{ henchman_run_id }}. henchman_host is set per machine when rendering.
+var runVars = struct {
+	sync.Mutex
+	vars pongo2.Context
+	// Template dialect of the plan, see Plan.TemplateDialect
+	dialect string
+}{vars: pongo2.Context{}}
+
+// Exposes the run and the plan to templates as henchman_run_id,
+// henchman_date, henchman_plan_name, henchman_hosts and
+// henchman_control_host, and renders them in the plan's dialect.
+func SetRunVars(run *Run, plan *Plan) {
+	controlHost, _ := os.Hostname()
+	runVars.Lock()
+	defer runVars.Unlock()
+	runVars.vars = pongo2.Context{
+		"henchman_run_id":       run.Id,
+		"henchman_date":         run.Started.Format("2006-01-02"),
+		"henchman_plan_name":    plan.Name,
+		"henchman_hosts":        plan.Hosts,
+		"henchman_control_host": controlHost,
+	}
+	runVars.dialect = plan.TemplateDialect
+}
+
+// Returns the template context for rendering on the machine. Besides
+// {{ vars.name }}, vars and facts can be referred to by their own names,
+// e.g. {{ app_user }} or {{ os_family }}.
+func templateContext(vars *TaskVars, machine *Machine) pongo2.Context {
+	machine = machine.renderedFor()
+	context := pongo2.Context{"vars": vars, "machine": machine, "lookup": Plugins.lookup}
+	// The item of the task's loop, see Task.WithItems
+	if vars != nil {
+		if item, present := (*vars)["item"]; present {
+			context["item"] = item
+		}
+	}
+	if machine != nil {
+		context["henchman_host"] = machine.Hostname
+	}
+	runVars.Lock()
+	defer runVars.Unlock()
+	for name, value := range runVars.vars {
+		context[name] = value
+	}
+	if runVars.dialect == JinjaDialect {
+		addJinjaContext(context, machine)
+	}
+	addBareVars(context, vars)
+	return context
+}
@@ -0,0 +1,26 @@
+package henchman
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunVars(t *testing.T) {
+	run := &Run{Id: "1234", Started: time.Date(2015, 3, 14, 9, 26, 0, 0, time.UTC)}
+	plan := &Plan{Name: "Deploy", Hosts: []string{"web1", "web2"}}
+	SetRunVars(run, plan)
+	defer func() { runVars.vars = nil }()
+
+	cache := newRenderCache()
+	vars := TaskVars{}
+	template := "{{ henchman_plan_name }} {{ henchman_run_id }} {{ henchman_date }} on {{ henchman_host }} of {{ henchman_hosts|join:\",\" }}"
+	for _, host := range plan.Hosts {
+		out, err := cache.render(template, digestVars(&vars), &vars, &Machine{Hostname: host})
+		if expected := "Deploy 1234 2015-03-14 on " + host + " of web1,web2"; out != expected {
+			t.Errorf("Render mismatch. Got '%s' (%v) instead of '%s'\n", out, err, expected)
+		}
+	}
+	if out, _ := cache.render("{{ henchman_control_host }}", digestVars(&vars), &vars, nil); out == "" {
+		t.Errorf("Expected the control host to be set\n")
+	}
+}
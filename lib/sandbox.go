@@ -0,0 +1,91 @@
+package henchman
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var errLocalSandbox = errors.New("Sandboxing isn't supported for local actions")
+
+var (
+	umaskPattern = regexp.MustCompile(`^[0-7]{3,4}$`)
+	shellPattern = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+)
+
+// Sandbox restricts how a task's command runs on the remote machine, for
+// defense in depth when running third party modules. Under a restricted
+// shell like rbash, commands have to be found on the PATH since names with
+// slashes are refused.
+type Sandbox struct {
+	// Shell the command is run with, e.g. rbash
+	Shell string
+	Umask string
+	// Paths bind mounted read-only over themselves in a private mount
+	// namespace. Needs unshare and the privileges to mount on the machine,
+	// the task fails instead of running unprotected otherwise.
+	ReadOnly []string `yaml:"read_only"`
+}
+
+func (sandbox *Sandbox) validate() error {
+	if sandbox.Umask != "" && !umaskPattern.MatchString(sandbox.Umask) {
+		return fmt.Errorf("Invalid umask '%s'", sandbox.Umask)
+	}
+	if sandbox.Shell != "" && !shellPattern.MatchString(sandbox.Shell) {
+		return fmt.Errorf("Invalid shell '%s'", sandbox.Shell)
+	}
+	for _, p := range sandbox.ReadOnly {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("Read-only path '%s' isn't absolute", p)
+		}
+	}
+	return nil
+}
+
+// Wraps the command so that it runs in the sandbox. A nil sandbox leaves
+// the command alone.
+func (sandbox *Sandbox) wrap(command string) (string, error) {
+	if sandbox == nil {
+		return command, nil
+	}
+	if err := sandbox.validate(); err != nil {
+		return "", err
+	}
+	if sandbox.Umask != "" {
+		command = "umask " + sandbox.Umask + "; " + command
+	}
+	shell := sandbox.Shell
+	if shell == "" {
+		shell = "sh"
+	}
+	command = shell + " -c " + shellQuote(command)
+	if len(sandbox.ReadOnly) == 0 {
+		return command, nil
+	}
+
+	var paths []string
+	for _, p := range sandbox.ReadOnly {
+		paths = append(paths, shellQuote(p))
+	}
+	mounts := `for p in "$@"; do mount --bind "$p" "$p" && mount -o remount,ro,bind "$p" "$p" || exit 1; done; ` + command
+	return "command -v unshare >/dev/null || { echo 'read_only needs unshare on the machine' >&2; exit 1; }; " +
+		"unshare --mount sh -c " + shellQuote(mounts) + " sh " + strings.Join(paths, " "), nil
+}
+
+// Runs the action on the machine within the sandbox, if any, with sudo if
+// the task asks for it
+func (machine *Machine) execSandboxed(action string, sandbox *Sandbox, become *become) (*Output, error) {
+	if sandbox == nil {
+		return machine.run(become.wrap(action, nil))
+	}
+	if machine.isLocal() {
+		return NewOutput(), errLocalSandbox
+	}
+	command, err := sandbox.wrap(action)
+	if err != nil {
+		return NewOutput(), err
+	}
+	return machine.run(become.wrap(command, nil))
+}
@@ -0,0 +1,51 @@
+package henchman
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestSandboxWrap(t *testing.T) {
+	sandbox := &Sandbox{Umask: "0077"}
+	command, err := sandbox.wrap("umask; echo 'it''s' done")
+	if err != nil {
+		t.Fatalf("Couldn't wrap the command: %s\n", err)
+	}
+	out, err := exec.Command("sh", "-c", command).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Wrapped command failed: %s\n", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); lines[0] != "0077" || lines[1] != "its done" {
+		t.Errorf("Wrapped command output mismatch. Got %s\n", out)
+	}
+
+	command, _ = (&Sandbox{Shell: "rbash", ReadOnly: []string{"/etc"}}).wrap("ls")
+	if !strings.Contains(command, "unshare --mount") || !strings.Contains(command, `rbash -c '\''ls'\''`) || !strings.HasSuffix(command, " sh '/etc'") {
+		t.Errorf("Read-only wrapping mismatch. Got %s\n", command)
+	}
+
+	if command, _ := (*Sandbox)(nil).wrap("ls"); command != "ls" {
+		t.Errorf("Expected no sandbox to leave the command alone. Got %s\n", command)
+	}
+}
+
+func TestSandboxValidation(t *testing.T) {
+	invalid := []Sandbox{
+		{Umask: "022; rm -rf /"},
+		{Shell: "rbash; id"},
+		{ReadOnly: []string{"etc"}},
+	}
+	for _, sandbox := range invalid {
+		if _, err := sandbox.wrap("ls"); err == nil {
+			t.Errorf("Expected %v to be refused\n", sandbox)
+		}
+	}
+}
+
+func TestSandboxedLocalAction(t *testing.T) {
+	machine := Machine{Hostname: "127.0.0.1"}
+	if _, err := machine.execSandboxed("ls", &Sandbox{Umask: "077"}, nil); err != errLocalSandbox {
+		t.Errorf("Expected sandboxed local actions to be refused. Got %v\n", err)
+	}
+}
@@ -0,0 +1,87 @@
+package henchman
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Files laid down by Scaffold, relative to the target directory. The plan
+// runs as it is against the dev environment, whose inventory has the
+// control machine as a local host.
+var scaffoldFiles = map[string]string{
+	"plan.yaml": `---
+# Run with: henchman -env dev plan.yaml
+name: "My first plan"
+
+vars:
+  greeting: hello
+
+hosts:
+  - localhost
+
+tasks:
+  - name: Say {{ vars.greeting }} from the control machine
+    action: echo {{ vars.greeting }}
+    local: true
+
+  - name: Get uname
+    action: uname -a
+
+  - name: Run a local script on the host
+    script: scripts/hello.sh
+    ignore_errors: true
+`,
+	"scripts/hello.sh": `echo "hello from $(hostname)"
+`,
+	"vars/dev.yaml": `# Vars of the dev environment, layered over the plan's with -env dev
+greeting: hello from dev
+`,
+	"inventory/dev": `# Inventory of the dev environment, used with -env dev
+[local]
+localhost connection=local interpreter=sh
+`,
+	"group_vars/all.yaml": `# Connection settings and vars for every host. Groups and hosts can
+# override them in group_vars/<group>.yaml and host_vars/<host>.yaml.
+#
+# ansible_user: deploy
+# ansible_port: 22
+# ansible_ssh_private_key_file: ~/.ssh/id_rsa
+# interpreter: /usr/bin/python3
+`,
+	"modules/README": `Executable modules for this plan live here. See 'henchman module list'.
+`,
+	"templates/README": `Templates for template tasks and reports live here, e.g.
+'henchman -report-template report.tmpl plan.yaml'.
+`,
+	"templates/report.tmpl": `{{ .Plan }}
+{{ range .Results }}{{ .Host }}	{{ .Status }}	{{ .Task }}
+{{ end }}`,
+}
+
+// Lays down a working plan skeleton in dir. Existing files are never
+// overwritten; the scaffold fails instead.
+func Scaffold(dir string) ([]string, error) {
+	var files []string
+	for file := range scaffoldFiles {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(file))); err == nil {
+			return nil, fmt.Errorf("%s already exists", filepath.Join(dir, filepath.FromSlash(file)))
+		}
+	}
+	for _, file := range files {
+		target := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(target, []byte(scaffoldFiles[file]), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
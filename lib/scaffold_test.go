@@ -0,0 +1,78 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestScaffold(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := Scaffold(dir)
+	if err != nil {
+		t.Fatalf("Couldn't scaffold: %s\n", err)
+	}
+	if len(files) != len(scaffoldFiles) {
+		t.Errorf("Number of scaffolded files mismatch. Got %d instead\n", len(files))
+	}
+	planBuf, err := ioutil.ReadFile(path.Join(dir, "plan.yaml"))
+	if err != nil {
+		t.Fatalf("Scaffolded plan is missing: %s\n", err)
+	}
+	plan, err := NewPlanFromYAML(planBuf, nil)
+	if err != nil {
+		t.Fatalf("Scaffolded plan doesn't parse: %s\n", err)
+	}
+	if len(plan.Tasks) != 3 {
+		t.Errorf("Number of scaffolded tasks mismatch. Got %d instead\n", len(plan.Tasks))
+	}
+
+	if _, err := Scaffold(dir); err == nil {
+		t.Errorf("Scaffolding over existing files should fail\n")
+	}
+}
+
+func TestScaffoldedPlanLoads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := Scaffold(dir); err != nil {
+		t.Fatalf("Couldn't scaffold: %s\n", err)
+	}
+
+	envVars, err := LoadEnvVars(dir, "dev")
+	if err != nil {
+		t.Fatalf("Couldn't load the dev vars: %s\n", err)
+	}
+	planBuf, _ := ioutil.ReadFile(path.Join(dir, "plan.yaml"))
+	plan, err := NewPlanFromYAML(planBuf, &envVars)
+	if err != nil {
+		t.Fatalf("Scaffolded plan doesn't parse: %s\n", err)
+	}
+	plan.Dir = dir
+	if err := plan.ResolveFiles(); err != nil {
+		t.Errorf("Scaffolded plan's files should resolve. Got %s\n", err)
+	}
+	if (*plan.Vars)["greeting"] != "hello from dev" {
+		t.Errorf("The dev vars should win over the plan's. Got %v\n", (*plan.Vars)["greeting"])
+	}
+	if _, err := LoadGroupVars(dir); err != nil {
+		t.Errorf("Scaffolded group vars don't load: %s\n", err)
+	}
+
+	inventory, err := LoadInventory(EnvInventoryPath(dir, "dev"))
+	if err != nil {
+		t.Fatalf("Scaffolded inventory doesn't load: %s\n", err)
+	}
+	if connection := hostSetting(inventory.Vars(plan.Hosts[0]), "connection"); connection != LocalConnection {
+		t.Errorf("The plan's host should be local in the dev inventory. Got %q\n", connection)
+	}
+}
@@ -0,0 +1,118 @@
+package henchman
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduler limits how many machines run the plan concurrently and tracks,
+// per task, how many machines are waiting on it, running it or done with it.
+// A fork is held by a machine for its whole task list.
+type Scheduler struct {
+	Forks int
+
+	tasks   []Task
+	hosts   int
+	slots   chan bool
+	mutex   sync.Mutex
+	busy    int
+	running []int
+	done    []int
+}
+
+// Returns a scheduler for the tasks across the given number of hosts. A
+// non-positive forks value doesn't limit the concurrency.
+func NewScheduler(tasks []Task, hosts int, forks int) *Scheduler {
+	scheduler := &Scheduler{
+		Forks:   forks,
+		tasks:   tasks,
+		hosts:   hosts,
+		running: make([]int, len(tasks)),
+		done:    make([]int, len(tasks)),
+	}
+	if forks > 0 {
+		scheduler.slots = make(chan bool, forks)
+	}
+	return scheduler
+}
+
+// Blocks until a fork is free for a machine
+func (scheduler *Scheduler) Acquire() {
+	if scheduler.slots != nil {
+		scheduler.slots <- true
+	}
+	scheduler.mutex.Lock()
+	scheduler.busy++
+	scheduler.mutex.Unlock()
+}
+
+func (scheduler *Scheduler) Release() {
+	scheduler.mutex.Lock()
+	scheduler.busy--
+	scheduler.mutex.Unlock()
+	if scheduler.slots != nil {
+		<-scheduler.slots
+	}
+}
+
+func (scheduler *Scheduler) TaskStarted(index int) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.running[index]++
+}
+
+func (scheduler *Scheduler) TaskDone(index int) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.running[index]--
+	scheduler.done[index]++
+}
+
+// Takes back TaskStarted for a task that ended up not running
+func (scheduler *Scheduler) TaskAborted(index int) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.running[index]--
+}
+
+// Marks the tasks from index onwards as done for a machine that won't run them
+func (scheduler *Scheduler) SkipFrom(index int) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	for i := index; i < len(scheduler.done); i++ {
+		scheduler.done[i]++
+	}
+}
+
+func (scheduler *Scheduler) String() string {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	forks := "unlimited"
+	if scheduler.Forks > 0 {
+		forks = fmt.Sprintf("%d", scheduler.Forks)
+	}
+	lines := []string{fmt.Sprintf("forks in use %d/%s", scheduler.busy, forks)}
+	for i, task := range scheduler.tasks {
+		waiting := scheduler.hosts - scheduler.running[i] - scheduler.done[i]
+		lines = append(lines, fmt.Sprintf("  task %d '%s': waiting %d, running %d, done %d",
+			i+1, task.Name, waiting, scheduler.running[i], scheduler.done[i]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Logs the scheduler state every interval until stop is closed
+func (scheduler *Scheduler) Report(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.Printf("Scheduler: %s\n", scheduler)
+		case <-stop:
+			return
+		}
+	}
+}
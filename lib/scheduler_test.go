@@ -0,0 +1,47 @@
+package henchman
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchedulerState(t *testing.T) {
+	tasks := []Task{{Name: "first"}, {Name: "second"}}
+	scheduler := NewScheduler(tasks, 3, 2)
+
+	scheduler.Acquire()
+	scheduler.TaskStarted(0)
+	scheduler.TaskDone(0)
+	scheduler.TaskStarted(1)
+	scheduler.Acquire()
+	scheduler.TaskStarted(0)
+	scheduler.TaskDone(0)
+	scheduler.SkipFrom(1)
+
+	state := scheduler.String()
+	expected := []string{
+		"forks in use 2/2",
+		"task 1 'first': waiting 1, running 0, done 2",
+		"task 2 'second': waiting 1, running 1, done 1",
+	}
+	for _, line := range expected {
+		if !strings.Contains(state, line) {
+			t.Errorf("Scheduler state is missing '%s'. Got:\n%s\n", line, state)
+		}
+	}
+
+	scheduler.Release()
+	if state := scheduler.String(); !strings.Contains(state, "forks in use 1/2") {
+		t.Errorf("Releasing should free a fork. Got:\n%s\n", state)
+	}
+}
+
+func TestSchedulerUnlimitedForks(t *testing.T) {
+	scheduler := NewScheduler(nil, 100, 0)
+	for i := 0; i < 100; i++ {
+		scheduler.Acquire()
+	}
+	if state := scheduler.String(); !strings.Contains(state, "forks in use 100/unlimited") {
+		t.Errorf("Forks shouldn't be limited. Got:\n%s\n", state)
+	}
+}
@@ -0,0 +1,43 @@
+package henchman
+
+import (
+	"sort"
+)
+
+// ScopedVars are vars only meant for some of the machines, keyed by the
+// group or hostname they're scoped to, e.g. the extra args
+// 'db:pgsql_version=15'
+type ScopedVars map[string]TaskVars
+
+// Returns the vars scoped to the machine. Vars scoped to its hostname win
+// over the ones scoped to its groups, which are layered in the order of
+// their names.
+func (scoped ScopedVars) For(machine *Machine) TaskVars {
+	vars := make(TaskVars)
+	for _, layer := range scoped.Layers(machine) {
+		for variable, value := range layer.Vars {
+			vars[variable] = value
+		}
+	}
+	return vars
+}
+
+// Returns the layers of vars scoped to the machine in the order they
+// apply, see For. Layers are named after their scope.
+func (scoped ScopedVars) Layers(machine *Machine) []VarLayer {
+	var scopes []string
+	for scope := range scoped {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	var layers []VarLayer
+	for _, scope := range scopes {
+		if scope != machine.Hostname && machine.InGroup(scope) {
+			layers = append(layers, VarLayer{Source: scope, Vars: scoped[scope]})
+		}
+	}
+	if vars, present := scoped[machine.Hostname]; present {
+		layers = append(layers, VarLayer{Source: machine.Hostname, Vars: vars})
+	}
+	return layers
+}
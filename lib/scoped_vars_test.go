@@ -0,0 +1,24 @@
+package henchman
+
+import (
+	"testing"
+)
+
+func TestScopedVars(t *testing.T) {
+	DefaultInventory = &Inventory{Groups: map[string][]string{"db": {"db1", "db2"}, "primary": {"db1"}}}
+	defer func() { DefaultInventory = nil }()
+	scoped := ScopedVars{
+		"db":      {"pgsql_version": "15", "shared_buffers": "4GB"},
+		"primary": {"shared_buffers": "8GB"},
+		"db2":     {"pgsql_version": "14"},
+	}
+	if vars := scoped.For(&Machine{Hostname: "db1"}); vars["pgsql_version"] != "15" || vars["shared_buffers"] != "8GB" {
+		t.Errorf("Expected the vars of db1's groups. Got %v\n", vars)
+	}
+	if vars := scoped.For(&Machine{Hostname: "db2"}); vars["pgsql_version"] != "14" || vars["shared_buffers"] != "4GB" {
+		t.Errorf("Expected the host's own scope to win over its group's. Got %v\n", vars)
+	}
+	if vars := scoped.For(&Machine{Hostname: "web1", Groups: []string{"web"}}); len(vars) != 0 {
+		t.Errorf("Expected no vars outside the scopes. Got %v\n", vars)
+	}
+}
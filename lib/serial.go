@@ -0,0 +1,52 @@
+package henchman
+
+import "fmt"
+
+// SerialBatch is a set of machines that run the plan at the same time
+type SerialBatch struct {
+	// Value of the plan's serial_by var the machines share
+	Value    string
+	Machines []*Machine
+
+	// Whether these are the plan's canaries, see Plan.Batches
+	Canary bool
+}
+
+// Splits the machines into batches that run one after another by the value
+// of their serialBy var, e.g. 'az' or 'rack', so that a rolling change never
+// touches more than one zone or rack at a time. The var is looked up in the
+// machines' inventory vars. Batches come in the order their values first
+// appear, and machines without the var make up a batch of their own. All the
+// machines are a single batch without serialBy.
+func SerialBatches(machines []*Machine, serialBy string) []SerialBatch {
+	if serialBy == "" {
+		return []SerialBatch{{Machines: machines}}
+	}
+	var batches []SerialBatch
+	index := make(map[string]int)
+	for _, machine := range machines {
+		value := ""
+		if v, found := lookupVar(machine.Vars, serialBy); found {
+			value = fmt.Sprint(v)
+		}
+		i, seen := index[value]
+		if !seen {
+			i = len(batches)
+			index[value] = i
+			batches = append(batches, SerialBatch{Value: value})
+		}
+		batches[i].Machines = append(batches[i].Machines, machine)
+	}
+	return batches
+}
+
+// Whether any of the machines failed or was quarantined
+func (plan *Plan) AnyFailed(machines []*Machine) bool {
+	failed := plan.failedHosts()
+	for _, machine := range machines {
+		if failed[machine.Hostname] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package henchman
+
+import (
+	"testing"
+)
+
+func TestSerialBatches(t *testing.T) {
+	machines := []*Machine{
+		{Hostname: "web-01", Vars: TaskVars{"az": "us-east-1a"}},
+		{Hostname: "web-02", Vars: TaskVars{"az": "us-east-1b"}},
+		{Hostname: "web-03", Vars: TaskVars{"az": "us-east-1a"}},
+		{Hostname: "web-04"},
+	}
+	batches := SerialBatches(machines, "az")
+	if len(batches) != 3 {
+		t.Fatalf("Expected a batch per zone and one for the rest. Got %v\n", batches)
+	}
+	if batches[0].Value != "us-east-1a" || len(batches[0].Machines) != 2 || batches[0].Machines[1].Hostname != "web-03" {
+		t.Errorf("First batch mismatch. Got %+v\n", batches[0])
+	}
+	if batches[2].Value != "" || batches[2].Machines[0].Hostname != "web-04" {
+		t.Errorf("Expected the hosts without the var last. Got %+v\n", batches[2])
+	}
+	if batches := SerialBatches(machines, ""); len(batches) != 1 || len(batches[0].Machines) != 4 {
+		t.Errorf("Expected a single batch without serial_by. Got %v\n", batches)
+	}
+
+	plan := Plan{}
+	plan.SaveStatus(machines[1], &Task{Name: "restart"}, &TaskStatus{Status: "failed"})
+	if plan.AnyFailed(batches[0].Machines) || !plan.AnyFailed(batches[1].Machines) {
+		t.Errorf("Expected only the second batch to have failed\n")
+	}
+}
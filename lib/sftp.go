@@ -0,0 +1,214 @@
+package henchman
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The few packets of SFTP version 3 needed to upload and download files
+const (
+	sftpInit    = 1
+	sftpVersion = 2
+	sftpOpen    = 3
+	sftpClose   = 4
+	sftpRead    = 5
+	sftpWrite   = 6
+	sftpStatus  = 101
+	sftpHandle  = 102
+	sftpData    = 103
+
+	sftpStatusEOF = 1
+
+	sftpOpenRead     = 0x01
+	sftpOpenWrite    = 0x02
+	sftpOpenCreate   = 0x08
+	sftpOpenTruncate = 0x10
+
+	// Servers are only required to take packets of up to 34000 bytes
+	sftpChunkSize = 32 << 10
+)
+
+// sftpClient speaks SFTP over a session's stdin and stdout. Requests are
+// sent one at a time, each waiting for its response.
+type sftpClient struct {
+	w  io.Writer
+	r  io.Reader
+	id uint32
+}
+
+type sftpPacket []byte
+
+func (p sftpPacket) uint32(v uint32) sftpPacket {
+	return append(p, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (p sftpPacket) uint64(v uint64) sftpPacket {
+	return p.uint32(uint32(v >> 32)).uint32(uint32(v))
+}
+
+func (p sftpPacket) string(s []byte) sftpPacket {
+	return append(p.uint32(uint32(len(s))), s...)
+}
+
+func (p sftpPacket) append(other sftpPacket) sftpPacket {
+	return append(p, other...)
+}
+
+func (client *sftpClient) send(packetType byte, payload sftpPacket) error {
+	packet := sftpPacket{}.uint32(uint32(len(payload) + 1))
+	packet = append(packet, packetType)
+	_, err := client.w.Write(append(packet, payload...))
+	return err
+}
+
+func (client *sftpClient) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(client.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > 256<<10 {
+		return 0, nil, fmt.Errorf("sftp: bad packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	_, err := io.ReadFull(client.r, payload)
+	return header[4], payload, err
+}
+
+// Sends the request and returns the response's payload after its id
+func (client *sftpClient) request(packetType byte, payload sftpPacket) (byte, []byte, error) {
+	client.id++
+	id := client.id
+	if err := client.send(packetType, sftpPacket{}.uint32(id).append(payload)); err != nil {
+		return 0, nil, err
+	}
+	responseType, response, err := client.receive()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(response) < 4 || binary.BigEndian.Uint32(response) != id {
+		return 0, nil, fmt.Errorf("sftp: unexpected response")
+	}
+	return responseType, response[4:], nil
+}
+
+// Returns the code of a status response
+func sftpStatusCode(responseType byte, response []byte) (uint32, error) {
+	if responseType != sftpStatus || len(response) < 4 {
+		return 0, fmt.Errorf("sftp: expected a status, got packet type %d", responseType)
+	}
+	return binary.BigEndian.Uint32(response), nil
+}
+
+// Returns the error of a status response, nil if it's a success
+func sftpStatusError(responseType byte, response []byte) error {
+	code, err := sftpStatusCode(responseType, response)
+	if err != nil || code == 0 {
+		return err
+	}
+	message := ""
+	if len(response) >= 8 {
+		length := binary.BigEndian.Uint32(response[4:])
+		if int(length) <= len(response)-8 {
+			message = string(response[8 : 8+length])
+		}
+	}
+	return fmt.Errorf("sftp: %s (status %d)", message, code)
+}
+
+func (client *sftpClient) init() error {
+	if err := client.send(sftpInit, sftpPacket{}.uint32(3)); err != nil {
+		return err
+	}
+	responseType, _, err := client.receive()
+	if err == nil && responseType != sftpVersion {
+		err = fmt.Errorf("sftp: expected the version, got packet type %d", responseType)
+	}
+	return err
+}
+
+// Opens the remote path and returns its handle
+func (client *sftpClient) open(path string, flags uint32) ([]byte, error) {
+	responseType, response, err := client.request(sftpOpen, sftpPacket{}.string([]byte(path)).uint32(flags).uint32(0))
+	if err != nil {
+		return nil, err
+	}
+	if responseType != sftpHandle {
+		return nil, sftpStatusError(responseType, response)
+	}
+	if len(response) < 4 || int(binary.BigEndian.Uint32(response)) != len(response)-4 {
+		return nil, fmt.Errorf("sftp: bad handle")
+	}
+	return response[4:], nil
+}
+
+func (client *sftpClient) close(handle []byte) error {
+	responseType, response, err := client.request(sftpClose, sftpPacket{}.string(handle))
+	if err == nil {
+		err = sftpStatusError(responseType, response)
+	}
+	return err
+}
+
+// Writes the contents to the remote path, creating or truncating it
+func (client *sftpClient) upload(path string, contents io.Reader) (int64, error) {
+	handle, err := client.open(path, sftpOpenWrite|sftpOpenCreate|sftpOpenTruncate)
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	chunk := make([]byte, sftpChunkSize)
+	for {
+		n, readErr := contents.Read(chunk)
+		if n > 0 {
+			payload := sftpPacket{}.string(handle).uint64(uint64(offset)).string(chunk[:n])
+			responseType, response, err := client.request(sftpWrite, payload)
+			if err == nil {
+				err = sftpStatusError(responseType, response)
+			}
+			if err != nil {
+				return offset, err
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return offset, readErr
+		}
+	}
+	return offset, client.close(handle)
+}
+
+// Reads the remote path into w until the server reports the end of the file
+func (client *sftpClient) download(path string, w io.Writer) (int64, error) {
+	handle, err := client.open(path, sftpOpenRead)
+	if err != nil {
+		return 0, err
+	}
+	var offset int64
+	for {
+		payload := sftpPacket{}.string(handle).uint64(uint64(offset)).uint32(sftpChunkSize)
+		responseType, response, err := client.request(sftpRead, payload)
+		if err != nil {
+			return offset, err
+		}
+		if responseType != sftpData {
+			if code, err := sftpStatusCode(responseType, response); err == nil && code == sftpStatusEOF {
+				break
+			}
+			return offset, sftpStatusError(responseType, response)
+		}
+		if len(response) < 4 || int(binary.BigEndian.Uint32(response)) != len(response)-4 {
+			return offset, fmt.Errorf("sftp: bad data")
+		}
+		n, err := w.Write(response[4:])
+		offset += int64(n)
+		if err != nil {
+			return offset, err
+		}
+	}
+	return offset, client.close(handle)
+}
@@ -0,0 +1,135 @@
+package henchman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Serves the SFTP requests needed for uploads and downloads, keeping the
+// files in memory
+type fakeSFTPServer struct {
+	mutex sync.Mutex
+	files map[string][]byte
+}
+
+func (server *fakeSFTPServer) serve(r io.Reader, w io.Writer) {
+	client := &sftpClient{w: w, r: r}
+	handles := make(map[string]string)
+	for {
+		packetType, payload, err := client.receive()
+		if err != nil {
+			return
+		}
+		if packetType == sftpInit {
+			client.send(sftpVersion, sftpPacket{}.uint32(3))
+			continue
+		}
+		id := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+		readString := func() []byte {
+			length := binary.BigEndian.Uint32(payload)
+			s := payload[4 : 4+length]
+			payload = payload[4+length:]
+			return s
+		}
+		status := sftpPacket{}.uint32(id).uint32(0).string(nil).string(nil)
+		switch packetType {
+		case sftpOpen:
+			path := string(readString())
+			if strings.HasPrefix(path, "/readonly/") {
+				client.send(sftpStatus, sftpPacket{}.uint32(id).uint32(3).string([]byte("Permission denied")).string(nil))
+				continue
+			}
+			flags := binary.BigEndian.Uint32(payload)
+			server.mutex.Lock()
+			_, exists := server.files[path]
+			if flags&sftpOpenRead == 0 {
+				server.files[path] = nil
+			}
+			server.mutex.Unlock()
+			if flags&sftpOpenRead != 0 && !exists {
+				client.send(sftpStatus, sftpPacket{}.uint32(id).uint32(2).string([]byte("No such file")).string(nil))
+				continue
+			}
+			handles[path] = path
+			client.send(sftpHandle, sftpPacket{}.uint32(id).string([]byte(path)))
+		case sftpWrite:
+			path := string(readString())
+			offset := binary.BigEndian.Uint64(payload)
+			payload = payload[8:]
+			data := readString()
+			server.mutex.Lock()
+			server.files[path] = append(server.files[path][:offset], data...)
+			server.mutex.Unlock()
+			client.send(sftpStatus, status)
+		case sftpRead:
+			path := string(readString())
+			offset := binary.BigEndian.Uint64(payload)
+			length := uint64(binary.BigEndian.Uint32(payload[8:]))
+			server.mutex.Lock()
+			data := server.files[path]
+			server.mutex.Unlock()
+			if offset >= uint64(len(data)) {
+				client.send(sftpStatus, sftpPacket{}.uint32(id).uint32(sftpStatusEOF).string(nil).string(nil))
+				continue
+			}
+			if offset+length > uint64(len(data)) {
+				length = uint64(len(data)) - offset
+			}
+			client.send(sftpData, sftpPacket{}.uint32(id).string(data[offset:offset+length]))
+		case sftpClose:
+			client.send(sftpStatus, status)
+		}
+	}
+}
+
+func TestSFTPUpload(t *testing.T) {
+	server := &fakeSFTPServer{files: make(map[string][]byte)}
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	go server.serve(serverR, serverW)
+
+	client := &sftpClient{w: clientW, r: clientR}
+	if err := client.init(); err != nil {
+		t.Fatalf("Couldn't init: %s\n", err)
+	}
+	contents := bytes.Repeat([]byte("henchman"), sftpChunkSize/4)
+	written, err := client.upload("/etc/app.conf", bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("Couldn't upload: %s\n", err)
+	}
+	if written != int64(len(contents)) || !bytes.Equal(server.files["/etc/app.conf"], contents) {
+		t.Errorf("Upload mismatch. Got %d bytes\n", written)
+	}
+	if _, err := client.upload("/readonly/app.conf", bytes.NewReader(contents)); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("Expected the server's error. Got %v\n", err)
+	}
+}
+
+func TestSFTPDownload(t *testing.T) {
+	contents := bytes.Repeat([]byte("henchman"), sftpChunkSize/4)
+	server := &fakeSFTPServer{files: map[string][]byte{"/var/log/app.log": contents}}
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	go server.serve(serverR, serverW)
+
+	client := &sftpClient{w: clientW, r: clientR}
+	if err := client.init(); err != nil {
+		t.Fatalf("Couldn't init: %s\n", err)
+	}
+	var b bytes.Buffer
+	read, err := client.download("/var/log/app.log", &b)
+	if err != nil {
+		t.Fatalf("Couldn't download: %s\n", err)
+	}
+	if read != int64(len(contents)) || !bytes.Equal(b.Bytes(), contents) {
+		t.Errorf("Download mismatch. Got %d bytes\n", read)
+	}
+	if _, err := client.download("/var/log/missing.log", &b); err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("Expected the server's error. Got %v\n", err)
+	}
+}
@@ -0,0 +1,54 @@
+package henchman
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tag of tasks that run whatever tags are selected, unless skipped by name
+const AlwaysTag = "always"
+
+func splitTags(spec string) []string {
+	var tags []string
+	for _, tag := range strings.Split(spec, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Whether the task is tagged with one of the tags
+func (task *Task) tagged(tags []string) bool {
+	for _, tag := range tags {
+		for _, taskTag := range task.Tags {
+			if tag == taskTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Restricts the plan's tasks to the ones tagged with one of the comma
+// separated tags, when there are any, and leaves out the ones tagged with
+// one of the skipped tags, e.g. 'restart' and 'slow,db'. Tasks tagged
+// 'always' are kept unless skipped by name. The tasks keep their Index.
+func (plan *Plan) SelectTags(tags string, skipTags string) error {
+	only, skip := splitTags(tags), splitTags(skipTags)
+	var selected []Task
+	for _, task := range plan.Tasks {
+		if task.tagged(skip) {
+			continue
+		}
+		if len(only) > 0 && !task.tagged(only) && !task.tagged([]string{AlwaysTag}) {
+			continue
+		}
+		selected = append(selected, task)
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("No tasks left with the tags '%s' and without '%s'", tags, skipTags)
+	}
+	plan.Tasks = selected
+	return nil
+}
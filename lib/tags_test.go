@@ -0,0 +1,61 @@
+package henchman
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectTags(t *testing.T) {
+	plan_string := `---
+name: "Deploy"
+hosts:
+  - web1
+tasks:
+  - name: Install
+    action: apt-get install -y nginx
+    tags: [install, slow]
+  - name: Configure
+    action: nginx -t
+    tags: [config]
+  - name: Restart
+    action: service nginx restart
+    tags: [restart]
+  - name: Notify
+    action: echo done
+    tags: [always]
+`
+	for _, c := range []struct {
+		tags, skip string
+		expected   []string
+	}{
+		{"restart", "", []string{"Restart", "Notify"}},
+		{"config,restart", "", []string{"Configure", "Restart", "Notify"}},
+		{"", "slow", []string{"Configure", "Restart", "Notify"}},
+		{"restart", "always", []string{"Restart"}},
+	} {
+		plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+		if err != nil {
+			t.Fatalf("Couldn't parse the plan: %s\n", err)
+		}
+		if err := plan.SelectTags(c.tags, c.skip); err != nil {
+			t.Errorf("Couldn't select '%s' without '%s': %s\n", c.tags, c.skip, err)
+			continue
+		}
+		var names []string
+		for _, task := range plan.Tasks {
+			names = append(names, task.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("Tasks tagged '%s' without '%s' mismatch. Got %v\n", c.tags, c.skip, names)
+		}
+	}
+
+	plan, _ := NewPlanFromYAML([]byte(plan_string), nil)
+	plan.SelectTags("restart", "")
+	if plan.Tasks[0].Index != 3 {
+		t.Errorf("Expected the selected tasks to keep their index. Got %d\n", plan.Tasks[0].Index)
+	}
+	if err := plan.SelectTags("deploy", "always"); err == nil {
+		t.Errorf("Expected selecting no tasks to fail\n")
+	}
+}
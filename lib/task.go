@@ -1,77 +1,421 @@
 package henchman
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
+	"strings"
+	"time"
 
 	"code.google.com/p/go-uuid/uuid"
-	"github.com/flosch/pongo2"
 
 	"github.com/sudharsh/henchman/ansi"
 )
 
+// Statuses of a task on a machine
+const (
+	StatusOk      = "ok"
+	StatusChanged = "changed"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+	// Failed to connect to the machine
+	StatusUnreachable = "unreachable"
+	// Failed, but the task ignores errors
+	StatusIgnored = "ignored"
+)
+
 var statuses = map[string]string{
-	"reset":   ansi.ColorCode("reset"),
-	"success": ansi.ColorCode("green"),
-	"ignored": ansi.ColorCode("yellow"),
-	"failure": ansi.ColorCode("red"),
+	"reset":           ansi.ColorCode("reset"),
+	StatusOk:          ansi.ColorCode("green"),
+	StatusChanged:     ansi.ColorCode("yellow"),
+	StatusIgnored:     ansi.ColorCode("cyan"),
+	StatusFailed:      ansi.ColorCode("red"),
+	StatusUnreachable: ansi.ColorCode("red"),
 }
 
 type TaskStatus struct {
-	Status  string
-	Message string
+	Status   string
+	Message  string
+	Duration time.Duration
+	// Whether the task reported changing the machine. Modules, copies and
+	// templates do.
+	Changed bool
+	// What the task failed with, e.g. "connect timeout" or "unreachable"
+	ErrorCategory string
+}
+
+// Whether the status is a failure that stops the plan on the machine
+func failedStatus(status string) bool {
+	return status == StatusFailed || status == StatusUnreachable
+}
+
+// Whether the task failed on the machine, ignored errors aside
+func (status *TaskStatus) Failed() bool {
+	return failedStatus(status.Status)
+}
+
+// Whether the task ran through, whether it changed the machine or not
+func (status *TaskStatus) Succeeded() bool {
+	return status.Status == StatusOk || status.Status == StatusChanged
 }
 
 // Task is the unit of work in henchman.
 type Task struct {
 	Id string
 
+	// Position of the task in its plan, counting from 1. Tells tasks with
+	// the same name apart in the report.
+	Index int `yaml:"-"`
+
 	Name         string
 	Action       string
 	IgnoreErrors bool `yaml:"ignore_errors"`
 	LocalAction  bool `yaml:"local"`
+
+	// Lines sent one after the other instead of Action, e.g. a config
+	// snippet for a network device. Other hosts run them as a shell script.
+	Commands []string
+
+	// Path to a local script that is piped to the machine's interpreter
+	// instead of running Action.
+	Script string
+
+	// Restrictions the command runs under on the machine, if any
+	Sandbox *Sandbox
+
+	// Errors the task is retried on, if any
+	Retry *RetryPolicy
+
+	// Condition the task is run again until it holds on the machine, at
+	// most Retries more times and Delay apart, e.g.
+	// 'health.stdout == "ok"'. Without it, tasks with retries are retried
+	// on any failure like with a retry policy. See Task.retryUntil and
+	// Task.retryPolicy.
+	Until   string
+	Retries int
+	Delay   string
+
+	// Vars exported to the command as environment variables, if any
+	Env *EnvExport
+
+	// Run the command with sudo, as BecomeUser if set. Defaults to the
+	// plan's.
+	Sudo       *bool
+	BecomeUser string `yaml:"become_user"`
+
+	// Only run the task on machines in this group, if set
+	Group string
+
+	// Names the task can be picked or skipped by, see SelectTags
+	Tags []string
+
+	// Condition the task only runs on the machines it holds on, e.g.
+	// 'os_family == "debian"'. The task is skipped on the others.
+	When string
+
+	// Items the task runs once for each of, as {{ item }}. Either a list
+	// or the name of a list var, see Task.items.
+	WithItems interface{} `yaml:"with_items"`
+
+	// Var the task's result is stored in on the machine, see
+	// Machine.Register
+	Register string
+
+	// File copied to the machine instead of running Action
+	Copy *CopyFile
+
+	// Template rendered with the vars and copied to the machine instead of
+	// running Action, through sudo if the task runs with it. Looked up under
+	// templates/.
+	Template *CopyFile
+
+	// File fetched from the machine instead of running Action
+	Fetch *FetchFile
+
+	// Module run on the machine instead of Action
+	Module *ModuleCall
+
+	// How long the task is expected to take, e.g. '30s'. Runs taking
+	// longer than BudgetFactor times that are reported as over budget.
+	ExpectedDuration string `yaml:"expected_duration"`
+
+	// How long the task can run, e.g. '10m', before its session is killed
+	// and it fails with a task timeout. The plan's timeout when empty.
+	Timeout string
+
+	// Check that has to pass after the task for it to succeed, if any
+	Verify *Verify
+
+	// Handlers run at the end of the plan if the task changes the machine,
+	// by name, see Plan.RunHandlers
+	Notify []string
+
+	// Tasks undoing this one, run when a later task fails on the machine,
+	// see Plan.Rollback
+	Rollback []Task
+
+	isRollback bool
+	isHandler  bool
 }
 
 func prepareTemplate(data string, vars *TaskVars, machine *Machine) (string, error) {
-	tmpl, err := pongo2.FromString(data)
-	if err != nil {
-		panic(err)
+	return templateCache.render(data, digestVars(vars), vars, machine.renderedFor())
+}
+
+// Layers the machine's own vars, if it has any, over the plan's vars
+func machineVars(vars *TaskVars, machine *Machine) *TaskVars {
+	machine = machine.renderedFor()
+	if machine == nil || len(machine.Vars) == 0 {
+		return vars
+	}
+	merged := make(TaskVars)
+	if vars != nil {
+		mergeMap(vars, &merged)
 	}
-	ctxt := pongo2.Context{"vars": vars, "machine": machine}
-	return tmpl.Execute(ctxt)
+	mergeMap(&machine.Vars, &merged)
+	return &merged
 }
 
-// Renders the template parts in the task field.
-// Also assigns a new UUID to the task uniquely identifying it.
-func (task *Task) prepare(vars *TaskVars, machine *Machine) {
+// Assigns a new UUID to the task uniquely identifying it, unless it has
+// one already. Tasks get theirs before their task_started event so that
+// the event and the task's results share it.
+func (task *Task) AssignId() {
+	if task.Id == "" {
+		task.Id = uuid.New()
+	}
+}
+
+// Renders the template parts in the task's fields, e.g.
+// "useradd {{ app_user }}". Vars and facts can be referred to by name or
+// under vars, see templateContext. Also assigns the task its Id.
+func (task *Task) prepare(vars *TaskVars, machine *Machine) error {
 	var err error
-	task.Id = uuid.New()
-	task.Name, err = prepareTemplate(task.Name, vars, machine)
-	if err != nil {
-		panic(err)
+	vars = machineVars(vars, machine)
+	task.AssignId()
+	digest := digestVars(vars)
+	render := func(field string, data string) string {
+		if err != nil {
+			return data
+		}
+		rendered, renderErr := templateCache.render(data, digest, vars, machine.renderedFor())
+		if renderErr != nil {
+			err = fmt.Errorf("Couldn't render the %s of '%s': %s", field, task.Name, renderErr)
+			return data
+		}
+		return rendered
 	}
-	task.Action, err = prepareTemplate(task.Action, vars, machine)
-	if err != nil {
-		panic(err)
+	task.Name = render("name", task.Name)
+	task.Action = render("action", task.Action)
+	if len(task.Commands) > 0 {
+		commands := make([]string, len(task.Commands))
+		for i, command := range task.Commands {
+			commands[i] = render("commands", command)
+		}
+		task.Commands = commands
+	}
+	for _, spec := range []**CopyFile{&task.Copy, &task.Template} {
+		if *spec == nil {
+			continue
+		}
+		// The spec is shared with the other machines' copies of the task
+		prepared := **spec
+		prepared.Dest = render("dest", prepared.Dest)
+		*spec = &prepared
 	}
+	if task.Fetch != nil {
+		spec := *task.Fetch
+		spec.Src = render("src", spec.Src)
+		task.Fetch = &spec
+	}
+	if task.Module != nil {
+		call := *task.Module
+		call.Args = make(TaskVars)
+		for arg, value := range task.Module.Args {
+			call.Args[arg] = renderValue(value, func(s string) string { return render("args", s) })
+		}
+		task.Module = &call
+	}
+	if task.Verify != nil {
+		spec := *task.Verify
+		spec.Command = render("verify command", spec.Command)
+		spec.URI = render("verify uri", spec.URI)
+		task.Verify = &spec
+	}
+	return err
+}
+
+// Renders the strings in the value with render, in nested lists and maps too
+func renderValue(value interface{}, render func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return render(v)
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered[i] = renderValue(item, render)
+		}
+		return rendered
+	case map[interface{}]interface{}:
+		rendered := make(map[interface{}]interface{})
+		for key, item := range v {
+			rendered[key] = renderValue(item, render)
+		}
+		return rendered
+	case map[string]interface{}:
+		rendered := make(map[string]interface{})
+		for key, item := range v {
+			rendered[key] = renderValue(item, render)
+		}
+		return rendered
+	}
+	return value
 }
 
 // Runs the task on the machine. The task might mutate `vars` so that other
-// tasks down the `plan` can see any additions/updates.
+// tasks down the `plan` can see any additions/updates. Tasks that succeed
+// only do once their Verify check passes.
 func (task *Task) Run(machine *Machine, vars *TaskVars) (*TaskStatus, error) {
-	task.prepare(vars, machine)
-	log.Printf("%s: %s:%d '%s'\n", task.Id, machine.Hostname, machine.Port, task.Name)
-	out, err := machine.Exec(task.Action)
-	var taskStatus string = "success"
+	if task.WithItems != nil {
+		return task.loop(machine, vars, (*Task).Run)
+	}
+	if timeout := task.timeout(); timeout > 0 {
+		return task.runWithTimeout(machine, vars, timeout)
+	}
+	return task.runAttempts(machine, vars)
+}
+
+// Runs the task until its until condition holds, if it has one. The task's
+// timeout covers all of the attempts.
+func (task *Task) runAttempts(machine *Machine, vars *TaskVars) (*TaskStatus, error) {
+	if task.Until != "" {
+		return task.retryUntil(machine, vars, (*Task).runVerified)
+	}
+	return task.runVerified(machine, vars)
+}
+
+// Runs the task followed by its verification, if any
+func (task *Task) runVerified(machine *Machine, vars *TaskVars) (*TaskStatus, error) {
+	status, err := task.run(machine, vars)
+	if err != nil || task.Verify == nil || status.Status == StatusSkipped {
+		return status, err
+	}
+	start := time.Now()
+	message, err := machine.verify(task.Verify, task.become())
+	if message == "" && err == nil {
+		return status, nil
+	}
 	if err != nil {
-		if task.IgnoreErrors {
-			taskStatus = "ignored"
+		message = err.Error()
+	}
+	if status.Message != "" {
+		message = strings.TrimRight(status.Message, "\n") + "\n" + message
+	}
+	return task.changedStatus(message, status.Changed, err, status.Duration+time.Since(start)), err
+}
+
+func (task *Task) run(machine *Machine, vars *TaskVars) (*TaskStatus, error) {
+	start := time.Now()
+	if err := task.prepare(vars, machine); err != nil {
+		return task.status("", err, time.Since(start)), err
+	}
+	log.Printf("%s: %s:%d '%s'\n", task.Id, machine.Hostname, machine.Port, task.Name)
+	if status, err := task.skip(vars, machine, start); status != nil {
+		return status, err
+	}
+	var script []byte
+	if task.Script != "" {
+		var err error
+		if script, err = ioutil.ReadFile(task.Script); err != nil {
+			return &TaskStatus{Status: StatusFailed, Message: err.Error(), ErrorCategory: "error"}, err
+		}
+	}
+	exports := task.Env.exports(machineVars(vars, machine))
+	if exports != "" && machine.isLocal() {
+		return task.status("", errLocalEnv, time.Since(start)), errLocalEnv
+	}
+	become := task.become()
+	if become != nil && machine.isLocal() {
+		return task.status("", errLocalBecome, time.Since(start)), errLocalBecome
+	}
+	if machine.isNetwork() && !task.networkable(exports, become) {
+		return task.status("", errNetworkTask, time.Since(start)), errNetworkTask
+	}
+	action := task.Action
+	if len(task.Commands) > 0 {
+		action = strings.Join(task.Commands, "\n")
+	}
+	if task.Copy != nil {
+		message, changed, err := machine.copyFile(task.Copy, become)
+		return task.changedStatus(message, changed, err, time.Since(start)), err
+	}
+	if task.Template != nil {
+		message, changed, err := machine.templateFile(task.Template, vars, become)
+		return task.changedStatus(message, changed, err, time.Since(start)), err
+	}
+	if task.Fetch != nil {
+		message, err := machine.fetchFile(task.Fetch)
+		return task.status(message, err, time.Since(start)), err
+	}
+	if task.Module != nil {
+		result, message, err := machine.runModule(task.Module, become, false)
+		if result == nil {
+			return task.status(message, err, time.Since(start)), err
+		}
+		machine.addFacts(result.Facts)
+		return task.changedStatus(message, result.Changed, err, time.Since(start)), err
+	}
+	var out *Output
+	var err error
+	policy := task.retryPolicy()
+	for attempt := 1; ; attempt++ {
+		if task.Script != "" {
+			out, err = machine.execScript(script, task.Sandbox, exports, become)
 		} else {
-			taskStatus = "failure"
+			out, err = machine.execSandboxed(exports+action, task.Sandbox, become)
+		}
+		if attempt >= policy.attempts() || !policy.retryable(err, out.String()) {
+			break
 		}
+		log.Printf("%s: %s failed with '%s', retrying (%d/%d)\n", task.Id, machine.Hostname, err, attempt+1, policy.attempts())
+		if machine.sleep(policy.delay()) != nil {
+			break
+		}
+	}
+	return task.status(out.String(), err, time.Since(start)), err
+}
+
+// Builds and logs the task's status from its output and error
+func (task *Task) status(message string, err error, duration time.Duration) *TaskStatus {
+	return task.changedStatus(message, false, err, duration)
+}
+
+// Builds and logs the status of a task that knows whether it changed the
+// machine. Connection errors make it unreachable rather than failed.
+func (task *Task) changedStatus(message string, changed bool, err error, duration time.Duration) *TaskStatus {
+	taskStatus := StatusOk
+	switch {
+	case err != nil && task.IgnoreErrors:
+		taskStatus = StatusIgnored
+	case err != nil && connectionErrorCategories[ErrorCategory(err)]:
+		taskStatus = StatusUnreachable
+	case err != nil:
+		taskStatus = StatusFailed
+	case changed:
+		taskStatus = StatusChanged
+	}
+	status := TaskStatus{
+		Status:        taskStatus,
+		Changed:       changed,
+		Message:       message,
+		Duration:      duration,
+		ErrorCategory: ErrorCategory(err),
+	}
+	if status.Message == "" && err != nil {
+		// Nothing ran, say why instead
+		status.Message = err.Error()
 	}
-	status := TaskStatus{taskStatus, out.String()}
 	escapeCode := statuses[taskStatus]
 	var reset string = statuses["reset"]
 	log.Printf("%s: %s [%s] - %s", task.Id, escapeCode, status.Status, status.Message+reset)
-	return &status, err
+	return &status
 }
@@ -1,22 +1,25 @@
 package henchman
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPrepareTask(t *testing.T) {
-	task := Task{"fake-uuid",
-		"The {{ vars.variable1 }}",
-		"{{ vars.variable2 }}:{{ machine.Hostname }}",
-		false,
-		false,
+	task := Task{Id: "fake-uuid",
+		Name:   "The {{ vars.variable1 }}",
+		Action: "{{ vars.variable2 }}:{{ machine.Hostname }}",
 	}
-	machine := Machine{"foobar", 22, nil}
+	machine := Machine{Hostname: "foobar", Port: 22}
 
 	vars := make(TaskVars)
 	vars["variable1"] = "foo"
 	vars["variable2"] = "bar"
-	task.prepare(&vars, &machine)
+	if err := task.prepare(&vars, &machine); err != nil {
+		t.Fatalf("Couldn't prepare the task: %s\n", err)
+	}
 
 	if task.Name != "The foo" {
 		t.Errorf("Template execution for Task.Name failed. Got - %s\n", task.Name)
@@ -24,16 +27,47 @@ func TestPrepareTask(t *testing.T) {
 	if task.Action != "bar:foobar" {
 		t.Errorf("Template execution for Task.Action failed. Got - %s\n", task.Action)
 	}
+	if task.Id != "fake-uuid" {
+		t.Errorf("The Id assigned before the task started should be kept. Got - %s\n", task.Id)
+	}
+}
+
+func TestPrepareTaskBareVars(t *testing.T) {
+	task := Task{Name: "Add {{ app_user }}",
+		Action: "useradd -d {{ home }} {{ app_user }} # {{ os_family }}",
+		Module: &ModuleCall{Args: TaskVars{
+			"users":  []interface{}{"{{ app_user }}", "root"},
+			"config": map[interface{}]interface{}{"shell": "/bin/{{ shell }}"},
+		}},
+	}
+	machine := Machine{Hostname: "foobar", Vars: TaskVars{"facts": TaskVars{"os_family": "debian"}, "shell": "zsh"}}
+	vars := TaskVars{"app_user": "deploy", "home": "/srv/app"}
+	if err := task.prepare(&vars, &machine); err != nil {
+		t.Fatalf("Couldn't prepare the task: %s\n", err)
+	}
+	if task.Name != "Add deploy" || task.Action != "useradd -d /srv/app deploy # debian" {
+		t.Errorf("Expected the vars and facts by name. Got %s, %s\n", task.Name, task.Action)
+	}
+	if users := task.Module.Args["users"].([]interface{}); users[0] != "deploy" {
+		t.Errorf("Expected the nested module args rendered. Got %v\n", users)
+	}
+	if config := task.Module.Args["config"].(map[interface{}]interface{}); config["shell"] != "/bin/zsh" {
+		t.Errorf("Expected the nested module args rendered. Got %v\n", config)
+	}
+
+	broken := Task{Name: "Broken", Action: "echo {{ app_user"}
+	status, err := broken.Run(&Machine{Hostname: "127.0.0.1"}, &vars)
+	if err == nil || status.Status != "failed" || !strings.Contains(err.Error(), "action of 'Broken'") {
+		t.Errorf("Expected the bad template to fail the task. Got %v %v\n", status, err)
+	}
 }
 
 func TestRun(t *testing.T) {
-	task := Task{"fake-uuid",
-		"The {{ vars.variable1 }}",
-		"{{ vars.variable2 }}",
-		false,
-		false,
+	task := Task{Id: "fake-uuid",
+		Name:   "The {{ vars.variable1 }}",
+		Action: "{{ vars.variable2 }}",
 	}
-	machine := Machine{"127.0.0.1", 0, nil}
+	machine := Machine{Hostname: "127.0.0.1"}
 	vars := make(TaskVars)
 
 	vars["variable1"] = "foo"
@@ -43,7 +77,34 @@ func TestRun(t *testing.T) {
 	if err != nil {
 		t.Errorf("There shouldn't have been any error for this task")
 	}
-	if status.Status != "success" {
-		t.Errorf("Task execution failed. Got %s\n", status)
+	if status.Status != "ok" {
+		t.Errorf("Task execution failed. Got %v\n", status)
+	}
+}
+
+func TestTaskStatuses(t *testing.T) {
+	task := &Task{Name: "Deploy"}
+	unreachable := &TimeoutError{"connect", time.Second}
+	for _, c := range []struct {
+		changed  bool
+		err      error
+		expected string
+	}{
+		{false, nil, StatusOk},
+		{true, nil, StatusChanged},
+		{true, errors.New("exit status 1"), StatusFailed},
+		{false, unreachable, StatusUnreachable},
+	} {
+		status := task.changedStatus("", c.changed, c.err, 0)
+		if status.Status != c.expected || status.Changed != c.changed {
+			t.Errorf("Expected %s for %v, %v. Got %+v\n", c.expected, c.changed, c.err, status)
+		}
+		if status.Failed() != (c.err != nil) || status.Succeeded() != (c.err == nil) {
+			t.Errorf("Failed/Succeeded mismatch for %s\n", status.Status)
+		}
+	}
+	task.IgnoreErrors = true
+	if status := task.status("", unreachable, 0); status.Status != StatusIgnored || status.Failed() {
+		t.Errorf("Expected ignored errors to be ignored. Got %+v\n", status)
 	}
 }
@@ -0,0 +1,114 @@
+package henchman
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/flosch/pongo2"
+)
+
+// Maximum number of rendered templates kept. The cache is simply reset when
+// it fills up, which only happens on plans with a lot of per host templating.
+const renderCacheSize = 1024
+
+// Caches compiled templates by source and rendered results by a hash of the
+// source and everything it is rendered with, so that the same template
+// rendered for hundreds of hosts with identical vars is only rendered once.
+type renderCache struct {
+	sync.Mutex
+	compiled map[string]*pongo2.Template
+	rendered map[[sha256.Size]byte]string
+	hits     int
+	misses   int
+}
+
+var templateCache = newRenderCache()
+
+func newRenderCache() *renderCache {
+	return &renderCache{
+		compiled: make(map[string]*pongo2.Template),
+		rendered: make(map[[sha256.Size]byte]string),
+	}
+}
+
+// Hash of what templates are rendered with besides the machine: the vars,
+// the run's vars and the dialect. Maps print with their keys sorted so the
+// hash is stable. Tasks hash their vars once for all their fields.
+type varsDigest [sha256.Size]byte
+
+func digestVars(vars *TaskVars) varsDigest {
+	h := sha256.New()
+	runVars.Lock()
+	fmt.Fprintf(h, "%v\x00%v\x00%s", vars, runVars.vars, runVars.dialect)
+	runVars.Unlock()
+	var digest varsDigest
+	h.Sum(digest[:0])
+	return digest
+}
+
+// Templates that don't refer to the machine render the same on every host,
+// so the machine is only part of the key when it is referred to. Jinja2
+// templates get the machine under names of their own like
+// inventory_hostname, so they are always keyed by it.
+func renderKey(data string, digest varsDigest, machine *Machine) [sha256.Size]byte {
+	runVars.Lock()
+	jinja := runVars.dialect == JinjaDialect
+	runVars.Unlock()
+	key := data + "\x00" + string(digest[:])
+	if (jinja || strings.Contains(data, "machine") || strings.Contains(data, "henchman_host")) && machine != nil {
+		user := ""
+		if machine.SSHConfig != nil {
+			user = machine.SSHConfig.User
+		}
+		key += fmt.Sprintf("\x00%s\x00%d\x00%s\x00%s", machine.Hostname, machine.Port, machine.Interpreter, user)
+	}
+	return sha256.Sum256([]byte(key))
+}
+
+// Renders the template with the vars, whose digest is given
+func (cache *renderCache) render(data string, digest varsDigest, vars *TaskVars, machine *Machine) (string, error) {
+	key := renderKey(data, digest, machine)
+	cache.Lock()
+	if out, present := cache.rendered[key]; present {
+		cache.hits++
+		cache.Unlock()
+		return out, nil
+	}
+	cache.misses++
+	cache.Unlock()
+
+	// Jinja2 templates are compiled once translated
+	source := data
+	runVars.Lock()
+	if runVars.dialect == JinjaDialect {
+		source = jinjaToPongo(data)
+	}
+	runVars.Unlock()
+	cache.Lock()
+	tmpl, present := cache.compiled[source]
+	cache.Unlock()
+	if !present {
+		var err error
+		if tmpl, err = pongo2.FromString(source); err != nil {
+			return "", err
+		}
+	}
+	out, err := tmpl.Execute(templateContext(vars, machine))
+	if err != nil {
+		return "", err
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+	if len(cache.rendered) >= renderCacheSize {
+		cache.rendered = make(map[[sha256.Size]byte]string)
+	}
+	if len(cache.compiled) >= renderCacheSize {
+		cache.compiled = make(map[string]*pongo2.Template)
+	}
+	cache.compiled[source] = tmpl
+	cache.rendered[key] = out
+	return out, nil
+}
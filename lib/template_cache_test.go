@@ -0,0 +1,48 @@
+package henchman
+
+import "testing"
+
+func TestRenderCache(t *testing.T) {
+	cache := newRenderCache()
+	vars := TaskVars{"service": "nginx"}
+	foo := Machine{Hostname: "foo"}
+	bar := Machine{Hostname: "bar"}
+
+	for _, machine := range []*Machine{&foo, &bar} {
+		out, err := cache.render("restart {{ vars.service }}", digestVars(&vars), &vars, machine)
+		if err != nil || out != "restart nginx" {
+			t.Errorf("Render mismatch. Got '%s' (%s)\n", out, err)
+		}
+	}
+	if cache.hits != 1 || cache.misses != 1 {
+		t.Errorf("Templates not referring to the machine should be shared. Got %d hits, %d misses\n", cache.hits, cache.misses)
+	}
+
+	fooOut, _ := cache.render("ping {{ machine.Hostname }}", digestVars(&vars), &vars, &foo)
+	barOut, _ := cache.render("ping {{ machine.Hostname }}", digestVars(&vars), &vars, &bar)
+	if fooOut != "ping foo" || barOut != "ping bar" {
+		t.Errorf("Machine specific templates mismatch. Got '%s' and '%s'\n", fooOut, barOut)
+	}
+
+	vars["service"] = "haproxy"
+	if out, _ := cache.render("restart {{ vars.service }}", digestVars(&vars), &vars, &foo); out != "restart haproxy" {
+		t.Errorf("Changed vars should be re-rendered. Got '%s'\n", out)
+	}
+}
+
+func TestDigestVars(t *testing.T) {
+	first, second := make(TaskVars), make(TaskVars)
+	for i := 0; i < 50; i++ {
+		first[string(rune('a'+i%26))+string(rune('a'+i/26))] = map[interface{}]interface{}{"n": i}
+	}
+	for i := 49; i >= 0; i-- {
+		second[string(rune('a'+i%26))+string(rune('a'+i/26))] = map[interface{}]interface{}{"n": i}
+	}
+	if digestVars(&first) != digestVars(&second) {
+		t.Errorf("Equal vars should hash the same whatever their order\n")
+	}
+	second["aa"] = "changed"
+	if digestVars(&first) == digestVars(&second) {
+		t.Errorf("Different vars should hash differently\n")
+	}
+}
@@ -0,0 +1,173 @@
+package henchman
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+// Timeouts bound the phases of running an action on a machine, so that a
+// slow network and a hung command can be told apart. Zero values don't
+// time out.
+type Timeouts struct {
+	Connect   time.Duration
+	Handshake time.Duration
+	Session   time.Duration
+	Command   time.Duration
+}
+
+// TimeoutError is returned when a phase of running an action timed out.
+// Phase is one of connect, handshake, session, command or task.
+type TimeoutError struct {
+	Phase string
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Phase, e.After)
+}
+
+// Categorizes the error a task failed with for the report
+func ErrorCategory(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case *TimeoutError:
+		return e.Phase + " timeout"
+	case *net.OpError:
+		return "unreachable"
+	case *BrokerError:
+		return e.Category
+	}
+	return "error"
+}
+
+// Runs f, calling abort to unblock it if it doesn't finish within the timeout
+func withTimeout(phase string, timeout time.Duration, f func() error, abort func() error) error {
+	if timeout <= 0 {
+		return f()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		abort()
+		return &TimeoutError{phase, timeout}
+	}
+}
+
+func validateTaskTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+		return fmt.Errorf("Invalid timeout '%s', it has to be a positive duration like '10m'", timeout)
+	}
+	return nil
+}
+
+// Returns the task's timeout, 0 if it has none
+func (task *Task) timeout() time.Duration {
+	timeout, _ := time.ParseDuration(task.Timeout)
+	return timeout
+}
+
+// How long a cancelled task gets to wind down, e.g. when it's stuck dialing
+// a host without connect or handshake timeouts
+var cancelGrace = 10 * time.Second
+
+// Runs the task, cancelling it on the machine if it runs longer than the
+// timeout, which kills its commands. The task then fails with a task
+// timeout rather than holding up the rest of the run. It's only reported
+// once its commands are gone, so that nothing it started runs on, or
+// changes the machine, past the timeout. A task that doesn't wind down
+// within cancelGrace is given up on, and the machine stays cancelled so
+// that it can't run anything else. The task runs as a copy, prepared in
+// place of the task once it's done, since one given up on may still be
+// preparing it.
+func (task *Task) runWithTimeout(machine *Machine, vars *TaskVars, timeout time.Duration) (*TaskStatus, error) {
+	start := time.Now()
+	var status *TaskStatus
+	running := *task
+	exited := make(chan bool)
+	err := withTimeout("task", timeout, func() error {
+		defer close(exited)
+		var err error
+		status, err = running.runAttempts(machine, vars)
+		return err
+	}, func() error {
+		machine.Cancel()
+		return nil
+	})
+	if e, ok := err.(*TimeoutError); ok && e.Phase == "task" {
+		select {
+		case <-exited:
+			*task = running
+			machine.Resume()
+		case <-time.After(cancelGrace):
+			log.Printf("'%s' on %s didn't stop within %s of being cancelled\n", task.Name, machine.Hostname, cancelGrace)
+		}
+		log.Printf("'%s' on %s timed out after %s\n", task.Name, machine.Hostname, timeout)
+		return task.status("", err, time.Since(start)), err
+	}
+	*task = running
+	return status, err
+}
+
+// Opens the TCP connection to the machine, racing its addresses when it
+// resolves to more than one
+func (machine *Machine) connect() (net.Conn, error) {
+	addresses, err := machine.resolve()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := raceConnect(addresses, machine.Timeouts.Connect)
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return nil, &TimeoutError{"connect", machine.Timeouts.Connect}
+	}
+	return conn, err
+}
+
+// Runs the SSH handshake over an established connection to the machine
+func (machine *Machine) handshake(conn net.Conn) (*ssh.Client, error) {
+	var c ssh.Conn
+	var chans <-chan ssh.NewChannel
+	var reqs <-chan *ssh.Request
+	err := withTimeout("handshake", machine.Timeouts.Handshake, func() error {
+		var err error
+		c, chans, reqs, err = ssh.NewClientConn(conn, machine.address(), machine.SSHConfig)
+		return err
+	}, conn.Close)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func (machine *Machine) newSession(client *ssh.Client) (*ssh.Session, error) {
+	var session *ssh.Session
+	err := withTimeout("session", machine.Timeouts.Session, func() error {
+		var err error
+		session, err = client.NewSession()
+		return err
+	}, client.Close)
+	return session, err
+}
+
+// Runs the action in the session, killing it if the command times out
+func (machine *Machine) runSession(session *ssh.Session, action string) error {
+	return machine.command(func() error {
+		return session.Run(action)
+	}, func() error {
+		session.Signal(ssh.SIGKILL)
+		return session.Close()
+	})
+}
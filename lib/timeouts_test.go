@@ -0,0 +1,172 @@
+package henchman
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	aborted := make(chan bool, 1)
+	err := withTimeout("command", 10*time.Millisecond, func() error {
+		time.Sleep(time.Second)
+		return nil
+	}, func() error {
+		aborted <- true
+		return nil
+	})
+	if timeout, ok := err.(*TimeoutError); !ok || timeout.Phase != "command" {
+		t.Fatalf("Expected a command timeout. Got %v\n", err)
+	}
+	select {
+	case <-aborted:
+	default:
+		t.Errorf("Expected the command to be aborted\n")
+	}
+
+	failed := errors.New("failed")
+	if err := withTimeout("session", time.Second, func() error { return failed }, nil); err != failed {
+		t.Errorf("Expected the error to be passed through. Got %v\n", err)
+	}
+	if err := withTimeout("session", 0, func() error { return nil }, nil); err != nil {
+		t.Errorf("Expected no timeout. Got %v\n", err)
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	categories := map[error]string{
+		nil:                                     "",
+		&TimeoutError{"handshake", time.Second}: "handshake timeout",
+		&net.OpError{Op: "dial", Err: errors.New("refused")}: "unreachable",
+		errors.New("Process exited with status 1"):           "error",
+	}
+	for err, expected := range categories {
+		if category := ErrorCategory(err); category != expected {
+			t.Errorf("Category mismatch for %v. Got %s instead of %s\n", err, category, expected)
+		}
+	}
+}
+
+func TestLocalCommandTimeout(t *testing.T) {
+	machine := Machine{Hostname: "127.0.0.1", Timeouts: Timeouts{Command: 100 * time.Millisecond}}
+	start := time.Now()
+	_, err := machine.Exec("sleep 5")
+	if category := ErrorCategory(err); category != "command timeout" {
+		t.Errorf("Expected a command timeout. Got %v\n", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Command wasn't killed on timeout\n")
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	plan_string := `---
+name: "Timeouts"
+hosts:
+  - 127.0.0.1
+timeout: 100ms
+tasks:
+  - name: Hangs
+    action: sleep 1
+  - name: Quick
+    action: "true"
+    timeout: 10s
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		t.Fatalf("Couldn't read the plan: %s\n", err)
+	}
+	if plan.Tasks[0].Timeout != "100ms" || plan.Tasks[1].Timeout != "10s" {
+		t.Errorf("Expected the plan's timeout as the default. Got %s and %s\n", plan.Tasks[0].Timeout, plan.Tasks[1].Timeout)
+	}
+	machine := &Machine{Hostname: "127.0.0.1"}
+	start := time.Now()
+	status, err := plan.Tasks[0].Run(machine, nil)
+	if status.Status != "failed" || status.ErrorCategory != "task timeout" {
+		t.Errorf("Expected the task to time out. Got %v, %v\n", status, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Task wasn't given up on at its timeout\n")
+	}
+	if status, err := plan.Tasks[1].Run(machine, nil); err != nil || status.Status != "ok" {
+		t.Errorf("Expected the task to finish within its timeout. Got %v, %v\n", status, err)
+	}
+
+	plan_string = `---
+name: "Bad timeout"
+hosts:
+  - 127.0.0.1
+tasks:
+  - name: Hangs
+    action: sleep 1
+    timeout: soon
+`
+	if _, err := NewPlanFromYAML([]byte(plan_string), nil); err == nil {
+		t.Errorf("Expected an invalid timeout to be rejected\n")
+	}
+}
+
+func TestTaskTimeoutKillsCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "henchman")
+	if err != nil {
+		t.Fatalf("Couldn't create a temp dir: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+	task := Task{
+		Name:    "Hangs",
+		Action:  "sleep 1; touch " + marker,
+		Timeout: "100ms",
+		Retry:   &RetryPolicy{Attempts: 3, Delay: "10ms"},
+	}
+	machine := &Machine{Hostname: "127.0.0.1"}
+	start := time.Now()
+	status, err := task.Run(machine, nil)
+	if status.Status != StatusFailed || status.ErrorCategory != "task timeout" {
+		t.Errorf("Expected the task to time out. Got %v, %v\n", status, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Task was retried after timing out\n")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("Expected the timed out command to be killed\n")
+	}
+	if status, err := (&Task{Name: "Quick", Action: "true"}).Run(machine, nil); err != nil || status.Status != StatusOk {
+		t.Errorf("Expected the machine to run tasks after a timeout. Got %v, %v\n", status, err)
+	}
+}
+
+// Transport whose commands ignore being cancelled
+type stuckTransport struct{}
+
+func (stuckTransport) Name() string { return "stuck" }
+
+func (stuckTransport) Run(machine *Machine, action string, stdin io.Reader) (*Output, error) {
+	time.Sleep(time.Second)
+	return NewOutput(), nil
+}
+
+func TestTaskTimeoutGivesUpOnStuckTask(t *testing.T) {
+	defer func(grace time.Duration) { cancelGrace = grace }(cancelGrace)
+	cancelGrace = 50 * time.Millisecond
+	machine := &Machine{Hostname: "stuck01", Transport: stuckTransport{}}
+	start := time.Now()
+	status, err := (&Task{Name: "Hangs", Action: "sleep 1", Timeout: "50ms"}).Run(machine, nil)
+	if status.Status != StatusFailed || status.ErrorCategory != "task timeout" {
+		t.Errorf("Expected the task to time out. Got %v, %v\n", status, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expected the stuck task to be given up on\n")
+	}
+	select {
+	case <-machine.cancelled():
+	default:
+		t.Errorf("Expected the machine to stay cancelled while the task is stuck\n")
+	}
+}
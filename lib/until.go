@@ -0,0 +1,114 @@
+package henchman
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Pause between the attempts of tasks with retries or until and no delay
+const defaultUntilDelay = 5 * time.Second
+
+func (task *Task) validateUntil(dialect string) error {
+	if task.Retries < 0 {
+		return fmt.Errorf("retries can't be negative")
+	}
+	if task.Retries > 0 && task.Until == "" && task.Retry != nil {
+		return fmt.Errorf("retries without until can't be combined with retry, list the errors to retry on in retry instead")
+	}
+	if task.Delay != "" {
+		if delay, err := time.ParseDuration(task.Delay); err != nil || delay < 0 {
+			return fmt.Errorf("Invalid delay '%s'", task.Delay)
+		}
+	}
+	if task.Until != "" {
+		if _, err := compileCondition(task.Until, dialect); err != nil {
+			return fmt.Errorf("bad until '%s': %s", task.Until, err)
+		}
+	}
+	return nil
+}
+
+// Number of times the task runs at most, 3 retries after the first run when
+// it has no retries
+func (task *Task) untilAttempts() int {
+	if task.Retries == 0 {
+		return 4
+	}
+	return task.Retries + 1
+}
+
+// Returns the task's retry policy. Retries without an until condition are
+// a policy retrying any failure.
+func (task *Task) retryPolicy() *RetryPolicy {
+	if task.Retries > 0 && task.Until == "" && task.Retry == nil {
+		return &RetryPolicy{Attempts: task.Retries + 1, Delay: task.untilDelay().String(), Errors: []string{"any"}}
+	}
+	return task.Retry
+}
+
+func (task *Task) untilDelay() time.Duration {
+	if task.Delay == "" {
+		return defaultUntilDelay
+	}
+	delay, _ := time.ParseDuration(task.Delay)
+	return delay
+}
+
+// Whether the attempt is through because its until condition holds. The
+// condition sees the attempt's result under the task's register name, or
+// as result.
+func (task *Task) attemptDone(status *TaskStatus, err error, machine *Machine, vars *TaskVars) (bool, error) {
+	name := task.Register
+	if name == "" {
+		name = "result"
+	}
+	withResult := make(TaskVars)
+	if merged := machineVars(vars, machine); merged != nil {
+		mergeMap(merged, &withResult)
+	}
+	withResult[name] = registeredResult(status, err)
+	return evalCondition(task.Until, &withResult, machine)
+}
+
+// Runs the task with run until its until condition holds, pausing Delay
+// between attempts. The task fails with its last attempt when it runs out
+// of retries, or when it's cancelled meanwhile.
+func (task *Task) retryUntil(machine *Machine, vars *TaskVars, run func(*Task, *Machine, *TaskVars) (*TaskStatus, error)) (*TaskStatus, error) {
+	start := time.Now()
+	attempts := task.untilAttempts()
+	for attempt := 1; ; attempt++ {
+		attemptTask := *task
+		attemptTask.Retries, attemptTask.Until = 0, ""
+		status, err := run(&attemptTask, machine, vars)
+		// The attempt is prepared in place of the task for its results
+		task.Id, task.Name = attemptTask.Id, attemptTask.Name
+		if status.Status == StatusSkipped {
+			return status, err
+		}
+		done, condErr := task.attemptDone(status, err, machine, vars)
+		if condErr != nil {
+			condErr = fmt.Errorf("Couldn't evaluate until '%s': %s", task.Until, condErr)
+			return task.status(status.Message, condErr, time.Since(start)), condErr
+		}
+		if done {
+			status.Duration = time.Since(start)
+			return status, err
+		}
+		if attempt >= attempts {
+			if err == nil {
+				err = fmt.Errorf("until '%s' still false after %d attempts", task.Until, attempt)
+			}
+			message := strings.TrimRight(status.Message, "\n")
+			if message != "" {
+				message += "\n"
+			}
+			return task.changedStatus(message+fmt.Sprintf("Gave up after %d attempts", attempt), status.Changed, err, time.Since(start)), err
+		}
+		log.Printf("'%s' on %s isn't through yet, retrying in %s (%d/%d)\n", task.Name, machine.Hostname, task.untilDelay(), attempt+1, attempts)
+		if err := machine.sleep(task.untilDelay()); err != nil {
+			return task.changedStatus(status.Message, status.Changed, err, time.Since(start)), err
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryUntil(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	counter := filepath.Join(dir, "counter")
+	// Prints how many times it ran
+	count := "n=$(( $(cat " + counter + " 2>/dev/null || echo 0) + 1 )); echo $n > " + counter + "; echo $n"
+	machine := &Machine{Hostname: "127.0.0.1"}
+	vars := TaskVars{"healthy": "3"}
+	sudo := false
+
+	task := Task{Name: "Wait for {{ vars.healthy }}", Sudo: &sudo, Action: count, Register: "health", Until: "health.stdout == healthy", Retries: 5, Delay: "10ms"}
+	status, err := task.Run(machine, &vars)
+	if err != nil || status.Status != "ok" || strings.TrimSpace(status.Message) != "3" {
+		t.Errorf("Expected the task to run until the condition held. Got %v %v\n", status, err)
+	}
+	if task.Id == "" || task.Name != "Wait for 3" {
+		t.Errorf("Expected the task to be prepared for its results. Got '%s' '%s'\n", task.Id, task.Name)
+	}
+
+	os.Remove(counter)
+	task = Task{Name: "Wait", Sudo: &sudo, Action: count, Until: `result.stdout == "10"`, Retries: 2, Delay: "10ms"}
+	status, err = task.Run(machine, &vars)
+	if err == nil || status.Status != "failed" || !strings.Contains(status.Message, "Gave up after 3 attempts") {
+		t.Errorf("Expected the task to give up after its retries. Got %v %v\n", status, err)
+	}
+
+	os.Remove(counter)
+	task = Task{Name: "Flaky", Sudo: &sudo, Action: count + "; [ $n -ge 2 ]", Retries: 3, Delay: "10ms"}
+	if status, err := task.Run(machine, &vars); err != nil || strings.TrimSpace(status.Message) != "2" {
+		t.Errorf("Expected the task to run until it succeeded. Got %v %v\n", status, err)
+	}
+
+	for _, broken := range []string{"retries: -1", "delay: soon", "until: result.rc ==", "retries: 2\n    retry: {errors: [unreachable]}"} {
+		plan_string := "name: Broken\ntasks:\n  - name: Wait\n    action: echo\n    " + broken + "\n"
+		if _, err := NewPlanFromYAML([]byte(plan_string), nil); err == nil {
+			t.Errorf("Expected '%s' to be refused\n", broken)
+		}
+	}
+}
+
+func TestUntilTimeout(t *testing.T) {
+	machine := &Machine{Hostname: "127.0.0.1"}
+	task := Task{Name: "Wait", Action: "echo starting", Until: `result.stdout == "ready"`, Retries: 50, Delay: "50ms", Timeout: "200ms"}
+	start := time.Now()
+	status, err := task.Run(machine, &TaskVars{})
+	if status.Status != StatusFailed || status.ErrorCategory != "task timeout" {
+		t.Errorf("Expected the timeout to cover every attempt. Got %v, %v\n", status, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected the retries to stop at the timeout. Took %s\n", time.Since(start))
+	}
+}
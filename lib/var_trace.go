@@ -0,0 +1,90 @@
+package henchman
+
+import (
+	"sort"
+	"strings"
+)
+
+// VarLayer is one of the sources a machine's vars are resolved from, e.g.
+// "plan" or "group_vars/web"
+type VarLayer struct {
+	Source string
+	Vars   TaskVars
+}
+
+// TracedVar is the final value of a var on a machine along with the source
+// it came from and the ones it overrode, lowest precedence first
+type TracedVar struct {
+	Name       string
+	Value      interface{}
+	Source     string
+	Overridden []string
+}
+
+// Resolves the vars of the layers, given from the lowest precedence to the
+// highest, returning them sorted by name
+func TraceVars(layers []VarLayer) []TracedVar {
+	traced := make(map[string]*TracedVar)
+	for _, layer := range layers {
+		for name, value := range layer.Vars {
+			if previous, present := traced[name]; present {
+				previous.Overridden = append(previous.Overridden, previous.Source)
+				previous.Value, previous.Source = value, layer.Source
+				continue
+			}
+			traced[name] = &TracedVar{Name: name, Value: value, Source: layer.Source}
+		}
+	}
+	var names []string
+	for name := range traced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := make([]TracedVar, len(names))
+	for i, name := range names {
+		vars[i] = *traced[name]
+	}
+	return vars
+}
+
+// Returns the inventory's layers of vars for the host, the groups' in the
+// order they apply and then the host's own, see Vars
+func (inventory *Inventory) VarLayers(host string) []VarLayer {
+	if inventory == nil {
+		return nil
+	}
+	var layers []VarLayer
+	for _, group := range inventory.groupsOf(host) {
+		layers = append(layers, VarLayer{Source: "group_vars/" + group, Vars: inventory.GroupVars[group]})
+	}
+	hostVars, present := inventory.HostVars[host]
+	if !present {
+		host = strings.Split(host, ":")[0]
+		hostVars = inventory.HostVars[host]
+	}
+	if hostVars != nil {
+		layers = append(layers, VarLayer{Source: "host_vars/" + host, Vars: hostVars})
+	}
+	return layers
+}
+
+// Returns the facts as layer, by their names under vars.facts
+func FactsLayer(facts TaskVars) VarLayer {
+	vars := make(TaskVars)
+	for name, value := range facts {
+		vars["facts."+name] = value
+	}
+	return VarLayer{Source: "facts", Vars: vars}
+}
+
+// Returns a layer per task registering its result, whose value is only
+// known once the task runs
+func (plan *Plan) RegisterLayers() []VarLayer {
+	var layers []VarLayer
+	for _, task := range plan.allTasks() {
+		if task.Register != "" {
+			layers = append(layers, VarLayer{Source: "register of '" + task.Name + "'", Vars: TaskVars{task.Register: "(set when the task runs)"}})
+		}
+	}
+	return layers
+}
@@ -0,0 +1,38 @@
+package henchman
+
+import (
+	"testing"
+)
+
+func TestTraceVars(t *testing.T) {
+	inventory := &Inventory{
+		Groups:    map[string][]string{"web": {"web1", "web2"}},
+		GroupVars: map[string]TaskVars{"all": {"port": 80, "user": "www"}, "web": {"port": 8000}},
+		HostVars:  map[string]TaskVars{"web1": {"port": 8080}},
+	}
+	layers := []VarLayer{{Source: "plan", Vars: TaskVars{"port": 1, "release": "v1"}}}
+	layers = append(layers, inventory.VarLayers("web1:2222")...)
+	layers = append(layers, VarLayer{Source: "-args", Vars: TaskVars{"release": "v2"}})
+	layers = append(layers, FactsLayer(TaskVars{"os_family": "debian"}))
+
+	traced := TraceVars(layers)
+	byName := make(map[string]TracedVar)
+	for _, v := range traced {
+		byName[v.Name] = v
+	}
+	if len(traced) != 4 || traced[0].Name != "facts.os_family" {
+		t.Errorf("Expected the vars sorted by name. Got %v\n", traced)
+	}
+	if port := byName["port"]; port.Value != 8080 || port.Source != "host_vars/web1" || len(port.Overridden) != 3 || port.Overridden[0] != "plan" {
+		t.Errorf("Expected port from the host's vars over the groups' and the plan's. Got %+v\n", port)
+	}
+	if release := byName["release"]; release.Value != "v2" || release.Source != "-args" {
+		t.Errorf("Expected release from the extra args. Got %+v\n", release)
+	}
+	if user := byName["user"]; user.Source != "group_vars/all" || len(user.Overridden) != 0 {
+		t.Errorf("Expected user from the all group. Got %+v\n", user)
+	}
+	if vars := inventory.Vars("web1"); vars["port"] != 8080 || vars["user"] != "www" {
+		t.Errorf("Expected the layered inventory vars. Got %v\n", vars)
+	}
+}
@@ -0,0 +1,107 @@
+package henchman
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Time a verify URI has to answer in
+const verifyRequestTimeout = 10 * time.Second
+
+// Verify gates a task on a check that its change took, e.g. that a
+// restarted service answers, before the host counts as done with it. Either
+// Command runs on the machine, as the task's user, passing when it exits 0,
+// or URI is fetched from where henchman runs, passing when it answers with
+// Status. Both are templated. The check is retried until it passes.
+type Verify struct {
+	Command string
+	URI     string
+	// Expected HTTP status of URI, 200 if not set
+	Status int
+	// Total number of attempts, 3 if not set
+	Retries int
+	// Pause between attempts, 5s if not set
+	Delay string
+}
+
+func (spec *Verify) validate() error {
+	if (spec.Command == "") == (spec.URI == "") {
+		return errors.New("verify needs either a command or a uri")
+	}
+	if spec.URI != "" && !strings.HasPrefix(spec.URI, "http://") && !strings.HasPrefix(spec.URI, "https://") {
+		return fmt.Errorf("Verify uri '%s' should be http or https", spec.URI)
+	}
+	if spec.Delay != "" {
+		if _, err := time.ParseDuration(spec.Delay); err != nil {
+			return fmt.Errorf("Invalid verify delay '%s'", spec.Delay)
+		}
+	}
+	return nil
+}
+
+func (spec *Verify) attempts() int {
+	if spec.Retries <= 0 {
+		return 3
+	}
+	return spec.Retries
+}
+
+func (spec *Verify) delay() time.Duration {
+	if spec.Delay == "" {
+		return 5 * time.Second
+	}
+	delay, _ := time.ParseDuration(spec.Delay)
+	return delay
+}
+
+// Fetches the URI, failing unless it answers with the expected status
+func (spec *Verify) checkURI() error {
+	expected := spec.Status
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	client := &http.Client{Timeout: verifyRequestTimeout}
+	response, err := client.Get(spec.URI)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != expected {
+		return fmt.Errorf("%s answered %s, expected %d", spec.URI, response.Status, expected)
+	}
+	return nil
+}
+
+// Runs the check until it passes or runs out of attempts, returning what
+// was verified
+func (machine *Machine) verify(spec *Verify, become *become) (string, error) {
+	if spec.URI != "" && machine.Noop != nil {
+		machine.Noop.record(machine, "verify "+spec.URI, 0)
+		return "", nil
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		if spec.URI != "" {
+			err = spec.checkURI()
+		} else {
+			var out *Output
+			if out, err = machine.run(become.wrap(spec.Command, nil)); err != nil && out.String() != "" {
+				err = fmt.Errorf("%s: %s", err, strings.TrimSpace(out.String()))
+			}
+		}
+		if err == nil {
+			return fmt.Sprintf("Verified after %d attempt(s)", attempt), nil
+		}
+		if attempt >= spec.attempts() {
+			return "", fmt.Errorf("Verification failed after %d attempt(s): %s", attempt, err)
+		}
+		log.Printf("%s: verification failed with '%s', retrying (%d/%d)\n", machine.Hostname, err, attempt+1, spec.attempts())
+		if err := machine.sleep(spec.delay()); err != nil {
+			return "", err
+		}
+	}
+}
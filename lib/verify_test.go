@@ -0,0 +1,83 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyTask(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "deployed")
+
+	machine := Machine{Hostname: "127.0.0.1"}
+	vars := TaskVars{"marker": marker}
+	task := Task{
+		Name:   "Deploy",
+		Action: "touch " + marker,
+		Verify: &Verify{Command: "test -e {{ vars.marker }}", Retries: 2, Delay: "10ms"},
+	}
+	status, err := task.Run(&machine, &vars)
+	if err != nil || status.Status != "ok" || !strings.Contains(status.Message, "Verified after 1 attempt(s)") {
+		t.Errorf("Expected the task to be verified. Got %v %v\n", status, err)
+	}
+
+	task = Task{Name: "Deploy nothing", Action: "true", Verify: &Verify{Command: "test -e " + marker + ".missing", Retries: 2, Delay: "10ms"}}
+	status, err = task.Run(&machine, &vars)
+	if err == nil || status.Status != "failed" || !strings.Contains(status.Message, "Verification failed after 2 attempt(s)") {
+		t.Errorf("Expected the verification to fail the task. Got %v\n", status)
+	}
+}
+
+func TestVerifyURI(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests++; requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	machine := Machine{Hostname: "127.0.0.1"}
+	message, err := machine.verify(&Verify{URI: server.URL + "/health", Retries: 3, Delay: "10ms"}, nil)
+	if err != nil || message != "Verified after 3 attempt(s)" {
+		t.Errorf("Expected the uri to be verified on the third attempt. Got %s %v\n", message, err)
+	}
+	if _, err := machine.verify(&Verify{URI: server.URL, Status: 204, Retries: 1}, nil); err == nil {
+		t.Errorf("Expected an unexpected status to fail the verification\n")
+	}
+}
+
+func TestParsePlanWithVerify(t *testing.T) {
+	plan_string := `---
+name: "Sample plan"
+hosts:
+  - 192.168.1.2
+tasks:
+  - name: Restart app
+    action: systemctl restart app
+    verify:
+      uri: "http://{{ henchman_host }}:8080/health"
+      retries: 10
+      delay: 3s
+`
+	plan, err := NewPlanFromYAML([]byte(plan_string), nil)
+	if err != nil {
+		panic(err)
+	}
+	verify := plan.Tasks[0].Verify
+	if verify == nil || verify.Retries != 10 || verify.Delay != "3s" {
+		t.Errorf("Verify mismatch. Got %v\n", verify)
+	}
+	if plan.Tasks[0].batchable() {
+		t.Errorf("Verified tasks shouldn't be batched\n")
+	}
+	if _, err := NewPlanFromYAML([]byte(plan_string+"      command: curl -f localhost\n"), nil); err == nil {
+		t.Errorf("Expected a verify with both a command and a uri to be refused\n")
+	}
+}
@@ -0,0 +1,79 @@
+package henchman
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Events queued for a webhook before Send blocks on it
+const webhookQueue = 1024
+
+// Webhook posts the result of each task as JSON to URL as soon as it
+// completes, along with the end of the plan, so that other systems can act
+// on them during the run. The body is the Event, with the host, task,
+// status and duration among others. Events are posted one at a time in the
+// order they happen, in the background. Posts failing or answered with an
+// error are logged and dropped.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+
+	queue chan Event
+	done  chan bool
+}
+
+// Returns the webhook posting to the URL, see Close
+func NewWebhook(url string) *Webhook {
+	webhook := &Webhook{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Event, webhookQueue),
+		done:   make(chan bool),
+	}
+	go webhook.post()
+	return webhook
+}
+
+// Queues the event for posting if it's a task's result or the end of the
+// plan
+func (webhook *Webhook) Send(event *Event) error {
+	if event.Type == TaskFinished || event.Type == PlanFinished {
+		webhook.queue <- *event
+	}
+	return nil
+}
+
+func (webhook *Webhook) post() {
+	defer close(webhook.done)
+	for event := range webhook.queue {
+		if err := webhook.postEvent(&event); err != nil {
+			log.Printf("Couldn't post the %s event to the webhook: %s\n", event.Type, err)
+		}
+	}
+}
+
+func (webhook *Webhook) postEvent(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := webhook.Client.Post(webhook.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", webhook.URL, resp.Status)
+	}
+	return nil
+}
+
+// Waits for the queued events to be posted
+func (webhook *Webhook) Close() {
+	close(webhook.queue)
+	<-webhook.done
+}
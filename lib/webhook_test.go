@@ -0,0 +1,61 @@
+package henchman
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var mutex sync.Mutex
+	var posted []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Couldn't decode the posted event: %s\n", err)
+		}
+		mutex.Lock()
+		posted = append(posted, event)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	plan := &Plan{Name: "deploy", Hosts: []string{"web-01"}}
+	task := &Task{Id: "1", Name: "Restart"}
+	machine := &Machine{Hostname: "web-01"}
+	webhook := NewWebhook(server.URL)
+	events := NewEventLog(nil)
+	events.AddSink(webhook)
+	events.PlanStarted(plan)
+	events.TaskStarted(machine, task)
+	events.TaskFinished(machine, task, &TaskStatus{Status: "ok", Duration: 2 * time.Second})
+	events.PlanFinished(plan)
+	webhook.Close()
+
+	if len(posted) != 2 {
+		t.Fatalf("Expected the task's result and the end of the plan to be posted. Got %v\n", posted)
+	}
+	result := posted[0]
+	if result.Type != TaskFinished || result.Host != "web-01" || result.Task != "Restart" || result.Status != "ok" || result.Duration != 2*time.Second {
+		t.Errorf("Posted result mismatch. Got %v\n", result)
+	}
+	if posted[1].Type != PlanFinished {
+		t.Errorf("Expected the end of the plan last. Got %v\n", posted[1])
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL)
+	defer webhook.Close()
+	if err := webhook.postEvent(&Event{Type: TaskFinished}); err == nil {
+		t.Errorf("Expected an error when the webhook answers with one\n")
+	}
+}
@@ -0,0 +1,56 @@
+package henchman
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/flosch/pongo2"
+)
+
+// Compiles the condition, e.g. 'os_family == "debian"', to the template
+// rendering "true" when it holds
+func compileCondition(when string, dialect string) (*pongo2.Template, error) {
+	if dialect == JinjaDialect {
+		when = translateJinjaExpression(when)
+	}
+	return pongo2.FromString("{% if " + when + " %}true{% endif %}")
+}
+
+// Whether the task's when condition holds on the machine. Conditions see
+// the same context as templates, see templateContext.
+func (task *Task) condition(vars *TaskVars, machine *Machine) (bool, error) {
+	if task.When == "" {
+		return true, nil
+	}
+	return evalCondition(task.When, machineVars(vars, machine), machine)
+}
+
+// Whether the condition holds on the machine with the vars, which already
+// have the machine's own layered over them
+func evalCondition(condition string, vars *TaskVars, machine *Machine) (bool, error) {
+	runVars.Lock()
+	dialect := runVars.dialect
+	runVars.Unlock()
+	tmpl, err := compileCondition(condition, dialect)
+	if err != nil {
+		return false, err
+	}
+	out, err := tmpl.Execute(templateContext(vars, machine))
+	return out == "true", err
+}
+
+// Returns the status of the task when its condition keeps it from running on
+// the machine, nil when it runs
+func (task *Task) skip(vars *TaskVars, machine *Machine, start time.Time) (*TaskStatus, error) {
+	run, err := task.condition(vars, machine)
+	if err != nil {
+		err = fmt.Errorf("Couldn't evaluate when '%s': %s", task.When, err)
+		return task.status("", err, time.Since(start)), err
+	}
+	if run {
+		return nil, nil
+	}
+	log.Printf("Skipping '%s' on %s, when '%s' is false\n", task.Name, machine.Hostname, task.When)
+	return &TaskStatus{Status: StatusSkipped, Message: "when '" + task.When + "' is false", Duration: time.Since(start)}, nil
+}
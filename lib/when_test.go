@@ -0,0 +1,53 @@
+package henchman
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWhen(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "henchman")
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+	machine := &Machine{Hostname: "127.0.0.1", Vars: TaskVars{"facts": TaskVars{"os_family": "debian"}}}
+	vars := TaskVars{"env": "prod", "workers": 4}
+
+	sudo := false
+	task := Task{Name: "Touch", Sudo: &sudo, Action: "touch " + marker, When: `os_family == "redhat"`}
+	status, err := task.Run(machine, &vars)
+	if err != nil || status.Status != "skipped" {
+		t.Errorf("Expected the task to be skipped. Got %v %v\n", status, err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("The skipped task shouldn't have run\n")
+	}
+
+	for _, when := range []string{`os_family == "debian" and env == "prod"`, `vars.workers > 2`, `vars.facts.os_family in "debian,ubuntu"`} {
+		task = Task{Name: "Touch", Sudo: &sudo, Action: "touch " + marker, When: when}
+		if status, err := task.Run(machine, &vars); err != nil || status.Status != "ok" {
+			t.Errorf("Expected '%s' to hold. Got %v %v\n", when, status, err)
+		}
+	}
+
+	plan_string := `---
+name: "Conditional"
+hosts:
+  - web1
+tasks:
+  - name: Broken
+    action: echo
+    when: os_family ==
+`
+	if _, err := NewPlanFromYAML([]byte(plan_string), nil); err == nil {
+		t.Errorf("Expected a bad condition to be refused\n")
+	}
+
+	plan := &Plan{Hosts: []string{"web1", "web2"}, Tasks: []Task{task}}
+	plan.SaveStatus(&Machine{Hostname: "web1"}, &task, &TaskStatus{Status: "skipped"})
+	plan.SaveStatus(&Machine{Hostname: "web2"}, &task, &TaskStatus{Status: "ok"})
+	if report := plan.Report(); report.Attempted != 1 || report.Counts["skipped"] != 1 || report.Results[0].Status != "skipped" {
+		t.Errorf("Expected the skip in the report. Got %+v\n", report)
+	}
+}
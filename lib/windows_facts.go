@@ -0,0 +1,48 @@
+package henchman
+
+import "strings"
+
+// PowerShell snippets gathering the facts of Windows hosts, connected to
+// over WinRM. They print the same keys factGatherers do where there's an
+// equivalent, so that conditionals like vars.facts.os_family work on every
+// host. features, services and disks are Windows only.
+var windowsFactGatherers = map[string]string{
+	"os": `$os = Get-CimInstance Win32_OperatingSystem
+"system=Windows"
+"kernel=$($os.Version)"
+"os_family=windows"
+"distribution=$($os.Caption)"
+"distribution_version=$($os.Version)"
+"windows_build=$($os.BuildNumber)"`,
+	"network": `"hostname=$env:COMPUTERNAME"
+"fqdn=$([System.Net.Dns]::GetHostEntry('').HostName)"
+"ipv4=$((Get-NetIPAddress -AddressFamily IPv4 | Where-Object { $_.IPAddress -ne '127.0.0.1' } | Select-Object -First 1).IPAddress)"`,
+	"hardware": `"processors=$env:NUMBER_OF_PROCESSORS"
+"memory_kb=$([math]::Round((Get-CimInstance Win32_ComputerSystem).TotalPhysicalMemory / 1KB))"`,
+	// Get-WindowsFeature only exists on Windows Server
+	"features": `if (Get-Command Get-WindowsFeature) {
+"features=$((Get-WindowsFeature | Where-Object Installed | ForEach-Object Name) -join ',')"
+}`,
+	"services": `"services=$((Get-Service | Where-Object Status -eq 'Running' | ForEach-Object Name) -join ',')"`,
+	// Fixed disks as disks=C,D and disk_C_size_kb, disk_C_free_kb, ...
+	"disks": `$disks = Get-CimInstance Win32_LogicalDisk -Filter 'DriveType=3'
+"disks=$(($disks | ForEach-Object { $_.DeviceID.TrimEnd(':') }) -join ',')"
+$disks | ForEach-Object {
+$d = $_.DeviceID.TrimEnd(':')
+"disk_${d}_size_kb=$([math]::Round($_.Size / 1KB))"
+"disk_${d}_free_kb=$([math]::Round($_.FreeSpace / 1KB))"
+}`,
+}
+
+// Returns the script gathering the subsets on a Windows host. Errors are
+// silenced so that facts that can't be found are left out like on other
+// hosts.
+func windowsFactsScript(subsets []string) string {
+	script := []string{"$ErrorActionPreference = 'SilentlyContinue'"}
+	for _, subset := range subsets {
+		if gatherer, present := windowsFactGatherers[subset]; present {
+			script = append(script, "& {\n"+gatherer+"\n}")
+		}
+	}
+	return strings.Join(script, "\n") + "\n"
+}
@@ -0,0 +1,334 @@
+package henchman
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// Connection host var value for Windows hosts, see ApplyHostSettings
+const WinRMConnection = "winrm"
+
+// Asked for the WinRM password of hosts without one in their host vars
+var WinRMPasswords CredentialProvider
+
+// The password asked for once for all the hosts
+var winrmPassword struct {
+	sync.Mutex
+	value string
+	err   error
+	asked bool
+}
+
+const (
+	wsmanShellURI  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+	wsmanCreate    = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	wsmanDelete    = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	wsmanCommand   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	wsmanSend      = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	wsmanReceive   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	wsmanStateDone = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+
+	// Fault code of a Receive that had no output within the operation timeout
+	wsmanTimedOut = "2150858793"
+)
+
+// WinRM runs actions on Windows hosts as PowerShell over WS-Management,
+// with basic authentication. Only actions run over it, tasks that copy
+// files or use sudo need SSH.
+type WinRM struct {
+	// The machine's SSH user when empty
+	User     string
+	Password string
+	HTTPS    bool
+	// Don't verify the host's certificate
+	Insecure bool
+
+	once   sync.Once
+	client *http.Client
+}
+
+func (winrm *WinRM) Name() string {
+	return WinRMConnection
+}
+
+func (winrm *WinRM) httpClient(machine *Machine) *http.Client {
+	winrm.once.Do(func() {
+		dialer := &net.Dialer{Timeout: machine.Timeouts.Connect}
+		winrm.client = &http.Client{Transport: &http.Transport{
+			Dial:            dialer.Dial,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: winrm.Insecure},
+		}}
+	})
+	return winrm.client
+}
+
+func (winrm *WinRM) endpoint(machine *Machine) string {
+	scheme := "http"
+	if winrm.HTTPS {
+		scheme = "https"
+	}
+	return scheme + "://" + machine.address() + "/wsman"
+}
+
+func (winrm *WinRM) user(machine *Machine) string {
+	if winrm.User == "" && machine.SSHConfig != nil {
+		return machine.SSHConfig.User
+	}
+	return winrm.User
+}
+
+func (winrm *WinRM) password() (string, error) {
+	if winrm.Password != "" || WinRMPasswords == nil {
+		return winrm.Password, nil
+	}
+	winrmPassword.Lock()
+	defer winrmPassword.Unlock()
+	if !winrmPassword.asked {
+		winrmPassword.value, winrmPassword.err = WinRMPasswords.Credential(WinRMPassword)
+		winrmPassword.asked = true
+	}
+	return winrmPassword.value, winrmPassword.err
+}
+
+// Encodes the script for powershell -EncodedCommand, which takes UTF-16LE
+func encodePowerShell(script string) string {
+	var buf []byte
+	for _, unit := range utf16.Encode([]rune(script)) {
+		buf = append(buf, byte(unit), byte(unit>>8))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Returns the SOAP envelope of a request to the shell resource
+func wsmanEnvelope(endpoint string, action string, shellId string, options map[string]string, body string) string {
+	var header bytes.Buffer
+	fmt.Fprintf(&header, `<a:To>%s</a:To>`, xmlEscape(endpoint))
+	header.WriteString(`<a:ReplyTo><a:Address env:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>`)
+	header.WriteString(`<w:MaxEnvelopeSize env:mustUnderstand="true">153600</w:MaxEnvelopeSize>`)
+	fmt.Fprintf(&header, `<a:MessageID>uuid:%s</a:MessageID>`, uuid.New())
+	header.WriteString(`<w:Locale xml:lang="en-US" env:mustUnderstand="false"/>`)
+	header.WriteString(`<w:OperationTimeout>PT60S</w:OperationTimeout>`)
+	fmt.Fprintf(&header, `<w:ResourceURI env:mustUnderstand="true">%s</w:ResourceURI>`, wsmanShellURI)
+	fmt.Fprintf(&header, `<a:Action env:mustUnderstand="true">%s</a:Action>`, action)
+	if shellId != "" {
+		fmt.Fprintf(&header, `<w:SelectorSet><w:Selector Name="ShellId">%s</w:Selector></w:SelectorSet>`, xmlEscape(shellId))
+	}
+	if len(options) > 0 {
+		header.WriteString(`<w:OptionSet>`)
+		for name, value := range options {
+			fmt.Fprintf(&header, `<w:Option Name="%s">%s</w:Option>`, name, value)
+		}
+		header.WriteString(`</w:OptionSet>`)
+	}
+	return `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd"` +
+		` xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">` +
+		`<env:Header>` + header.String() + `</env:Header>` +
+		`<env:Body>` + body + `</env:Body></env:Envelope>`
+}
+
+// What a WS-Management response says, as far as running commands goes
+type wsmanReply struct {
+	shellId   string
+	commandId string
+	output    []byte
+	done      bool
+	exitCode  int
+	fault     string
+	faultCode string
+}
+
+func parseWSManReply(data []byte) (*wsmanReply, error) {
+	reply := &wsmanReply{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var current xml.StartElement
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return reply, nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			current = t
+			switch t.Name.Local {
+			case "CommandState":
+				reply.done = attr(t, "State") == wsmanStateDone
+			case "WSManFault":
+				reply.faultCode = attr(t, "Code")
+			}
+		case xml.EndElement:
+			current = xml.StartElement{}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" {
+				continue
+			}
+			switch current.Name.Local {
+			case "ShellId":
+				reply.shellId = text
+			case "Selector":
+				if attr(current, "Name") == "ShellId" && reply.shellId == "" {
+					reply.shellId = text
+				}
+			case "CommandId":
+				reply.commandId = text
+			case "Stream":
+				decoded, err := base64.StdEncoding.DecodeString(text)
+				if err != nil {
+					return nil, err
+				}
+				reply.output = append(reply.output, decoded...)
+			case "ExitCode":
+				reply.exitCode, _ = strconv.Atoi(text)
+			case "Text", "Message":
+				if reply.fault == "" {
+					reply.fault = text
+				}
+			}
+		}
+	}
+}
+
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Posts the envelope and parses the reply. Faults are returned as errors,
+// along with the reply so that timeouts can be told apart.
+func (winrm *WinRM) post(machine *Machine, envelope string) (*wsmanReply, error) {
+	password, err := winrm.password()
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("POST", winrm.endpoint(machine), strings.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	request.Cancel = machine.cancelled()
+	request.SetBasicAuth(winrm.user(machine), password)
+	response, err := winrm.httpClient(machine).Do(request)
+	if err != nil {
+		if e, ok := err.(*url.Error); ok {
+			// Keeps network errors categorised as such
+			return nil, e.Err
+		}
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("WinRM refused the credentials of %s", winrm.user(machine))
+	}
+	reply, err := parseWSManReply(data)
+	if err != nil {
+		return nil, fmt.Errorf("Bad WinRM response (%s): %s", response.Status, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		if reply.fault == "" {
+			reply.fault = response.Status
+		}
+		return reply, fmt.Errorf("WinRM: %s", reply.fault)
+	}
+	return reply, nil
+}
+
+// Runs the action as a PowerShell script in a shell of its own, feeding it
+// stdin, if any. Output and errors are interleaved like over SSH.
+func (winrm *WinRM) Run(machine *Machine, action string, stdin io.Reader) (*Output, error) {
+	b := NewOutput()
+	defer b.Close()
+	err := machine.command(func() error {
+		return winrm.run(machine, action, stdin, b)
+	}, func() error {
+		// Requests in flight are cancelled, see post. The shell is left to
+		// WinRM's idle timeout.
+		return nil
+	})
+	return b, err
+}
+
+func (winrm *WinRM) run(machine *Machine, action string, stdin io.Reader, out io.Writer) error {
+	endpoint := winrm.endpoint(machine)
+	options := map[string]string{"WINRS_NOPROFILE": "FALSE", "WINRS_CODEPAGE": "65001"}
+	body := `<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>`
+	reply, err := winrm.post(machine, wsmanEnvelope(endpoint, wsmanCreate, "", options, body))
+	if err != nil {
+		return err
+	}
+	shellId := reply.shellId
+	if shellId == "" {
+		return fmt.Errorf("WinRM didn't create a shell")
+	}
+	defer winrm.post(machine, wsmanEnvelope(endpoint, wsmanDelete, shellId, nil, ""))
+
+	body = fmt.Sprintf(`<rsp:CommandLine><rsp:Command>powershell.exe</rsp:Command><rsp:Arguments>-NoProfile -NonInteractive -EncodedCommand %s</rsp:Arguments></rsp:CommandLine>`, encodePowerShell(action))
+	options = map[string]string{"WINRS_CONSOLEMODE_STDIN": "TRUE", "WINRS_SKIP_CMD_SHELL": "TRUE"}
+	if reply, err = winrm.post(machine, wsmanEnvelope(endpoint, wsmanCommand, shellId, options, body)); err != nil {
+		return err
+	}
+	commandId := xmlEscape(reply.commandId)
+
+	if stdin != nil {
+		input, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		body = fmt.Sprintf(`<rsp:Send><rsp:Stream Name="stdin" CommandId="%s" End="true">%s</rsp:Stream></rsp:Send>`, commandId, base64.StdEncoding.EncodeToString(input))
+		if _, err := winrm.post(machine, wsmanEnvelope(endpoint, wsmanSend, shellId, nil, body)); err != nil {
+			return err
+		}
+	}
+
+	body = fmt.Sprintf(`<rsp:Receive><rsp:DesiredStream CommandId="%s">stdout stderr</rsp:DesiredStream></rsp:Receive>`, commandId)
+	for {
+		reply, err := winrm.post(machine, wsmanEnvelope(endpoint, wsmanReceive, shellId, nil, body))
+		if err != nil {
+			if reply != nil && reply.faultCode == wsmanTimedOut {
+				// Nothing printed for a while, keep waiting
+				continue
+			}
+			return err
+		}
+		if _, err := out.Write(reply.output); err != nil {
+			return err
+		}
+		if reply.done {
+			if reply.exitCode != 0 {
+				return fmt.Errorf("Process exited with status %d", reply.exitCode)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package henchman
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"code.google.com/p/go.crypto/ssh"
+)
+
+var (
+	wsmanActionPattern    = regexp.MustCompile(`<a:Action[^>]*>([^<]*)</a:Action>`)
+	wsmanArgumentsPattern = regexp.MustCompile(`-EncodedCommand ([A-Za-z0-9+/=]+)`)
+	wsmanStdinPattern     = regexp.MustCompile(`<rsp:Stream Name="stdin"[^>]*>([^<]*)</rsp:Stream>`)
+)
+
+// Answers like WinRM would, echoing the decoded script and stdin back
+// unless output is set. The first Receive times out.
+type fakeWinRMServer struct {
+	output   string
+	script   string
+	stdin    string
+	exitCode int
+	receives int
+	actions  []string
+}
+
+func decodePowerShell(encoded string) string {
+	buf, _ := base64.StdEncoding.DecodeString(encoded)
+	units := make([]uint16, len(buf)/2)
+	for i := range units {
+		units[i] = uint16(buf[2*i]) | uint16(buf[2*i+1])<<8
+	}
+	return string(utf16.Decode(units))
+}
+
+func (server *fakeWinRMServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "Administrator" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	action := wsmanActionPattern.FindStringSubmatch(string(body))[1]
+	server.actions = append(server.actions, action[strings.LastIndex(action, "/")+1:])
+	reply := ""
+	switch action {
+	case wsmanCreate:
+		reply = `<rsp:Shell><rsp:ShellId>shell-1</rsp:ShellId></rsp:Shell>`
+	case wsmanCommand:
+		server.script = decodePowerShell(wsmanArgumentsPattern.FindStringSubmatch(string(body))[1])
+		reply = `<rsp:CommandResponse><rsp:CommandId>command-1</rsp:CommandId></rsp:CommandResponse>`
+	case wsmanSend:
+		stdin, _ := base64.StdEncoding.DecodeString(wsmanStdinPattern.FindStringSubmatch(string(body))[1])
+		server.stdin = string(stdin)
+	case wsmanReceive:
+		if server.receives++; server.receives == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			reply = `<s:Fault><s:Reason><s:Text>The WS-Management service cannot complete the operation within the time specified</s:Text></s:Reason>` +
+				`<s:Detail><f:WSManFault Code="` + wsmanTimedOut + `"/></s:Detail></s:Fault>`
+			break
+		}
+		output := server.output
+		if output == "" {
+			output = server.script + "\n" + server.stdin
+		}
+		out := base64.StdEncoding.EncodeToString([]byte(output))
+		reply = `<rsp:ReceiveResponse><rsp:Stream Name="stdout" CommandId="command-1">` + out + `</rsp:Stream>` +
+			`<rsp:CommandState CommandId="command-1" State="` + wsmanStateDone + `"><rsp:ExitCode>` + strconv.Itoa(server.exitCode) + `</rsp:ExitCode></rsp:CommandState></rsp:ReceiveResponse>`
+	}
+	fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell" xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault"><s:Body>%s</s:Body></s:Envelope>`, reply)
+}
+
+func winrmMachine(url string, vars TaskVars) (*Machine, error) {
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(url, "http://"))
+	machine := &Machine{Hostname: host, Port: 22, SSHConfig: &ssh.ClientConfig{User: "Administrator"}, Vars: vars}
+	machine.Vars["ansible_port"] = port
+	return machine, ApplyHostSettings([]*Machine{machine})
+}
+
+func TestWinRMRun(t *testing.T) {
+	fake := &fakeWinRMServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	machine, err := winrmMachine(server.URL, TaskVars{"ansible_connection": "winrm", "ansible_password": "secret"})
+	if err != nil {
+		t.Fatalf("Couldn't apply the host settings: %s\n", err)
+	}
+	if machine.Transport == nil || machine.Transport.Name() != "winrm" {
+		t.Fatalf("Expected the winrm transport. Got %v\n", machine.Transport)
+	}
+	out, err := machine.run("Get-Service 'Spooler' | Select-Object -Expand Status", strings.NewReader("input"))
+	if err != nil {
+		t.Fatalf("Couldn't run over WinRM: %s\n", err)
+	}
+	if out.String() != "Get-Service 'Spooler' | Select-Object -Expand Status\ninput" {
+		t.Errorf("Output mismatch. Got %q\n", out.String())
+	}
+	if strings.Join(fake.actions, " ") != "Create Command Send Receive Receive Delete" {
+		t.Errorf("Operations mismatch. Got %v\n", fake.actions)
+	}
+
+	fake.exitCode, fake.receives = 3, 0
+	if _, err := machine.run("exit 3", nil); err == nil || err.Error() != "Process exited with status 3" {
+		t.Errorf("Expected the exit status as the error. Got %v\n", err)
+	}
+	if _, _, err := machine.connection(); err == nil {
+		t.Errorf("Expected SSH to be refused for WinRM hosts\n")
+	}
+
+	machine.Transport.(*WinRM).Password = "wrong"
+	if _, err := machine.run("hostname", nil); err == nil || !strings.Contains(err.Error(), "refused the credentials") {
+		t.Errorf("Expected the credentials to be refused. Got %v\n", err)
+	}
+}
+
+func TestApplyWinRMConnection(t *testing.T) {
+	machine := &Machine{Hostname: "win01", Port: 22, Vars: TaskVars{"ansible_connection": "winrm"}}
+	if err := ApplyHostSettings([]*Machine{machine}); err != nil {
+		t.Fatalf("Couldn't apply the host settings: %s\n", err)
+	}
+	if machine.Port != 5985 || machine.Transport.(*WinRM).HTTPS {
+		t.Errorf("Expected plain WinRM on 5985. Got %d\n", machine.Port)
+	}
+
+	machine = &Machine{Hostname: "win02", Port: 22, Vars: TaskVars{"ansible_connection": "winrm", "ansible_port": 5986}}
+	if err := ApplyHostSettings([]*Machine{machine}); err != nil {
+		t.Fatalf("Couldn't apply the host settings: %s\n", err)
+	}
+	if !machine.Transport.(*WinRM).HTTPS {
+		t.Errorf("Expected HTTPS on port 5986\n")
+	}
+
+	machine = &Machine{Hostname: "win03", Port: 22, Vars: TaskVars{"ansible_connection": "telnet"}}
+	if err := ApplyHostSettings([]*Machine{machine}); err == nil {
+		t.Errorf("Expected an unknown connection to be an error\n")
+	}
+}
@@ -1,97 +1,477 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/user"
-	"path"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"code.google.com/p/go.crypto/ssh"
-	"code.google.com/p/gopass"
 
 	"github.com/sudharsh/henchman/lib"
 )
 
-func currentUsername() *user.User {
-	u, err := user.Current()
-	if err != nil {
-		panic("Couldn't get current username: " + err.Error())
+// Returns the current user's name and home directory. Falls back to the
+// environment when the user can't be looked up, which happens for some
+// Windows domain accounts and for binaries built without cgo.
+func currentUser() (string, string) {
+	var username, home string
+	if u, err := user.Current(); err == nil {
+		username, home = u.Username, u.HomeDir
+	}
+	if username == "" {
+		username = firstEnv("USER", "USERNAME")
+	}
+	if home == "" {
+		home = firstEnv("HOME", "USERPROFILE")
+	}
+	// Windows usernames are qualified with the domain
+	if i := strings.LastIndex(username, "\\"); i >= 0 {
+		username = username[i+1:]
+	}
+	return username, home
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Picks where secrets come from. An external command wins over a file, which
+// wins over the environment. The user is prompted otherwise.
+func credentialProvider(command string, file string, fromEnv bool) henchman.CredentialProvider {
+	switch {
+	case command != "":
+		return &henchman.CommandCredentials{Command: command}
+	case file != "":
+		return &henchman.FileCredentials{Path: file}
+	case fromEnv:
+		return &henchman.EnvCredentials{}
 	}
-	return u
+	return &henchman.PromptCredentials{Prompt: readPassword}
 }
 
 func defaultKeyFile() string {
-	u := currentUsername()
-	return path.Join(u.HomeDir, ".ssh", "id_rsa")
+	_, home := currentUser()
+	return filepath.Join(home, ".ssh", "id_rsa")
+}
+
+func defaultKnownHosts() string {
+	_, home := currentUser()
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+func defaultBrokerSocket() string {
+	_, home := currentUser()
+	return filepath.Join(home, ".henchman", "broker.sock")
 }
 
 // Split args from the cli that are of the form,
 // "a=x b=y c=z" as a map of form { "a": "b", "b": "y", "c": "z" }
 // These plan arguments override the variables that may be defined
-// as part of the plan file.
+// as part of the plan file. Args scoped to a group are left out, see
+// parseScopedArgs.
 func parseExtraArgs(args string) henchman.TaskVars {
 	extraArgs := make(henchman.TaskVars)
 	if args == "" {
 		return extraArgs
 	}
 	for _, a := range strings.Split(args, " ") {
-		kv := strings.Split(a, "=")
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 || strings.Contains(kv[0], ":") {
+			continue
+		}
 		extraArgs[kv[0]] = kv[1]
 	}
 	return extraArgs
 }
 
-// TODO: Modules
-func validateModulesPath() (string, error) {
-	_modulesDir := os.Getenv("HENCHMAN_MODULES_PATH")
-	if _modulesDir == "" {
+// Split args from the cli scoped to a group or host, like
+// "db:pgsql_version=15", by scope. They override the other args on the
+// machines in the scope only.
+func parseScopedArgs(args string) henchman.ScopedVars {
+	scoped := make(henchman.ScopedVars)
+	for _, a := range strings.Fields(args) {
+		kv := strings.SplitN(a, "=", 2)
+		scope_name := strings.SplitN(kv[0], ":", 2)
+		if len(kv) != 2 || len(scope_name) != 2 {
+			continue
+		}
+		if scoped[scope_name[0]] == nil {
+			scoped[scope_name[0]] = make(henchman.TaskVars)
+		}
+		scoped[scope_name[0]][scope_name[1]] = kv[1]
+	}
+	return scoped
+}
+
+// Layers the extra args over the machine's vars, the ones scoped to the
+// machine last
+func layerExtraArgs(vars henchman.TaskVars, args string, machine *henchman.Machine) henchman.TaskVars {
+	for variable, value := range parseExtraArgs(args) {
+		vars[variable] = value
+	}
+	for variable, value := range parseScopedArgs(args).For(machine) {
+		vars[variable] = value
+	}
+	return vars
+}
+
+// Adds the host_vars and group_vars next to the inventory and the plan to
+// the inventory. The ones next to the plan win over the ones next to the
+// inventory.
+func loadVarsDirs(planDir string, inventoryPath string) {
+	varsDirs := []string{planDir}
+	if inventoryPath != "" && !strings.HasPrefix(inventoryPath, "consul://") {
+		varsDirs = []string{filepath.Dir(inventoryPath), planDir}
+	}
+	if henchman.DefaultInventory == nil {
+		henchman.DefaultInventory = &henchman.Inventory{}
+	}
+	for _, dir := range varsDirs {
+		hostVars, err := henchman.LoadHostVars(dir)
+		if err != nil {
+			log.Fatalf("Couldn't load the host vars in %s: %s", dir, err)
+		}
+		henchman.DefaultInventory.AddHostVars(hostVars)
+		groupVars, err := henchman.LoadGroupVars(dir)
+		if err != nil {
+			log.Fatalf("Couldn't load the group vars in %s: %s", dir, err)
+		}
+		henchman.DefaultInventory.AddGroupVars(groupVars)
+	}
+}
+
+// Collects the repeatable -host-override flag
+type hostOverrides []henchman.HostOverride
+
+func (overrides *hostOverrides) String() string {
+	return fmt.Sprint(*overrides)
+}
+
+func (overrides *hostOverrides) Set(spec string) error {
+	override, err := henchman.ParseHostOverride(spec)
+	if err != nil {
+		return err
+	}
+	*overrides = append(*overrides, override)
+	return nil
+}
+
+// Collects the repeatable -output flag as format to path
+type outputs map[string]string
+
+func (o outputs) String() string {
+	return fmt.Sprint(map[string]string(o))
+}
+
+func (o outputs) Set(spec string) error {
+	format_path := strings.SplitN(spec, "=", 2)
+	if len(format_path) != 2 || format_path[1] == "" {
+		return fmt.Errorf("Output '%s' should be of the form <format>=<path>", spec)
+	}
+	if format_path[0] != "html" {
+		return fmt.Errorf("Unknown output format '%s'", format_path[0])
+	}
+	o[format_path[0]] = format_path[1]
+	return nil
+}
+
+// Collects the repeatable -modules flag as a list of directories like
+// $PATH. The first -modules replaces the default.
+type modulesFlag struct {
+	path string
+	set  bool
+}
+
+func (modules *modulesFlag) String() string {
+	return modules.path
+}
+
+func (modules *modulesFlag) Set(dir string) error {
+	if modules.set {
+		dir = modules.path + string(filepath.ListSeparator) + dir
+	}
+	modules.path, modules.set = dir, true
+	return nil
+}
+
+// Asks the user to confirm running the plan on protected machines
+func confirmProtected(protected []*henchman.Machine) bool {
+	fmt.Fprintf(os.Stderr, "The plan targets protected hosts:\n")
+	for _, machine := range protected {
+		fmt.Fprintf(os.Stderr, "  %s\n", machine.Hostname)
+	}
+	fmt.Fprintf(os.Stderr, "Type 'yes' to continue: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// Asks the user whether to carry on past the canaries
+func confirmCanaries(canaries []*henchman.Machine) bool {
+	fmt.Fprintf(os.Stderr, "The plan ran on its canaries:\n")
+	for _, machine := range canaries {
+		fmt.Fprintf(os.Stderr, "  %s\n", machine.Hostname)
+	}
+	fmt.Fprintf(os.Stderr, "Type 'yes' to run it on the remaining hosts: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// Verbosity is bumped by every -v. -vv is accepted as a shorthand for -v -v
+type verbosity int
+
+func (v *verbosity) String() string {
+	return fmt.Sprint(int(*v))
+}
+
+func (v *verbosity) Set(string) error {
+	*v++
+	return nil
+}
+
+func (v *verbosity) IsBoolFlag() bool {
+	return true
+}
+
+// HENCHMAN_PLUGINS_PATH can list several directories like $PATH
+func defaultPluginsPath() string {
+	pluginsDir := os.Getenv("HENCHMAN_PLUGINS_PATH")
+	if pluginsDir == "" {
 		cwd, _ := os.Getwd()
-		_modulesDir = path.Join(cwd, "modules")
+		pluginsDir = filepath.Join(cwd, "plugins")
 	}
-	modulesDir := flag.String("modules", _modulesDir, "Path to the modules")
-	_, err := os.Stat(*modulesDir)
-	return *modulesDir, err
+	return pluginsDir
+}
+
+// HENCHMAN_MODULES_PATH can list several directories like $PATH
+func defaultModulesPath() string {
+	modulesDir := os.Getenv("HENCHMAN_MODULES_PATH")
+	if modulesDir == "" {
+		cwd, _ := os.Getwd()
+		modulesDir = filepath.Join(cwd, "modules")
+	}
+	return modulesDir
+}
+
+func validateModulesPath(modulesPath string) error {
+	for _, modulesDir := range filepath.SplitList(modulesPath) {
+		if _, err := os.Stat(modulesDir); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func main() {
-	username := flag.String("user", currentUsername().Username, "User to run as")
+	defaultUsername, _ := currentUser()
+	username := flag.String("user", defaultUsername, "User to run as")
 	usePassword := flag.Bool("password", false, "Use password authentication")
+	passwordCmd := flag.String("password-cmd", "", "Command printing the password, e.g 'pass show infra/ssh'. It gets the kind of secret asked for in HENCHMAN_CREDENTIAL")
+	passwordFile := flag.String("password-file", "", "File to read the password from")
+	passwordEnv := flag.Bool("password-env", false, "Read passwords from HENCHMAN_SSH_PASSWORD and friends")
+	askSudoPass := flag.Bool("ask-sudo-pass", false, "Ask for the sudo password of tasks run with sudo. Otherwise sudo has to work without one")
 	keyfile := flag.String("private-keyfile", defaultKeyFile(), "Path to the keyfile")
-	extraArgs := flag.String("args", "", "Extra arguments for the plan")
+	knownHostsPath := flag.String("known-hosts", defaultKnownHosts(), "Path to the known_hosts file host keys are verified against")
+	strictHostKeys := flag.String("strict-host-key-checking", henchman.AcceptNewHostKeys, "'yes' refuses hosts missing from known_hosts, 'accept-new' records them on first connect")
+	insecureHostKeys := flag.Bool("insecure-ignore-host-keys", false, "Accept any host key without verifying it. Insecure")
+	extraArgs := flag.String("args", "", "Extra arguments for the plan, e.g. 'version=2.1 db:pgsql_version=15'. Arguments prefixed with a group or host only apply to its hosts")
+	useBroker := flag.Bool("broker", false, "Run actions through the connection broker")
+	brokerSocket := flag.String("broker-socket", defaultBrokerSocket(), "Path to the connection broker's socket")
+	forks := flag.Int("forks", 0, "Number of hosts to run the plan on concurrently. 0 runs on all of them at once")
+	hostLogPath := flag.String("host-log", "", "Write each host's task output to this path. Can refer to {{ host }}, {{ run_id }} and {{ date }}")
+	env := flag.String("env", "", "Environment to run the plan against. Layers vars/<env>.yaml over the plan's vars and uses inventory/<env> unless -inventory is given")
+	yesReally := flag.Bool("yes-really", false, "Confirm running against a protected environment like prod")
+	protectedConfirmed := flag.Bool("confirm-protected", false, "Run on protected hosts without asking for a confirmation")
+	canaryConfirmed := flag.Bool("confirm-canary", false, "Carry on past the plan's canaries without asking for a confirmation when it has no canary_check")
+	reportTemplate := flag.String("report-template", "", "Render the final report with this Go template instead")
+	limit := flag.String("limit", "", "Only run on the hosts matching these comma separated patterns or groups, e.g. 'web-*,db-01'")
+	tags := flag.String("tags", "", "Only run the tasks tagged with one of these comma separated tags, and the ones tagged 'always'")
+	skipTags := flag.String("skip-tags", "", "Skip the tasks tagged with one of these comma separated tags")
+	exclude := flag.String("exclude", "", "Skip the hosts matching these comma separated patterns or groups, '@file' reads patterns from a file, e.g. '@downhosts.txt,db-03'")
+	quarantineAfter := flag.Int("quarantine-after", 5, "Quarantine hosts failing this many tasks in a row, ignored failures included. 0 never does")
+	quarantineConnErrors := flag.Int("quarantine-connection-errors", 3, "Quarantine hosts running into this many connection errors. 0 never does")
+	skipFacts := flag.Bool("skip-facts", false, "Don't gather facts even if the plan asks for them")
+	batch := flag.Bool("batch", false, "Run consecutive plain shell tasks as a single script per host to save round trips")
+	manifestPath := flag.String("manifest", "", "Write the run's manifest, its plan hash, hosts and vars with secrets redacted, to this path before running. Can refer to {{ run_id }} and {{ date }}")
+	webhookURL := flag.String("webhook", "", "POST each task's result as JSON to this URL as soon as it completes, and the end of the plan")
+	eventLogPath := flag.String("events", "", "Write the run's events as newline delimited JSON to this path, for 'replay'")
+	progress := flag.String("progress", "", "'plain' prints a single line progress summary every -progress-interval, for CI logs")
+	progressInterval := flag.Duration("progress-interval", 10*time.Second, "How often -progress plain prints")
+	estimate := flag.Bool("estimate", false, "Don't connect anywhere, print the tasks, commands, connections and bytes the plan would take per host instead")
+	check := flag.Bool("check", false, "Don't change anything, print what each task would do on each host instead. Modules supporting check mode predict their changes")
+	diff := flag.Bool("diff", false, "Show a diff of the remote files copy and template tasks change, with -check too")
+	bundlePath := flag.String("bundle", "", "Run the plan in this bundle, made with 'bundle', with its modules")
+	reportOutputs := make(outputs)
+	flag.Var(reportOutputs, "output", "Also write the report as format=path. Supported formats: html")
+	budgetFactor := flag.Float64("budget-factor", henchman.BudgetFactor, "Warn about tasks taking longer than this many times their expected_duration")
+	maxOutput := flag.Int("max-output", henchman.OutputCap, "Cap the bytes of output captured per task. 0 doesn't cap")
+	outputLimit := flag.Int("output-limit", henchman.OutputLimit, "Spill task outputs larger than this many bytes to files, keeping only the head and tail in memory")
+	spillDir := flag.String("spill-dir", henchman.SpillDir, "Directory for spilled task outputs")
+	var verbose verbosity
+	flag.Var(&verbose, "v", "Verbose output. Repeat for more")
+	veryVerbose := flag.Bool("vv", false, "Same as -v -v")
+	var overrides hostOverrides
+	flag.Var(&overrides, "host-override", "Connection settings for matching hosts, e.g 'db*:user=postgres,port=2202,keyfile=path,connect_timeout=30s,command_timeout=10m'. Repeatable")
+
+	modules := modulesFlag{path: defaultModulesPath()}
+	flag.Var(&modules, "modules", "Directory of modules. Repeat to search several in order, the first module of a name shadows the rest")
+	pluginsPath := flag.String("plugins", defaultPluginsPath(), "Directories of plugins like $PATH, each with modules/, lookups/, callbacks/, inventory/ and transports/ subdirectories")
+	ec2PrivateIP := flag.Bool("ec2-private-ip", false, "Connect to EC2 instances looked up by tag:<key>=<value> on their private IPs")
+	inventoryPath := flag.String("inventory", os.Getenv("HENCHMAN_INVENTORY"), "Inventory file (YAML, INI or an executable printing JSON) or consul://host:port catalog defining the hosts and groups plans can target")
+	connectTimeout := flag.Duration("connect-timeout", 10*time.Second, "Give up connecting to a host after this long. 0 waits forever")
+	handshakeTimeout := flag.Duration("handshake-timeout", 30*time.Second, "Give up on the SSH handshake with a host after this long")
+	sessionTimeout := flag.Duration("session-timeout", 30*time.Second, "Give up opening a session on a host after this long")
+	commandTimeout := flag.Duration("command-timeout", 0, "Kill task commands running longer than this. 0 lets them run")
+	reconnectAttempts := flag.Int("reconnect-attempts", 3, "Try connecting to a host this many times before failing its task")
+	reconnectBackoff := flag.Duration("reconnect-backoff", time.Second, "Wait this long before reconnecting the first time, twice as long each time after")
+	reconnectMaxBackoff := flag.Duration("reconnect-max-backoff", 30*time.Second, "Never wait longer than this between reconnections")
 
-	modulesDir, err := validateModulesPath()
-	if err != nil {
-		log.Fatalf("Couldn't stat modules path '%s'\n", modulesDir)
-	}
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [args] <plan>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [args] <plan>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [args] bench [-rounds n] [-size bytes] <hosts>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [args] broker [-idle duration]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [args] bootstrap [-login-user root] [-public-keyfile path] <hosts>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [args] module list | doc <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [args] plugin list\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [args] vars [-plan plan.yaml] [-var name] [-facts subsets] <host>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [args] bundle [-o path] <plan>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [args] run -bundle <bundle.tgz>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s init [dir]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [-v] [-report-template path] replay <events.ndjson>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s template render <template> [-vars vars.yaml] [-args 'k=v'] [-host name] [-dialect jinja2]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s completion bash|zsh|fish\n\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-
+	if flag.Arg(0) == "run" {
+		// 'run' is optional, flags may follow it
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	if *veryVerbose {
+		verbose += 2
+	}
+	henchman.OutputCap = *maxOutput
+	henchman.BudgetFactor = *budgetFactor
+	henchman.OutputLimit = *outputLimit
+	henchman.SpillDir = *spillDir
+	switch flag.Arg(0) {
+	case "init":
+		runInit(flag.Args()[1:])
+		return
+	case "completion":
+		runCompletion(flag.Args()[1:])
+		return
+	case "__complete":
+		runComplete(flag.Args()[1:], modules.path)
+		return
+	case "replay":
+		runReplay(flag.Args()[1:], verbose, *reportTemplate)
+		return
+	case "template":
+		runTemplate(flag.Args()[1:])
+		return
+	}
 	planFile := flag.Arg(0)
+	if err := henchman.Plugins.Discover(*pluginsPath); err != nil {
+		log.Fatalf("%s", err)
+	}
+	henchman.Plugins.RegisterHostProviders()
+	henchman.Plugins.RegisterTransports()
+	defer henchman.Plugins.Close()
+	if *bundlePath != "" {
+		var bundleDir string
+		planFile, modules.path, bundleDir = extractBundle(*bundlePath)
+		defer os.RemoveAll(bundleDir)
+	} else if pluginModules := henchman.PluginModulesPath(*pluginsPath); pluginModules != "" {
+		// Modules on the modules path shadow the plugins' ones
+		modules.path += string(filepath.ListSeparator) + pluginModules
+	}
+	err := validateModulesPath(modules.path)
+	if err != nil {
+		log.Fatalf("Couldn't stat modules path '%s': %s\n", modules.path, err)
+	}
+
 	if planFile == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	switch planFile {
+	case "module":
+		runModule(flag.Args()[1:], modules.path)
+		return
+	case "bundle":
+		runBundle(flag.Args()[1:], modules.path)
+		return
+	case "plugin":
+		runPlugin(flag.Args()[1:])
+		return
+	}
+
+	henchman.EC2.PrivateIP = *ec2PrivateIP
+	// An explicit -inventory wins over the environment's. The vars
+	// subcommand looks for it next to its own plan.
+	if *env != "" && *inventoryPath == "" && planFile != "vars" {
+		*inventoryPath = henchman.EnvInventoryPath(filepath.Dir(planFile), *env)
+	}
+	if *inventoryPath != "" {
+		if henchman.DefaultInventory, err = henchman.LoadInventory(*inventoryPath); err != nil {
+			log.Fatalf("Couldn't load the inventory: %s", err)
+		}
+	}
+
 	if *username == "" {
 		fmt.Fprintf(os.Stderr, "Missing username")
 		os.Exit(1)
 	}
 
+	credentials := credentialProvider(*passwordCmd, *passwordFile, *passwordEnv)
+	henchman.KeyPassphrases = credentials
+	henchman.WinRMPasswords = credentials
+	henchman.ShowDiffs = *diff
+	if *askSudoPass {
+		if henchman.BecomePassword, err = credentials.Credential(henchman.SudoPassword); err != nil {
+			log.Fatalf("Couldn't get the sudo password: %s", err)
+		}
+	}
+	hostKeyMode := *strictHostKeys
+	if *insecureHostKeys {
+		hostKeyMode = henchman.InsecureHostKeys
+	}
+	knownHosts, err := henchman.LoadKnownHosts(*knownHostsPath, hostKeyMode)
+	if err != nil {
+		log.Fatalf("Couldn't load known hosts: %s\n", err)
+	}
+	timeouts := henchman.Timeouts{
+		Connect:   *connectTimeout,
+		Handshake: *handshakeTimeout,
+		Session:   *sessionTimeout,
+		Command:   *commandTimeout,
+	}
+	if planFile == "bootstrap" {
+		runBootstrap(flag.Args()[1:], *username, *keyfile, credentials, knownHosts, timeouts)
+		return
+	}
+
 	// We support two SSH authentications methods for now
 	// password and client key bases. Both are mutually exclusive and password takes
 	// higher precedence
 	var sshAuth ssh.AuthMethod
 	if *usePassword {
 		var password string
-		if password, err = gopass.GetPass("Password:"); err != nil {
+		if password, err = credentials.Credential(henchman.SSHPassword); err != nil {
 			log.Fatalf("Couldn't get password: " + err.Error())
 			os.Exit(1)
 		}
@@ -102,9 +482,23 @@ func main() {
 	if err != nil {
 		log.Fatalf("SSH Auth prep failed: " + err.Error())
 	}
+	// Pinned host keys in the plan take precedence, see PinHostKeys
 	config := &ssh.ClientConfig{
-		User: *username,
-		Auth: []ssh.AuthMethod{sshAuth},
+		User:            *username,
+		Auth:            []ssh.AuthMethod{sshAuth},
+		HostKeyCallback: knownHosts.Callback(),
+	}
+
+	switch planFile {
+	case "bench":
+		runBench(flag.Args()[1:], config, overrides)
+		return
+	case "broker":
+		runBroker(flag.Args()[1:], config, *brokerSocket)
+		return
+	case "vars":
+		runVars(flag.Args()[1:], config, *extraArgs, *env, *inventoryPath)
+		return
 	}
 
 	planBuf, err := ioutil.ReadFile(planFile)
@@ -115,42 +509,457 @@ func main() {
 
 	var plan *henchman.Plan
 	parsedArgs := parseExtraArgs(*extraArgs)
+	if *env != "" {
+		if henchman.IsProtectedEnv(*env) && !*yesReally {
+			log.Fatalf("Refusing to run against the protected environment '%s' without -yes-really", *env)
+		}
+		envVars, err := henchman.LoadEnvVars(filepath.Dir(planFile), *env)
+		if err != nil {
+			log.Fatalf("Couldn't load the vars for environment '%s': %s", *env, err)
+		}
+		for variable, value := range parsedArgs {
+			envVars[variable] = value
+		}
+		parsedArgs = envVars
+	}
 	plan, err = henchman.NewPlanFromYAML(planBuf, &parsedArgs)
 	if err != nil {
 		log.Fatalf("Couldn't read the plan: %s", err)
 		os.Exit(1)
 	}
+	plan.Dir = filepath.Dir(planFile)
+	// Hosts in the plan can carry their connection settings too
+	entrySettings := make(map[string]henchman.TaskVars)
+	for i, entry := range plan.Hosts {
+		var settings henchman.TaskVars
+		if plan.Hosts[i], settings = henchman.ParseHostEntry(entry); len(settings) > 0 {
+			entrySettings[plan.Hosts[i]] = settings
+		}
+	}
+	if plan.Hosts, err = henchman.ResolveHosts(plan.Hosts); err != nil {
+		log.Fatalf("%s", err)
+	}
+	if *limit != "" {
+		if plan.Hosts, err = henchman.LimitHosts(plan.Hosts, *limit); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	if *exclude != "" {
+		if plan.Hosts, err = henchman.ExcludeHosts(plan.Hosts, *exclude); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	if plan.Hosts, err = henchman.OrderHosts(plan.Hosts, plan.Order); err != nil {
+		log.Fatalf("%s", err)
+	}
+	if *tags != "" || *skipTags != "" {
+		if err := plan.SelectTags(*tags, *skipTags); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
+	// Vars precedence is extra args > host vars > group vars > hierarchy
+	// data > plan vars.
+	loadVarsDirs(plan.Dir, *inventoryPath)
+	henchman.DefaultInventory.AddHostVars(entrySettings)
+	if err := plan.ResolveFiles(); err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := plan.ResolveModules(modules.path); err != nil {
+		log.Fatalf("%s", err)
+	}
+	factSubsets, err := henchman.ParseFactSubsets(plan.GatherFacts)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if *skipFacts {
+		factSubsets = nil
+	}
+	if len(plan.GroupBy) > 0 && len(factSubsets) == 0 {
+		log.Fatalf("group_by needs facts, set gather_facts in the plan")
+	}
+	if *reportTemplate != "" {
+		// Paths given on the command line are taken as they are first
+		if _, err := os.Stat(*reportTemplate); err != nil {
+			if *reportTemplate, err = plan.Lookup("templates", *reportTemplate); err != nil {
+				log.Fatalf("Couldn't find the report template: %s", err)
+			}
+		}
+	}
 
 	// Execute the same plan concurrently across all the machines.
 	// Note the tasks themselves in plan are executed sequentially.
 	wg := new(sync.WaitGroup)
+	hops := plan.Via
+	if plan.Bastion != "" {
+		if len(hops) > 0 {
+			log.Fatalf("Set either bastion or via in the plan, not both")
+		}
+		hops = []henchman.Hop{henchman.ParseBastion(plan.Bastion)}
+	}
+	via, err := henchman.JumpHosts(hops, config)
+	if err != nil {
+		log.Fatalf("Couldn't prepare the jump hosts: %s", err)
+	}
+	for _, hop := range via {
+		hop.Timeouts = timeouts
+	}
 	machines := henchman.Machines(plan.Hosts, config)
-	localhost := henchman.Machine{"127.0.0.1", 0, nil}
-	for _, _machine := range machines {
-		machine := _machine
-		wg.Add(1)
+	for _, machine := range machines {
+		if len(machine.Vars) > 0 || len(parseScopedArgs(*extraArgs).For(machine)) > 0 {
+			// Extra args still take precedence over host vars
+			vars := make(henchman.TaskVars)
+			for variable, value := range machine.Vars {
+				vars[variable] = value
+			}
+			machine.Vars = layerExtraArgs(vars, *extraArgs, machine)
+		}
+		machine.Timeouts = timeouts
+		machine.Reconnect = henchman.Reconnect{
+			Attempts:   *reconnectAttempts,
+			Backoff:    *reconnectBackoff,
+			MaxBackoff: *reconnectMaxBackoff,
+		}
+		machine.Interpreter = plan.Interpreters[machine.Hostname]
+		machine.Via = via
+		if *useBroker {
+			machine.Broker = *brokerSocket
+		}
+	}
+	bastions, err := henchman.UseBastions(machines, config)
+	if err != nil {
+		log.Fatalf("Couldn't prepare the bastions: %s", err)
+	}
+	for _, bastion := range bastions {
+		bastion.Timeouts = timeouts
+	}
+	// Command line overrides win over the inventory's settings
+	if err := henchman.ApplyHostSettings(machines); err != nil {
+		log.Fatalf("Couldn't apply the host settings: %s", err)
+	}
+	for _, override := range overrides {
+		if err := override.Apply(machines); err != nil {
+			log.Fatalf("Couldn't apply host override for '%s': %s", override.Pattern, err)
+		}
+	}
+	henchman.PinHostKeys(via, plan.HostKeys)
+	henchman.PinHostKeys(bastions, plan.HostKeys)
+	henchman.PinHostKeys(machines, plan.HostKeys)
+	var noop *henchman.NoopTransport
+	if *estimate {
+		noop = henchman.NewNoopTransport()
+		for _, machine := range machines {
+			noop.Attach(machine)
+		}
+	}
+	if *check && *estimate {
+		log.Fatalf("-check and -estimate can't be used together")
+	}
+	if protected := henchman.ProtectedMachines(machines, plan.Protected); len(protected) > 0 && !*protectedConfirmed && !*estimate && !*check {
+		if !confirmProtected(protected) {
+			log.Fatalf("Not running the plan on protected hosts")
+		}
+	}
+	scheduler := henchman.NewScheduler(plan.Tasks, len(machines), *forks)
+	if verbose >= 2 {
+		stop := make(chan bool)
+		defer close(stop)
+		go scheduler.Report(5*time.Second, stop)
+	}
+	var progressStop, progressDone chan bool
+	switch *progress {
+	case "":
+	case "plain":
+		progressStop, progressDone = make(chan bool), make(chan bool)
 		go func() {
-			defer wg.Done()
-			for _, task := range plan.Tasks {
-				var status *henchman.TaskStatus
-				var err error
-				if task.LocalAction {
-					log.Printf("Local action detected\n")
-					status, err = task.Run(&localhost, plan.Vars)
-				} else {
-					status, err = task.Run(machine, plan.Vars)
-				}
-				plan.SaveStatus(&task, status.Status)
-				if err != nil {
-					log.Printf("Error when executing task: %s\n", err.Error())
-				}
-				if status.Status == "failure" {
-					log.Printf("Task was unsuccessful: %s\n", task.Id)
+			henchman.ReportProgress(os.Stdout, *progressInterval, scheduler, plan, progressStop)
+			close(progressDone)
+		}()
+	default:
+		log.Fatalf("Unknown progress mode '%s'", *progress)
+	}
+	run := henchman.NewRun()
+	henchman.SetRunVars(run, plan)
+	if *manifestPath != "" {
+		path, err := run.ArtifactPath(*manifestPath, &henchman.Machine{})
+		if err != nil {
+			log.Fatalf("Bad manifest path: %s", err)
+		}
+		if err := henchman.NewManifest(run, plan, planFile, planBuf, machines).Write(path); err != nil {
+			log.Fatalf("Couldn't write the manifest: %s", err)
+		}
+		plan.Manifest = run.Id
+		log.Printf("Wrote the manifest %s to %s\n", run.Id, path)
+	}
+	var events *henchman.EventLog
+	if *eventLogPath != "" {
+		f, err := os.Create(*eventLogPath)
+		if err != nil {
+			log.Fatalf("Couldn't create the event log: %s", err)
+		}
+		defer f.Close()
+		events = henchman.NewEventLog(f)
+	}
+	if *webhookURL != "" {
+		if events == nil {
+			events = henchman.NewEventLog(nil)
+		}
+		webhook := henchman.NewWebhook(*webhookURL)
+		defer webhook.Close()
+		events.AddSink(webhook)
+	}
+	for _, plugin := range henchman.Plugins.List(henchman.CallbackPlugins) {
+		if events == nil {
+			events = henchman.NewEventLog(nil)
+		}
+		callback, err := henchman.Plugins.Process(plugin.Kind, plugin.Name)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		events.AddSink(callback)
+	}
+	events.PlanStarted(plan)
+	// Local actions of a host run on a localhost of its own, so that
+	// cancelling them on a task timeout leaves the other hosts' alone and
+	// they're rendered with the host's vars
+	newLocalhost := func() *henchman.Machine {
+		localhost := &henchman.Machine{Hostname: "127.0.0.1", Transport: &henchman.Local{}}
+		if noop != nil {
+			noop.Attach(localhost)
+		}
+		return localhost
+	}
+	localhost := newLocalhost()
+	// With canaries or serial_by, a batch only starts once the previous one
+	// is through the plan, and not at all if it had failures
+	batches, err := plan.Batches(machines)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	describe := func(serial henchman.SerialBatch) string {
+		if serial.Canary {
+			return "canaries"
+		} else if plan.SerialBy == "" {
+			return "remaining hosts"
+		}
+		return fmt.Sprintf("hosts with %s=%s", plan.SerialBy, serial.Value)
+	}
+	for b, serial := range batches {
+		if b > 0 && plan.AnyFailed(batches[b-1].Machines) {
+			log.Printf("Not running on the remaining hosts, the %s had failures\n", describe(batches[b-1]))
+			break
+		}
+		// Nothing changed on the canaries in check mode
+		if b > 0 && batches[b-1].Canary && !*check {
+			if plan.CanaryCheck != nil {
+				if err := plan.CheckCanaries(batches[b-1].Machines, localhost); err != nil {
+					log.Printf("%s, not running on the remaining hosts\n", err)
 					break
 				}
+			} else if !*canaryConfirmed && !*estimate && !confirmCanaries(batches[b-1].Machines) {
+				log.Printf("Not running on the remaining hosts\n")
+				break
 			}
-		}()
+		}
+		if len(batches) > 1 {
+			log.Printf("Running on the %d %s\n", len(serial.Machines), describe(serial))
+		}
+		for _, _machine := range serial.Machines {
+			machine := _machine
+			wg.Add(1)
+			// Acquired here so that the machines start in the plan's order
+			scheduler.Acquire()
+			go func() {
+				defer wg.Done()
+				defer scheduler.Release()
+				defer machine.Close()
+				localhost := newLocalhost()
+				localhost.OnBehalfOf = machine
+				var hostLog *log.Logger
+				if *hostLogPath != "" {
+					f, err := run.CreateArtifact(*hostLogPath, machine)
+					if err != nil {
+						log.Printf("Couldn't create the host log for %s: %s\n", machine.Hostname, err)
+					} else {
+						defer f.Close()
+						hostLog = log.New(f, "", log.LstdFlags)
+					}
+				}
+				if len(factSubsets) > 0 {
+					facts, err := machine.GatherFacts(factSubsets)
+					if err != nil {
+						log.Printf("%s\n", err)
+						plan.SaveSetupFailure(machine, "Gathering facts", err)
+						scheduler.SkipFrom(0)
+						return
+					}
+					vars := henchman.TaskVars{"facts": facts}
+					for variable, value := range machine.Vars {
+						vars[variable] = value
+					}
+					machine.Vars = vars
+					machine.GroupByFacts(plan.GroupBy, facts)
+				}
+				if len(plan.Hierarchy) > 0 {
+					vars, err := plan.HierarchyVars(machine)
+					if err != nil {
+						log.Printf("Couldn't resolve the hierarchy for %s: %s\n", machine.Hostname, err)
+						plan.SaveSetupFailure(machine, "Resolving the hierarchy", err)
+						scheduler.SkipFrom(0)
+						return
+					}
+					for variable, value := range machine.Vars {
+						vars[variable] = value
+					}
+					machine.Vars = layerExtraArgs(vars, *extraArgs, machine)
+				} else if len(plan.GroupBy) > 0 {
+					// Args can be scoped to the groups made from the facts too
+					machine.Vars = layerExtraArgs(machine.Vars, *extraArgs, machine)
+				}
+				health := henchman.HostHealth{MaxFailures: *quarantineAfter, MaxConnectionErrors: *quarantineConnErrors}
+				// Tasks that succeeded on the machine, to roll back if a later one fails
+				var succeeded []*henchman.Task
+				// Handlers notified by tasks that changed the machine
+				notified := make(map[string]bool)
+				stopped := false
+				record := func(task *henchman.Task, status *henchman.TaskStatus) {
+					plan.SaveStatus(machine, task, status)
+					events.TaskFinished(machine, task, status)
+					if hostLog != nil {
+						hostLog.Printf("%s: '%s' [%s]\n%s", task.Id, task.Name, status.Status, status.Message)
+					}
+				}
+				// Records the task's outcome, returning whether the plan should
+				// stop on this machine
+				finish := func(i int, task *henchman.Task, status *henchman.TaskStatus, err error) bool {
+					record(task, status)
+					machine.Register(task, status, err)
+					task.NotifyHandlers(status, notified)
+					scheduler.TaskDone(i)
+					if err != nil {
+						log.Printf("Error when executing task: %s\n", err.Error())
+					}
+					if status.Failed() {
+						stopped = true
+						log.Printf("Task was unsuccessful: %s\n", task.Id)
+						scheduler.SkipFrom(i + 1)
+						if !*check {
+							plan.Rollback(machine, localhost, succeeded, record)
+						}
+						return true
+					}
+					if status.Succeeded() && len(task.Rollback) > 0 {
+						succeeded = append(succeeded, task)
+					}
+					if reason := health.Record(status); reason != "" {
+						log.Printf("Quarantining %s after %s\n", machine.Hostname, reason)
+						plan.Quarantine(machine, reason)
+						events.HostQuarantined(machine, reason)
+						scheduler.SkipFrom(i + 1)
+						stopped = true
+						return true
+					}
+					return false
+				}
+				for i := 0; i < len(plan.Tasks); {
+					if n := henchman.BatchLength(plan.Tasks[i:]); *batch && !*check && machine.Transport == nil && n > 1 {
+						tasks := append([]henchman.Task(nil), plan.Tasks[i:i+n]...)
+						for j := range tasks {
+							scheduler.TaskStarted(i + j)
+							tasks[j].AssignId()
+							events.TaskStarted(machine, &tasks[j])
+						}
+						statuses, err := machine.RunBatch(tasks, plan.Vars)
+						for j, status := range statuses {
+							var stepErr error
+							if j == len(statuses)-1 {
+								stepErr = err
+							}
+							if finish(i+j, &tasks[j], status, stepErr) {
+								return
+							}
+						}
+						// A batch cut short resumes after its last attempted task
+						for j := len(statuses); j < n; j++ {
+							scheduler.TaskAborted(i + j)
+						}
+						i += len(statuses)
+						continue
+					}
+					task := plan.Tasks[i]
+					if task.Group != "" && !machine.InGroup(task.Group) {
+						log.Printf("Skipping '%s' on %s, not in group %s\n", task.Name, machine.Hostname, task.Group)
+						scheduler.TaskStarted(i)
+						finish(i, &task, &henchman.TaskStatus{Status: henchman.StatusSkipped, Message: "not in group " + task.Group}, nil)
+						i++
+						continue
+					}
+					scheduler.TaskStarted(i)
+					task.AssignId()
+					events.TaskStarted(machine, &task)
+					var status *henchman.TaskStatus
+					var err error
+					target := machine
+					if task.LocalAction {
+						log.Printf("Local action detected\n")
+						target = localhost
+					}
+					if *check {
+						status, err = task.Check(target, plan.Vars)
+					} else {
+						status, err = task.Run(target, plan.Vars)
+					}
+					if finish(i, &task, status, err) {
+						break
+					}
+					i++
+				}
+				if !stopped && len(notified) > 0 {
+					run := (*henchman.Task).Run
+					if *check {
+						run = (*henchman.Task).Check
+					}
+					if err := plan.RunHandlers(machine, localhost, notified, run, record); err != nil {
+						log.Printf("%s\n", err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	if progressStop != nil {
+		close(progressStop)
+		<-progressDone
+	}
+	events.PlanFinished(plan)
+	if *check {
+		plan.PrintCheck()
+		return
+	}
+	if noop != nil {
+		noop.PrintEstimate(plan)
+		return
+	}
+	if htmlPath, present := reportOutputs["html"]; present {
+		f, err := os.Create(htmlPath)
+		if err != nil {
+			log.Fatalf("Couldn't create the HTML report: %s", err)
+		}
+		err = plan.WriteHTMLReport(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Couldn't write the HTML report: %s", err)
+		}
+	}
+	if *reportTemplate != "" {
+		tmpl, err := ioutil.ReadFile(*reportTemplate)
+		if err != nil {
+			log.Fatalf("Couldn't read the report template: %s", err)
+		}
+		if err := plan.RenderReport(os.Stdout, string(tmpl)); err != nil {
+			log.Fatalf("Couldn't render the report: %s", err)
+		}
+		return
 	}
-	wg.Wait()
 	plan.PrintReport()
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Lists the plugins found on the plugins path
+func runPlugin(args []string) {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintf(os.Stderr, "Usage: plugin list\n")
+		os.Exit(1)
+	}
+	henchman.Plugins.Print()
+}
+
+// Lists the available modules or prints the documentation of one of them
+func runModule(args []string, modulesPath string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: module list | module doc <name>\n")
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list":
+		modules, err := henchman.DiscoverModules(modulesPath)
+		if err != nil {
+			log.Fatalf("Couldn't discover modules: %s", err)
+		}
+		for _, module := range modules {
+			fmt.Printf("%s\t%s\n", module.Name, module.Doc.Description)
+			for _, shadowed := range module.Shadows {
+				fmt.Printf("\tshadows %s\n", shadowed)
+			}
+		}
+	case "doc":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Missing module name\n")
+			os.Exit(1)
+		}
+		module, err := henchman.FindModule(modulesPath, args[1])
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		module.PrintDoc()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown module command '%s'\n", args[0])
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "code.google.com/p/gopass"
+
+// Prompts for a password without echoing it
+func readPassword(prompt string) (string, error) {
+	return gopass.GetPass(prompt)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Re-renders the console output and the report of a run from its event log.
+// The output gets more detailed with the verbosity, independently of how
+// verbose the original run was.
+func runReplay(args []string, verbose verbosity, reportTemplate string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Missing the event log to replay\n")
+		os.Exit(1)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Fatalf("Couldn't open the event log: %s", err)
+	}
+	defer f.Close()
+	events, err := henchman.ReadEvents(f)
+	if err != nil {
+		log.Fatalf("Couldn't read the event log: %s", err)
+	}
+
+	for _, event := range events {
+		timestamp := event.Time.Format("2006/01/02 15:04:05")
+		switch {
+		case event.Type == henchman.TaskStarted && verbose >= 2:
+			fmt.Printf("%s %s: %s '%s'\n", timestamp, event.TaskId, event.Host, event.Task)
+		case event.Type == henchman.TaskFinished && verbose >= 1:
+			fmt.Printf("%s %s: %s '%s' [%s] (%s)\n", timestamp, event.TaskId, event.Host, event.Task, event.Status, event.Duration)
+			if event.ErrorCategory != "" {
+				fmt.Printf("    %s\n", event.ErrorCategory)
+			}
+			if verbose >= 2 && event.Message != "" {
+				fmt.Printf("    %s\n", strings.Replace(strings.TrimRight(event.Message, "\n"), "\n", "\n    ", -1))
+			}
+		}
+	}
+
+	plan := henchman.ReplayPlan(events)
+	if reportTemplate != "" {
+		tmpl, err := ioutil.ReadFile(reportTemplate)
+		if err != nil {
+			log.Fatalf("Couldn't read the report template: %s", err)
+		}
+		if err := plan.RenderReport(os.Stdout, string(tmpl)); err != nil {
+			log.Fatalf("Couldn't render the report: %s", err)
+		}
+		return
+	}
+	plan.PrintReport()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Collects the repeatable -vars flag
+type varsFiles []string
+
+func (files *varsFiles) String() string {
+	return strings.Join(*files, ",")
+}
+
+func (files *varsFiles) Set(path string) error {
+	*files = append(*files, path)
+	return nil
+}
+
+// Renders a template locally with the vars given and prints it, so that
+// template changes can be reviewed and tested without running a plan
+func runTemplate(args []string) {
+	if len(args) == 0 || args[0] != "render" {
+		fmt.Fprintf(os.Stderr, "Usage: template render <template> [-vars vars.yaml] [-args 'k=v'] [-host name] [-dialect jinja2]\n")
+		os.Exit(1)
+	}
+	renderFlags := flag.NewFlagSet("template render", flag.ExitOnError)
+	var files varsFiles
+	renderFlags.Var(&files, "vars", "YAML file of the vars to render with. Repeatable, later files win")
+	extraArgs := renderFlags.String("args", "", "Extra vars winning over the files, e.g. 'port=8080 env=staging'")
+	host := renderFlags.String("host", "localhost", "Hostname the template is rendered for")
+	dialect := renderFlags.String("dialect", "", "Template dialect, pongo2 or jinja2 as plans' template_dialect")
+	renderFlags.Parse(args[1:])
+	src := renderFlags.Arg(0)
+	if src == "" {
+		fmt.Fprintf(os.Stderr, "Missing template\n")
+		os.Exit(1)
+	}
+	// Flags may follow the template too
+	renderFlags.Parse(renderFlags.Args()[1:])
+
+	vars := make(henchman.TaskVars)
+	for _, path := range files {
+		fileVars, err := henchman.LoadVarsFile(path)
+		if err != nil {
+			log.Fatalf("Couldn't load the vars in %s: %s", path, err)
+		}
+		for variable, value := range fileVars {
+			vars[variable] = value
+		}
+	}
+	machine := &henchman.Machine{Hostname: *host}
+	vars = layerExtraArgs(vars, *extraArgs, machine)
+	if *dialect != "" && *dialect != "pongo2" && *dialect != henchman.JinjaDialect {
+		log.Fatalf("Unknown template dialect '%s', expected pongo2 or jinja2", *dialect)
+	}
+	plan := &henchman.Plan{Name: "template render", Hosts: []string{*host}, TemplateDialect: *dialect}
+	henchman.SetRunVars(henchman.NewRun(), plan)
+	rendered, err := henchman.RenderTemplate(src, vars, machine)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	fmt.Print(rendered)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"code.google.com/p/go.crypto/ssh"
+
+	"github.com/sudharsh/henchman/lib"
+)
+
+// Prints the vars a host ends up with and the source each one came from,
+// the plan, the environment, the hierarchy, group_vars, host_vars, the
+// extra args, facts or a task registering it
+func runVars(args []string, config *ssh.ClientConfig, extraArgs string, env string, inventoryPath string) {
+	varsFlags := flag.NewFlagSet("vars", flag.ExitOnError)
+	planFile := varsFlags.String("plan", "", "Plan whose vars, hierarchy and registered results to include")
+	name := varsFlags.String("var", "", "Only print this var, along with the sources it overrides")
+	factSubsets := varsFlags.String("facts", "", "Connect to the host to gather these subsets of facts too, e.g. 'os' or 'all'")
+	varsFlags.Parse(args)
+	host := varsFlags.Arg(0)
+	if host == "" {
+		fmt.Fprintf(os.Stderr, "Missing host\n")
+		os.Exit(1)
+	}
+	// Flags may follow the host too
+	varsFlags.Parse(varsFlags.Args()[1:])
+
+	plan := &henchman.Plan{Dir: "."}
+	var layers []henchman.VarLayer
+	entrySettings := make(map[string]henchman.TaskVars)
+	if *planFile != "" {
+		planBuf, err := ioutil.ReadFile(*planFile)
+		if err != nil {
+			log.Fatalf("Error reading plan - %s\n", *planFile)
+		}
+		if plan, err = henchman.NewPlanFromYAML(planBuf, nil); err != nil {
+			log.Fatalf("Couldn't read the plan: %s", err)
+		}
+		plan.Dir = filepath.Dir(*planFile)
+		layers = append(layers, henchman.VarLayer{Source: "plan", Vars: *plan.Vars})
+		for _, entry := range plan.Hosts {
+			if entryHost, settings := henchman.ParseHostEntry(entry); len(settings) > 0 {
+				entrySettings[entryHost] = settings
+			}
+		}
+	}
+	if env != "" {
+		envVars, err := henchman.LoadEnvVars(plan.Dir, env)
+		if err != nil {
+			log.Fatalf("Couldn't load the vars for environment '%s': %s", env, err)
+		}
+		layers = append(layers, henchman.VarLayer{Source: "vars/" + env + ".yaml", Vars: envVars})
+		if plan.Vars != nil {
+			for variable, value := range envVars {
+				(*plan.Vars)[variable] = value
+			}
+		}
+		if inventoryPath == "" {
+			if inventoryPath = henchman.EnvInventoryPath(plan.Dir, env); inventoryPath != "" {
+				inventory, err := henchman.LoadInventory(inventoryPath)
+				if err != nil {
+					log.Fatalf("Couldn't load the inventory: %s", err)
+				}
+				henchman.DefaultInventory = inventory
+			}
+		}
+	}
+	loadVarsDirs(plan.Dir, inventoryPath)
+	henchman.DefaultInventory.AddHostVars(entrySettings)
+
+	machines := henchman.Machines([]string{host}, config)
+	if len(machines) != 1 {
+		log.Fatalf("'%s' is a group of %d hosts, pick one of them", host, len(machines))
+	}
+	machine := machines[0]
+	if *factSubsets != "" {
+		subsets, err := henchman.ParseFactSubsets(*factSubsets)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		if err := henchman.ApplyHostSettings(machines); err != nil {
+			log.Fatalf("Couldn't apply the host settings: %s", err)
+		}
+		facts, err := machine.GatherFacts(subsets)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		machine.Close()
+		if machine.Vars == nil {
+			machine.Vars = make(henchman.TaskVars)
+		}
+		machine.Vars["facts"] = facts
+		layers = append(layers, henchman.FactsLayer(facts))
+	}
+	if len(plan.Hierarchy) > 0 {
+		hierarchy, err := plan.HierarchyLayers(machine)
+		if err != nil {
+			log.Fatalf("Couldn't resolve the hierarchy for %s: %s", host, err)
+		}
+		layers = append(layers, hierarchy...)
+	}
+	layers = append(layers, henchman.DefaultInventory.VarLayers(machine.Hostname)...)
+	layers = append(layers, henchman.VarLayer{Source: "-args", Vars: parseExtraArgs(extraArgs)})
+	for _, scoped := range parseScopedArgs(extraArgs).Layers(machine) {
+		layers = append(layers, henchman.VarLayer{Source: "-args " + scoped.Source + ":", Vars: scoped.Vars})
+	}
+	layers = append(layers, plan.RegisterLayers()...)
+
+	traced := henchman.TraceVars(layers)
+	if *name != "" {
+		for _, v := range traced {
+			if v.Name == *name {
+				fmt.Printf("%s = %v\t(%s)\n", v.Name, v.Value, v.Source)
+				for i := len(v.Overridden) - 1; i >= 0; i-- {
+					fmt.Printf("  overrides %s\n", v.Overridden[i])
+				}
+				return
+			}
+		}
+		fmt.Fprintf(os.Stderr, "'%s' isn't set for %s\n", *name, host)
+		os.Exit(1)
+	}
+	for _, v := range traced {
+		fmt.Printf("%s = %v\t(%s)\n", v.Name, v.Value, v.Source)
+	}
+}